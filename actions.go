@@ -3,15 +3,19 @@ package varuh
 
 import (
 	"bufio"
+	"bytes"
 	"errors"
 	"fmt"
+	"golang.org/x/crypto/ssh"
 	"gorm.io/gorm"
 	"os"
 	"os/signal"
 	"path/filepath"
+	"runtime"
 	"strconv"
 	"strings"
 	"syscall"
+	"time"
 )
 
 type CustomEntry struct {
@@ -25,14 +29,23 @@ func WrapperMaxKryptStringFunc(fn ActionFunc) ActionFunc {
 	return func(inputStr string) error {
 		var maxKrypt bool
 		var defaultDB string
-		var encPasswd string
+		var reEncrypt VoidFunc
+		var unlock VoidFunc
 		var err error
 
+		// Keep other varuh processes out till the action is done
+		err, unlock = lockActiveDatabase()
+		if err != nil {
+			return err
+		}
+
+		defer unlock()
+
 		maxKrypt, defaultDB = isActiveDatabaseEncryptedAndMaxKryptOn()
 
 		// If max krypt on - then autodecrypt on call and auto encrypt after call
 		if maxKrypt {
-			err, encPasswd = DecryptDatabase(defaultDB)
+			err, reEncrypt = unlockForAction(defaultDB)
 			if err != nil {
 				return err
 			}
@@ -43,8 +56,9 @@ func WrapperMaxKryptStringFunc(fn ActionFunc) ActionFunc {
 			go func() {
 				sig := <-sigChan
 				fmt.Println("Received signal", sig)
-				// Reencrypt
-				EncryptDatabase(defaultDB, &encPasswd)
+				// Save changes so far
+				reEncrypt()
+				unlock()
 				os.Exit(1)
 			}()
 		}
@@ -53,7 +67,7 @@ func WrapperMaxKryptStringFunc(fn ActionFunc) ActionFunc {
 
 		// If max krypt on - then autodecrypt on call and auto encrypt after call
 		if maxKrypt {
-			EncryptDatabase(defaultDB, &encPasswd)
+			reEncrypt()
 		}
 
 		return err
@@ -67,14 +81,23 @@ func WrapperMaxKryptVoidFunc(fn VoidFunc) VoidFunc {
 	return func() error {
 		var maxKrypt bool
 		var defaultDB string
-		var encPasswd string
+		var reEncrypt VoidFunc
+		var unlock VoidFunc
 		var err error
 
+		// Keep other varuh processes out till the action is done
+		err, unlock = lockActiveDatabase()
+		if err != nil {
+			return err
+		}
+
+		defer unlock()
+
 		maxKrypt, defaultDB = isActiveDatabaseEncryptedAndMaxKryptOn()
 
 		// If max krypt on - then autodecrypt on call and auto encrypt after call
 		if maxKrypt {
-			err, encPasswd = DecryptDatabase(defaultDB)
+			err, reEncrypt = unlockForAction(defaultDB)
 			if err != nil {
 				return err
 			}
@@ -85,8 +108,9 @@ func WrapperMaxKryptVoidFunc(fn VoidFunc) VoidFunc {
 			go func() {
 				sig := <-sigChan
 				fmt.Println("Received signal", sig)
-				// Reencrypt
-				EncryptDatabase(defaultDB, &encPasswd)
+				// Save changes so far
+				reEncrypt()
+				unlock()
 				os.Exit(1)
 			}()
 		}
@@ -95,12 +119,163 @@ func WrapperMaxKryptVoidFunc(fn VoidFunc) VoidFunc {
 
 		// If max krypt on - then autodecrypt on call and auto encrypt after call
 		if maxKrypt {
-			EncryptDatabase(defaultDB, &encPasswd)
+			reEncrypt()
+		}
+
+		return err
+	}
+
+}
+
+// Unlock an encrypted database into memory for an action. The unlock agent is
+// asked first, else the password is prompted for and the derived key handed to
+// the agent if one is running. Plain text is never written to disk. Returns a
+// function which writes the database back encrypted, if it was modified.
+func unlockForAction(dbPath string) (error, VoidFunc) {
+
+	var err error
+	var encText []byte
+	var plainText []byte
+	var header *FileHeader
+	var key []byte
+	var passwd string
+	var keyFileHash []byte
+	var agentUp bool
+	var unlocked bool
+	var db *gorm.DB
+
+	encText, err = os.ReadFile(dbPath)
+	if err != nil {
+		fmt.Printf("Error - Can't read database -\"%s\"\n", err)
+		return err, nil
+	}
+
+	err, header, _ = ParseFileHeader(encText)
+	if err != nil {
+		fmt.Printf("Error - %s\n", err.Error())
+		return err, nil
+	}
+
+	dbPath, _ = filepath.Abs(dbPath)
+
+	// Legacy containers are not cached by the agent
+	if header.Version > 0 {
+		err, plainText = AgentDecrypt(dbPath, encText)
+		agentUp = err == nil || err == ErrAgentLocked
+		unlocked = err == nil
+	}
+
+	if !unlocked {
+		err, passwd, keyFileHash = readDecryptionPassword(dbPath)
+		if err != nil {
+			return err, nil
+		}
+
+		err, plainText, header, key = UnlockData(encText, passwd, keyFileHash)
+		if err != nil {
+			return err, nil
+		}
+
+		if agentUp {
+			if err = AgentAddKey(dbPath, key, encText); err != nil {
+				fmt.Printf("Warning - agent did not accept the key - \"%s\"\n", err.Error())
+			}
+		}
+	}
+
+	err, db = OpenMemoryDatabase(plainText)
+	if err != nil {
+		fmt.Printf("Error opening decrypted database - \"%s\"\n", err.Error())
+		return err, nil
+	}
+
+	SetMemoryDatabase(dbPath, db)
+
+	return nil, func() error {
+
+		var err error
+		var contents []byte
+		var encText []byte
+
+		defer SetMemoryDatabase(dbPath, nil)
+
+		err, contents = SerializeDatabase(db)
+		if err != nil {
+			fmt.Printf("Error reading database from memory - \"%s\"\n", err.Error())
+			return err
+		}
+
+		if bytes.Equal(contents, plainText) {
+			// Not modified
+			return nil
+		}
+
+		if header.Version == 0 {
+			// Rewrite in the current format
+			err, encText = EncryptData(contents, passwd, keyFileHash, header.Cipher, getKdfParams())
+		} else if key != nil {
+			err, encText = ResealData(contents, header, key)
+		} else {
+			err, encText = AgentEncrypt(dbPath, contents)
+			if err != nil {
+				// Agent went away or forgot the key meanwhile
+				fmt.Printf("Agent could not encrypt the database - \"%s\"\n", err.Error())
+				err, encText = resealWithPassword(dbPath, contents)
+			}
+		}
+
+		if err != nil {
+			fmt.Printf("Error encrypting database, changes not saved - \"%s\"\n", err.Error())
+			return err
 		}
 
+		return writeEncryptedFile(dbPath, encText)
+	}
+}
+
+// Seal new contents of an encrypted database by prompting for its password again
+func resealWithPassword(dbPath string, contents []byte) (error, []byte) {
+
+	var err error
+	var encText []byte
+	var passwd string
+	var keyFileHash []byte
+	var header *FileHeader
+	var key []byte
+
+	encText, err = os.ReadFile(dbPath)
+	if err != nil {
+		return err, nil
+	}
+
+	err, passwd, keyFileHash = readDecryptionPassword(dbPath)
+	if err != nil {
+		return err, nil
+	}
+
+	err, _, header, key = UnlockData(encText, passwd, keyFileHash)
+	if err != nil {
+		return err, nil
+	}
+
+	return ResealData(contents, header, key)
+}
+
+// Clear the keys cached by the unlock agent
+func LockAgent() error {
+
+	if !IsAgentRunning() {
+		fmt.Println("No agent running.")
+		return nil
+	}
+
+	if err := AgentLock(); err != nil {
+		fmt.Printf("Error locking agent - \"%s\"\n", err.Error())
 		return err
 	}
 
+	fmt.Println("Agent locked - cached keys cleared.")
+	return nil
 }
 
 // Print the current active database path
@@ -132,6 +307,7 @@ func SetActiveDatabasePath(dbPath string) error {
 	var fullPath string
 	var activeEncrypted bool
 	var newEncrypted bool
+	var unlock VoidFunc
 
 	err, settings := GetOrCreateLocalConfig(APP)
 
@@ -155,6 +331,19 @@ func SetActiveDatabasePath(dbPath string) error {
 			return nil
 		}
 
+		// Neither database may be in use while switching
+		if err, unlock = lockActiveDatabase(); err != nil {
+			return err
+		}
+
+		defer unlock()
+
+		if err, unlock = lockForAction(fullPath); err != nil {
+			return err
+		}
+
+		defer unlock()
+
 		if _, flag = IsFileEncrypted(settings.ActiveDB); flag {
 			activeEncrypted = true
 		}
@@ -297,15 +486,27 @@ func AddNewCardEntry() error {
 	return err
 }
 
-// Text menu driven function to add a new entry
-func AddNewEntry() error {
+// Read the identity fields which are validated - email, phone and expiry
+// date. Empty values are allowed.
+func readIdentityContact(reader *bufio.Reader, prefix string) (error, string, string) {
 
-	var userName string
-	var title string
-	var url string
-	var notes string
-	var passwd string
-	var tags string
+	email := readInput(reader, prefix+"Email")
+	if email != "" && !ValidateEmail(email) {
+		return errors.New("invalid email"), "", ""
+	}
+
+	phone := readInput(reader, prefix+"Phone Number")
+	if phone != "" && !ValidatePhoneNumber(phone) {
+		return errors.New("invalid phone number"), "", ""
+	}
+
+	return nil, email, phone
+}
+
+// Text menu driven function to add a new entry for an identity type
+func AddNewIdentityEntry() error {
+
+	var entry Entry
 	var err error
 	var customEntries []CustomEntry
 
@@ -313,50 +514,53 @@ func AddNewEntry() error {
 		return err
 	}
 
-	if SettingsRider.Type == "card" {
-		return AddNewCardEntry()
+	reader := bufio.NewReader(os.Stdin)
+	entry.FirstName = readInput(reader, "First Name")
+	entry.MiddleName = readInput(reader, "Middle Name")
+	entry.LastName = readInput(reader, "Last Name")
+
+	// Name cant be blank
+	if len(entry.FirstName) == 0 && len(entry.LastName) == 0 {
+		fmt.Printf("Error - first or last name required\n")
+		return errors.New("invalid input")
 	}
 
-	reader := bufio.NewReader(os.Stdin)
-	title = readInput(reader, "Title")
-	url = readInput(reader, "URL")
-	if len(url) > 0 && !strings.HasPrefix(strings.ToLower(url), "http://") && !strings.HasPrefix(strings.ToLower(url), "https://") {
-		url = "http://" + url
+	err, entry.Email, entry.PhoneNumber = readIdentityContact(reader, "")
+	if err != nil {
+		return err
 	}
 
-	userName = readInput(reader, "Username")
+	entry.Company = readInput(reader, "Company")
 
-	fmt.Printf("Password (enter to generate new): ")
-	err, passwd = ReadPassword()
+	entry.Class = readInput(reader, "\nDocument Type (passport, driving license etc)")
+	entry.Number = readInput(reader, "Document Number")
+	entry.Issuer = readInput(reader, "Issued By")
+	entry.ExpiryDate = readInput(reader, "Expiry Date as yyyy-mm-dd")
 
-	if len(passwd) == 0 {
-		fmt.Printf("\nGenerating password ...")
-		err, passwd = GenerateStrongPassword()
-		fmt.Printf("done")
+	if entry.ExpiryDate != "" && !CheckValidDocumentExpiry(entry.ExpiryDate) {
+		return errors.New("Invalid Expiry Date")
 	}
-	//  fmt.Printf("Password => %s\n", passwd)
 
-	tags = readInput(reader, "\nTags (separated by space): ")
-	notes = readInput(reader, "Notes")
-
-	// Title and username/password are mandatory
-	if len(title) == 0 {
-		fmt.Printf("Error - valid Title required\n")
-		return errors.New("invalid input")
-	}
-	if len(userName) == 0 {
-		fmt.Printf("Error - valid Username required\n")
-		return errors.New("invalid input")
-	}
-	if len(passwd) == 0 {
-		fmt.Printf("Error - valid Password required\n")
-		return errors.New("invalid input")
+	entry.Title = readInput(reader, "A name for this Identity")
+	if len(entry.Title) == 0 {
+		entry.Title = FullName(entry.FirstName, entry.MiddleName, entry.LastName)
+		if entry.Class != "" {
+			entry.Title += " - " + entry.Class
+		}
 	}
 
+	entry.Tags = readInput(reader, "\nTags (separated by space): ")
+	entry.Notes = readInput(reader, "Notes")
+
+	addresses := readMoreAddresses(reader)
+
 	customEntries = AddCustomFields(reader)
 
-	// Trim spaces
-	err = AddNewDatabaseEntry(title, userName, url, passwd, tags, notes, customEntries)
+	err = AddNewDatabaseIdentityEntry(&entry, customEntries)
+
+	if err == nil && len(addresses) > 0 {
+		err = CloneAddresses(&entry, addresses)
+	}
 
 	if err != nil {
 		fmt.Printf("Error adding entry - \"%s\"\n", err.Error())
@@ -365,213 +569,383 @@ func AddNewEntry() error {
 	return err
 }
 
-// Function to update existing custom entries and add new ones
-// The bool part of the return value indicates whether to take action
-func AddOrUpdateCustomFields(reader *bufio.Reader, entry *Entry) ([]CustomEntry, bool) {
+// Edit an identity entry
+func EditCurrentIdentityEntry(entry *Entry) error {
 
-	var customEntries []ExtendedEntry
-	var editedCustomEntries []CustomEntry
-	var newCustomEntries []CustomEntry
+	var err error
 	var flag bool
+	var customEntries []CustomEntry
 
-	customEntries = GetExtendedEntries(entry)
+	keyValMap := make(map[string]string)
+	reader := bufio.NewReader(os.Stdin)
 
-	if len(customEntries) > 0 {
+	fmt.Printf("Title: %s\n", entry.Title)
+	keyValMap["title"] = readInput(reader, "New Title")
+	fmt.Printf("First Name: %s\n", entry.FirstName)
+	keyValMap["first_name"] = readInput(reader, "New First Name")
+	fmt.Printf("Middle Name: %s\n", entry.MiddleName)
+	keyValMap["middle_name"] = readInput(reader, "New Middle Name")
+	fmt.Printf("Last Name: %s\n", entry.LastName)
+	keyValMap["last_name"] = readInput(reader, "New Last Name")
+
+	fmt.Printf("Email: %s\n", entry.Email)
+	fmt.Printf("Phone Number: %s\n", entry.PhoneNumber)
+	err, keyValMap["email"], keyValMap["phone_number"] = readIdentityContact(reader, "New ")
+	if err != nil {
+		return err
+	}
 
-		fmt.Println("Editing/deleting custom fields")
-		for _, customEntry := range customEntries {
-			var fieldName string
-			var fieldValue string
+	fmt.Printf("Company: %s\n", entry.Company)
+	keyValMap["company"] = readInput(reader, "New Company")
 
-			fmt.Println("Field Name: " + customEntry.FieldName)
-			fieldName = readInput(reader, "\tNew Field Name (Enter to keep, \"x\" to delete)")
-			if strings.ToLower(strings.TrimSpace(fieldName)) == "x" {
-				fmt.Println("Deleting field: " + customEntry.FieldName)
-			} else {
-				if strings.TrimSpace(fieldName) == "" {
-					fieldName = customEntry.FieldName
-				}
+	fmt.Printf("\nDocument Type: %s\n", entry.Class)
+	keyValMap["class"] = readInput(reader, "New Document Type")
+	fmt.Printf("Document Number: %s\n", entry.Number)
+	keyValMap["number"] = readInput(reader, "New Document Number")
+	fmt.Printf("Issued By: %s\n", entry.Issuer)
+	keyValMap["issuer"] = readInput(reader, "New Issued By")
+	fmt.Printf("Expiry Date: %s\n", entry.ExpiryDate)
+	keyValMap["expiry_date"] = readInput(reader, "New Expiry Date (as yyyy-mm-dd)")
 
-				fmt.Println("Field Value: " + customEntry.FieldValue)
-				fieldValue = readInput(reader, "\tNew Field Value (Enter to keep)")
-				if strings.TrimSpace(fieldValue) == "" {
-					fieldValue = customEntry.FieldValue
-				}
+	if keyValMap["expiry_date"] != "" && !CheckValidDocumentExpiry(keyValMap["expiry_date"]) {
+		return errors.New("Invalid Expiry Date")
+	}
 
-				editedCustomEntries = append(editedCustomEntries, CustomEntry{fieldName, fieldValue})
+	fmt.Printf("\nCurrent Tags: %s\n", entry.Tags)
+	keyValMap["tags"] = readInput(reader, "New Tags")
+	fmt.Printf("\nCurrent Notes: %s\n", entry.Notes)
+	keyValMap["notes"] = readInput(reader, "New Notes")
+
+	err = editAddresses(reader, entry)
+	if err != nil {
+		fmt.Printf("Error updating address - \"%s\"\n", err.Error())
+		return err
+	}
+
+	addresses := readMoreAddresses(reader)
+
+	customEntries, flag = AddOrUpdateCustomFields(reader, entry)
+
+	// Update
+	err = UpdateDatabaseIdentityEntry(entry, keyValMap, customEntries, flag)
+
+	if err == nil && len(addresses) > 0 {
+		err = CloneAddresses(entry, addresses)
+	}
+
+	if err != nil {
+		fmt.Printf("Error updating entry - \"%s\"\n", err.Error())
+	}
+
+	return err
+}
+
+// Read an address, showing the current values of an existing one which
+// are kept if nothing is entered
+func readAddress(reader *bufio.Reader, current *Address) *Address {
+
+	var address Address
+
+	if current != nil {
+		address = *current
+	}
+
+	fields := []struct {
+		prompt string
+		value  *string
+	}{
+		{"Address Type (Home/Work/Business)", &address.Type},
+		{"Flat/Building Number", &address.Number},
+		{"Building Name", &address.Building},
+		{"Street", &address.Street},
+		{"Locality", &address.Locality},
+		{"Area", &address.Area},
+		{"City", &address.City},
+		{"State", &address.State},
+		{"PIN/ZIP Code", &address.ZipCode},
+		{"Country", &address.Country},
+		{"Landmark", &address.Landmark},
+	}
+
+	for _, field := range fields {
+		if current != nil {
+			fmt.Printf("%s: %s\n", field.prompt, *field.value)
+			if val := readInput(reader, "New "+field.prompt); val != "" {
+				*field.value = val
 			}
+		} else {
+			*field.value = readInput(reader, field.prompt)
 		}
 	}
 
-	newCustomEntries = AddCustomFields(reader)
+	return &address
+}
 
-	editedCustomEntries = append(editedCustomEntries, newCustomEntries...)
+// Return true if an address has enough to find the place
+func isAddressValid(address *Address) bool {
 
-	// Cases where length == 0
-	// 1. Existing entries - all deleted
-	flag = len(customEntries) > 0 || len(editedCustomEntries) > 0
+	if address.Street == "" && address.Locality == "" && address.City == "" {
+		fmt.Printf("Error - street, locality or city required\n")
+		return false
+	}
 
-	return editedCustomEntries, flag
+	return true
 }
 
-// Function to add custom fields to an entry
-func AddCustomFields(reader *bufio.Reader) []CustomEntry {
+// Ask for any number of addresses to add
+func readMoreAddresses(reader *bufio.Reader) []Address {
 
-	// Custom fields
-	var custom string
-	var customEntries []CustomEntry
+	var addresses []Address
 
-	custom = readInput(reader, "Do you want to add custom fields [y/N]")
-	if strings.ToLower(custom) == "y" {
+	for {
+		response := readInput(reader, "Add an address ? [y/N]")
+		if strings.ToLower(response) != "y" {
+			break
+		}
 
-		fmt.Println("Keep entering custom field name followed by the value. Press return with no input once done.")
-		for true {
-			var customFieldName string
-			var customFieldValue string
+		address := readAddress(reader, nil)
+		if isAddressValid(address) {
+			addresses = append(addresses, *address)
+		}
+	}
 
-			customFieldName = strings.TrimSpace(readInput(reader, "Field Name"))
-			if customFieldName != "" {
-				customFieldValue = strings.TrimSpace(readInput(reader, "Value for "+customFieldName))
-			}
+	return addresses
+}
 
-			if customFieldName == "" && customFieldValue == "" {
-				break
-			}
+// Edit the existing addresses of an entry
+func editAddresses(reader *bufio.Reader, entry *Entry) error {
 
-			customEntries = append(customEntries, CustomEntry{customFieldName, customFieldValue})
+	for _, address := range GetAddresses(entry) {
+		fmt.Printf("\nAddress (%s): %s\n", address.Type, address.String())
+
+		response := readInput(reader, "Edit this address ? [y/N]")
+		if strings.ToLower(response) != "y" {
+			continue
+		}
+
+		updated := readAddress(reader, &address)
+		if !isAddressValid(updated) {
+			return errors.New("invalid address")
+		}
+
+		if err := UpdateAddress(updated); err != nil {
+			return err
 		}
 	}
 
-	return customEntries
+	return nil
 }
 
-// Edit a card entry by id
-func EditCurrentCardEntry(entry *Entry) error {
-	var klass string
+// Text menu driven function to add a new entry for an address type
+func AddNewAddressEntry() error {
+
 	var err error
-	var flag bool
+	var address *Address
 	var customEntries []CustomEntry
 
-	reader := bufio.NewReader(os.Stdin)
-
-	fmt.Printf("Card Title: %s\n", entry.Title)
-	title := readInput(reader, "New Card Title")
-	fmt.Printf("Name on Card: %s\n", entry.User)
-	name := readInput(reader, "New Name on Card")
-	fmt.Printf("Card Number: %s\n", entry.Url)
-	number := readInput(reader, "New Card Number")
-	if number != "" {
-		klass, err = DetectCardType(number)
-
-		if err != nil {
-			fmt.Printf("Error - %s\n", err.Error())
-			return err
-		} else {
-			fmt.Printf("<Card type is %s>\n", klass)
-		}
+	if err = checkActiveDatabase(); err != nil {
+		return err
 	}
 
-	fmt.Printf("Card CVV: %s\n", entry.Password)
-	fmt.Printf("New Card CVV: ")
-	err, cvv := ReadPassword()
+	reader := bufio.NewReader(os.Stdin)
 
-	if cvv != "" && !ValidateCvv(cvv, klass) {
-		fmt.Printf("\nError - Invalid CVV for %s\n", klass)
-		return errors.New(fmt.Sprintf("Error - Invalid CVV for %s\n", klass))
+	address = readAddress(reader, nil)
+	if !isAddressValid(address) {
+		return errors.New("invalid input")
 	}
-	fmt.Printf("\nCard PIN: %s\n", entry.Pin)
-	fmt.Printf("New Card PIN: ")
-	err, pin := ReadPassword()
 
-	if pin != "" && !ValidateCardPin(pin) {
-		fmt.Printf("\n<Warning - Empty PIN!>")
-	}
-	fmt.Printf("\nCard Expiry Date: %s\n", entry.ExpiryDate)
-	expiryDate := readInput(reader, "New Card Expiry Date (as mm/dd): ")
-	// expiry has to be in the form of <month>/<year>
-	if expiryDate != "" && !CheckValidExpiry(expiryDate) {
-		return errors.New("Invalid Expiry Date")
+	title := readInput(reader, "\nA name for this Address")
+	if len(title) == 0 {
+		title = strings.TrimSpace(address.Type + " Address")
 	}
+
 	tags := readInput(reader, "\nTags (separated by space): ")
 	notes := readInput(reader, "Notes")
 
-	customEntries, flag = AddOrUpdateCustomFields(reader, entry)
+	customEntries = AddCustomFields(reader)
 
-	// Update
-	err = UpdateDatabaseCardEntry(entry, title, number, name,
-		klass, cvv, pin, expiryDate, notes, tags, customEntries, flag)
+	err = AddNewDatabaseAddressEntry(title, notes, tags, address, customEntries)
 
 	if err != nil {
 		fmt.Printf("Error adding entry - \"%s\"\n", err.Error())
 	}
 
-	return nil
+	return err
 }
 
-// Edit a current entry by id
-func EditCurrentEntry(idString string) error {
+// Edit an address entry
+func EditCurrentAddressEntry(entry *Entry) error {
 
-	var userName string
-	var title string
-	var url string
-	var notes string
-	var tags string
-	var passwd string
 	var err error
-	var entry *Entry
-	var id int
+	var flag bool
+	var customEntries []CustomEntry
 
-	if err = checkActiveDatabase(); err != nil {
+	reader := bufio.NewReader(os.Stdin)
+
+	fmt.Printf("Title: %s\n", entry.Title)
+	title := readInput(reader, "New Title")
+
+	err = editAddresses(reader, entry)
+	if err != nil {
+		fmt.Printf("Error updating address - \"%s\"\n", err.Error())
 		return err
 	}
 
-	id, _ = strconv.Atoi(idString)
+	fmt.Printf("\nCurrent Tags: %s\n", entry.Tags)
+	tags := readInput(reader, "New Tags")
+	fmt.Printf("\nCurrent Notes: %s\n", entry.Notes)
+	notes := readInput(reader, "New Notes")
 
-	err, entry = GetEntryById(id)
-	if err != nil || entry == nil {
-		fmt.Printf("No entry found for id %d\n", id)
-		return err
+	customEntries, flag = AddOrUpdateCustomFields(reader, entry)
+
+	err = UpdateDatabaseEntry(entry, title, "", "", "", tags, notes, customEntries, flag)
+	if err != nil {
+		fmt.Printf("Error updating entry - \"%s\"\n", err.Error())
 	}
 
-	if entry.Type == "card" {
-		return EditCurrentCardEntry(entry)
+	return err
+}
+
+// Read the comment of the public key next to a private key file, if any
+func readSSHKeyComment(keyPath string) string {
+
+	data, err := os.ReadFile(keyPath + ".pub")
+	if err != nil {
+		return ""
+	}
+
+	_, comment, _, _, err := ssh.ParseAuthorizedKey(data)
+	if err != nil {
+		return ""
+	}
+
+	return comment
+}
+
+// Text menu driven function to add a new entry for an sshkey type. The key
+// is either generated or imported from a private key file.
+func AddNewSSHKeyEntry() error {
+
+	var entry Entry
+	var err error
+	var data []byte
+	var publicKey ssh.PublicKey
+	var customEntries []CustomEntry
+
+	if err = checkActiveDatabase(); err != nil {
+		return err
 	}
 
 	reader := bufio.NewReader(os.Stdin)
 
-	fmt.Printf("Current Title: %s\n", entry.Title)
-	title = readInput(reader, "New Title")
+	entry.Title = readInput(reader, "Title")
+	if len(entry.Title) == 0 {
+		fmt.Printf("Error - valid Title required\n")
+		return errors.New("invalid input")
+	}
 
-	fmt.Printf("Current URL: %s\n", entry.Url)
-	url = readInput(reader, "New URL")
+	entry.User = readInput(reader, "Comment (e.g user@host)")
+	keyPath := readInput(reader, "Private key file to import (enter to generate new)")
 
-	if len(url) > 0 && !strings.HasPrefix(strings.ToLower(url), "http://") && !strings.HasPrefix(strings.ToLower(url), "https://") {
-		url = "http://" + url
+	if len(keyPath) == 0 {
+		keyType := readInput(reader, "Key type (ed25519, rsa, rsa-2048, rsa-3072) [ed25519]")
+
+		fmt.Printf("Generating key ...")
+		err, entry.PrivateKey, entry.PublicKey = GenerateSSHKey(keyType, entry.User)
+		if err != nil {
+			fmt.Printf("\nError generating key - \"%s\"\n", err.Error())
+			return err
+		}
+		fmt.Printf("done\n")
+	} else {
+		data, err = os.ReadFile(keyPath)
+		if err != nil {
+			fmt.Printf("Error reading key file - \"%s\"\n", err.Error())
+			return err
+		}
+		entry.PrivateKey = string(data)
+
+		if IsSSHKeyEncrypted(entry.PrivateKey) {
+			fmt.Printf("Passphrase: ")
+			err, entry.Password = ReadPassword()
+			fmt.Println()
+			if err != nil {
+				fmt.Printf("Error reading passphrase - \"%s\"\n", err.Error())
+				return err
+			}
+		}
+
+		err, publicKey, _ = ParseSSHPrivateKey(entry.PrivateKey, entry.Password)
+		if err != nil {
+			fmt.Printf("Error - invalid private key - %s\n", err.Error())
+			return err
+		}
+
+		entry.PublicKey = FormatSSHPublicKey(publicKey, "")
+		if len(entry.User) == 0 {
+			entry.User = readSSHKeyComment(keyPath)
+		}
 	}
 
-	fmt.Printf("Current Username: %s\n", entry.User)
-	userName = readInput(reader, "New Username")
+	_, entry.Class, _ = SSHKeyFingerprint(entry.PublicKey)
 
-	fmt.Printf("Current Password: %s\n", entry.Password)
-	fmt.Printf("New Password ([y/Y] to generate new, enter will keep old one): ")
-	err, passwd = ReadPassword()
+	entry.Tags = readInput(reader, "\nTags (separated by space): ")
+	entry.Notes = readInput(reader, "Notes")
 
-	if strings.ToLower(passwd) == "y" {
-		fmt.Printf("\nGenerating new password ...")
-		err, passwd = GenerateStrongPassword()
+	customEntries = AddCustomFields(reader)
+
+	err = AddNewDatabaseSSHKeyEntry(&entry, customEntries)
+	if err != nil {
+		fmt.Printf("Error adding entry - \"%s\"\n", err.Error())
+		return err
 	}
-	//  fmt.Printf("Password => %s\n", passwd)
 
-	fmt.Printf("\nCurrent Tags: %s\n", entry.Tags)
-	tags = readInput(reader, "New Tags")
+	fmt.Printf("Public key: %s\n", strings.TrimSpace(entry.PublicKey+" "+entry.User))
 
-	fmt.Printf("\nCurrent Notes: %s\n", entry.Notes)
-	notes = readInput(reader, "New Notes")
+	return nil
+}
 
-	customEntries, flag := AddOrUpdateCustomFields(reader, entry)
+// Edit an sshkey entry. The key itself is not changed.
+func EditCurrentSSHKeyEntry(entry *Entry) error {
 
-	// Update
-	err = UpdateDatabaseEntry(entry, title, userName, url, passwd, tags, notes, customEntries, flag)
+	var err error
+	var flag bool
+	var passphrase string
+	var customEntries []CustomEntry
+
+	reader := bufio.NewReader(os.Stdin)
+
+	fmt.Printf("Current Title: %s\n", entry.Title)
+	title := readInput(reader, "New Title")
+
+	fmt.Printf("Current Comment: %s\n", entry.User)
+	comment := readInput(reader, "New Comment")
+
+	if IsSSHKeyEncrypted(entry.PrivateKey) {
+		fmt.Printf("New Passphrase (enter to keep): ")
+		err, passphrase = ReadPassword()
+		fmt.Println()
+		if err != nil {
+			fmt.Printf("Error reading passphrase - \"%s\"\n", err.Error())
+			return err
+		}
+
+		if len(passphrase) > 0 {
+			if err, _, _ = ParseSSHPrivateKey(entry.PrivateKey, passphrase); err != nil {
+				fmt.Printf("Error - passphrase does not decrypt the key - %s\n", err.Error())
+				return err
+			}
+		}
+	}
+
+	fmt.Printf("Current Tags: %s\n", entry.Tags)
+	tags := readInput(reader, "New Tags")
+	fmt.Printf("Current Notes: %s\n", entry.Notes)
+	notes := readInput(reader, "New Notes")
+
+	customEntries, flag = AddOrUpdateCustomFields(reader, entry)
+
+	err = UpdateDatabaseEntry(entry, title, comment, "", passphrase, tags, notes, customEntries, flag)
 	if err != nil {
 		fmt.Printf("Error updating entry - \"%s\"\n", err.Error())
 	}
@@ -579,133 +953,1304 @@ func EditCurrentEntry(idString string) error {
 	return err
 }
 
-// List current entry by id
-func ListCurrentEntry(idString string) error {
+// Load the key of an sshkey entry into the running ssh-agent, for the
+// time given by --ssh-lifetime if any
+func LoadSSHKey(idString string) error {
 
-	var id int
 	var err error
 	var entry *Entry
+	var id int
+	var lifetime time.Duration
 
 	if err = checkActiveDatabase(); err != nil {
 		return err
 	}
 
+	if SettingsRider.SSHLifetime != "" {
+		lifetime, err = parseAge(SettingsRider.SSHLifetime)
+		if err != nil || lifetime < time.Second {
+			fmt.Printf("Error - invalid lifetime \"%s\" (use e.g 30m, 8h, 1d)\n", SettingsRider.SSHLifetime)
+			return errors.New("invalid lifetime")
+		}
+	}
+
 	id, _ = strconv.Atoi(idString)
 
-	//  fmt.Printf("Listing current entry - %d\n", id)
 	err, entry = GetEntryById(id)
 	if err != nil || entry == nil {
 		fmt.Printf("No entry found for id %d\n", id)
 		return err
 	}
 
-	err = PrintEntry(entry, true)
+	err = AddSSHKeyToAgent(entry, lifetime)
+	if err != nil {
+		fmt.Printf("Error adding key to ssh-agent - \"%s\"\n", err.Error())
+		return err
+	}
 
-	if err == nil && SettingsRider.CopyPassword {
-		//      fmt.Printf("Copying password " + entry.Password + " to clipboard\n")
-		CopyPasswordToClipboard(entry.Password)
+	_, _, fingerprint := SSHKeyFingerprint(entry.PublicKey)
+	if lifetime > 0 {
+		fmt.Printf("Added key %s (%s) to ssh-agent for %s.\n", entry.Title, fingerprint, SettingsRider.SSHLifetime)
+	} else {
+		fmt.Printf("Added key %s (%s) to ssh-agent.\n", entry.Title, fingerprint)
 	}
 
-	return err
+	return nil
 }
 
-// List all entries
-func ListAllEntries() error {
+// Text menu driven function to add a new entry for a note type
+func AddNewNoteEntry() error {
 
 	var err error
-	var maxKrypt bool
-	var defaultDB string
-	var passwd string
-
-	maxKrypt, defaultDB = isActiveDatabaseEncryptedAndMaxKryptOn()
-
-	// If max krypt on - then autodecrypt on call and auto encrypt after call
-	if maxKrypt {
-		err, passwd = DecryptDatabase(defaultDB)
-		if err != nil {
-			return err
-		}
-	}
+	var body string
+	var customEntries []CustomEntry
 
 	if err = checkActiveDatabase(); err != nil {
 		return err
 	}
 
-	err, settings := GetOrCreateLocalConfig(APP)
+	reader := bufio.NewReader(os.Stdin)
 
-	if err != nil {
-		fmt.Printf("Error parsing config - \"%s\"\n", err.Error())
-		return err
+	title := readInput(reader, "Title")
+	if len(title) == 0 {
+		fmt.Printf("Error - valid Title required\n")
+		return errors.New("invalid input")
 	}
 
-	orderKeys := strings.Split(settings.ListOrder, ",")
-	err, entries := IterateEntries(orderKeys[0], orderKeys[1])
+	tags := readInput(reader, "Tags (separated by space)")
 
-	if err == nil {
-		if len(entries) > 0 {
-			fmt.Printf("%s", GetColor(strings.ToLower(settings.Color)))
-			PrintDelim(settings.Delim, settings.Color)
-			for _, entry := range entries {
-				PrintEntry(&entry, false)
-			}
-		} else {
-			fmt.Println("No entries.")
-		}
-	} else {
-		fmt.Printf("Error fetching entries: \"%s\"\n", err.Error())
+	err, body = readMultiLineInput(reader, "Body", "")
+	if err != nil {
+		fmt.Printf("Error reading body - \"%s\"\n", err.Error())
 		return err
 	}
+	if len(body) == 0 {
+		fmt.Printf("Error - note body is empty\n")
+		return errors.New("invalid input")
+	}
 
-	// If max krypt on - then autodecrypt on call and auto encrypt after call
-	if maxKrypt {
-		err = EncryptDatabase(defaultDB, &passwd)
+	// Custom fields are asked only at a terminal, piped input is all body
+	if isTerminalInput() {
+		customEntries = AddCustomFields(reader)
+	}
+
+	err = AddNewDatabaseNoteEntry(title, body, tags, customEntries)
+	if err != nil {
+		fmt.Printf("Error adding entry - \"%s\"\n", err.Error())
 	}
 
 	return err
 }
 
-// Find current entry by term - prints all matches
-func FindCurrentEntry(term string) error {
+// Edit a note entry
+func EditCurrentNoteEntry(entry *Entry) error {
 
 	var err error
-	var entries []Entry
-	var terms []string
+	var flag bool
+	var body string
+	var customEntries []CustomEntry
 
-	if err = checkActiveDatabase(); err != nil {
-		return err
-	}
+	reader := bufio.NewReader(os.Stdin)
 
-	terms = strings.Split(term, " ")
+	fmt.Printf("Current Title: %s\n", entry.Title)
+	title := readInput(reader, "New Title")
 
-	err, entries = SearchDatabaseEntries(terms, "AND")
-	if err != nil || len(entries) == 0 {
-		fmt.Printf("Entry for query \"%s\" not found\n", term)
-		return err
-	} else {
-		var delim bool
-		var pcopy bool
+	fmt.Printf("Current Tags: %s\n", entry.Tags)
+	tags := readInput(reader, "New Tags")
 
-		if len(entries) == 1 {
-			delim = true
-			pcopy = true
-			// Single entry means copy password can be enabled
-		} else {
-			_, settings := GetOrCreateLocalConfig(APP)
-			fmt.Printf("%s", GetColor(strings.ToLower(settings.Color)))
-			PrintDelim(settings.Delim, settings.Color)
+	response := readInput(reader, "Edit body ? [y/N]")
+	if strings.ToLower(response) == "y" {
+		err, body = readMultiLineInput(reader, "New Body", entry.Notes)
+		if err != nil {
+			fmt.Printf("Error reading body - \"%s\"\n", err.Error())
+			return err
 		}
+		if body == entry.Notes {
+			body = ""
+		}
+	}
+
+	customEntries, flag = AddOrUpdateCustomFields(reader, entry)
+
+	err = UpdateDatabaseEntry(entry, title, "", "", "", tags, body, customEntries, flag)
+	if err != nil {
+		fmt.Printf("Error updating entry - \"%s\"\n", err.Error())
+	}
+
+	return err
+}
+
+// Text menu driven function to add a new entry
+func AddNewEntry() error {
+
+	var userName string
+	var title string
+	var url string
+	var notes string
+	var passwd string
+	var tags string
+	var otp string
+	var err error
+	var customEntries []CustomEntry
+
+	if err = checkActiveDatabase(); err != nil {
+		return err
+	}
+
+	if SettingsRider.Type == "card" {
+		return AddNewCardEntry()
+	} else if SettingsRider.Type == "identity" {
+		return AddNewIdentityEntry()
+	} else if SettingsRider.Type == "address" {
+		return AddNewAddressEntry()
+	} else if SettingsRider.Type == "note" {
+		return AddNewNoteEntry()
+	} else if SettingsRider.Type == "sshkey" {
+		return AddNewSSHKeyEntry()
+	}
+
+	reader := bufio.NewReader(os.Stdin)
+	title = readInput(reader, "Title")
+	url = readInput(reader, "URL")
+	if len(url) > 0 && !strings.HasPrefix(strings.ToLower(url), "http://") && !strings.HasPrefix(strings.ToLower(url), "https://") {
+		url = "http://" + url
+	}
+
+	userName = readInput(reader, "Username")
+
+	fmt.Printf("Password (enter to generate new): ")
+	err, passwd = ReadPassword()
+
+	if len(passwd) == 0 {
+		fmt.Printf("\nGenerating password ...")
+		err, passwd = GenerateStrongPassword()
+		fmt.Printf("done")
+	}
+	//  fmt.Printf("Password => %s\n", passwd)
+
+	tags = readInput(reader, "\nTags (separated by space): ")
+	notes = readInput(reader, "Notes")
+	otp = readInput(reader, "OTP secret or otpauth:// URI (enter to skip)")
+
+	// Title and username/password are mandatory
+	if len(title) == 0 {
+		fmt.Printf("Error - valid Title required\n")
+		return errors.New("invalid input")
+	}
+	if len(userName) == 0 {
+		fmt.Printf("Error - valid Username required\n")
+		return errors.New("invalid input")
+	}
+	if len(passwd) == 0 {
+		fmt.Printf("Error - valid Password required\n")
+		return errors.New("invalid input")
+	}
+	if len(otp) > 0 {
+		err, otp = NormalizeOtp(otp, title)
+		if err != nil {
+			fmt.Printf("Error - invalid OTP secret - %s\n", err.Error())
+			return err
+		}
+	}
+
+	customEntries = AddCustomFields(reader)
+
+	// Trim spaces
+	err = AddNewDatabaseEntry(title, userName, url, passwd, tags, notes, otp, customEntries)
+
+	if err != nil {
+		fmt.Printf("Error adding entry - \"%s\"\n", err.Error())
+	}
+
+	return err
+}
+
+// Function to update existing custom entries and add new ones
+// The bool part of the return value indicates whether to take action
+func AddOrUpdateCustomFields(reader *bufio.Reader, entry *Entry) ([]CustomEntry, bool) {
+
+	var customEntries []ExtendedEntry
+	var editedCustomEntries []CustomEntry
+	var newCustomEntries []CustomEntry
+	var flag bool
+
+	customEntries = GetExtendedEntries(entry)
+
+	if len(customEntries) > 0 {
+
+		fmt.Println("Editing/deleting custom fields")
+		for _, customEntry := range customEntries {
+			var fieldName string
+			var fieldValue string
+
+			fmt.Println("Field Name: " + customEntry.FieldName)
+			fieldName = readInput(reader, "\tNew Field Name (Enter to keep, \"x\" to delete)")
+			if strings.ToLower(strings.TrimSpace(fieldName)) == "x" {
+				fmt.Println("Deleting field: " + customEntry.FieldName)
+			} else {
+				if strings.TrimSpace(fieldName) == "" {
+					fieldName = customEntry.FieldName
+				}
+
+				fmt.Println("Field Value: " + customEntry.FieldValue)
+				fieldValue = readInput(reader, "\tNew Field Value (Enter to keep)")
+				if strings.TrimSpace(fieldValue) == "" {
+					fieldValue = customEntry.FieldValue
+				}
+
+				editedCustomEntries = append(editedCustomEntries, CustomEntry{fieldName, fieldValue})
+			}
+		}
+	}
+
+	newCustomEntries = AddCustomFields(reader)
+
+	editedCustomEntries = append(editedCustomEntries, newCustomEntries...)
+
+	// Cases where length == 0
+	// 1. Existing entries - all deleted
+	flag = len(customEntries) > 0 || len(editedCustomEntries) > 0
+
+	return editedCustomEntries, flag
+}
+
+// Function to add custom fields to an entry
+func AddCustomFields(reader *bufio.Reader) []CustomEntry {
+
+	// Custom fields
+	var custom string
+	var customEntries []CustomEntry
+
+	custom = readInput(reader, "Do you want to add custom fields [y/N]")
+	if strings.ToLower(custom) == "y" {
+
+		fmt.Println("Keep entering custom field name followed by the value. Press return with no input once done.")
+		for true {
+			var customFieldName string
+			var customFieldValue string
+
+			customFieldName = strings.TrimSpace(readInput(reader, "Field Name"))
+			if customFieldName != "" {
+				customFieldValue = strings.TrimSpace(readInput(reader, "Value for "+customFieldName))
+			}
+
+			if customFieldName == "" && customFieldValue == "" {
+				break
+			}
+
+			customEntries = append(customEntries, CustomEntry{customFieldName, customFieldValue})
+		}
+	}
+
+	return customEntries
+}
+
+// Edit a card entry by id
+func EditCurrentCardEntry(entry *Entry) error {
+	var klass string
+	var err error
+	var flag bool
+	var customEntries []CustomEntry
+
+	reader := bufio.NewReader(os.Stdin)
+
+	fmt.Printf("Card Title: %s\n", entry.Title)
+	title := readInput(reader, "New Card Title")
+	fmt.Printf("Name on Card: %s\n", entry.User)
+	name := readInput(reader, "New Name on Card")
+	fmt.Printf("Card Number: %s\n", entry.Url)
+	number := readInput(reader, "New Card Number")
+	if number != "" {
+		klass, err = DetectCardType(number)
+
+		if err != nil {
+			fmt.Printf("Error - %s\n", err.Error())
+			return err
+		} else {
+			fmt.Printf("<Card type is %s>\n", klass)
+		}
+	}
+
+	fmt.Printf("Card CVV: %s\n", entry.Password)
+	fmt.Printf("New Card CVV: ")
+	err, cvv := ReadPassword()
+
+	if cvv != "" && !ValidateCvv(cvv, klass) {
+		fmt.Printf("\nError - Invalid CVV for %s\n", klass)
+		return errors.New(fmt.Sprintf("Error - Invalid CVV for %s\n", klass))
+	}
+	fmt.Printf("\nCard PIN: %s\n", entry.Pin)
+	fmt.Printf("New Card PIN: ")
+	err, pin := ReadPassword()
+
+	if pin != "" && !ValidateCardPin(pin) {
+		fmt.Printf("\n<Warning - Empty PIN!>")
+	}
+	fmt.Printf("\nCard Expiry Date: %s\n", entry.ExpiryDate)
+	expiryDate := readInput(reader, "New Card Expiry Date (as mm/dd): ")
+	// expiry has to be in the form of <month>/<year>
+	if expiryDate != "" && !CheckValidExpiry(expiryDate) {
+		return errors.New("Invalid Expiry Date")
+	}
+	tags := readInput(reader, "\nTags (separated by space): ")
+	notes := readInput(reader, "Notes")
+
+	customEntries, flag = AddOrUpdateCustomFields(reader, entry)
+
+	// Update
+	err = UpdateDatabaseCardEntry(entry, title, number, name,
+		klass, cvv, pin, expiryDate, notes, tags, customEntries, flag)
+
+	if err != nil {
+		fmt.Printf("Error adding entry - \"%s\"\n", err.Error())
+	}
+
+	return nil
+}
+
+// Edit a current entry by id
+func EditCurrentEntry(idString string) error {
+
+	var userName string
+	var title string
+	var url string
+	var notes string
+	var tags string
+	var passwd string
+	var err error
+	var entry *Entry
+	var id int
+
+	if err = checkActiveDatabase(); err != nil {
+		return err
+	}
+
+	id, _ = strconv.Atoi(idString)
+
+	err, entry = GetEntryById(id)
+	if err != nil || entry == nil {
+		fmt.Printf("No entry found for id %d\n", id)
+		return err
+	}
+
+	if entry.Type == "card" {
+		return EditCurrentCardEntry(entry)
+	} else if entry.Type == "identity" {
+		return EditCurrentIdentityEntry(entry)
+	} else if entry.Type == "address" {
+		return EditCurrentAddressEntry(entry)
+	} else if entry.Type == "note" {
+		return EditCurrentNoteEntry(entry)
+	} else if entry.Type == "sshkey" {
+		return EditCurrentSSHKeyEntry(entry)
+	}
+
+	reader := bufio.NewReader(os.Stdin)
+
+	fmt.Printf("Current Title: %s\n", entry.Title)
+	title = readInput(reader, "New Title")
+
+	fmt.Printf("Current URL: %s\n", entry.Url)
+	url = readInput(reader, "New URL")
+
+	if len(url) > 0 && !strings.HasPrefix(strings.ToLower(url), "http://") && !strings.HasPrefix(strings.ToLower(url), "https://") {
+		url = "http://" + url
+	}
+
+	fmt.Printf("Current Username: %s\n", entry.User)
+	userName = readInput(reader, "New Username")
+
+	fmt.Printf("Current Password: %s\n", entry.Password)
+	fmt.Printf("New Password ([y/Y] to generate new, enter will keep old one): ")
+	err, passwd = ReadPassword()
+
+	if strings.ToLower(passwd) == "y" {
+		fmt.Printf("\nGenerating new password ...")
+		err, passwd = GenerateStrongPassword()
+	}
+	//  fmt.Printf("Password => %s\n", passwd)
+
+	fmt.Printf("\nCurrent Tags: %s\n", entry.Tags)
+	tags = readInput(reader, "New Tags")
+
+	fmt.Printf("\nCurrent Notes: %s\n", entry.Notes)
+	notes = readInput(reader, "New Notes")
+
+	if entry.Otp != "" {
+		_, params := ParseOtp(entry.Otp)
+		if params != nil {
+			fmt.Printf("\nCurrent OTP: %s\n", params.Describe())
+		}
+	}
+	otp := readInput(reader, "New OTP secret or otpauth:// URI (enter to keep, - to remove)")
+
+	customEntries, flag := AddOrUpdateCustomFields(reader, entry)
+
+	// Update
+	err = UpdateDatabaseEntry(entry, title, userName, url, passwd, tags, notes, customEntries, flag)
+	if err != nil {
+		fmt.Printf("Error updating entry - \"%s\"\n", err.Error())
+		return err
+	}
+
+	if otp == "-" {
+		otp = ""
+	} else if otp == "" {
+		return nil
+	}
+
+	err = SetEntryOtp(entry, otp)
+	if err != nil {
+		fmt.Printf("Error updating OTP - \"%s\"\n", err.Error())
+	}
+
+	return err
+}
+
+// List current entry by id
+func ListCurrentEntry(idString string) error {
+
+	var id int
+	var err error
+	var entry *Entry
+
+	if err = checkActiveDatabase(); err != nil {
+		return err
+	}
+
+	id, _ = strconv.Atoi(idString)
+
+	//  fmt.Printf("Listing current entry - %d\n", id)
+	err, entry = GetEntryById(id)
+	if err != nil || entry == nil {
+		fmt.Printf("No entry found for id %d\n", id)
+		return err
+	}
+
+	err = PrintEntry(entry, true)
+
+	if err == nil && SettingsRider.CopyPassword {
+		//      fmt.Printf("Copying password " + entry.Password + " to clipboard\n")
+		CopyPasswordToClipboard(entry.Password)
+	}
+
+	return err
+}
+
+// List all entries
+func ListAllEntries() error {
+
+	var err error
+	var maxKrypt bool
+	var defaultDB string
+	var reEncrypt VoidFunc
+
+	maxKrypt, defaultDB = isActiveDatabaseEncryptedAndMaxKryptOn()
+
+	// If max krypt on - then autodecrypt on call and auto encrypt after call
+	if maxKrypt {
+		err, reEncrypt = unlockForAction(defaultDB)
+		if err != nil {
+			return err
+		}
+	}
+
+	if err = checkActiveDatabase(); err != nil {
+		return err
+	}
+
+	err, settings := GetOrCreateLocalConfig(APP)
+
+	if err != nil {
+		fmt.Printf("Error parsing config - \"%s\"\n", err.Error())
+		return err
+	}
+
+	// Order is optional, as in "title"
+	orderKeys := append(strings.SplitN(settings.ListOrder, ",", 2), "")
+	err, entries := IterateEntries(orderKeys[0], orderKeys[1])
+
+	if err == nil {
+		err, entries = filterEntriesByGroupSetting(entries)
+	}
+
+	if err == nil {
+		if len(entries) > 0 {
+			// Entries of a group are listed together under its path
+			SortEntriesByGroup(entries)
+			groupHeaders := hasGroups(entries)
+
+			fmt.Printf("%s", GetColor(strings.ToLower(settings.Color)))
+			PrintDelim(settings.Delim, settings.Color)
+			for idx, entry := range entries {
+				if groupHeaders && (idx == 0 || entries[idx-1].GroupID != entry.GroupID) {
+					fmt.Printf("[Group: /%s]\n", GetGroupPath(entry.GroupID))
+					PrintDelim(settings.Delim, settings.Color)
+				}
+				PrintEntry(&entry, false)
+			}
+		} else {
+			fmt.Println("No entries.")
+		}
+	} else {
+		fmt.Printf("Error fetching entries: \"%s\"\n", err.Error())
+		return err
+	}
+
+	// If max krypt on - then autodecrypt on call and auto encrypt after call
+	if maxKrypt {
+		err = reEncrypt()
+	}
+
+	return err
+}
+
+// Find current entry by term - prints all matches
+func FindCurrentEntry(term string) error {
+
+	var err error
+	var entries []Entry
+
+	if err = checkActiveDatabase(); err != nil {
+		return err
+	}
+
+	err, entries = SearchEntries(term)
+	if err != nil {
+		fmt.Printf("Error - %s\n", err.Error())
+		return err
+	}
+
+	err, entries = filterEntriesByGroupSetting(entries)
+
+	if err != nil || len(entries) == 0 {
+		fmt.Printf("Entry for query \"%s\" not found\n", term)
+		return err
+	} else {
+		var delim bool
+		var pcopy bool
+
+		if len(entries) == 1 {
+			delim = true
+			pcopy = true
+			// Single entry means copy password can be enabled
+		} else {
+			_, settings := GetOrCreateLocalConfig(APP)
+			fmt.Printf("%s", GetColor(strings.ToLower(settings.Color)))
+			PrintDelim(settings.Delim, settings.Color)
+		}
+
+		for _, entry := range entries {
+			PrintEntry(&entry, delim)
+		}
+
+		if pcopy && SettingsRider.CopyPassword {
+			// Single entry
+			CopyPasswordToClipboard(entries[0].Password)
+		}
+	}
+
+	return err
+}
+
+// Print the current one-time code of an entry by id
+func ShowOtpCode(idString string) error {
+
+	var err error
+	var entry *Entry
+	var id int
+	var code string
+	var remaining time.Duration
+
+	if err = checkActiveDatabase(); err != nil {
+		return err
+	}
+
+	id, _ = strconv.Atoi(idString)
+
+	err, entry = GetEntryById(id)
+	if err != nil || entry == nil {
+		fmt.Printf("No entry found for id %d\n", id)
+		return err
+	}
+
+	err, code, remaining = GetEntryOtpCode(entry)
+	if err != nil {
+		fmt.Printf("Error - %s\n", err.Error())
+		return err
+	}
+
+	if remaining > 0 {
+		fmt.Printf("%s (valid for %ds)\n", code, int(remaining.Seconds()+0.5))
+	} else {
+		fmt.Printf("%s\n", code)
+	}
+
+	if SettingsRider.CopyPassword {
+		CopyPasswordToClipboard(code)
+	}
+
+	return nil
+}
+
+// Return a readable label for an entry field given its column name
+func fieldLabel(entry *Entry, column string) string {
+
+	labels := map[string]string{
+		"title":        "Title",
+		"user":         "User",
+		"url":          "URL",
+		"password":     "Password",
+		"pin":          "PIN",
+		"expiry_date":  "Expiry Date",
+		"issuer":       "Issuer",
+		"class":        "Class",
+		"notes":        "Notes",
+		"tags":         "Tags",
+		"otp":          "OTP",
+		"first_name":   "First Name",
+		"middle_name":  "Middle Name",
+		"last_name":    "Last Name",
+		"email":        "Email",
+		"phone_number": "Phone Number",
+		"company":      "Company",
+		"number":       "Document Number",
+		"private_key":  "Private Key",
+		"public_key":   "Public Key",
+	}
+
+	if entry.Type == "card" {
+		labels["title"] = "Card Name"
+		labels["user"] = "Card Holder"
+		labels["url"] = "Card Number"
+		labels["password"] = "Card CVV"
+		labels["pin"] = "Card PIN"
+		labels["issuer"] = "Issuing Bank"
+		labels["class"] = "Card Type"
+	} else if entry.Type == "identity" {
+		labels["user"] = "Name"
+		labels["issuer"] = "Issued By"
+		labels["class"] = "Document Type"
+	} else if entry.Type == "note" {
+		labels["notes"] = "Body"
+	} else if entry.Type == "sshkey" {
+		labels["user"] = "Comment"
+		labels["password"] = "Passphrase"
+		labels["class"] = "Key Type"
+	}
+
+	if label, ok := labels[column]; ok {
+		return label
+	}
+
+	return column
+}
+
+// Format the old value of a revision for display, hiding secrets
+func revisionValue(entry *Entry, rev *Revision) string {
+
+	if rev.Absent {
+		return "<not set>"
+	}
+
+	if !rev.Custom {
+		_, settings := GetOrCreateLocalConfig(APP)
+		shown := settings.ShowPasswords || SettingsRider.ShowPasswords
+
+		if (rev.FieldName == "password" || rev.FieldName == "pin" || rev.FieldName == "otp" || rev.FieldName == "number") && !shown {
+			return HideSecret(rev.OldValue)
+		}
+		if ((entry.Type == "note" && rev.FieldName == "notes") || rev.FieldName == "private_key") && !shown {
+			return HideNote(rev.OldValue)
+		}
+	}
+
+	return rev.OldValue
+}
+
+// List the revision history of an entry by id
+func ShowEntryHistory(idString string) error {
+
+	var err error
+	var entry *Entry
+	var id int
+	var revisions []Revision
+
+	if err = checkActiveDatabase(); err != nil {
+		return err
+	}
+
+	id, _ = strconv.Atoi(idString)
+
+	err, entry = GetEntryById(id)
+	if err != nil || entry == nil {
+		fmt.Printf("No entry found for id %d\n", id)
+		return err
+	}
+
+	err, revisions = GetEntryRevisions(entry)
+	if err != nil {
+		fmt.Printf("Error fetching history - \"%s\"\n", err.Error())
+		return err
+	}
+
+	if len(revisions) == 0 {
+		fmt.Printf("No history for entry %d.\n", id)
+		return nil
+	}
+
+	fmt.Printf("History of entry %d - %s (previous values)\n", id, entry.Title)
+
+	for idx, rev := range revisions {
+		if idx == 0 || revisions[idx-1].Revision != rev.Revision {
+			fmt.Printf("\nRevision %d - %s\n", rev.Revision, rev.Timestamp.Format("2006-01-02 15:04:05"))
+		}
+
+		if rev.Custom {
+			fmt.Printf("\t%s (custom): %s\n", rev.FieldName, revisionValue(entry, &rev))
+		} else {
+			fmt.Printf("\t%s: %s\n", fieldLabel(entry, rev.FieldName), revisionValue(entry, &rev))
+		}
+	}
+
+	fmt.Printf("\nUse --restore %d@<revision> to roll back to before a revision.\n", id)
+
+	return nil
+}
+
+// Restore an entry - from the trash given <id>, or to before a revision given <id>@<revision>
+func RestoreEntry(spec string) error {
+
+	var err error
+	var id int
+
+	if strings.Contains(spec, "@") {
+		return RestoreEntryRevision(spec)
+	}
+
+	if err = checkActiveDatabase(); err != nil {
+		return err
+	}
+
+	id, err = strconv.Atoi(spec)
+	if err != nil {
+		fmt.Printf("Error - invalid id \"%s\"\n", spec)
+		return err
+	}
+
+	err = RestoreTrashedEntry(id)
+	if err != nil {
+		fmt.Printf("Error restoring entry - \"%s\"\n", err.Error())
+		return err
+	}
+
+	fmt.Printf("Entry with id %d was restored from the trash\n", id)
+	return nil
+}
+
+// List entries in the trash
+func ListTrashedEntries() error {
+
+	var err error
+	var entries []Entry
+
+	if err = checkActiveDatabase(); err != nil {
+		return err
+	}
+
+	err, entries = GetTrashedEntries()
+	if err != nil {
+		fmt.Printf("Error fetching trash: \"%s\"\n", err.Error())
+		return err
+	}
+
+	if len(entries) == 0 {
+		fmt.Println("Trash is empty.")
+		return nil
+	}
+
+	_, settings := GetOrCreateLocalConfig(APP)
+
+	fmt.Printf("%s", GetColor(strings.ToLower(settings.Color)))
+	PrintDelim(settings.Delim, settings.Color)
+	for _, entry := range entries {
+		fmt.Printf("ID: %d\n", entry.ID)
+		fmt.Printf("Title: %s\n", entry.Title)
+		fmt.Printf("User: %s\n", entry.User)
+		fmt.Printf("Deleted: %s\n", entry.DeletedAt.Time.Format("2006-01-02 15:04:05"))
+		PrintDelim(settings.Delim, settings.Color)
+	}
+	fmt.Printf("%s", GetColor("default"))
+
+	return nil
+}
+
+// Parse an age such as 30d, 12h or 90m
+func parseAge(age string) (time.Duration, error) {
+
+	if strings.HasSuffix(age, "d") {
+		days, err := strconv.Atoi(strings.TrimSuffix(age, "d"))
+		if err != nil || days < 0 {
+			return 0, fmt.Errorf("invalid age \"%s\"", age)
+		}
+		return time.Duration(days) * 24 * time.Hour, nil
+	}
+
+	return time.ParseDuration(age)
+}
+
+// Permanently delete entries in the trash, optionally only those older than --older-than
+func PurgeTrash() error {
+
+	var err error
+	var olderThan time.Duration
+	var count int
+	var response string
+	var prompt string
+
+	if err = checkActiveDatabase(); err != nil {
+		return err
+	}
+
+	if SettingsRider.PurgeOlderThan != "" {
+		olderThan, err = parseAge(SettingsRider.PurgeOlderThan)
+		if err != nil {
+			fmt.Printf("Error - invalid age \"%s\" (use e.g 30d, 12h)\n", SettingsRider.PurgeOlderThan)
+			return err
+		}
+		prompt = fmt.Sprintf("Permanently delete entries trashed more than %s ago [y/N]", SettingsRider.PurgeOlderThan)
+	} else {
+		prompt = "Permanently delete all entries in the trash [y/N]"
+	}
+
+	if !SettingsRider.AssumeYes {
+		response = readInput(bufio.NewReader(os.Stdin), prompt)
+	} else {
+		response = "y"
+	}
+
+	if strings.ToLower(response) != "y" {
+		fmt.Println("Purge cancelled by user.")
+		return nil
+	}
+
+	err, count = PurgeTrashedEntries(olderThan)
+	if err != nil {
+		fmt.Printf("Error purging trash - \"%s\"\n", err.Error())
+		return err
+	}
+
+	fmt.Printf("Purged %d entries from the trash.\n", count)
+	return nil
+}
+
+// Roll back an entry to before a revision given as <id>@<revision>
+func RestoreEntryRevision(spec string) error {
+
+	var err error
+	var entry *Entry
+	var id, revision int
+	var pieces []string
+	var response string
+
+	if err = checkActiveDatabase(); err != nil {
+		return err
+	}
+
+	pieces = strings.Split(spec, "@")
+	if len(pieces) == 2 {
+		id, err = strconv.Atoi(pieces[0])
+		if err == nil {
+			revision, err = strconv.Atoi(pieces[1])
+		}
+	}
+
+	if len(pieces) != 2 || err != nil {
+		fmt.Printf("Error - invalid revision \"%s\", use <id>@<revision>\n", spec)
+		return errors.New("invalid revision - " + spec)
+	}
+
+	err, entry = GetEntryById(id)
+	if err != nil || entry == nil {
+		fmt.Printf("No entry found for id %d\n", id)
+		return err
+	}
+
+	if !SettingsRider.AssumeYes {
+		response = readInput(bufio.NewReader(os.Stdin), fmt.Sprintf("Roll back entry %d to before revision %d [Y/n]", id, revision))
+	} else {
+		response = "y"
+	}
+
+	if strings.ToLower(response) == "n" {
+		fmt.Println("Restore cancelled by user.")
+		return nil
+	}
+
+	err = RestoreEntryToRevision(entry, revision)
+	if err != nil {
+		fmt.Printf("Error restoring entry - \"%s\"\n", err.Error())
+		return err
+	}
+
+	fmt.Printf("Entry %d restored to before revision %d.\n", id, revision)
+	return nil
+}
+
+// Create a group, along with any missing parents, e.g work/prod
+func MakeGroup(path string) error {
+
+	var err error
+	var group *Group
+	var created bool
+
+	if err = checkActiveDatabase(); err != nil {
+		return err
+	}
+
+	err, group, created = MakeGroupPath(path)
+	if err != nil {
+		fmt.Printf("Error creating group - \"%s\"\n", err.Error())
+		return err
+	}
+
+	if created {
+		fmt.Printf("Created group %s with id %d\n", GetGroupPath(group.ID), group.ID)
+	} else {
+		fmt.Printf("Group %s exists\n", GetGroupPath(group.ID))
+	}
+
+	return nil
+}
+
+// Move an entry to a group - spec is "<id> <group>", use / for top level
+func MoveEntry(spec string) error {
+
+	var err error
+	var entry *Entry
+	var path string
+
+	if err = checkActiveDatabase(); err != nil {
+		return err
+	}
+
+	err, entry, path = parseIdAndArgument(spec, "--mv <id> <group>")
+	if err != nil {
+		return err
+	}
+
+	err = MoveEntryToGroup(entry, path)
+	if err != nil {
+		fmt.Printf("Error moving entry - \"%s\"\n", err.Error())
+		return err
+	}
+
+	fmt.Printf("Entry %d moved to %s\n", entry.ID, "/"+GetGroupPath(entry.GroupID))
+	return nil
+}
+
+// Print a tree of groups and entries, indenting each level
+func printGroupTree(node *GroupNode, depth int) {
+
+	indent := strings.Repeat("  ", depth)
+
+	for _, child := range node.Children {
+		fmt.Printf("%s%s/\n", indent, child.Group.Name)
+		printGroupTree(child, depth+1)
+	}
+
+	for _, entry := range node.Entries {
+		if entry.User != "" {
+			fmt.Printf("%s%d: %s (%s)\n", indent, entry.ID, entry.Title, entry.User)
+		} else {
+			fmt.Printf("%s%d: %s\n", indent, entry.ID, entry.Title)
+		}
+	}
+}
+
+// List the groups and entries below a group, use / for top level
+func ListGroup(path string) error {
+
+	var err error
+	var tree *GroupNode
+
+	if err = checkActiveDatabase(); err != nil {
+		return err
+	}
+
+	err, tree = GetGroupTree(path)
+	if err != nil {
+		fmt.Printf("Error listing group - \"%s\"\n", err.Error())
+		return err
+	}
+
+	if len(tree.Children) == 0 && len(tree.Entries) == 0 {
+		fmt.Println("No entries.")
+		return nil
+	}
+
+	fmt.Printf("/%s\n", GetGroupPath(tree.Group.ID))
+	printGroupTree(tree, 1)
+
+	return nil
+}
+
+// Return true if any of the entries is in a group
+func hasGroups(entries []Entry) bool {
+
+	for _, entry := range entries {
+		if entry.GroupID != 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Keep entries in the subtree of the --group setting, if given
+func filterEntriesByGroupSetting(entries []Entry) (error, []Entry) {
+
+	if SettingsRider.Group == "" {
+		return nil, entries
+	}
+
+	err, groupIds := GetSubtreeGroupIds(SettingsRider.Group)
+	if err != nil {
+		fmt.Printf("Error - %s\n", err.Error())
+		return err, nil
+	}
+
+	return nil, FilterEntriesByGroups(entries, groupIds)
+}
+
+// List all tags with the number of entries having each
+func ListAllTags() error {
+
+	var err error
+	var counts []TagCount
+
+	if err = checkActiveDatabase(); err != nil {
+		return err
+	}
+
+	err, counts = GetTagCounts()
+	if err != nil {
+		fmt.Printf("Error fetching tags: \"%s\"\n", err.Error())
+		return err
+	}
+
+	if len(counts) == 0 {
+		fmt.Println("No tags.")
+		return nil
+	}
+
+	for _, count := range counts {
+		fmt.Printf("%-24s %d\n", count.Name, count.Count)
+	}
+
+	return nil
+}
+
+// Rename a tag - spec is "<old> <new>"
+func RenameEntryTag(spec string) error {
+
+	var err error
+	var count int
+
+	if err = checkActiveDatabase(); err != nil {
+		return err
+	}
+
+	fields := strings.Fields(spec)
+	if len(fields) != 2 {
+		fmt.Println("Usage: --tag-rename <old> <new>")
+		return errors.New("invalid tag rename - " + spec)
+	}
+
+	err, count = RenameTag(fields[0], fields[1])
+	if err != nil {
+		fmt.Printf("Error renaming tag - \"%s\"\n", err.Error())
+		return err
+	}
+
+	fmt.Printf("Renamed tag %s to %s in %d entries\n", fields[0], fields[1], count)
+	return nil
+}
+
+// Merge tags into the last one - spec is "<tag1> <tag2> ... <into>"
+func MergeEntryTags(spec string) error {
+
+	var err error
+	var count int
+
+	if err = checkActiveDatabase(); err != nil {
+		return err
+	}
+
+	fields := strings.Fields(spec)
+	if len(fields) < 2 {
+		fmt.Println("Usage: --tag-merge <tag1> <tag2> ... <into>")
+		return errors.New("invalid tag merge - " + spec)
+	}
+
+	target := fields[len(fields)-1]
+
+	err, count = MergeTags(fields[:len(fields)-1], target)
+	if err != nil {
+		fmt.Printf("Error merging tags - \"%s\"\n", err.Error())
+		return err
+	}
+
+	fmt.Printf("Merged tags %s into %s in %d entries\n", strings.Join(fields[:len(fields)-1], ", "), target, count)
+	return nil
+}
+
+// Split "<id> <rest>" arguments of list options
+func parseIdAndArgument(spec, usage string) (error, *Entry, string) {
+
+	var err error
+	var entry *Entry
+	var id int
+
+	fields := strings.SplitN(strings.TrimSpace(spec), " ", 2)
+	if len(fields) != 2 || strings.TrimSpace(fields[1]) == "" {
+		fmt.Printf("Usage: %s\n", usage)
+		return errors.New("invalid arguments - " + spec), nil, ""
+	}
+
+	id, err = strconv.Atoi(fields[0])
+	if err != nil {
+		fmt.Printf("Invalid entry id - %s\n", fields[0])
+		return err, nil, ""
+	}
+
+	err, entry = GetEntryById(id)
+	if err != nil || entry == nil {
+		fmt.Printf("No entry with id %d was found\n", id)
+		return errors.New("no such entry"), nil, ""
+	}
+
+	return nil, entry, strings.TrimSpace(fields[1])
+}
+
+// Attach a file to an entry - spec is "<id> <file>"
+func AttachFile(spec string) error {
+
+	var err error
+	var entry *Entry
+	var path string
+	var attachment *Attachment
+
+	if err = checkActiveDatabase(); err != nil {
+		return err
+	}
+
+	err, entry, path = parseIdAndArgument(spec, "--attach <id> <file>")
+	if err != nil {
+		return err
+	}
+
+	err, attachment = AddAttachment(entry, path, SettingsRider.AssumeYes)
+	if err != nil {
+		fmt.Printf("Error attaching file - \"%s\"\n", err.Error())
+		if strings.Contains(err.Error(), "already has") {
+			fmt.Println("Use -y to replace it.")
+		}
+		return err
+	}
+
+	fmt.Printf("Attached %s (%s, %s) to entry %d\n", attachment.Name, attachment.ContentType,
+		FormatSize(attachment.Size), entry.ID)
+	return nil
+}
+
+// List the attachments of an entry by id
+func ListAttachments(idString string) error {
+
+	var err error
+	var entry *Entry
+	var attachments []Attachment
+	var id int
+
+	if err = checkActiveDatabase(); err != nil {
+		return err
+	}
+
+	id, _ = strconv.Atoi(idString)
+
+	err, entry = GetEntryById(id)
+	if err != nil || entry == nil {
+		fmt.Printf("No entry found for id %d\n", id)
+		return err
+	}
+
+	err, attachments = GetAttachments(entry)
+	if err != nil {
+		fmt.Printf("Error fetching attachments: \"%s\"\n", err.Error())
+		return err
+	}
+
+	if len(attachments) == 0 {
+		fmt.Printf("Entry %d has no attachments.\n", id)
+		return nil
+	}
+
+	for _, attachment := range attachments {
+		fmt.Printf("%-32s %10s  %-24s %s\n", attachment.Name, FormatSize(attachment.Size),
+			attachment.ContentType, attachment.Timestamp.Format("2006-01-02 15:04:05"))
+	}
+
+	return nil
+}
+
+// Write an attachment of an entry to a file - spec is "<id> <name>". The
+// file is written to the current directory unless -o is given.
+func ExtractAttachment(spec string) error {
+
+	var err error
+	var entry *Entry
+	var name string
+	var attachment *Attachment
+
+	if err = checkActiveDatabase(); err != nil {
+		return err
+	}
+
+	err, entry, name = parseIdAndArgument(spec, "--extract <id> <name> [-o <path>]")
+	if err != nil {
+		return err
+	}
+
+	err, attachment = GetAttachment(entry, name)
+	if err != nil {
+		fmt.Printf("Error - %s\n", err.Error())
+		return err
+	}
 
-		for _, entry := range entries {
-			PrintEntry(&entry, delim)
+	outPath := SettingsRider.OutputPath
+	if info, err := os.Stat(outPath); outPath == "" || (err == nil && info.IsDir()) {
+		// Names stored by older imports may be paths
+		if !isAttachmentBaseName(attachment.Name) {
+			err = fmt.Errorf("attachment name %q is not a file name - give the file to write with -o", attachment.Name)
+			fmt.Printf("Error - %s\n", err.Error())
+			return err
 		}
+		outPath = filepath.Join(outPath, attachment.Name)
+	}
 
-		if pcopy && SettingsRider.CopyPassword {
-			// Single entry
-			CopyPasswordToClipboard(entries[0].Password)
-		}
+	if _, err = os.Stat(outPath); err == nil && !SettingsRider.AssumeYes {
+		fmt.Printf("Error - %s exists, use -y to overwrite it\n", outPath)
+		return os.ErrExist
 	}
 
-	return err
+	err = WriteFileAtomic(outPath, attachment.Data, 0600)
+	if err != nil {
+		fmt.Printf("Error writing attachment - \"%s\"\n", err.Error())
+		return err
+	}
+
+	fmt.Printf("Extracted %s to %s\n", attachment.Name, outPath)
+	return nil
 }
 
 // Remove a range of entries <id1>-<id2> say 10-14
@@ -770,10 +2315,10 @@ func RemoveCurrentEntry(idString string) error {
 	}
 
 	if strings.ToLower(response) != "n" {
-		// Drop from the database
+		// Move to the trash
 		err = RemoveDatabaseEntry(entry)
 		if err == nil {
-			fmt.Printf("Entry with id %d was removed from the database\n", id)
+			fmt.Printf("Entry with id %d was moved to the trash (--restore %d to undo)\n", id, id)
 		}
 	} else {
 		fmt.Println("Removal of entry cancelled by user.")
@@ -822,6 +2367,17 @@ func CopyCurrentEntry(idString string) error {
 		}
 	}
 
+	err = CloneAttachments(entry, entryNew)
+	if err != nil {
+		fmt.Printf("Error cloning attachments: \"%s\"\n", err.Error())
+		return err
+	}
+
+	err = CloneAddresses(entryNew, GetAddresses(entry))
+	if err != nil {
+		fmt.Printf("Error cloning addresses: \"%s\"\n", err.Error())
+	}
+
 	return err
 }
 
@@ -844,12 +2400,136 @@ func EncryptActiveDatabase() error {
 	return EncryptDatabase(dbPath, nil)
 }
 
+// Read a new password twice from the console and verify they match
+func readNewPassword(prompt string) (error, string) {
+
+	var err error
+	var passwd string
+	var passwd2 string
+
+	fmt.Printf("%s: ", prompt)
+	err, passwd = ReadPassword()
+
+	if err == nil {
+		fmt.Printf("\n%s again: ", prompt)
+		err, passwd2 = ReadPassword()
+		if err == nil {
+			if passwd != passwd2 {
+				fmt.Println("\nPassword mismatch.")
+				return errors.New("mismatched passwords"), ""
+			}
+		}
+	}
+
+	if err != nil {
+		fmt.Printf("Error reading password - \"%s\"\n", err.Error())
+		return err, ""
+	}
+
+	return nil, passwd
+}
+
+// Return the cipher to use for encryption - command line override or config
+func getEncryptionCipher() uint8 {
+
+	var cipherId uint8
+
+	if SettingsRider.Cipher != "" {
+		cipherId = CipherIdFromName(SettingsRider.Cipher)
+	} else {
+		_, settings := GetOrCreateLocalConfig(APP)
+		cipherId = CipherIdFromName(settings.Cipher)
+	}
+
+	if cipherId == CIPHER_UNKNOWN {
+		fmt.Println("No cipher set, defaulting to AES")
+		cipherId = CIPHER_AES
+	}
+
+	return cipherId
+}
+
+// Return the hash of the keyfile given on the command line, if any
+func getKeyFileHash() (error, []byte) {
+
+	var err error
+	var hash []byte
+
+	if SettingsRider.KeyFile == "" {
+		return nil, nil
+	}
+
+	err, hash = HashKeyFile(SettingsRider.KeyFile)
+	if err != nil {
+		fmt.Printf("Error - %s\n", err.Error())
+	}
+
+	return err, hash
+}
+
+// Check that a keyfile is given if the encrypted database requires one
+func checkKeyFile(dbPath string) error {
+
+	err, header := ReadFileHeader(dbPath)
+	if err != nil {
+		fmt.Printf("Error reading header - %s: %s\n", dbPath, err.Error())
+		return err
+	}
+
+	if header.RequiresKeyFile() && SettingsRider.KeyFile == "" {
+		fmt.Printf("Database %s requires a keyfile - use --keyfile <path>\n", dbPath)
+		return errors.New("keyfile required")
+	}
+
+	return nil
+}
+
+// Return the key derivation parameters for encryption from config.
+// Unset values fall back to the defaults.
+func getKdfParams() *KdfParams {
+
+	var params *KdfParams
+
+	params = DefaultKdfParams()
+	_, settings := GetOrCreateLocalConfig(APP)
+
+	if settings == nil {
+		return params
+	}
+
+	if kdf := KdfIdFromName(settings.Kdf); kdf != 0 {
+		params.Kdf = kdf
+	}
+	if settings.KdfTime > 0 {
+		params.Time = settings.KdfTime
+	}
+	if settings.KdfMemory > 0 {
+		params.Memory = settings.KdfMemory
+	}
+	if settings.KdfThreads > 0 {
+		params.Threads = settings.KdfThreads
+	}
+
+	return params
+}
+
 // Encrypt the database using AES
 func EncryptDatabase(dbPath string, givenPasswd *string) error {
 
 	var err error
 	var passwd string
-	var passwd2 string
+	var keyFileHash []byte
+	var unlock VoidFunc
+
+	if err, keyFileHash = getKeyFileHash(); err != nil {
+		return err
+	}
+
+	if err, unlock = lockForAction(dbPath); err != nil {
+		return err
+	}
+
+	defer unlock()
 
 	// If password is given, use it
 	if givenPasswd != nil {
@@ -857,38 +2537,13 @@ func EncryptDatabase(dbPath string, givenPasswd *string) error {
 	}
 
 	if len(passwd) == 0 {
-		fmt.Printf("Encryption Password: ")
-		err, passwd = ReadPassword()
-
-		if err == nil {
-			fmt.Printf("\nEncryption Password again: ")
-			err, passwd2 = ReadPassword()
-			if err == nil {
-				if passwd != passwd2 {
-					fmt.Println("\nPassword mismatch.")
-					return errors.New("mismatched passwords")
-				}
-			}
-		}
-
+		err, passwd = readNewPassword("Encryption Password")
 		if err != nil {
-			fmt.Printf("Error reading password - \"%s\"\n", err.Error())
 			return err
 		}
 	}
 
-	//  err = EncryptFileAES(dbPath, passwd)
-	_, settings := GetOrCreateLocalConfig(APP)
-
-	switch settings.Cipher {
-	case "aes":
-		err = EncryptFileAES(dbPath, passwd)
-	case "xchacha", "chacha", "xchachapoly":
-		err = EncryptFileXChachaPoly(dbPath, passwd)
-	default:
-		fmt.Println("No cipher set, defaulting to AES")
-		err = EncryptFileAES(dbPath, passwd)
-	}
+	err = EncryptFile(dbPath, passwd, keyFileHash, getEncryptionCipher(), getKdfParams())
 
 	if err == nil {
 		fmt.Println("\nEncryption complete.")
@@ -897,43 +2552,260 @@ func EncryptDatabase(dbPath string, givenPasswd *string) error {
 	return err
 }
 
+// Read the password (and keyfile, if required) to decrypt a database
+func readDecryptionPassword(dbPath string) (error, string, []byte) {
+
+	var err error
+	var passwd string
+	var keyFileHash []byte
+
+	if err = checkKeyFile(dbPath); err != nil {
+		return err, "", nil
+	}
+
+	if err, keyFileHash = getKeyFileHash(); err != nil {
+		return err, "", nil
+	}
+
+	fmt.Printf("Decryption Password: ")
+	err, passwd = ReadPassword()
+
+	if err != nil {
+		fmt.Printf("\nError reading password - \"%s\"\n", err.Error())
+		return err, "", nil
+	}
+
+	fmt.Println()
+	return nil, passwd, keyFileHash
+}
+
 // Decrypt an encrypted database
 func DecryptDatabase(dbPath string) (error, string) {
 
 	var err error
 	var passwd string
 	var flag bool
+	var keyFileHash []byte
+	var unlock VoidFunc
 
 	if err, flag = IsFileEncrypted(dbPath); !flag {
 		fmt.Println(err.Error())
 		return err, ""
 	}
 
+	if err, unlock = lockForAction(dbPath); err != nil {
+		return err, ""
+	}
+
+	defer unlock()
+
+	err, passwd, keyFileHash = readDecryptionPassword(dbPath)
+	if err != nil {
+		return err, ""
+	}
+
+	// Cipher and KDF parameters are read from the file header
+	err = DecryptFile(dbPath, passwd, keyFileHash)
+
+	if err == nil {
+		fmt.Println("...decryption complete.")
+	}
+
+	return err, passwd
+}
+
+// Upgrade an encrypted database to the latest container format
+func UpgradeDatabaseFormat(dbPath string) error {
+
+	var err error
+	var flag bool
+	var passwd string
+	var header *FileHeader
+	var keyFileHash []byte
+	var unlock VoidFunc
+
+	if err, flag = IsFileEncrypted(dbPath); !flag {
+		fmt.Println(err.Error())
+		return err
+	}
+
+	if err, unlock = lockForAction(dbPath); err != nil {
+		return err
+	}
+
+	defer unlock()
+
+	err, header = ReadFileHeader(dbPath)
+	if err != nil {
+		fmt.Printf("Error reading header - %s: %s\n", dbPath, err.Error())
+		return err
+	}
+
+	if header.Version == FORMAT_VERSION {
+		fmt.Printf("Database %s is already in the latest format (v%d)\n", dbPath, FORMAT_VERSION)
+		return nil
+	}
+
+	if err = checkKeyFile(dbPath); err != nil {
+		return err
+	}
+
+	if err, keyFileHash = getKeyFileHash(); err != nil {
+		return err
+	}
+
 	fmt.Printf("Decryption Password: ")
 	err, passwd = ReadPassword()
 
 	if err != nil {
 		fmt.Printf("\nError reading password - \"%s\"\n", err.Error())
-		return err, ""
+		return err
 	}
 
-	_, settings := GetOrCreateLocalConfig(APP)
+	err = UpgradeFileFormat(dbPath, passwd, keyFileHash)
+	if err == nil {
+		fmt.Printf("\nUpgraded %s from format v%d to v%d.\n", dbPath, header.Version, FORMAT_VERSION)
+	}
 
-	switch settings.Cipher {
-	case "aes":
-		err = DecryptFileAES(dbPath, passwd)
-	case "xchacha", "chacha", "xchachapoly":
-		err = DecryptFileXChachaPoly(dbPath, passwd)
-	default:
-		fmt.Println("No cipher set, defaulting to AES")
-		err = DecryptFileAES(dbPath, passwd)
+	return err
+}
+
+// Change the master password of an encrypted database. The database is
+// re-encrypted in memory with a fresh salt, optionally switching the cipher.
+func ChangeDatabasePassword(dbPath string) error {
+
+	var err error
+	var flag bool
+	var oldPasswd string
+	var newPasswd string
+	var cipherId uint8
+	var keyFileHash []byte
+	var unlock VoidFunc
+
+	if err, flag = IsFileEncrypted(dbPath); !flag {
+		fmt.Println(err.Error())
+		return err
+	}
+
+	if err, unlock = lockForAction(dbPath); err != nil {
+		return err
+	}
+
+	defer unlock()
+
+	if err = checkKeyFile(dbPath); err != nil {
+		return err
+	}
+
+	if err, keyFileHash = getKeyFileHash(); err != nil {
+		return err
+	}
+
+	if SettingsRider.Cipher != "" {
+		cipherId = CipherIdFromName(SettingsRider.Cipher)
+		if cipherId == CIPHER_UNKNOWN {
+			fmt.Printf("Error - unknown cipher \"%s\"\n", SettingsRider.Cipher)
+			return errors.New("unknown cipher")
+		}
+	}
+
+	fmt.Printf("Current Password: ")
+	err, oldPasswd = ReadPassword()
+
+	if err != nil {
+		fmt.Printf("\nError reading password - \"%s\"\n", err.Error())
+		return err
+	}
+
+	fmt.Println()
+	err, newPasswd = readNewPassword("New Password")
+	if err != nil {
+		return err
+	}
+
+	if len(newPasswd) == 0 {
+		fmt.Println("\nError - new password cannot be empty")
+		return errors.New("empty password")
+	}
+
+	err = RekeyFile(dbPath, oldPasswd, newPasswd, keyFileHash, cipherId, getKdfParams())
+	if err != nil {
+		fmt.Printf("\nError changing password - \"%s\"\n", err.Error())
+		return err
+	}
+
+	if cipherId != CIPHER_UNKNOWN {
+		fmt.Printf("\nPassword changed, cipher set to %s.\n", CipherName(cipherId))
+	} else {
+		fmt.Println("\nPassword changed.")
+	}
+
+	return nil
+}
+
+// Calibrate the key derivation parameters to a target unlock time (e.g "1s")
+// on this machine and save them to the config
+func BenchmarkKdfParams(target string) error {
+
+	var err error
+	var duration time.Duration
+	var params *KdfParams
+	var elapsed time.Duration
+	var response string
+	var threads int
+
+	duration, err = time.ParseDuration(target)
+	if err != nil || duration <= 0 {
+		fmt.Printf("Error - invalid target time \"%s\" (use e.g 500ms, 1s)\n", target)
+		return errors.New("invalid target time")
+	}
+
+	err, settings := GetOrCreateLocalConfig(APP)
+	if err != nil {
+		fmt.Printf("Error parsing config - \"%s\"\n", err.Error())
+		return err
+	}
+
+	threads = runtime.NumCPU()
+	if threads > DEFAULT_ARGON2_THREADS {
+		threads = DEFAULT_ARGON2_THREADS
+	}
+
+	fmt.Printf("Calibrating argon2id for an unlock time of %s ...\n", duration)
+	err, params, elapsed = CalibrateKdfParams(duration, DEFAULT_ARGON2_MEMORY, uint8(threads))
+	if err != nil {
+		fmt.Printf("Error - key derivation failed - \"%s\"\n", err.Error())
+		return err
+	}
+
+	fmt.Printf("kdf: %s\n", KdfName(params.Kdf))
+	fmt.Printf("kdf_time: %d\n", params.Time)
+	fmt.Printf("kdf_memory: %d KiB\n", params.Memory)
+	fmt.Printf("kdf_threads: %d\n", params.Threads)
+	fmt.Printf("Measured unlock time: %s\n", elapsed.Round(time.Millisecond))
+
+	if !SettingsRider.AssumeYes {
+		response = readInput(bufio.NewReader(os.Stdin), "Save to config [Y/n]")
+	} else {
+		response = "y"
+	}
+
+	if strings.ToLower(response) == "n" {
+		return nil
 	}
 
+	settings.Kdf = KdfName(params.Kdf)
+	settings.KdfTime = params.Time
+	settings.KdfMemory = params.Memory
+	settings.KdfThreads = params.Threads
+
+	err = UpdateSettings(settings, settings.ConfigPath)
 	if err == nil {
-		fmt.Println("...decryption complete.")
+		fmt.Println("Saved. New parameters apply when a database is encrypted with -e or its password is changed with --passwd.")
+		fmt.Println("Databases in auto-encrypt mode keep their current parameters until then.")
 	}
 
-	return err, passwd
+	return err
 }
 
 // Migrate an existing database to the new schema
@@ -941,7 +2813,8 @@ func MigrateDatabase(dbPath string) error {
 
 	var err error
 	var flag bool
-	var passwd string
+	var reEncrypt VoidFunc
+	var unlock VoidFunc
 	var db *gorm.DB
 
 	if _, err = os.Stat(dbPath); os.IsNotExist(err) {
@@ -949,8 +2822,14 @@ func MigrateDatabase(dbPath string) error {
 		return err
 	}
 
+	if err, unlock = lockForAction(dbPath); err != nil {
+		return err
+	}
+
+	defer unlock()
+
 	if err, flag = IsFileEncrypted(dbPath); flag {
-		err, passwd = DecryptDatabase(dbPath)
+		err, reEncrypt = unlockForAction(dbPath)
 		if err != nil {
 			fmt.Printf("Error decrypting - %s: %s\n", dbPath, err.Error())
 			return err
@@ -965,23 +2844,16 @@ func MigrateDatabase(dbPath string) error {
 	}
 
 	fmt.Println("Migrating tables ...")
-	err = db.AutoMigrate(&Entry{})
-
-	if err != nil {
-		fmt.Printf("Error migrating table \"entries\" - %s: %s\n", dbPath, err.Error())
-		return err
-	}
-
-	err = db.AutoMigrate(&ExtendedEntry{})
+	err = MigrateSchema(db)
 
 	if err != nil {
-		fmt.Printf("Error migrating table \"exentries\" - %s: %s\n", dbPath, err.Error())
+		fmt.Printf("Error migrating %s: %s\n", dbPath, err.Error())
 		return err
 	}
 
 	if flag {
 		// File was encrypted - encrypt it again
-		EncryptDatabase(dbPath, &passwd)
+		reEncrypt()
 	}
 
 	fmt.Println("Migration successful.")
@@ -0,0 +1,386 @@
+// Unlock agent - caches derived keys of encrypted databases over a Unix socket
+package varuh
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const AGENT_SOCKET = "varuh-agent.sock"
+const AGENT_SOCKET_ENV = "VARUH_AGENT_SOCK"
+const AGENT_DEFAULT_TIMEOUT = "15m"
+
+// Returned by the agent when it holds no (valid) key for a database
+var ErrAgentLocked = errors.New("agent has no key for this database")
+
+// Request sent to the agent
+type agentRequest struct {
+	Op   string `json:"op"`             // add, decrypt, encrypt or lock
+	Path string `json:"path,omitempty"` // absolute path of the database
+	Key  []byte `json:"key,omitempty"`  // derived key (add only)
+	Data []byte `json:"data,omitempty"` // encrypted or plain data
+}
+
+// Response from the agent
+type agentResponse struct {
+	Error string `json:"error,omitempty"`
+	Data  []byte `json:"data,omitempty"`
+}
+
+// A derived key held by the agent along with the header it belongs to
+type cachedKey struct {
+	header *FileHeader
+	key    []byte
+}
+
+// The unlock agent
+type UnlockAgent struct {
+	mutex   sync.Mutex
+	keys    map[string]*cachedKey
+	timeout time.Duration
+	timer   *time.Timer
+}
+
+// Create an agent which forgets its keys after the given idle timeout
+func NewUnlockAgent(timeout time.Duration) *UnlockAgent {
+	return &UnlockAgent{keys: make(map[string]*cachedKey), timeout: timeout}
+}
+
+// Return the path of the agent socket. Can be overridden by $VARUH_AGENT_SOCK.
+func AgentSocketPath() string {
+
+	var dir string
+
+	if path := os.Getenv(AGENT_SOCKET_ENV); path != "" {
+		return path
+	}
+
+	dir = os.Getenv("XDG_RUNTIME_DIR")
+	if dir == "" {
+		dir = filepath.Join(os.TempDir(), fmt.Sprintf("varuh-%d", os.Getuid()))
+	}
+
+	return filepath.Join(dir, AGENT_SOCKET)
+}
+
+// Make sure the socket directory exists and is private to the user
+func checkSocketDir(dir string) error {
+
+	var info os.FileInfo
+	var err error
+
+	if err = os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+
+	info, err = os.Lstat(dir)
+	if err != nil {
+		return err
+	}
+
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok && int(stat.Uid) != os.Getuid() {
+		return fmt.Errorf("socket directory %s is not owned by the user", dir)
+	}
+
+	if !info.IsDir() || info.Mode().Perm()&0077 != 0 {
+		return fmt.Errorf("socket directory %s is accessible by other users", dir)
+	}
+
+	return nil
+}
+
+// Drop all cached keys
+func (a *UnlockAgent) Lock() {
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	for path, cached := range a.keys {
+		wipeKey(cached.key)
+		delete(a.keys, path)
+	}
+}
+
+// Restart the idle timer. Caller holds the mutex.
+func (a *UnlockAgent) touch() {
+
+	if a.timeout <= 0 {
+		return
+	}
+
+	if a.timer != nil {
+		a.timer.Stop()
+	}
+	a.timer = time.AfterFunc(a.timeout, a.Lock)
+}
+
+// Return the cached key for a database if it matches the header
+func (a *UnlockAgent) lookup(path string, header *FileHeader) *cachedKey {
+
+	cached, ok := a.keys[path]
+	if !ok || !bytes.Equal(cached.header.Salt, header.Salt) {
+		return nil
+	}
+
+	return cached
+}
+
+// Process a single request
+func (a *UnlockAgent) handle(req *agentRequest) *agentResponse {
+
+	var err error
+	var header *FileHeader
+	var plainText []byte
+	var encText []byte
+
+	if req.Op == "lock" {
+		a.Lock()
+		return &agentResponse{}
+	}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	a.touch()
+
+	switch req.Op {
+	case "add":
+		// Verify the key before caching it
+		err, _, header = OpenDataWithKey(req.Data, req.Key)
+		if err != nil {
+			return &agentResponse{Error: err.Error()}
+		}
+		if old, ok := a.keys[req.Path]; ok {
+			wipeKey(old.key)
+		}
+		a.keys[req.Path] = &cachedKey{header, lockKey(req.Key)}
+		return &agentResponse{}
+	case "decrypt":
+		err, header, _ = ParseFileHeader(req.Data)
+		if err != nil {
+			return &agentResponse{Error: err.Error()}
+		}
+		cached := a.lookup(req.Path, header)
+		if cached == nil {
+			return &agentResponse{Error: ErrAgentLocked.Error()}
+		}
+		err, plainText, _ = OpenDataWithKey(req.Data, cached.key)
+		if err != nil {
+			return &agentResponse{Error: err.Error()}
+		}
+		return &agentResponse{Data: plainText}
+	case "encrypt":
+		cached, ok := a.keys[req.Path]
+		if !ok {
+			return &agentResponse{Error: ErrAgentLocked.Error()}
+		}
+		err, encText = ResealData(req.Data, cached.header, cached.key)
+		if err != nil {
+			return &agentResponse{Error: err.Error()}
+		}
+		return &agentResponse{Data: encText}
+	}
+
+	return &agentResponse{Error: fmt.Sprintf("unknown request \"%s\"", req.Op)}
+}
+
+// Serve a client connection
+func (a *UnlockAgent) serveConn(conn net.Conn) {
+
+	var req agentRequest
+
+	defer conn.Close()
+
+	if err := json.NewDecoder(conn).Decode(&req); err != nil {
+		return
+	}
+
+	json.NewEncoder(conn).Encode(a.handle(&req))
+	wipeKey(req.Key)
+}
+
+// Serve requests on the listener till it is closed
+func (a *UnlockAgent) Serve(listener net.Listener) error {
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		go a.serveConn(conn)
+	}
+}
+
+// Copy the key into memory locked against swapping
+func lockKey(key []byte) []byte {
+
+	locked := make([]byte, len(key))
+	copy(locked, key)
+
+	if err := syscall.Mlock(locked); err != nil {
+		fmt.Printf("Warning - could not lock key in memory - \"%s\"\n", err.Error())
+	}
+
+	return locked
+}
+
+// Zero a key and unlock its memory
+func wipeKey(key []byte) {
+
+	for i := range key {
+		key[i] = 0
+	}
+	syscall.Munlock(key)
+}
+
+// Start the unlock agent on the socket and serve till interrupted
+func RunAgent() error {
+
+	var err error
+	var timeout time.Duration
+	var sockPath string
+	var listener net.Listener
+
+	err, settings := GetOrCreateLocalConfig(APP)
+	if err != nil {
+		fmt.Printf("Error parsing config - \"%s\"\n", err.Error())
+		return err
+	}
+
+	if settings.AgentTimeout == "" {
+		settings.AgentTimeout = AGENT_DEFAULT_TIMEOUT
+	}
+
+	timeout, err = time.ParseDuration(settings.AgentTimeout)
+	if err != nil {
+		fmt.Printf("Error - invalid agent_timeout \"%s\" in config\n", settings.AgentTimeout)
+		return err
+	}
+
+	sockPath = AgentSocketPath()
+	// An explicitly given socket path is trusted as is
+	if os.Getenv(AGENT_SOCKET_ENV) == "" {
+		if err = checkSocketDir(filepath.Dir(sockPath)); err != nil {
+			fmt.Printf("Error - %s\n", err.Error())
+			return err
+		}
+	}
+
+	if IsAgentRunning() {
+		fmt.Printf("Agent already running on %s\n", sockPath)
+		return errors.New("agent already running")
+	}
+
+	// Stale socket from an agent that died
+	os.Remove(sockPath)
+
+	// Socket is created accessible only to the user
+	oldMask := syscall.Umask(0177)
+	listener, err = net.Listen("unix", sockPath)
+	syscall.Umask(oldMask)
+
+	if err != nil {
+		fmt.Printf("Error - can't listen on %s - \"%s\"\n", sockPath, err.Error())
+		return err
+	}
+
+	if err = os.Chmod(sockPath, 0600); err != nil {
+		listener.Close()
+		return err
+	}
+
+	agent := NewUnlockAgent(timeout)
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+
+	go func() {
+		<-sigChan
+		listener.Close()
+	}()
+
+	fmt.Printf("Agent listening on %s (idle timeout %s)\n", sockPath, timeout)
+	agent.Serve(listener)
+
+	agent.Lock()
+	os.Remove(sockPath)
+	fmt.Println("Agent stopped.")
+
+	return nil
+}
+
+// Send a request to the running agent
+func agentCall(req *agentRequest) (error, []byte) {
+
+	var err error
+	var conn net.Conn
+	var resp agentResponse
+
+	conn, err = net.DialTimeout("unix", AgentSocketPath(), time.Second)
+	if err != nil {
+		return err, nil
+	}
+	defer conn.Close()
+
+	if err = json.NewEncoder(conn).Encode(req); err != nil {
+		return err, nil
+	}
+
+	if err = json.NewDecoder(conn).Decode(&resp); err != nil {
+		return err, nil
+	}
+
+	if resp.Error == ErrAgentLocked.Error() {
+		return ErrAgentLocked, nil
+	} else if resp.Error != "" {
+		return errors.New(resp.Error), nil
+	}
+
+	return nil, resp.Data
+}
+
+// Return true if an agent is reachable
+func IsAgentRunning() bool {
+
+	conn, err := net.DialTimeout("unix", AgentSocketPath(), time.Second)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+
+	return true
+}
+
+// Hand the derived key of a database to the agent.
+// The agent verifies the key against the encrypted contents.
+func AgentAddKey(dbPath string, key []byte, encText []byte) error {
+
+	err, _ := agentCall(&agentRequest{Op: "add", Path: dbPath, Key: key, Data: encText})
+	return err
+}
+
+// Ask the agent to decrypt the contents of a database
+func AgentDecrypt(dbPath string, encText []byte) (error, []byte) {
+	return agentCall(&agentRequest{Op: "decrypt", Path: dbPath, Data: encText})
+}
+
+// Ask the agent to encrypt the contents of a database with its cached key
+func AgentEncrypt(dbPath string, plainText []byte) (error, []byte) {
+	return agentCall(&agentRequest{Op: "encrypt", Path: dbPath, Data: plainText})
+}
+
+// Ask the agent to drop all cached keys
+func AgentLock() error {
+
+	err, _ := agentCall(&agentRequest{Op: "lock"})
+	return err
+}
@@ -0,0 +1,281 @@
+// Argon2d key derivation, which golang.org/x/crypto/argon2 does not
+// expose. KeePass databases commonly use it. Follows RFC 9106 (version 0x13).
+package varuh
+
+import (
+	"encoding/binary"
+	"hash"
+	"math/bits"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+const (
+	argon2Version     = 0x13
+	argon2BlockLength = 128 // 64-bit words in a 1 KiB block
+	argon2SyncPoints  = 4   // slices of a pass
+)
+
+type argon2Block [argon2BlockLength]uint64
+
+// Derive a key of keyLen bytes with Argon2d. Memory is in KiB.
+func Argon2dKey(password, salt []byte, time, memory uint32, threads uint8, keyLen uint32) []byte {
+
+	if time < 1 {
+		time = 1
+	}
+	if threads < 1 {
+		threads = 1
+	}
+
+	lanesCount := uint32(threads)
+	h0 := argon2InitHash(password, salt, time, memory, lanesCount, keyLen)
+
+	memory = memory / (argon2SyncPoints * lanesCount) * (argon2SyncPoints * lanesCount)
+	if memory < 2*argon2SyncPoints*lanesCount {
+		memory = 2 * argon2SyncPoints * lanesCount
+	}
+
+	B := argon2InitBlocks(&h0, memory, lanesCount)
+	argon2dProcessBlocks(B, time, memory, lanesCount)
+
+	return argon2ExtractKey(B, memory, lanesCount, keyLen)
+}
+
+// H0 of the password, salt and parameters, with room for the block
+// and lane counters
+func argon2InitHash(password, salt []byte, time, memory, threads, keyLen uint32) [blake2b.Size + 8]byte {
+
+	var h0 [blake2b.Size + 8]byte
+	var params [24]byte
+	var tmp [4]byte
+
+	b2, _ := blake2b.New512(nil)
+	binary.LittleEndian.PutUint32(params[0:4], threads)
+	binary.LittleEndian.PutUint32(params[4:8], keyLen)
+	binary.LittleEndian.PutUint32(params[8:12], memory)
+	binary.LittleEndian.PutUint32(params[12:16], time)
+	binary.LittleEndian.PutUint32(params[16:20], argon2Version)
+	binary.LittleEndian.PutUint32(params[20:24], 0) // argon2d
+	b2.Write(params[:])
+
+	// Password, salt and then the (empty) secret and associated data
+	for _, value := range [][]byte{password, salt, nil, nil} {
+		binary.LittleEndian.PutUint32(tmp[:], uint32(len(value)))
+		b2.Write(tmp[:])
+		b2.Write(value)
+	}
+
+	b2.Sum(h0[:0])
+	return h0
+}
+
+// First two blocks of each lane
+func argon2InitBlocks(h0 *[blake2b.Size + 8]byte, memory, threads uint32) []argon2Block {
+
+	var block0 [1024]byte
+
+	B := make([]argon2Block, memory)
+	for lane := uint32(0); lane < threads; lane++ {
+		j := lane * (memory / threads)
+		binary.LittleEndian.PutUint32(h0[blake2b.Size+4:], lane)
+
+		for i := uint32(0); i < 2; i++ {
+			binary.LittleEndian.PutUint32(h0[blake2b.Size:], i)
+			argon2Hash(block0[:], h0[:])
+			for k := range B[j+i] {
+				B[j+i][k] = binary.LittleEndian.Uint64(block0[k*8:])
+			}
+		}
+	}
+
+	return B
+}
+
+// Fill the memory. Reference blocks depend on the data (argon2d), and
+// segments of a slice only reference earlier slices of other lanes, so
+// the lanes are filled one after another.
+func argon2dProcessBlocks(B []argon2Block, time, memory, threads uint32) {
+
+	lanes := memory / threads
+	segments := lanes / argon2SyncPoints
+
+	for n := uint32(0); n < time; n++ {
+		for slice := uint32(0); slice < argon2SyncPoints; slice++ {
+			for lane := uint32(0); lane < threads; lane++ {
+				index := uint32(0)
+				if n == 0 && slice == 0 {
+					index = 2 // first two blocks are set
+				}
+
+				offset := lane*lanes + slice*segments + index
+				for index < segments {
+					prev := offset - 1
+					if index == 0 && slice == 0 {
+						prev += lanes // last block in lane
+					}
+					random := B[prev][0]
+					newOffset := argon2IndexAlpha(random, lanes, segments, threads, n, slice, lane, index)
+					argon2ProcessBlock(&B[offset], &B[prev], &B[newOffset], n > 0)
+					index, offset = index+1, offset+1
+				}
+			}
+		}
+	}
+}
+
+// Hash the final blocks of the lanes into the key
+func argon2ExtractKey(B []argon2Block, memory, threads, keyLen uint32) []byte {
+
+	var block [1024]byte
+
+	lanes := memory / threads
+	for lane := uint32(0); lane < threads-1; lane++ {
+		for i, v := range B[(lane*lanes)+lanes-1] {
+			B[memory-1][i] ^= v
+		}
+	}
+
+	for i, v := range B[memory-1] {
+		binary.LittleEndian.PutUint64(block[i*8:], v)
+	}
+
+	key := make([]byte, keyLen)
+	argon2Hash(key, block[:])
+
+	return key
+}
+
+// Position of the block referenced when filling a block
+func argon2IndexAlpha(rand uint64, lanes, segments, threads, n, slice, lane, index uint32) uint32 {
+
+	refLane := uint32(rand>>32) % threads
+	if n == 0 && slice == 0 {
+		refLane = lane
+	}
+
+	m, s := 3*segments, ((slice+1)%argon2SyncPoints)*segments
+	if lane == refLane {
+		m += index
+	}
+	if n == 0 {
+		m, s = slice*segments, 0
+		if slice == 0 || lane == refLane {
+			m += index
+		}
+	}
+	if index == 0 || lane == refLane {
+		m--
+	}
+
+	p := rand & 0xFFFFFFFF
+	p = (p * p) >> 32
+	p = (p * uint64(m)) >> 32
+
+	return refLane*lanes + uint32((uint64(s)+uint64(m)-(p+1))%uint64(lanes))
+}
+
+// Compression function G - out is set to (or xored with, after the first
+// pass) the permutation of in1 ^ in2 xored with in1 ^ in2
+func argon2ProcessBlock(out, in1, in2 *argon2Block, xor bool) {
+
+	var t argon2Block
+
+	for i := range t {
+		t[i] = in1[i] ^ in2[i]
+	}
+
+	// Rows, then columns of 16-byte registers
+	for i := 0; i < argon2BlockLength; i += 16 {
+		blamkaRound(&t, i, i+1, i+2, i+3, i+4, i+5, i+6, i+7,
+			i+8, i+9, i+10, i+11, i+12, i+13, i+14, i+15)
+	}
+	for i := 0; i < argon2BlockLength/8; i += 2 {
+		blamkaRound(&t, i, i+1, 16+i, 16+i+1, 32+i, 32+i+1, 48+i, 48+i+1,
+			64+i, 64+i+1, 80+i, 80+i+1, 96+i, 96+i+1, 112+i, 112+i+1)
+	}
+
+	for i := range t {
+		if xor {
+			out[i] ^= in1[i] ^ in2[i] ^ t[i]
+		} else {
+			out[i] = in1[i] ^ in2[i] ^ t[i]
+		}
+	}
+}
+
+// BLAKE2b round with the multiplications of BlaMka, on the given words
+func blamkaRound(t *argon2Block, idx ...int) {
+
+	var v [16]uint64
+
+	for i, k := range idx {
+		v[i] = t[k]
+	}
+
+	g := func(a, b, c, d int) {
+		v[a] += v[b] + 2*uint64(uint32(v[a]))*uint64(uint32(v[b]))
+		v[d] = bits.RotateLeft64(v[d]^v[a], -32)
+		v[c] += v[d] + 2*uint64(uint32(v[c]))*uint64(uint32(v[d]))
+		v[b] = bits.RotateLeft64(v[b]^v[c], -24)
+		v[a] += v[b] + 2*uint64(uint32(v[a]))*uint64(uint32(v[b]))
+		v[d] = bits.RotateLeft64(v[d]^v[a], -16)
+		v[c] += v[d] + 2*uint64(uint32(v[c]))*uint64(uint32(v[d]))
+		v[b] = bits.RotateLeft64(v[b]^v[c], -63)
+	}
+
+	g(0, 4, 8, 12)
+	g(1, 5, 9, 13)
+	g(2, 6, 10, 14)
+	g(3, 7, 11, 15)
+	g(0, 5, 10, 15)
+	g(1, 6, 11, 12)
+	g(2, 7, 8, 13)
+	g(3, 4, 9, 14)
+
+	for i, k := range idx {
+		t[k] = v[i]
+	}
+}
+
+// Variable length hash H' of Argon2
+func argon2Hash(out []byte, in []byte) {
+
+	var b2 hash.Hash
+	var buffer [blake2b.Size]byte
+
+	if n := len(out); n < blake2b.Size {
+		b2, _ = blake2b.New(n, nil)
+	} else {
+		b2, _ = blake2b.New512(nil)
+	}
+
+	binary.LittleEndian.PutUint32(buffer[:4], uint32(len(out)))
+	b2.Write(buffer[:4])
+	b2.Write(in)
+
+	if len(out) <= blake2b.Size {
+		b2.Sum(out[:0])
+		return
+	}
+
+	outLen := len(out)
+	b2.Sum(buffer[:0])
+	b2.Reset()
+	copy(out, buffer[:32])
+	out = out[32:]
+	for len(out) > blake2b.Size {
+		b2.Write(buffer[:])
+		b2.Sum(buffer[:0])
+		copy(out, buffer[:32])
+		out = out[32:]
+		b2.Reset()
+	}
+
+	if outLen%blake2b.Size > 0 {
+		r := ((outLen + 31) / 32) - 2
+		b2, _ = blake2b.New(outLen-32*r, nil)
+	}
+	b2.Write(buffer[:])
+	b2.Sum(out[:0])
+}
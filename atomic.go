@@ -0,0 +1,321 @@
+// Crash-safe file writes - write to a temp file, fsync, rename, fsync directory
+package varuh
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// Temp files are created next to the target as .<name>.varuh-tmp-<random>
+const TEMP_FILE_MARKER = ".varuh-tmp-"
+
+// Backup left behind by older versions which wrote <path>.varuh first
+const LEGACY_BACKUP_SUFFIX = ".varuh"
+
+const SQLITE_MAGIC = "SQLite format 3\x00"
+const SQLITE_HEADER_SIZE = 100
+
+// A file written to a temp path which replaces the target atomically on Commit
+type AtomicFile struct {
+	*os.File
+	path      string
+	committed bool
+}
+
+// Return the glob pattern matching temp files of a path
+func tempFilePattern(path string) string {
+	return filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+TEMP_FILE_MARKER+"*")
+}
+
+// Create a temp file in the directory of path with the given mode. Symlinks
+// are resolved so that the file they point to is the one replaced.
+func CreateAtomicFile(path string, mode fs.FileMode) (error, *AtomicFile) {
+
+	var err error
+	var fh *os.File
+	var suffix []byte
+
+	if realPath, err := filepath.EvalSymlinks(path); err == nil {
+		path = realPath
+	}
+
+	err, suffix = GenerateRandomBytes(8)
+	if err != nil {
+		return err, nil
+	}
+
+	tmpPath := filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+TEMP_FILE_MARKER+hex.EncodeToString(suffix))
+
+	fh, err = os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, mode)
+	if err != nil {
+		return err, nil
+	}
+
+	// Umask may have dropped bits
+	if err = fh.Chmod(mode); err != nil {
+		fh.Close()
+		os.Remove(tmpPath)
+		return err, nil
+	}
+
+	return nil, &AtomicFile{File: fh, path: path}
+}
+
+// Flush the temp file to disk and rename it over the target
+func (af *AtomicFile) Commit() error {
+
+	var err error
+	var tmpPath string
+
+	tmpPath = af.Name()
+
+	if err = af.Sync(); err != nil {
+		af.Abort()
+		return err
+	}
+
+	if err = af.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	if err = os.Rename(tmpPath, af.path); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	af.committed = true
+	return syncDir(filepath.Dir(af.path))
+}
+
+// Drop the temp file, leaving the target untouched. No-op after Commit.
+func (af *AtomicFile) Abort() {
+
+	if af.committed {
+		return
+	}
+
+	af.Close()
+	os.Remove(af.Name())
+}
+
+// Flush a directory so that a rename in it survives a crash
+func syncDir(dir string) error {
+
+	fh, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+
+	defer fh.Close()
+
+	return fh.Sync()
+}
+
+// Replace the contents of a file atomically. Either the old or the new
+// contents are found at path after a crash, never a mix.
+func WriteFileAtomic(path string, contents []byte, mode fs.FileMode) error {
+
+	err, af := CreateAtomicFile(path, mode)
+	if err != nil {
+		return err
+	}
+
+	if _, err = af.Write(contents); err != nil {
+		af.Abort()
+		return err
+	}
+
+	return af.Commit()
+}
+
+// Return true if the data looks like a complete sqlite database
+func isValidSQLiteData(data []byte) bool {
+
+	var pageSize int
+
+	if len(data) < SQLITE_HEADER_SIZE || string(data[:len(SQLITE_MAGIC)]) != SQLITE_MAGIC {
+		return false
+	}
+
+	pageSize = int(binary.BigEndian.Uint16(data[16:18]))
+	if pageSize == 1 {
+		pageSize = 65536
+	}
+
+	if pageSize < 512 || len(data)%pageSize != 0 {
+		return false
+	}
+
+	// The page count in the header is valid only if written by the same change
+	if binary.BigEndian.Uint32(data[92:96]) == binary.BigEndian.Uint32(data[24:28]) {
+		pageCount := int(binary.BigEndian.Uint32(data[28:32]))
+		if len(data) < pageCount*pageSize {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Return true if the body of an encrypted container (hmac + nonce + ciphertext)
+// has the length of a sealed sqlite database. The plain text is whole pages of
+// at least 512 bytes, so a truncated container almost never passes.
+func isValidContainerBody(header *FileHeader, body []byte) bool {
+
+	ciphers := []uint8{header.Cipher}
+	if header.Cipher == CIPHER_UNKNOWN {
+		ciphers = []uint8{CIPHER_AES, CIPHER_XCHACHA}
+	}
+
+	for _, cipherId := range ciphers {
+		err, aead := newAEAD(cipherId, make([]byte, KEY_SIZE))
+		if err != nil {
+			continue
+		}
+
+		size := len(body) - HMAC_SHA512_SIZE - aead.NonceSize() - aead.Overhead()
+		if size >= 512 && size%512 == 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Return true if the file is a complete encrypted container or sqlite database.
+// The hmac of an encrypted container can't be verified without the key, so
+// its header and the length of its body are checked.
+func IsValidDatabaseFile(path string) bool {
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return false
+	}
+
+	if err, header, body := ParseFileHeader(data); err == nil {
+		return isValidContainerBody(header, body)
+	}
+
+	return isValidSQLiteData(data)
+}
+
+// Return the temp files and legacy backup left next to a database
+func recoveryCandidates(dbPath string) (error, []string) {
+
+	candidates, err := filepath.Glob(tempFilePattern(dbPath))
+	if err != nil {
+		return err, nil
+	}
+
+	if _, err = os.Stat(dbPath + LEGACY_BACKUP_SUFFIX); err == nil {
+		candidates = append(candidates, dbPath+LEGACY_BACKUP_SUFFIX)
+	}
+
+	return nil, candidates
+}
+
+// Clean up temp files left by an interrupted write of a database. If the
+// database itself is missing or damaged, the newest valid temp file (or legacy
+// backup) is moved into its place. Returns the recovered file, if any. Nothing
+// is done while another process holds the lock, as its temp file is in use.
+func RecoverTempFiles(dbPath string) (error, string) {
+
+	var err error
+	var candidates []string
+	var recovered string
+	var dbValid bool
+	var realPath string
+	var unlock VoidFunc
+
+	realPath = dbPath
+	if resolved, err := filepath.EvalSymlinks(dbPath); err == nil {
+		realPath = resolved
+	}
+
+	err, candidates = recoveryCandidates(realPath)
+	if err != nil || len(candidates) == 0 {
+		return err, ""
+	}
+
+	// Writers lock the path as configured, not the resolved one
+	err, unlock = LockDatabase(dbPath, 0)
+	if errors.Is(err, ErrVaultBusy) {
+		return nil, ""
+	} else if err != nil {
+		return err, ""
+	}
+
+	defer unlock()
+
+	// A write may have finished since the first look
+	dbPath = realPath
+	err, candidates = recoveryCandidates(dbPath)
+	if err != nil || len(candidates) == 0 {
+		return err, ""
+	}
+
+	dbValid = IsValidDatabaseFile(dbPath)
+
+	if !dbValid {
+		var newest os.FileInfo
+
+		for _, candidate := range candidates {
+			info, err := os.Stat(candidate)
+			if err != nil || !IsValidDatabaseFile(candidate) {
+				continue
+			}
+			if newest == nil || info.ModTime().After(newest.ModTime()) {
+				newest = info
+				recovered = candidate
+			}
+		}
+
+		if recovered == "" {
+			// Nothing usable - keep everything for manual inspection
+			return fmt.Errorf("database %s is damaged and no valid temp file was found", dbPath), ""
+		}
+
+		if err = os.Rename(recovered, dbPath); err != nil {
+			return err, ""
+		}
+		if err = syncDir(filepath.Dir(dbPath)); err != nil {
+			return err, ""
+		}
+	}
+
+	// The database is good now, the rest are leftovers
+	for _, candidate := range candidates {
+		if candidate != recovered {
+			os.Remove(candidate)
+		}
+	}
+
+	return nil, recovered
+}
+
+// Recover the active database from an interrupted write, if needed
+func RecoverActiveDatabase() error {
+
+	err, settings := GetOrCreateLocalConfig(APP)
+	if err != nil || settings.ActiveDB == "" {
+		return err
+	}
+
+	err, recovered := RecoverTempFiles(settings.ActiveDB)
+	if err != nil {
+		fmt.Printf("Warning - %s\n", err.Error())
+		return err
+	}
+
+	if recovered != "" {
+		fmt.Printf("Recovered %s from interrupted write %s\n", settings.ActiveDB, filepath.Base(recovered))
+	}
+
+	return nil
+}
@@ -5,6 +5,7 @@ import (
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/hmac"
+	"crypto/sha256"
 	"crypto/sha512"
 	"errors"
 	"fmt"
@@ -26,6 +27,7 @@ const SALT_SIZE = 128
 const KEY_N_ITER = 120000
 const HMAC_SHA512_SIZE = 64
 const MAGIC_HEADER = 0xcafebabe
+const KEYFILE_SIZE = 64
 
 // Generate random bytes of the given length
 func GenerateRandomBytes(size int) (error, []byte) {
@@ -43,9 +45,69 @@ func GenerateRandomBytes(size int) (error, []byte) {
 	return nil, data
 }
 
+// Return the SHA-256 hash of a keyfile's contents
+func HashKeyFile(keyFile string) (error, []byte) {
+
+	var data []byte
+	var err error
+
+	data, err = os.ReadFile(keyFile)
+	if err != nil {
+		return fmt.Errorf("can't read keyfile - %s", err.Error()), nil
+	}
+
+	if len(data) == 0 {
+		return fmt.Errorf("keyfile %s is empty", keyFile), nil
+	}
+
+	hash := sha256.Sum256(data)
+	return nil, hash[:]
+}
+
+// Create a new keyfile with random contents. Existing files are never overwritten.
+func CreateKeyFile(keyFile string) error {
+
+	var err error
+	var data []byte
+	var fh *os.File
+
+	err, data = GenerateRandomBytes(KEYFILE_SIZE)
+	if err != nil {
+		return err
+	}
+
+	fh, err = os.OpenFile(keyFile, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0400)
+	if err != nil {
+		return err
+	}
+
+	defer fh.Close()
+
+	_, err = fh.Write(data)
+	return err
+}
+
+// Combine a passphrase with the hash of a keyfile into the secret fed to the KDF.
+// Without a keyfile the passphrase is used as is.
+func CompositeKey(passPhrase string, keyFileHash []byte) []byte {
+
+	if len(keyFileHash) == 0 {
+		return []byte(passPhrase)
+	}
+
+	passHash := sha256.Sum256([]byte(passPhrase))
+
+	h := sha256.New()
+	h.Write(passHash[:])
+	h.Write(keyFileHash)
+
+	return h.Sum(nil)
+}
+
 // Generate a key from the given passphrase and (optional) salt
 // If 2nd argument is nil, salt will be generated. Uses argon2
-func GenerateKeyArgon2(passPhrase string, oldSalt *[]byte) (error, []byte, []byte) {
+// An optional keyfile hash makes it a composite key.
+func GenerateKeyArgon2(passPhrase string, oldSalt *[]byte, keyFileHash ...[]byte) (error, []byte, []byte) {
 
 	var salt []byte
 	var key []byte
@@ -67,8 +129,16 @@ func GenerateKeyArgon2(passPhrase string, oldSalt *[]byte) (error, []byte, []byt
 		return errors.New("invalid salt"), key, salt
 	}
 
+	var secret []byte
+
+	if len(keyFileHash) > 0 {
+		secret = CompositeKey(passPhrase, keyFileHash[0])
+	} else {
+		secret = []byte(passPhrase)
+	}
+
 	// key = argon2.IDKey([]byte(passPhrase), salt, 1, 64*1024, 4, KEY_SIZE)
-	key = argon2.Key([]byte(passPhrase), salt, 3, 32*1024, 4, KEY_SIZE)
+	key = argon2.Key(secret, salt, 3, 32*1024, 4, KEY_SIZE)
 	return nil, key, salt
 }
 
@@ -134,253 +204,357 @@ func IsFileEncrypted(encDbPath string) (error, bool) {
 	return nil, true
 }
 
-// Encrypt the database path using AES
-func EncryptFileAES(dbPath string, password string) error {
+// Derive the encryption key for a container using the KDF parameters in its header.
+// The keyfile hash must be given if and only if the header says so.
+func DeriveKey(passPhrase string, keyFileHash []byte, header *FileHeader) (error, []byte) {
 
-	var err error
 	var key []byte
-	var salt []byte
-	var nonce []byte
-	var plainText []byte
-	var cipherText []byte
-	var magicBytes []byte
-	var encText []byte
-	var encDbPath string
-	var hmacHash []byte
 
-	plainText, err = os.ReadFile(dbPath)
-	if err != nil {
-		fmt.Printf("Error - Can't read database -\"%s\"\n", err)
-		return err
+	if len(header.Salt) != SALT_SIZE {
+		return errors.New("invalid salt length"), key
 	}
 
-	err, key, salt = GenerateKeyArgon2(password, nil)
+	if header.RequiresKeyFile() && len(keyFileHash) == 0 {
+		return errors.New("database requires a keyfile - use --keyfile <path>"), key
+	}
 
-	if err != nil {
-		fmt.Printf("Error - Key derivation failed -\"%s\"\n", err)
-		return err
+	if !header.RequiresKeyFile() && len(keyFileHash) > 0 {
+		return errors.New("database does not use a keyfile"), key
 	}
 
-	//	fmt.Printf("\nsalt: %x\n", salt)
-	//	fmt.Printf("key: %x\n", key)
-	cipherBlock, err := aes.NewCipher(key)
-	if err != nil {
-		fmt.Printf("Error - Cipher block creation failed - \"%s\"\n", err)
-		return err
+	if header.Time == 0 || header.Memory == 0 || header.Threads == 0 || header.Time > MAX_ARGON2_TIME ||
+		header.Memory > MAX_ARGON2_MEMORY || header.Threads > MAX_ARGON2_THREADS {
+		return errors.New("invalid key derivation parameters"), key
 	}
 
-	aesGCM, err := cipher.NewGCM(cipherBlock)
-	if err != nil {
-		fmt.Printf("Error - AES GCM creation failed - \"%s\"\n", err)
-		return err
+	switch header.Kdf {
+	case KDF_ARGON2I:
+		key = argon2.Key(CompositeKey(passPhrase, keyFileHash), header.Salt, header.Time, header.Memory, header.Threads, KEY_SIZE)
+	case KDF_ARGON2ID:
+		key = argon2.IDKey(CompositeKey(passPhrase, keyFileHash), header.Salt, header.Time, header.Memory, header.Threads, KEY_SIZE)
+	default:
+		return fmt.Errorf("unsupported key derivation function %d", header.Kdf), key
 	}
 
-	nonceSize := aesGCM.NonceSize()
-	//	fmt.Printf("%d\n", nonceSize)
-	err, nonce = GenerateRandomBytes(nonceSize)
+	return nil, key
+}
+
+// Bounds for KDF calibration
+const KDF_BENCH_MIN_MEMORY = 8 * 1024
+const KDF_BENCH_MAX_TIME = 64
 
+// Time a single key derivation with the given parameters
+func timeKdf(params *KdfParams) (error, time.Duration) {
+
+	var err error
+	var header *FileHeader
+
+	err, header = NewFileHeader(CIPHER_AES, params)
 	if err != nil {
-		fmt.Printf("Error - Nonce generation failed -\"%s\"\n", err)
-		return err
+		return err, 0
 	}
 
-	//	fmt.Printf("nonce: %x\n", nonce)
-	magicBytes = []byte(fmt.Sprintf("%x", MAGIC_HEADER))
-	cipherText = aesGCM.Seal(nonce, nonce, plainText, nil)
+	start := time.Now()
+	if err, _ = DeriveKey("benchmark", nil, header); err != nil {
+		return err, 0
+	}
 
-	// Calculate hmac signature and write it
-	hCipher := hmac.New(sha512.New, key)
-	hCipher.Write(cipherText)
+	return nil, time.Since(start)
+}
 
-	hmacHash = hCipher.Sum(nil)
+// Calibrate argon2id parameters so that key derivation takes about the target
+// duration on this machine. Memory is lowered if a single pass is already slower
+// than the target, else passes are added. Returns the parameters and measured time.
+func CalibrateKdfParams(target time.Duration, memory uint32, threads uint8) (error, *KdfParams, time.Duration) {
 
-	encText = append(magicBytes, salt...)
-	encText = append(encText, hmacHash...)
-	encText = append(encText, cipherText...)
+	var err error
+	var params *KdfParams
+	var elapsed time.Duration
 
-	encDbPath = dbPath + ".varuh"
+	params = &KdfParams{KDF_ARGON2ID, 1, memory, threads}
+	if err, elapsed = timeKdf(params); err != nil {
+		return err, nil, 0
+	}
 
-	err = os.WriteFile(encDbPath, encText, 0600)
-	if err == nil {
-		err = os.WriteFile(dbPath, encText, 0600)
-		if err == nil {
-			// Remove backup
-			os.Remove(encDbPath)
-		} else {
-			fmt.Printf("Error writing encrypted database - \"%s\"\n", err.Error())
+	for elapsed > target && params.Memory/2 >= KDF_BENCH_MIN_MEMORY {
+		params.Memory /= 2
+		if err, elapsed = timeKdf(params); err != nil {
+			return err, nil, 0
 		}
 	}
-	//	fmt.Printf("%x\n", cipherText)
 
-	return err
+	if elapsed < target {
+		// Cost is roughly linear in passes
+		passes := uint32(target / elapsed)
+		if passes > KDF_BENCH_MAX_TIME {
+			passes = KDF_BENCH_MAX_TIME
+		}
+
+		if passes > 1 {
+			params.Time = passes
+			if err, elapsed = timeKdf(params); err != nil {
+				return err, nil, 0
+			}
+		}
+	}
+
+	return nil, params, elapsed
 }
 
-// Decrypt an already encrypted database file using given password using AES
-func DecryptFileAES(encDbPath string, password string) error {
+// Return an AEAD for the given cipher id and key
+func newAEAD(cipherId uint8, key []byte) (error, cipher.AEAD) {
 
-	var encText []byte
-	var cipherText []byte
-	var plainText []byte
-	var key []byte
-	var salt []byte
-	var nonce []byte
-	var hmacHash []byte
-	var hmacSig []byte
-	var origFile string
+	switch cipherId {
+	case CIPHER_AES:
+		cipherBlock, err := aes.NewCipher(key)
+		if err != nil {
+			fmt.Printf("Error - Cipher block creation failed - \"%s\"\n", err)
+			return err, nil
+		}
 
-	var err error
+		aesGCM, err := cipher.NewGCM(cipherBlock)
+		if err != nil {
+			fmt.Printf("Error - AES GCM creation failed - \"%s\"\n", err)
+			return err, nil
+		}
 
-	encText, err = os.ReadFile(encDbPath)
-	if err != nil {
-		fmt.Printf("Error - Can't read database -\"%s\"\n", err)
-		return err
+		return nil, aesGCM
+	case CIPHER_XCHACHA:
+		aead, err := chacha.NewX(key)
+		if err != nil {
+			fmt.Printf("Error - AEAD creation failed - \"%s\"\n", err)
+			return err, nil
+		}
+
+		return nil, aead
 	}
 
-	encText = encText[unsafe.Sizeof(MAGIC_HEADER):]
-	// Read the old salt
-	salt, encText = encText[:SALT_SIZE], encText[SALT_SIZE:]
-	// Read the hmac hash checksum
-	hmacHash, encText = encText[:HMAC_SHA512_SIZE], encText[HMAC_SHA512_SIZE:]
+	return fmt.Errorf("unsupported cipher %d", cipherId), nil
+}
 
-	err, key, _ = GenerateKeyArgon2(password, &salt)
+// Seal plain text with the derived key and return the full container
+func sealContainer(header *FileHeader, key []byte, plainText []byte) (error, []byte) {
+
+	var err error
+	var aead cipher.AEAD
+	var nonce []byte
+	var cipherText []byte
+	var hmacHash []byte
+	var encText []byte
 
+	err, aead = newAEAD(header.Cipher, key)
 	if err != nil {
-		fmt.Printf("Error - Key derivation failed -\"%s\"\n", err)
-		return err
+		return err, nil
 	}
 
+	nonce = make([]byte, aead.NonceSize(), aead.NonceSize()+len(plainText)+aead.Overhead())
+	if _, err = crand.Read(nonce); err != nil {
+		fmt.Printf("Error - Nonce generation failed -\"%s\"\n", err)
+		return err, nil
+	}
+
+	cipherText = aead.Seal(nonce, nonce, plainText, header.additionalData())
+
+	// Calculate hmac signature over header and cipher text
+	hCipher := hmac.New(sha512.New, key)
+	hCipher.Write(header.additionalData())
+	hCipher.Write(cipherText)
+
+	hmacHash = hCipher.Sum(nil)
+
+	encText = header.Bytes()
+	encText = append(encText, hmacHash...)
+	encText = append(encText, cipherText...)
+
+	return nil, encText
+}
+
+// Verify and open the body of a container (hmac + nonce + ciphertext) with the derived key.
+// For legacy containers with no recorded cipher, both ciphers are tried and the header updated.
+func openContainer(header *FileHeader, key []byte, body []byte) (error, []byte) {
+
+	var err error
+	var hmacHash []byte
+	var hmacSig []byte
+	var plainText []byte
+	var ciphers []uint8
+
+	// Read the hmac hash checksum
+	hmacHash, body = body[:HMAC_SHA512_SIZE], body[HMAC_SHA512_SIZE:]
+
 	// verify the hmac
-	// Calculate hmac signature and write it
 	hCipher := hmac.New(sha512.New, key)
-	hCipher.Write(encText)
+	hCipher.Write(header.additionalData())
+	hCipher.Write(body)
 
 	hmacSig = hCipher.Sum(nil)
 
 	// Compare
 	if !hmac.Equal(hmacSig, hmacHash) {
 		fmt.Println("Invalid password or tampered data. Aborted")
-		return errors.New("signature check failed")
+		return errors.New("signature check failed"), nil
 	}
-	//	fmt.Printf("\nsalt: %x\n", salt)
-	//	fmt.Printf("key: %x\n", key)
 
-	cipherBlock, err := aes.NewCipher(key)
-	if err != nil {
-		fmt.Printf("Error - Cipher block creation failed - \"%s\"\n", err)
-		return err
+	if header.Cipher == CIPHER_UNKNOWN {
+		ciphers = []uint8{CIPHER_AES, CIPHER_XCHACHA}
+	} else {
+		ciphers = []uint8{header.Cipher}
 	}
 
-	aesGCM, err := cipher.NewGCM(cipherBlock)
-	if err != nil {
-		fmt.Printf("Error - AES GCM creation failed - \"%s\"\n", err)
-		return err
+	for _, cipherId := range ciphers {
+		var aead cipher.AEAD
+
+		err, aead = newAEAD(cipherId, key)
+		if err != nil {
+			return err, nil
+		}
+
+		nonceSize := aead.NonceSize()
+		if len(body) < nonceSize {
+			err = errors.New("encrypted database is truncated")
+			continue
+		}
+
+		plainText, err = aead.Open(nil, body[:nonceSize], body[nonceSize:], header.additionalData())
+		if err == nil {
+			header.Cipher = cipherId
+			return nil, plainText
+		}
 	}
 
-	nonceSize := aesGCM.NonceSize()
+	fmt.Printf("Error - Decryption failed - \"%s\"\n", err)
+	return err, nil
+}
 
-	nonce, cipherText = encText[:nonceSize], encText[nonceSize:]
-	//	fmt.Printf("nonce: %x\n", nonce)
-	plainText, err = aesGCM.Open(nil, nonce, cipherText, nil)
+// Encrypt plain text with the given password (and optional keyfile hash), cipher and
+// KDF parameters (nil for defaults), returning the encrypted container
+func EncryptData(plainText []byte, password string, keyFileHash []byte, cipherId uint8, params *KdfParams) (error, []byte) {
 
+	var err error
+	var key []byte
+	var header *FileHeader
+
+	err, header = NewFileHeader(cipherId, params)
 	if err != nil {
-		fmt.Printf("Error - Decryption failed - \"%s\"\n", err)
-		return err
+		fmt.Printf("Error - Salt generation failed -\"%s\"\n", err)
+		return err, nil
 	}
 
-	err, origFile = RewriteFile(encDbPath, plainText, 0600)
+	if len(keyFileHash) > 0 {
+		header.Flags |= FLAG_KEYFILE
+	}
 
+	err, key = DeriveKey(password, keyFileHash, header)
 	if err != nil {
-		fmt.Printf("Error writing decrypted data to %s - \"%s\"\n", origFile, err.Error())
+		fmt.Printf("Error - Key derivation failed -\"%s\"\n", err)
+		return err, nil
 	}
 
-	//	fmt.Printf("%s\n", string(plainText))
-	return err
+	return sealContainer(header, key, plainText)
 }
 
-// Encrypt a file using XChaCha20-Poly1305 cipher
-func EncryptFileXChachaPoly(dbPath string, password string) error {
+// Decrypt an encrypted container with the given password (and optional keyfile hash).
+// The cipher and KDF parameters are taken from the container header.
+func DecryptData(encText []byte, password string, keyFileHash []byte) (error, []byte, *FileHeader) {
+
+	err, plainText, header, _ := UnlockData(encText, password, keyFileHash)
+	return err, plainText, header
+}
+
+// Decrypt an encrypted container like DecryptData, also returning the derived key
+// so that the container can be sealed again with ResealData without another KDF run
+func UnlockData(encText []byte, password string, keyFileHash []byte) (error, []byte, *FileHeader, []byte) {
 
 	var err error
 	var key []byte
-	var nonce []byte
-	var salt []byte
+	var header *FileHeader
 	var plainText []byte
-	var cipherText []byte
-	var magicBytes []byte
-	var encText []byte
-	var encDbPath string
-	var hmacHash []byte
 
-	plainText, err = os.ReadFile(dbPath)
+	err, header, encText = ParseFileHeader(encText)
 	if err != nil {
-		fmt.Printf("Error - Can't read database -\"%s\"\n", err)
-		return err
+		fmt.Printf("Error - %s\n", err.Error())
+		return err, nil, nil, nil
 	}
 
-	err, key, salt = GenerateKeyArgon2(password, nil)
-
+	err, key = DeriveKey(password, keyFileHash, header)
 	if err != nil {
 		fmt.Printf("Error - Key derivation failed -\"%s\"\n", err)
-		return err
+		return err, nil, nil, nil
 	}
 
-	aead, err := chacha.NewX(key)
-
+	err, plainText = openContainer(header, key, encText)
 	if err != nil {
-		fmt.Printf("Error - AEAD creation failed - \"%s\"\n", err)
-		return err
+		return err, nil, nil, nil
 	}
 
-	nonce = make([]byte, aead.NonceSize(), aead.NonceSize()+len(plainText)+aead.Overhead())
-	if _, err = crand.Read(nonce); err != nil {
-		fmt.Printf("Error - Nonce generation failed -\"%s\"\n", err)
-		return err
+	return nil, plainText, header, key
+}
+
+// Decrypt an encrypted container with an already derived key
+func OpenDataWithKey(encText []byte, key []byte) (error, []byte, *FileHeader) {
+
+	var err error
+	var header *FileHeader
+	var plainText []byte
+
+	err, header, encText = ParseFileHeader(encText)
+	if err != nil {
+		return err, nil, nil
 	}
 
-	magicBytes = []byte(fmt.Sprintf("%x", MAGIC_HEADER))
-	cipherText = aead.Seal(nonce, nonce, plainText, nil)
+	err, plainText = openContainer(header, key, encText)
+	return err, plainText, header
+}
 
-	// Calculate hmac signature and write it
-	hCipher := hmac.New(sha512.New, key)
-	hCipher.Write(cipherText)
+// Seal plain text again under an existing header and derived key with a fresh nonce
+func ResealData(plainText []byte, header *FileHeader, key []byte) (error, []byte) {
 
-	hmacHash = hCipher.Sum(nil)
+	if header.Version == 0 {
+		return errors.New("legacy containers can't be resealed - upgrade the format"), nil
+	}
 
-	// No need for salt in chacha
-	encText = append(magicBytes, salt...)
-	encText = append(encText, hmacHash...)
-	encText = append(encText, cipherText...)
+	return sealContainer(header, key, plainText)
+}
 
-	encDbPath = dbPath + ".varuh"
+// Write an encrypted container over the database path atomically
+func writeEncryptedFile(dbPath string, encText []byte) error {
 
-	err = os.WriteFile(encDbPath, encText, 0600)
-	if err == nil {
-		err = os.WriteFile(dbPath, encText, 0600)
-		if err == nil {
-			// Remove backup
-			os.Remove(encDbPath)
-		} else {
-			fmt.Printf("Error writing encrypted database - \"%s\"\n", err.Error())
-		}
+	err := WriteFileAtomic(dbPath, encText, 0600)
+	if err != nil {
+		fmt.Printf("Error writing encrypted database - \"%s\"\n", err.Error())
 	}
-	//	fmt.Printf("%x\n", cipherText)
 
 	return err
 }
 
-// Decrypt an already encrypted database file using given password using XChaCha20-Poly1305
-func DecryptFileXChachaPoly(encDbPath string, password string) error {
+// Encrypt the database path using the given cipher and KDF parameters
+func EncryptFile(dbPath string, password string, keyFileHash []byte, cipherId uint8, params *KdfParams) error {
+
+	var err error
+	var plainText []byte
+	var encText []byte
+
+	plainText, err = os.ReadFile(dbPath)
+	if err != nil {
+		fmt.Printf("Error - Can't read database -\"%s\"\n", err)
+		return err
+	}
+
+	err, encText = EncryptData(plainText, password, keyFileHash, cipherId, params)
+	if err != nil {
+		return err
+	}
+
+	return writeEncryptedFile(dbPath, encText)
+}
+
+// Decrypt an already encrypted database file using given password.
+// The cipher is read from the file header.
+func DecryptFile(encDbPath string, password string, keyFileHash []byte) error {
 
 	var encText []byte
-	var cipherText []byte
 	var plainText []byte
-	var salt []byte
-	var key []byte
-	var nonce []byte
-	var hmacHash []byte
-	var hmacSig []byte
 	var origFile string
-
 	var err error
 
 	encText, err = os.ReadFile(encDbPath)
@@ -389,60 +563,89 @@ func DecryptFileXChachaPoly(encDbPath string, password string) error {
 		return err
 	}
 
-	encText = encText[unsafe.Sizeof(MAGIC_HEADER):]
-	// Read the old salt
-	salt, encText = encText[:SALT_SIZE], encText[SALT_SIZE:]
-	// Read the hmac hash checksum
-	hmacHash, encText = encText[:HMAC_SHA512_SIZE], encText[HMAC_SHA512_SIZE:]
+	err, plainText, _ = DecryptData(encText, password, keyFileHash)
+	if err != nil {
+		return err
+	}
 
-	err, key, _ = GenerateKeyArgon2(password, &salt)
+	err, origFile = RewriteFile(encDbPath, plainText, 0600)
 
 	if err != nil {
-		fmt.Printf("Error - Key derivation failed -\"%s\"\n", err)
-		return err
+		fmt.Printf("Error writing decrypted data to %s - \"%s\"\n", origFile, err.Error())
 	}
 
-	// verify the hmac
-	// Calculate hmac signature and write it
-	hCipher := hmac.New(sha512.New, key)
-	hCipher.Write(encText)
+	return err
+}
 
-	hmacSig = hCipher.Sum(nil)
+// Re-encrypt an encrypted container under a new password with a fresh salt and nonce.
+// The cipher and KDF parameters are switched if given, else the current ones are kept.
+// The same keyfile hash (if any) is used for both. The plain text is only ever held in memory.
+func RekeyData(encText []byte, oldPassword, newPassword string, keyFileHash []byte, cipherId uint8, params *KdfParams) (error, []byte) {
 
-	// Compare
-	if !hmac.Equal(hmacSig, hmacHash) {
-		fmt.Println("Invalid password or tampered data. Aborted")
-		return errors.New("signature check failed")
-	}
-	//	fmt.Printf("\nsalt: %x\n", salt)
-	//	fmt.Printf("key: %x\n", key)
+	var err error
+	var plainText []byte
+	var header *FileHeader
 
-	aead, err := chacha.NewX(key)
+	err, plainText, header = DecryptData(encText, oldPassword, keyFileHash)
 	if err != nil {
-		fmt.Printf("Error - AEAD creation failed - \"%s\"\n", err)
-		return err
+		return err, nil
 	}
 
-	nonceSize := aead.NonceSize()
+	if cipherId == CIPHER_UNKNOWN {
+		cipherId = header.Cipher
+	}
+
+	if params == nil {
+		params = header.KdfParams()
+	}
 
-	nonce, cipherText = encText[:nonceSize], encText[nonceSize:]
-	//	fmt.Printf("nonce: %x\n", nonce)
-	plainText, err = aead.Open(nil, nonce, cipherText, nil)
+	return EncryptData(plainText, newPassword, keyFileHash, cipherId, params)
+}
+
+// Re-encrypt an encrypted database file under a new password and optionally a new cipher
+func RekeyFile(encDbPath string, oldPassword, newPassword string, keyFileHash []byte, cipherId uint8, params *KdfParams) error {
+
+	var encText []byte
+	var err error
 
+	encText, err = os.ReadFile(encDbPath)
 	if err != nil {
-		fmt.Printf("Error - Decryption failed - \"%s\"\n", err)
+		fmt.Printf("Error - Can't read database -\"%s\"\n", err)
 		return err
 	}
 
-	//	err = os.WriteFile("test.sqlite3", oplainText, 0600)
-	err, origFile = RewriteFile(encDbPath, plainText, 0600)
-
+	err, encText = RekeyData(encText, oldPassword, newPassword, keyFileHash, cipherId, params)
 	if err != nil {
-		fmt.Printf("Error writing decrypted data to %s - \"%s\"\n", origFile, err.Error())
+		return err
 	}
 
-	//	fmt.Printf("%s\n", string(plainText))
-	return err
+	return writeEncryptedFile(encDbPath, encText)
+}
+
+// Rewrite an encrypted database in the latest container format, keeping its cipher
+// and key derivation parameters
+func UpgradeFileFormat(encDbPath string, password string, keyFileHash []byte) error {
+	return RekeyFile(encDbPath, password, password, keyFileHash, CIPHER_UNKNOWN, nil)
+}
+
+// Encrypt the database path using AES
+func EncryptFileAES(dbPath string, password string) error {
+	return EncryptFile(dbPath, password, nil, CIPHER_AES, nil)
+}
+
+// Decrypt an already encrypted database file using given password using AES
+func DecryptFileAES(encDbPath string, password string) error {
+	return DecryptFile(encDbPath, password, nil)
+}
+
+// Encrypt a file using XChaCha20-Poly1305 cipher
+func EncryptFileXChachaPoly(dbPath string, password string) error {
+	return EncryptFile(dbPath, password, nil, CIPHER_XCHACHA, nil)
+}
+
+// Decrypt an already encrypted database file using given password using XChaCha20-Poly1305
+func DecryptFileXChachaPoly(encDbPath string, password string) error {
+	return DecryptFile(encDbPath, password, nil)
 }
 
 // Generate a random password - for adding listings
@@ -2,14 +2,18 @@
 package varuh
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
+	"github.com/mattn/go-sqlite3"
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 	"gorm.io/gorm/logger"
 	"os"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -19,44 +23,36 @@ import (
 type Entry struct {
 	ID         int    `gorm:"column:id;autoIncrement;primaryKey"`
 	Title      string `gorm:"column:title"`      // For card type this -> Card Name
-	User       string `gorm:"column:user"`       // For card type this -> Card Holder Name
+	User       string `gorm:"column:user"`       // For card type this -> Card Holder Name, for identity -> full name
 	Url        string `gorm:"column:url"`        // For card type this -> Card Number
 	Password   string `gorm:"column:password"`   // For card type this -> CVV number
 	Pin        string `gorm:"column:pin"`        // For card type this -> card pin
-	ExpiryDate string `gorm:"colum:expiry_date"` // For card type this -> Card expiry date
-	Issuer     string `gorm:"column:issuer"`     // For card type this -> Issuing bank
-	Class      string `gorm:"column:class"`      // For card type this -> visa/mastercard/amex etc
+	ExpiryDate string `gorm:"colum:expiry_date"` // For card type this -> Card expiry date, for identity -> document expiry
+	Issuer     string `gorm:"column:issuer"`     // For card type this -> Issuing bank, for identity -> issuing authority
+	Class      string `gorm:"column:class"`      // For card type this -> visa/mastercard/amex etc, for identity -> document type
 
 	Notes     string    `gorm:"column:notes"`
 	Tags      string    `gorm:"column:tags"`
+	Otp       string    `gorm:"column:otp"`                                           // otpauth:// URI of a 2FA secret
+	GroupID   int       `gorm:"column:group_id;index"`                                // Group of the entry, 0 for top level
 	Type      string    `gorm:"column:type"`                                          // Entry type, default/card/ID
 	Timestamp time.Time `gorm:"type:timestamp;default:(datetime('now','localtime'))"` // sqlite3
 
-	// 	ID        int       `gorm:"column:id;autoIncrement;primaryKey"`
-	// 	Type      string    `gorm:"column:type"`  // Type of entry - password (default), card, identity etc
-	// 	Title     string    `gorm:"column:title"`
-	//     Name      string    `gorm:"column:name"` // Card holder name/ID card name - for types cards/identity
-	//   	Company   string    `gorm:"column:company"` // Company name of person  - for type identity and
-	//                                              	// Credit card company for type CC
-	// 	Number    string    `gorm:"column:number"`  // Number type - CC number for credit cards
-	// 	                                            // ID card number for identity types
-	// 	SecurityCode string  `gorm:"security_code"` // CVV number/security code for CC type
-	// 	ExpiryMonth string   `gorm:"expiry_month"` // CC or Identity document expiry month
-	// 	ExpiryDay string     `gorm:"expiry_day"`   // Identity document expiry day
-	// 	ExpiryYear string    `gorm:"expiry_year"`  // CC or Identity document expiry year
-	// 	FirstName  string    `gorm:"column:first_name"` // first name - for ID card types
-	// 	MiddleName string    `gorm:"column:middle_name"` // middle name - for ID card types
-	// 	LastName   string    `gorm:"column:last_name"` // last name - for ID card types
-	// 	Email      string    `gorm:"email"` // Email - for ID card types
-	// 	PhoneNumber string   `gorm:"phone_number"` // Phone number - for ID card types
-
-	// 	Active    bool       `gorm:"active;default:true"` // Is the id card/CC active ?
-	// 	User      string    `gorm:"column:user"`
-	// 	Url       string    `gorm:"column:url"`
-	// 	Password  string    `gorm:"column:password"`
-	// 	Notes     string    `gorm:"column:notes"`
-	// 	Tags      string    `gorm:"column:tags"`
-	// 	Timestamp time.Time `gorm:"type:timestamp;default:(datetime('now','localtime'))"` // sqlite3
+	DeletedAt gorm.DeletedAt `gorm:"column:deleted_at;index"` // Set when moved to trash
+
+	// For identity type
+	FirstName   string `gorm:"column:first_name"`
+	MiddleName  string `gorm:"column:middle_name"`
+	LastName    string `gorm:"column:last_name"`
+	Email       string `gorm:"column:email"`
+	PhoneNumber string `gorm:"column:phone_number"`
+	Company     string `gorm:"column:company"`
+	Number      string `gorm:"column:number"` // Document number - passport, license etc
+
+	// For sshkey type - comment is kept in user, passphrase in password
+	// and key type in class
+	PrivateKey string `gorm:"column:private_key"` // OpenSSH or PEM format
+	PublicKey  string `gorm:"column:public_key"`  // authorized_keys format, without the comment
 
 }
 
@@ -102,6 +98,78 @@ func (ad *Address) TableName() string {
 	return "address"
 }
 
+// Clone an address
+func (a1 *Address) Copy(a2 *Address) {
+
+	if a2 != nil {
+		a1.Number = a2.Number
+		a1.Building = a2.Building
+		a1.Street = a2.Street
+		a1.Locality = a2.Locality
+		a1.Area = a2.Area
+		a1.City = a2.City
+		a1.State = a2.State
+		a1.Country = a2.Country
+		a1.Landmark = a2.Landmark
+		a1.ZipCode = a2.ZipCode
+		a1.Type = a2.Type
+		a1.EntryID = a2.EntryID
+	}
+}
+
+// Format an address on one line
+func (a *Address) String() string {
+
+	var parts []string
+
+	for _, part := range []string{a.Number, a.Building, a.Street, a.Locality, a.Area, a.City,
+		strings.TrimSpace(a.State + " " + a.ZipCode), a.Country} {
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+
+	address := strings.Join(parts, ", ")
+	if a.Landmark != "" {
+		address += " (near " + a.Landmark + ")"
+	}
+
+	return address
+}
+
+// Structure representing a group (folder) of entries. Groups nest.
+type Group struct {
+	ID       int    `gorm:"column:id;autoIncrement;primaryKey"`
+	Name     string `gorm:"column:name;uniqueIndex:idx_group_parent_name"`
+	ParentID int    `gorm:"column:parent_id;uniqueIndex:idx_group_parent_name"` // 0 for top level groups
+
+	Timestamp time.Time `gorm:"type:timestamp;default:(datetime('now','localtime'))"` // sqlite3
+}
+
+func (g *Group) TableName() string {
+	return "groups"
+}
+
+// Structure representing the previous value of a field of an entry.
+// All fields changed by one update share the revision number.
+type Revision struct {
+	ID        int    `gorm:"column:id;autoIncrement;primaryKey"`
+	Revision  int    `gorm:"column:revision"`   // Revision number, counted per entry
+	FieldName string `gorm:"column:field_name"` // Column name or custom field name
+	OldValue  string `gorm:"column:old_value"`  // Value before the revision
+	Custom    bool   `gorm:"column:custom"`     // Is it a custom field ?
+	Absent    bool   `gorm:"column:absent"`     // Custom field did not exist before the revision
+
+	Timestamp time.Time `gorm:"type:timestamp;default:(datetime('now','localtime'))"` // sqlite3
+
+	Entry   Entry `gorm:"foreignKey:EntryID"`
+	EntryID int
+}
+
+func (r *Revision) TableName() string {
+	return "revisions"
+}
+
 // Clone an entry
 func (e1 *Entry) Copy(e2 *Entry) {
 
@@ -114,7 +182,9 @@ func (e1 *Entry) Copy(e2 *Entry) {
 			e1.Password = e2.Password
 			e1.Notes = e2.Notes
 			e1.Tags = e2.Tags
+			e1.Otp = e2.Otp
 			e1.Type = e2.Type
+			e1.GroupID = e2.GroupID
 		case "card":
 			e1.Title = e2.Title
 			e1.User = e2.User // card holder name
@@ -125,20 +195,41 @@ func (e1 *Entry) Copy(e2 *Entry) {
 			e1.Tags = e2.Tags
 			e1.Notes = e2.Notes
 			e1.Type = e2.Type
-			// case "identity":
-			// 	e1.Title = e2.Title
-			// 	e1.Name = e2.Name
-			// 	e1.Company = e2.Company
-			// 	e1.FirstName = e2.FirstName
-			// 	e1.LastName = e2.LastName
-			// 	e1.MiddleName = e2.MiddleName
-			// 	e1.User = e2.User
-			// 	e1.Email = e2.Email
-			// 	e1.PhoneNumber = e2.PhoneNumber
-			// 	e1.Number = e2.Number
-			// 	e1.Notes = e2.Notes
-			// 	e1.Tags = e2.Tags
-			// 	e1.Type = e2.Type
+			e1.GroupID = e2.GroupID
+		case "identity":
+			e1.Title = e2.Title
+			e1.Company = e2.Company
+			e1.FirstName = e2.FirstName
+			e1.LastName = e2.LastName
+			e1.MiddleName = e2.MiddleName
+			e1.User = e2.User
+			e1.Email = e2.Email
+			e1.PhoneNumber = e2.PhoneNumber
+			e1.Number = e2.Number
+			e1.Class = e2.Class
+			e1.Issuer = e2.Issuer
+			e1.ExpiryDate = e2.ExpiryDate
+			e1.Notes = e2.Notes
+			e1.Tags = e2.Tags
+			e1.Type = e2.Type
+			e1.GroupID = e2.GroupID
+		case "sshkey":
+			e1.Title = e2.Title
+			e1.User = e2.User // comment
+			e1.Password = e2.Password
+			e1.Class = e2.Class
+			e1.PrivateKey = e2.PrivateKey
+			e1.PublicKey = e2.PublicKey
+			e1.Notes = e2.Notes
+			e1.Tags = e2.Tags
+			e1.Type = e2.Type
+			e1.GroupID = e2.GroupID
+		case "address", "note":
+			e1.Title = e2.Title
+			e1.Notes = e2.Notes
+			e1.Tags = e2.Tags
+			e1.Type = e2.Type
+			e1.GroupID = e2.GroupID
 		}
 	}
 }
@@ -153,6 +244,10 @@ func (e1 *ExtendedEntry) Copy(e2 *ExtendedEntry) {
 	}
 }
 
+// Encrypted database unlocked in memory, if any, and its path
+var memoryDB *gorm.DB
+var memoryDBPath string
+
 // Create a new database
 func OpenDatabase(filePath string) (error, *gorm.DB) {
 	// Check if file exists first
@@ -160,6 +255,11 @@ func OpenDatabase(filePath string) (error, *gorm.DB) {
 		return errors.New("database path cannot be empty"), nil
 	}
 
+	// Database is unlocked in memory - use that
+	if memoryDB != nil && isMemoryDatabasePath(filePath) {
+		return nil, memoryDB
+	}
+
 	if _, err := os.Stat(filePath); os.IsNotExist(err) {
 		return fmt.Errorf("database file does not exist: %s", filePath), nil
 	}
@@ -170,6 +270,104 @@ func OpenDatabase(filePath string) (error, *gorm.DB) {
 	return err, db
 }
 
+// Open an in-memory database from the contents of a sqlite file.
+// Nothing is written to disk.
+func OpenMemoryDatabase(contents []byte) (error, *gorm.DB) {
+
+	var err error
+	var db *gorm.DB
+	var sqlDB *sql.DB
+
+	db, err = gorm.Open(sqlite.Open(":memory:"), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		return err, nil
+	}
+
+	// Every connection to ":memory:" is a new database, so keep just one
+	sqlDB, err = db.DB()
+	if err != nil {
+		return err, nil
+	}
+	sqlDB.SetMaxOpenConns(1)
+	sqlDB.SetMaxIdleConns(1)
+	sqlDB.SetConnMaxLifetime(0)
+
+	if len(contents) == 0 {
+		return nil, db
+	}
+
+	err = withSQLiteConn(sqlDB, func(conn *sqlite3.SQLiteConn) error {
+		return conn.Deserialize(contents, "main")
+	})
+	if err != nil {
+		sqlDB.Close()
+		return err, nil
+	}
+
+	return nil, db
+}
+
+// Return the contents of a database as a sqlite file image
+func SerializeDatabase(db *gorm.DB) (error, []byte) {
+
+	var err error
+	var sqlDB *sql.DB
+	var contents []byte
+
+	sqlDB, err = db.DB()
+	if err != nil {
+		return err, nil
+	}
+
+	err = withSQLiteConn(sqlDB, func(conn *sqlite3.SQLiteConn) error {
+		contents, err = conn.Serialize("main")
+		return err
+	})
+
+	return err, contents
+}
+
+// Run a function on the underlying sqlite connection of the database
+func withSQLiteConn(sqlDB *sql.DB, fn func(*sqlite3.SQLiteConn) error) error {
+
+	conn, err := sqlDB.Conn(context.Background())
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	return conn.Raw(func(driverConn interface{}) error {
+		sqliteConn, ok := driverConn.(*sqlite3.SQLiteConn)
+		if !ok {
+			return errors.New("not a sqlite connection")
+		}
+		return fn(sqliteConn)
+	})
+}
+
+// Make an in-memory database the handle for a database path, so that
+// OpenDatabase returns it for that path. A nil handle clears it.
+func SetMemoryDatabase(dbPath string, db *gorm.DB) {
+
+	if db == nil && memoryDB != nil {
+		if sqlDB, err := memoryDB.DB(); err == nil {
+			sqlDB.Close()
+		}
+	}
+
+	memoryDBPath, _ = filepath.Abs(dbPath)
+	memoryDB = db
+}
+
+// Return true if the path is that of the database unlocked in memory
+func isMemoryDatabasePath(dbPath string) bool {
+
+	absPath, err := filepath.Abs(dbPath)
+	return err == nil && absPath == memoryDBPath
+}
+
 // Create a new table for Entries in the database
 func CreateNewEntry(db *gorm.DB) error {
 	return db.AutoMigrate(&Entry{})
@@ -180,6 +378,59 @@ func CreateNewExEntry(db *gorm.DB) error {
 	return db.AutoMigrate(&ExtendedEntry{})
 }
 
+// Create a new table for entry revisions in the database
+func CreateNewRevision(db *gorm.DB) error {
+	return db.AutoMigrate(&Revision{})
+}
+
+// Version of the schema, kept in the sqlite user_version of a database.
+// Bump when models change so that databases are migrated when opened.
+const SCHEMA_VERSION = 9
+
+// Create or migrate all tables to the latest schema
+func MigrateSchema(db *gorm.DB) error {
+
+	var version int
+
+	models := []interface{}{&Entry{}, &ExtendedEntry{}, &Revision{}, &Group{}, &Tag{}, &EntryTag{}, &Attachment{}, &Address{}}
+
+	for _, model := range models {
+		if err := db.AutoMigrate(model); err != nil {
+			return fmt.Errorf("table \"%s\" - %s", model.(interface{ TableName() string }).TableName(), err.Error())
+		}
+	}
+
+	if err := CreateSearchIndex(db); err != nil {
+		return fmt.Errorf("search index - %s", err.Error())
+	}
+
+	db.Raw("PRAGMA user_version").Scan(&version)
+	if version < 3 {
+		// Tags table is built from the tags strings of entries
+		if err := SyncAllEntryTags(db); err != nil {
+			return fmt.Errorf("tags - %s", err.Error())
+		}
+	}
+
+	return db.Exec(fmt.Sprintf("PRAGMA user_version = %d", SCHEMA_VERSION)).Error
+}
+
+// Migrate a database opened for an action if it has an older schema
+func ensureSchema(db *gorm.DB) error {
+
+	var version int
+
+	db.Raw("PRAGMA user_version").Scan(&version)
+	if version >= SCHEMA_VERSION {
+		if isFTS5SearchIndex(db) {
+			return CreateSearchIndex(db)
+		}
+		return nil
+	}
+
+	return MigrateSchema(db)
+}
+
 // Init new database including tables
 func InitNewDatabase(dbPath string) error {
 
@@ -202,8 +453,27 @@ func InitNewDatabase(dbPath string) error {
 		} else {
 			// TBD
 			fmt.Printf("Encrytping current database - %s\n", activeDbPath)
+			// Any keyfile given is meant for the new database
+			keyFile := SettingsRider.KeyFile
+			SettingsRider.KeyFile = ""
 			EncryptDatabase(activeDbPath, nil)
+			SettingsRider.KeyFile = keyFile
+		}
+	}
+
+	if SettingsRider.KeyFile != "" {
+		if _, err = os.Stat(SettingsRider.KeyFile); os.IsNotExist(err) {
+			err = CreateKeyFile(SettingsRider.KeyFile)
+			if err != nil {
+				fmt.Printf("Error creating keyfile - \"%s\"\n", err.Error())
+				return err
+			}
+			fmt.Printf("Created new keyfile - %s\n", SettingsRider.KeyFile)
+		} else if err, _ = HashKeyFile(SettingsRider.KeyFile); err != nil {
+			fmt.Printf("Error - %s\n", err.Error())
+			return err
 		}
+		fmt.Println("Keep the keyfile safe, it is needed along with the password to decrypt this database.")
 	}
 
 	if _, err = os.Stat(dbPath); err == nil {
@@ -217,13 +487,7 @@ func InitNewDatabase(dbPath string) error {
 		return err
 	}
 
-	err = CreateNewEntry(db)
-	if err != nil {
-		fmt.Printf("Error creating schema - \"%s\"\n", err.Error())
-		return err
-	}
-
-	err = CreateNewExEntry(db)
+	err = MigrateSchema(db)
 	if err != nil {
 		fmt.Printf("Error creating schema - \"%s\"\n", err.Error())
 		return err
@@ -265,6 +529,12 @@ func openActiveDatabase() (error, *gorm.DB) {
 		return err, nil
 	}
 
+	err = ensureSchema(db)
+	if err != nil {
+		fmt.Printf("Error migrating active database - %s: %s\n", dbPath, err.Error())
+		return err, nil
+	}
+
 	return nil, db
 }
 
@@ -329,14 +599,14 @@ func ReplaceCustomEntries(db *gorm.DB, entry *Entry, updatedEntries []CustomEntr
 
 // Add a new entry to current database
 func AddNewDatabaseEntry(title, userName, url, passwd, tags string,
-	notes string, customEntries []CustomEntry) error {
+	notes, otp string, customEntries []CustomEntry) error {
 
 	var entry Entry
 	var err error
 	var db *gorm.DB
 
 	entry = Entry{Title: title, User: userName, Url: url, Password: passwd, Tags: strings.TrimSpace(tags),
-		Notes: notes}
+		Notes: notes, Otp: otp}
 
 	err, db = openActiveDatabase()
 	if err == nil && db != nil {
@@ -345,6 +615,7 @@ func AddNewDatabaseEntry(title, userName, url, passwd, tags string,
 		if result.Error == nil && result.RowsAffected == 1 {
 			// Add custom fields if given
 			fmt.Printf("Created new entry with id: %d.\n", entry.ID)
+			updateEntryTags(db, entry.ID)
 			if len(customEntries) > 0 {
 				return AddCustomEntries(db, &entry, customEntries)
 			}
@@ -396,11 +667,21 @@ func UpdateDatabaseCardEntry(entry *Entry, cardName, cardNumber, cardHolder, car
 	err, db := openActiveDatabase()
 
 	if err == nil && db != nil {
+		// Keep the old values before overwriting them
+		err = RecordRevision(db, entry, updateMap, customEntries, flag)
+		if err != nil {
+			return err
+		}
+
 		result := db.Model(entry).Updates(updateMap)
 		if result.Error != nil {
 			return result.Error
 		}
 
+		if _, ok := updateMap["tags"]; ok {
+			updateEntryTags(db, entry.ID)
+		}
+
 		if flag {
 			ReplaceCustomEntries(db, entry, customEntries)
 		}
@@ -444,6 +725,7 @@ func AddNewDatabaseCardEntry(cardName, cardNumber, cardHolder, cardIssuer, cardC
 		if result.Error == nil && result.RowsAffected == 1 {
 			// Add custom fields if given
 			fmt.Printf("Created new entry with id: %d.\n", entry.ID)
+			updateEntryTags(db, entry.ID)
 			if len(customEntries) > 0 {
 				return AddCustomEntries(db, &entry, customEntries)
 			}
@@ -456,6 +738,101 @@ func AddNewDatabaseCardEntry(cardName, cardNumber, cardHolder, cardIssuer, cardC
 	return err
 }
 
+// Join the parts of a name which are set
+func FullName(firstName, middleName, lastName string) string {
+	return strings.Join(strings.Fields(strings.Join([]string{firstName, middleName, lastName}, " ")), " ")
+}
+
+// Add a new identity entry to current database
+func AddNewDatabaseIdentityEntry(entry *Entry, customEntries []CustomEntry) error {
+
+	var err error
+	var db *gorm.DB
+
+	entry.Type = "identity"
+	entry.User = FullName(entry.FirstName, entry.MiddleName, entry.LastName)
+	entry.Tags = strings.TrimSpace(entry.Tags)
+
+	err, db = openActiveDatabase()
+	if err == nil && db != nil {
+		result := db.Create(entry)
+		if result.Error == nil && result.RowsAffected == 1 {
+			// Add custom fields if given
+			fmt.Printf("Created new entry with id: %d.\n", entry.ID)
+			updateEntryTags(db, entry.ID)
+			if len(customEntries) > 0 {
+				return AddCustomEntries(db, entry, customEntries)
+			}
+			return nil
+		} else if result.Error != nil {
+			return result.Error
+		}
+	}
+
+	return err
+}
+
+// Update an identity entry with the non-empty values in keyValMap, keyed by column
+func UpdateDatabaseIdentityEntry(entry *Entry, keyValMap map[string]string,
+	customEntries []CustomEntry, flag bool) error {
+
+	var updateMap map[string]interface{}
+	updateMap = make(map[string]interface{})
+
+	for key, val := range keyValMap {
+		val := strings.TrimSpace(val)
+		if len(val) > 0 {
+			updateMap[key] = val
+		}
+	}
+
+	if len(updateMap) == 0 && !flag {
+		fmt.Printf("Nothing to update\n")
+		return nil
+	}
+
+	// Full name follows the parts
+	names := []string{entry.FirstName, entry.MiddleName, entry.LastName}
+	for idx, column := range []string{"first_name", "middle_name", "last_name"} {
+		if val, ok := updateMap[column]; ok {
+			names[idx] = val.(string)
+		}
+	}
+	if fullName := FullName(names[0], names[1], names[2]); fullName != entry.User {
+		updateMap["user"] = fullName
+	}
+
+	// Update timestamp also
+	updateMap["timestamp"] = time.Now()
+
+	err, db := openActiveDatabase()
+
+	if err == nil && db != nil {
+		// Keep the old values before overwriting them
+		err = RecordRevision(db, entry, updateMap, customEntries, flag)
+		if err != nil {
+			return err
+		}
+
+		result := db.Model(entry).Updates(updateMap)
+		if result.Error != nil {
+			return result.Error
+		}
+
+		if _, ok := updateMap["tags"]; ok {
+			updateEntryTags(db, entry.ID)
+		}
+
+		if flag {
+			ReplaceCustomEntries(db, entry, customEntries)
+		}
+		fmt.Println("Updated entry.")
+		return nil
+	}
+
+	return err
+}
+
 // Update current database entry with new values
 func UpdateDatabaseEntry(entry *Entry, title, userName, url, passwd, tags string,
 	notes string, customEntries []CustomEntry, flag bool) error {
@@ -489,11 +866,21 @@ func UpdateDatabaseEntry(entry *Entry, title, userName, url, passwd, tags string
 	err, db := openActiveDatabase()
 
 	if err == nil && db != nil {
+		// Keep the old values before overwriting them
+		err = RecordRevision(db, entry, updateMap, customEntries, flag)
+		if err != nil {
+			return err
+		}
+
 		result := db.Model(entry).Updates(updateMap)
 		if result.Error != nil {
 			return result.Error
 		}
 
+		if _, ok := updateMap["tags"]; ok {
+			updateEntryTags(db, entry.ID)
+		}
+
 		if flag {
 			ReplaceCustomEntries(db, entry, customEntries)
 		}
@@ -504,6 +891,44 @@ func UpdateDatabaseEntry(entry *Entry, title, userName, url, passwd, tags string
 	return err
 }
 
+// Set or clear the OTP secret of an entry, keeping the old one in its history
+func SetEntryOtp(entry *Entry, otp string) error {
+
+	var err error
+	var db *gorm.DB
+
+	if otp != "" {
+		if err, otp = NormalizeOtp(otp, entry.Title); err != nil {
+			return err
+		}
+	}
+
+	err, db = openActiveDatabase()
+	if err != nil {
+		return err
+	}
+
+	updateMap := map[string]interface{}{"otp": otp}
+	if err = RecordRevision(db, entry, updateMap, nil, false); err != nil {
+		return err
+	}
+
+	updateMap["timestamp"] = time.Now()
+	return db.Model(entry).Updates(updateMap).Error
+}
+
+// Store the OTP URI of an entry without recording a revision - used to
+// move HOTP counters on
+func updateEntryOtp(entry *Entry, otp string) error {
+
+	err, db := openActiveDatabase()
+	if err != nil {
+		return err
+	}
+
+	return db.Model(entry).Update("otp", otp).Error
+}
+
 // Find entry given the id
 func GetEntryById(id int) (error, *Entry) {
 
@@ -524,41 +949,15 @@ func GetEntryById(id int) (error, *Entry) {
 	return err, nil
 }
 
-// Search database for the given string and return all matches
+// Search database for the given query and return all matches
 func SearchDatabaseEntry(term string) (error, []Entry) {
+	return SearchEntries(term)
+}
 
-	var entries []Entry
-	var err error
-	var db *gorm.DB
-	var searchTerm string
+// Union of two entry arrays
+func union(entry1 []Entry, entry2 []Entry) []Entry {
 
-	err, db = openActiveDatabase()
-	if err == nil && db != nil {
-		searchTerm = fmt.Sprintf("%%%s%%", term)
-		// Search on fields title, user, url and notes and tags.
-		query := db.Where(fmt.Sprintf("title like \"%s\"", searchTerm))
-
-		for _, field := range []string{"user", "url", "notes", "tags"} {
-			query = query.Or(fmt.Sprintf("%s like \"%s\"", field, searchTerm))
-		}
-
-		res := query.Find(&entries)
-
-		if res.Error != nil {
-			return res.Error, nil
-		}
-
-		return nil, entries
-	}
-
-	return err, entries
-
-}
-
-// Union of two entry arrays
-func union(entry1 []Entry, entry2 []Entry) []Entry {
-
-	m := make(map[int]bool)
+	m := make(map[int]bool)
 
 	for _, item := range entry1 {
 		m[item.ID] = true
@@ -622,7 +1021,7 @@ func SearchDatabaseEntries(terms []string, operator string) (error, []Entry) {
 	return nil, finalEntries
 }
 
-// Remove a given database entry
+// Remove a given database entry - it is moved to the trash
 func RemoveDatabaseEntry(entry *Entry) error {
 
 	var err error
@@ -630,28 +1029,112 @@ func RemoveDatabaseEntry(entry *Entry) error {
 
 	err, db = openActiveDatabase()
 	if err == nil && db != nil {
-		var exEntries []ExtendedEntry
-
+		// Soft delete, custom fields and history are kept till purged
 		res := db.Delete(entry)
+		return res.Error
+	}
+
+	return err
+}
+
+// Get entries in the trash, most recently deleted first
+func GetTrashedEntries() (error, []Entry) {
+
+	var err error
+	var db *gorm.DB
+	var entries []Entry
+
+	err, db = openActiveDatabase()
+	if err == nil && db != nil {
+		res := db.Unscoped().Where("deleted_at is not null").Order("deleted_at desc").Find(&entries)
+		return res.Error, entries
+	}
+
+	return err, nil
+}
+
+// Bring an entry back from the trash
+func RestoreTrashedEntry(id int) error {
+
+	var err error
+	var db *gorm.DB
+
+	err, db = openActiveDatabase()
+	if err == nil && db != nil {
+		res := db.Unscoped().Model(&Entry{}).Where("id = ? and deleted_at is not null", id).Update("deleted_at", nil)
 		if res.Error != nil {
 			return res.Error
 		}
-
-		// Delete extended entries if any
-		exEntries = GetExtendedEntries(entry)
-		if len(exEntries) > 0 {
-			res = db.Delete(exEntries)
-			if res.Error != nil {
-				return res.Error
-			}
+		if res.RowsAffected == 0 {
+			return fmt.Errorf("no entry with id %d in trash", id)
 		}
-
 		return nil
 	}
 
 	return err
 }
 
+// Permanently delete entries in the trash along with their custom fields,
+// history, attachments and addresses. If olderThan is non-zero, only entries deleted longer ago
+// than that are purged. Returns the number of entries purged.
+func PurgeTrashedEntries(olderThan time.Duration) (error, int) {
+
+	var err error
+	var entries []Entry
+	var ids []int
+	var db *gorm.DB
+
+	err, entries = GetTrashedEntries()
+	if err != nil {
+		return err, 0
+	}
+
+	cutoff := time.Now().Add(-olderThan)
+
+	for _, entry := range entries {
+		if olderThan == 0 || entry.DeletedAt.Time.Before(cutoff) {
+			ids = append(ids, entry.ID)
+		}
+	}
+
+	if len(ids) == 0 {
+		return nil, 0
+	}
+
+	err, db = openActiveDatabase()
+	if err != nil {
+		return err, 0
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("entry_id in ?", ids).Delete(&ExtendedEntry{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("entry_id in ?", ids).Delete(&Revision{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("entry_id in ?", ids).Delete(&EntryTag{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("entry_id in ?", ids).Delete(&Attachment{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("entry_id in ?", ids).Delete(&Address{}).Error; err != nil {
+			return err
+		}
+		if err := removeOrphanTags(tx); err != nil {
+			return err
+		}
+		return tx.Unscoped().Where("id in ?", ids).Delete(&Entry{}).Error
+	})
+
+	if err != nil {
+		return err, 0
+	}
+
+	return nil, len(ids)
+}
+
 // Clone an entry and return cloned entry
 func CloneEntry(entry *Entry) (error, *Entry) {
 
@@ -666,6 +1149,7 @@ func CloneEntry(entry *Entry) (error, *Entry) {
 		result := db.Create(&entryNew)
 		if result.Error == nil && result.RowsAffected == 1 {
 			fmt.Printf("Cloned to new entry, id: %d.\n", entryNew.ID)
+			updateEntryTags(db, entryNew.ID)
 			return nil, &entryNew
 		} else if result.Error != nil {
 			return result.Error, nil
@@ -700,19 +1184,60 @@ func CloneExtendedEntries(entry *Entry, exEntries []ExtendedEntry) error {
 	return err
 }
 
+// Columns entries can be listed by, keyed by the names accepted in list_order.
+// An unset list_order lists by id.
+var entryOrderColumns = map[string]string{
+	"":          "id",
+	"id":        "id",
+	"title":     "title",
+	"user":      "user",
+	"username":  "user",
+	"url":       "url",
+	"type":      "type",
+	"timestamp": "timestamp",
+}
+
+// Validate an order key and direction against the allowed columns
+// and return them as an order clause
+func entryOrderClause(orderKey string, order string) (error, clause.OrderByColumn) {
+
+	column, ok := entryOrderColumns[strings.ToLower(strings.TrimSpace(orderKey))]
+	if !ok {
+		return fmt.Errorf("invalid order key \"%s\"", orderKey), clause.OrderByColumn{}
+	}
+
+	order = strings.ToLower(strings.TrimSpace(order))
+	if order != "" && order != "asc" && order != "desc" {
+		return fmt.Errorf("invalid order \"%s\" - should be asc or desc", order), clause.OrderByColumn{}
+	}
+
+	return nil, clause.OrderByColumn{Column: clause.Column{Name: column}, Desc: order == "desc"}
+}
+
 // Return an iterator over all entries using the given order query keys
 func IterateEntries(orderKey string, order string) (error, []Entry) {
 
 	var err error
 	var db *gorm.DB
 	var entries []Entry
+	var orderBy clause.OrderByColumn
+
+	err, orderBy = entryOrderClause(orderKey, order)
+	if err != nil {
+		return err, nil
+	}
 
 	err, db = openActiveDatabase()
 
 	if err == nil && db != nil {
 		var rows *sql.Rows
 
-		rows, err = db.Model(&Entry{}).Order(fmt.Sprintf("%s %s", orderKey, order)).Rows()
+		rows, err = db.Model(&Entry{}).Order(orderBy).Rows()
+		if err != nil {
+			return err, nil
+		}
+		defer rows.Close()
+
 		for rows.Next() {
 			var entry Entry
 
@@ -743,6 +1268,12 @@ func EntriesToStringArray(skipLongFields bool) (error, [][]string) {
 
 		dataArray = make([][]string, 0, count)
 
+		// Fetched up front - the memory database has one connection
+		addressMap := make(map[int][]Address)
+		for _, address := range addressesOf(db, nil) {
+			addressMap[address.EntryID] = append(addressMap[address.EntryID], address)
+		}
+
 		rows, err = db.Model(&Entry{}).Order("id asc").Rows()
 		for rows.Next() {
 			var entry Entry
@@ -750,6 +1281,16 @@ func EntriesToStringArray(skipLongFields bool) (error, [][]string) {
 
 			db.ScanRows(rows, &entry)
 
+			if entry.Type == "identity" {
+				identityExportFields(&entry)
+			} else if entry.Type == "sshkey" {
+				sshKeyExportFields(&entry)
+			}
+
+			for _, address := range addressMap[entry.ID] {
+				entry.Notes = strings.TrimPrefix(entry.Notes+"; Address: "+address.String(), "; ")
+			}
+
 			if skipLongFields {
 				// Skip Notes
 				entryData = []string{strconv.Itoa(entry.ID), entry.Title, entry.User, entry.Password, entry.Timestamp.Format("2006-06-02 15:04:05")}
@@ -764,6 +1305,213 @@ func EntriesToStringArray(skipLongFields bool) (error, [][]string) {
 	return err, dataArray
 }
 
+// Map the fields of an identity entry to the columns of an export - email
+// as URL, document number as password and the rest before the notes
+func identityExportFields(entry *Entry) {
+
+	var details []string
+
+	for _, field := range [][2]string{{"Phone", entry.PhoneNumber}, {"Company", entry.Company},
+		{"Document", strings.TrimSpace(entry.Class + " " + entry.Issuer)}, {"Expiry", entry.ExpiryDate}} {
+		if field[1] != "" {
+			details = append(details, field[0]+": "+field[1])
+		}
+	}
+
+	if entry.Notes != "" {
+		details = append(details, entry.Notes)
+	}
+
+	entry.Url = entry.Email
+	entry.Password = entry.Number
+	entry.Notes = strings.Join(details, "; ")
+}
+
+// Map the fields of an sshkey entry to the columns of an export - public
+// key as URL and the private key before the notes
+func sshKeyExportFields(entry *Entry) {
+
+	entry.Url = strings.TrimSpace(entry.PublicKey + " " + entry.User)
+	entry.Notes = strings.TrimSpace(entry.PrivateKey + "\n" + entry.Notes)
+}
+
+// Get addresses of an entry given the database, or of all entries if nil
+func addressesOf(db *gorm.DB, entry *Entry) []Address {
+
+	var addresses []Address
+
+	if db.Migrator().HasTable(&Address{}) {
+		query := db.Order("id asc")
+		if entry != nil {
+			query = query.Where("entry_id = ?", entry.ID)
+		}
+		query.Find(&addresses)
+	}
+
+	return addresses
+}
+
+// Add addresses to an entry
+func AddAddresses(db *gorm.DB, entry *Entry, addresses []Address) error {
+
+	for idx := range addresses {
+		addresses[idx].ID = 0
+		addresses[idx].EntryID = entry.ID
+
+		if res := db.Create(&addresses[idx]); res.Error != nil {
+			return res.Error
+		}
+	}
+
+	return nil
+}
+
+// Get addresses of an entry
+func GetAddresses(entry *Entry) []Address {
+
+	var err error
+	var db *gorm.DB
+	var addresses []Address
+
+	err, db = openActiveDatabase()
+
+	if err == nil && db != nil {
+		addresses = addressesOf(db, entry)
+	}
+
+	return addresses
+}
+
+// Add an address to an entry of the active database
+func AddEntryAddress(entry *Entry, address *Address) error {
+
+	err, db := openActiveDatabase()
+	if err != nil {
+		return err
+	}
+
+	addresses := []Address{*address}
+	if err = AddAddresses(db, entry, addresses); err != nil {
+		return err
+	}
+
+	*address = addresses[0]
+	return nil
+}
+
+// Save changes to an address
+func UpdateAddress(address *Address) error {
+
+	err, db := openActiveDatabase()
+	if err != nil {
+		return err
+	}
+
+	return db.Save(address).Error
+}
+
+// Add a new address entry to current database
+func AddNewDatabaseAddressEntry(title, notes, tags string, address *Address,
+	customEntries []CustomEntry) error {
+
+	var entry Entry
+	var err error
+	var db *gorm.DB
+
+	entry = Entry{Title: strings.TrimSpace(title), Notes: notes, Tags: strings.TrimSpace(tags), Type: "address"}
+
+	err, db = openActiveDatabase()
+	if err == nil && db != nil {
+		result := db.Create(&entry)
+		if result.Error == nil && result.RowsAffected == 1 {
+			fmt.Printf("Created new entry with id: %d.\n", entry.ID)
+			updateEntryTags(db, entry.ID)
+
+			if err = AddAddresses(db, &entry, []Address{*address}); err != nil {
+				return err
+			}
+			if len(customEntries) > 0 {
+				return AddCustomEntries(db, &entry, customEntries)
+			}
+			return nil
+		} else if result.Error != nil {
+			return result.Error
+		}
+	}
+
+	return err
+}
+
+// Add a new sshkey entry to current database
+func AddNewDatabaseSSHKeyEntry(entry *Entry, customEntries []CustomEntry) error {
+
+	var err error
+	var db *gorm.DB
+
+	entry.Type = "sshkey"
+	entry.Tags = strings.TrimSpace(entry.Tags)
+
+	err, db = openActiveDatabase()
+	if err == nil && db != nil {
+		result := db.Create(entry)
+		if result.Error == nil && result.RowsAffected == 1 {
+			fmt.Printf("Created new entry with id: %d.\n", entry.ID)
+			updateEntryTags(db, entry.ID)
+			if len(customEntries) > 0 {
+				return AddCustomEntries(db, entry, customEntries)
+			}
+			return nil
+		} else if result.Error != nil {
+			return result.Error
+		}
+	}
+
+	return err
+}
+
+// Add a new note entry to current database, the body is kept in the notes
+func AddNewDatabaseNoteEntry(title, body, tags string, customEntries []CustomEntry) error {
+
+	var entry Entry
+	var err error
+	var db *gorm.DB
+
+	entry = Entry{Title: strings.TrimSpace(title), Notes: body, Tags: strings.TrimSpace(tags), Type: "note"}
+
+	err, db = openActiveDatabase()
+	if err == nil && db != nil {
+		result := db.Create(&entry)
+		if result.Error == nil && result.RowsAffected == 1 {
+			fmt.Printf("Created new entry with id: %d.\n", entry.ID)
+			updateEntryTags(db, entry.ID)
+			if len(customEntries) > 0 {
+				return AddCustomEntries(db, &entry, customEntries)
+			}
+			return nil
+		} else if result.Error != nil {
+			return result.Error
+		}
+	}
+
+	return err
+}
+
+// Clone addresses of an entry to another
+func CloneAddresses(entry *Entry, addresses []Address) error {
+
+	err, db := openActiveDatabase()
+	if err != nil {
+		return err
+	}
+
+	newAddresses := make([]Address, len(addresses))
+	for idx := range addresses {
+		newAddresses[idx].Copy(&addresses[idx])
+	}
+
+	return AddAddresses(db, entry, newAddresses)
+}
+
 // Get extended entries associated to an entry
 func GetExtendedEntries(entry *Entry) []ExtendedEntry {
 
@@ -779,3 +1527,253 @@ func GetExtendedEntries(entry *Entry) []ExtendedEntry {
 
 	return customEntries
 }
+
+// Return the value of an entry field given its column name
+func entryFieldValue(entry *Entry, column string) string {
+
+	switch column {
+	case "title":
+		return entry.Title
+	case "user":
+		return entry.User
+	case "url":
+		return entry.Url
+	case "password":
+		return entry.Password
+	case "pin":
+		return entry.Pin
+	case "expiry_date":
+		return entry.ExpiryDate
+	case "issuer":
+		return entry.Issuer
+	case "class":
+		return entry.Class
+	case "notes":
+		return entry.Notes
+	case "tags":
+		return entry.Tags
+	case "otp":
+		return entry.Otp
+	case "first_name":
+		return entry.FirstName
+	case "middle_name":
+		return entry.MiddleName
+	case "last_name":
+		return entry.LastName
+	case "email":
+		return entry.Email
+	case "phone_number":
+		return entry.PhoneNumber
+	case "company":
+		return entry.Company
+	case "number":
+		return entry.Number
+	case "private_key":
+		return entry.PrivateKey
+	case "public_key":
+		return entry.PublicKey
+	}
+
+	return ""
+}
+
+// Record the current values of the fields of an entry which are about to change.
+// If flag is set, the custom fields are being replaced with customEntries.
+func RecordRevision(db *gorm.DB, entry *Entry, updateMap map[string]interface{},
+	customEntries []CustomEntry, flag bool) error {
+
+	var err error
+	var revision int
+	var revisions []Revision
+
+	err = CreateNewRevision(db)
+	if err != nil {
+		fmt.Printf("Error creating schema - \"%s\"\n", err.Error())
+		return err
+	}
+
+	columns := make([]string, 0, len(updateMap))
+	for column := range updateMap {
+		columns = append(columns, column)
+	}
+	sort.Strings(columns)
+
+	for _, column := range columns {
+		newVal, ok := updateMap[column].(string)
+		if !ok {
+			// timestamp
+			continue
+		}
+
+		if oldVal := entryFieldValue(entry, column); oldVal != newVal {
+			revisions = append(revisions, Revision{FieldName: column, OldValue: oldVal})
+		}
+	}
+
+	if flag {
+		var exEntries []ExtendedEntry
+
+		oldValues := make(map[string]string)
+		newValues := make(map[string]string)
+
+		db.Where("entry_id = ?", entry.ID).Find(&exEntries)
+		for _, exEntry := range exEntries {
+			oldValues[exEntry.FieldName] = exEntry.FieldValue
+		}
+		for _, customEntry := range customEntries {
+			newValues[customEntry.FieldName] = customEntry.FieldValue
+		}
+
+		// Changed or deleted fields
+		for _, exEntry := range exEntries {
+			if newVal, ok := newValues[exEntry.FieldName]; !ok || newVal != exEntry.FieldValue {
+				revisions = append(revisions, Revision{FieldName: exEntry.FieldName,
+					OldValue: exEntry.FieldValue, Custom: true})
+			}
+		}
+		// Added fields
+		for _, customEntry := range customEntries {
+			if _, ok := oldValues[customEntry.FieldName]; !ok {
+				revisions = append(revisions, Revision{FieldName: customEntry.FieldName,
+					Custom: true, Absent: true})
+			}
+		}
+	}
+
+	if len(revisions) == 0 {
+		return nil
+	}
+
+	db.Model(&Revision{}).Where("entry_id = ?", entry.ID).Select("coalesce(max(revision), 0)").Scan(&revision)
+	revision += 1
+
+	for idx := range revisions {
+		revisions[idx].Revision = revision
+		revisions[idx].EntryID = entry.ID
+	}
+
+	return db.Create(&revisions).Error
+}
+
+// Get the revisions of an entry, newest first
+func GetEntryRevisions(entry *Entry) (error, []Revision) {
+
+	var err error
+	var db *gorm.DB
+	var revisions []Revision
+
+	err, db = openActiveDatabase()
+	if err == nil && db != nil {
+		if !db.Migrator().HasTable(&Revision{}) {
+			return nil, revisions
+		}
+
+		res := db.Where("entry_id = ?", entry.ID).Order("revision desc, id asc").Find(&revisions)
+		return res.Error, revisions
+	}
+
+	return err, nil
+}
+
+// Roll an entry back to its state before the given revision. The rollback
+// is itself recorded as a new revision so that it can be undone.
+func RestoreEntryToRevision(entry *Entry, revision int) error {
+
+	var err error
+	var db *gorm.DB
+	var revisions []Revision
+	var found bool
+
+	err, db = openActiveDatabase()
+	if err != nil || db == nil {
+		return err
+	}
+
+	if db.Migrator().HasTable(&Revision{}) {
+		// Newest first, so that the value from the earliest revision wins
+		res := db.Where("entry_id = ? and revision >= ?", entry.ID, revision).Order("revision desc, id asc").Find(&revisions)
+		if res.Error != nil {
+			return res.Error
+		}
+	}
+
+	fieldValues := make(map[string]string)
+	customValues := make(map[string]*Revision)
+
+	for idx, rev := range revisions {
+		if rev.Revision == revision {
+			found = true
+		}
+		if rev.Custom {
+			customValues[rev.FieldName] = &revisions[idx]
+		} else {
+			fieldValues[rev.FieldName] = rev.OldValue
+		}
+	}
+
+	if !found {
+		return fmt.Errorf("entry %d has no revision %d", entry.ID, revision)
+	}
+
+	updateMap := make(map[string]interface{})
+	for column, val := range fieldValues {
+		if entryFieldValue(entry, column) != val {
+			updateMap[column] = val
+		}
+	}
+
+	var customEntries []CustomEntry
+	var flag bool
+
+	if len(customValues) > 0 {
+		var exEntries []ExtendedEntry
+
+		db.Where("entry_id = ?", entry.ID).Find(&exEntries)
+		for _, exEntry := range exEntries {
+			rev, ok := customValues[exEntry.FieldName]
+			if !ok {
+				customEntries = append(customEntries, CustomEntry{exEntry.FieldName, exEntry.FieldValue})
+				continue
+			}
+			delete(customValues, exEntry.FieldName)
+			flag = true
+			if !rev.Absent {
+				customEntries = append(customEntries, CustomEntry{exEntry.FieldName, rev.OldValue})
+			}
+		}
+		// Fields deleted since
+		for name, rev := range customValues {
+			if !rev.Absent {
+				customEntries = append(customEntries, CustomEntry{name, rev.OldValue})
+				flag = true
+			}
+		}
+	}
+
+	if len(updateMap) == 0 && !flag {
+		fmt.Println("Entry is already in that state.")
+		return nil
+	}
+
+	err = RecordRevision(db, entry, updateMap, customEntries, flag)
+	if err != nil {
+		return err
+	}
+
+	if len(updateMap) > 0 {
+		updateMap["timestamp"] = time.Now()
+		if res := db.Model(entry).Updates(updateMap); res.Error != nil {
+			return res.Error
+		}
+
+		if _, ok := updateMap["tags"]; ok {
+			updateEntryTags(db, entry.ID)
+		}
+	}
+
+	if flag {
+		return ReplaceCustomEntries(db, entry, customEntries)
+	}
+
+	return nil
+}
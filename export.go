@@ -17,16 +17,23 @@ func ExportToFile(fileName string) error {
 	var err error
 	var maxKrypt bool
 	var defaultDB string
-	var passwd string
+	var reEncrypt VoidFunc
+	var unlock VoidFunc
 
 	ext := strings.ToLower(filepath.Ext(fileName))
 
+	if err, unlock = lockActiveDatabase(); err != nil {
+		return err
+	}
+
+	defer unlock()
+
 	maxKrypt, defaultDB = isActiveDatabaseEncryptedAndMaxKryptOn()
 
-	if ext == ".csv" || ext == ".md" || ext == ".html" || ext == ".pdf" {
+	if ext == ".csv" || ext == ".md" || ext == ".html" || ext == ".pdf" || ext == ".kdbx" || ext == ".json" {
 		// If max krypt on - then autodecrypt on call and auto encrypt after call
 		if maxKrypt {
-			err, passwd = DecryptDatabase(defaultDB)
+			err, reEncrypt = unlockForAction(defaultDB)
 			if err != nil {
 				return err
 			}
@@ -42,6 +49,10 @@ func ExportToFile(fileName string) error {
 		err = ExportToHTML(fileName)
 	case ".pdf":
 		err = ExportToPDF(fileName)
+	case ".kdbx":
+		err = ExportToKDBX(fileName)
+	case ".json":
+		err = ExportToJSON(fileName)
 	default:
 		fmt.Printf("Error - extn %s not supported\n", ext)
 		return fmt.Errorf("format %s not supported", ext)
@@ -53,12 +64,10 @@ func ExportToFile(fileName string) error {
 	} else {
 		if _, err = os.Stat(fileName); err == nil {
 			fmt.Printf("Exported to %s.\n", fileName)
-			// Chmod 600
-			os.Chmod(fileName, 0600)
 
 			// If max krypt on - then autodecrypt on call and auto encrypt after call
 			if maxKrypt {
-				err = EncryptDatabase(defaultDB, &passwd)
+				err = reEncrypt()
 			}
 
 			return err
@@ -73,7 +82,7 @@ func ExportToMarkdown(fileName string) error {
 
 	var err error
 	var dataArray [][]string
-	var fh *os.File
+	var fh *AtomicFile
 	var maxLengths [7]int
 	var headers []string = []string{" ID ", " Title ", " User ", " URL ", " Password ", " Notes ", " Modified "}
 
@@ -94,13 +103,14 @@ func ExportToMarkdown(fileName string) error {
 	}
 
 	//  fmt.Printf("%+v\n", maxLengths)
-	fh, err = os.Create(fileName)
+	// Written to a private temp file and moved into place once complete
+	err, fh = CreateAtomicFile(fileName, 0600)
 	if err != nil {
 		fmt.Printf("Cannt open \"%s\" for writing - \"%s\"\n", fileName, err.Error())
 		return err
 	}
 
-	defer fh.Close()
+	defer fh.Abort()
 
 	writer := bufio.NewWriter(fh)
 
@@ -132,14 +142,17 @@ func ExportToMarkdown(fileName string) error {
 	for _, record := range dataArray {
 		writer.WriteString(" | ")
 		for _, field := range record {
-			writer.WriteString(field + " | ")
+			// Multi-line notes would break the table row
+			writer.WriteString(strings.ReplaceAll(field, "\n", "<br>") + " | ")
 		}
 		writer.WriteString("\n")
 	}
 
-	writer.Flush()
+	if err = writer.Flush(); err != nil {
+		return err
+	}
 
-	return nil
+	return fh.Commit()
 
 }
 
@@ -182,6 +195,7 @@ func ExportToPDF(fileName string) error {
 
 		// If the file is generated, encrypt it if pdfTkFound
 		if _, err = os.Stat(fileName); err == nil {
+			os.Chmod(fileName, 0600)
 			fmt.Printf("\nFile %s created without password.\n", fileName)
 
 			if pdfTkFound && len(passwd) > 0 {
@@ -194,7 +208,7 @@ func ExportToPDF(fileName string) error {
 				if err == nil {
 					// Copy over
 					fmt.Printf("Added password to %s.\n", fileName)
-					os.Remove(fileName)
+					os.Chmod(tmpFile, 0600)
 					err = os.Rename(tmpFile, fileName)
 				} else {
 					fmt.Printf("Error adding password to pdf - \"%s\"\n", err.Error())
@@ -212,7 +226,7 @@ func ExportToMarkdownLimited(fileName string) error {
 
 	var err error
 	var dataArray [][]string
-	var fh *os.File
+	var fh *AtomicFile
 	var maxLengths [5]int
 	var headers []string = []string{" ID ", " Title ", " User ", " Password ", " Modified "}
 
@@ -233,13 +247,13 @@ func ExportToMarkdownLimited(fileName string) error {
 	}
 
 	//  fmt.Printf("%+v\n", maxLengths)
-	fh, err = os.Create(fileName)
+	err, fh = CreateAtomicFile(fileName, 0600)
 	if err != nil {
 		fmt.Printf("Cannt open \"%s\" for writing - \"%s\"\n", fileName, err.Error())
 		return err
 	}
 
-	defer fh.Close()
+	defer fh.Abort()
 
 	writer := bufio.NewWriter(fh)
 
@@ -276,9 +290,11 @@ func ExportToMarkdownLimited(fileName string) error {
 		writer.WriteString("\n")
 	}
 
-	writer.Flush()
+	if err = writer.Flush(); err != nil {
+		return err
+	}
 
-	return nil
+	return fh.Commit()
 
 }
 
@@ -287,7 +303,7 @@ func ExportToHTML(fileName string) error {
 
 	var err error
 	var dataArray [][]string
-	var fh *os.File
+	var fh *AtomicFile
 	var headers []string = []string{" ID ", " Title ", " User ", " URL ", " Password ", " Notes ", " Modified "}
 
 	err, dataArray = EntriesToStringArray(false)
@@ -298,13 +314,13 @@ func ExportToHTML(fileName string) error {
 	}
 
 	//  fmt.Printf("%+v\n", maxLengths)
-	fh, err = os.Create(fileName)
+	err, fh = CreateAtomicFile(fileName, 0600)
 	if err != nil {
 		fmt.Printf("Cannt open \"%s\" for writing - \"%s\"\n", fileName, err.Error())
 		return err
 	}
 
-	defer fh.Close()
+	defer fh.Abort()
 
 	writer := bufio.NewWriter(fh)
 
@@ -322,7 +338,7 @@ func ExportToHTML(fileName string) error {
 	for _, record := range dataArray {
 		writer.WriteString("<tr>")
 		for _, field := range record {
-			writer.WriteString(fmt.Sprintf("<td>%s</td>", field))
+			writer.WriteString(fmt.Sprintf("<td>%s</td>", strings.ReplaceAll(field, "\n", "<br>")))
 		}
 		writer.WriteString("</tr>\n")
 	}
@@ -331,9 +347,11 @@ func ExportToHTML(fileName string) error {
 
 	writer.WriteString("</body></html>\n")
 
-	writer.Flush()
+	if err = writer.Flush(); err != nil {
+		return err
+	}
 
-	return nil
+	return fh.Commit()
 
 }
 
@@ -342,7 +360,7 @@ func ExportToCSV(fileName string) error {
 
 	var err error
 	var dataArray [][]string
-	var fh *os.File
+	var fh *AtomicFile
 
 	err, dataArray = EntriesToStringArray(false)
 
@@ -351,12 +369,14 @@ func ExportToCSV(fileName string) error {
 		return err
 	}
 
-	fh, err = os.Create(fileName)
+	err, fh = CreateAtomicFile(fileName, 0600)
 	if err != nil {
 		fmt.Printf("Cannt open \"%s\" for writing - \"%s\"\n", fileName, err.Error())
 		return err
 	}
 
+	defer fh.Abort()
+
 	writer := csv.NewWriter(fh)
 
 	// Write header
@@ -371,11 +391,18 @@ func ExportToCSV(fileName string) error {
 
 	writer.Flush()
 
+	if err == nil {
+		err = writer.Error()
+	}
+
+	if err == nil {
+		err = fh.Commit()
+	}
+
 	if err != nil {
 		return err
 	}
 
-	os.Chmod(fileName, 0600)
 	fmt.Printf("!WARNING: Passwords are stored in plain-text!\n")
 	fmt.Printf("Exported %d records to %s .\n", len(dataArray), fileName)
 
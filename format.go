@@ -0,0 +1,280 @@
+// Encrypted container format
+package varuh
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"unsafe"
+)
+
+// Layout of a versioned (v1+) container
+//
+//	magic | marker | version | cipher | kdf | flags | time | memory | threads | salt | hmac | nonce+ciphertext
+//
+// Legacy (v0) containers carry only magic | salt | hmac | nonce+ciphertext
+// and imply argon2i with fixed parameters. The cipher is not recorded in v0.
+const FORMAT_MARKER = "varuhfmt"
+const FORMAT_VERSION = 1
+
+// Size of the fixed part of a v1 header (without salt)
+const HEADER_FIXED_SIZE = 8 + len(FORMAT_MARKER) + 4 + 4 + 4 + 1
+
+// Cipher identifiers stored in the header
+const (
+	CIPHER_UNKNOWN uint8 = 0 // v0 files
+	CIPHER_AES     uint8 = 1 // AES-256 GCM
+	CIPHER_XCHACHA uint8 = 2 // XChaCha20-Poly1305
+)
+
+// KDF identifiers stored in the header
+const (
+	KDF_ARGON2I  uint8 = 1
+	KDF_ARGON2ID uint8 = 2
+)
+
+// Header flags
+const (
+	FLAG_KEYFILE uint8 = 1 << 0 // a keyfile is part of the composite key
+)
+
+// Argon2 parameters of legacy (v0) containers
+const (
+	LEGACY_ARGON2_TIME    = 3
+	LEGACY_ARGON2_MEMORY  = 32 * 1024
+	LEGACY_ARGON2_THREADS = 4
+)
+
+// Argon2 parameters for new containers unless configured otherwise
+const (
+	DEFAULT_ARGON2_TIME    = 4
+	DEFAULT_ARGON2_MEMORY  = 64 * 1024
+	DEFAULT_ARGON2_THREADS = 4
+)
+
+// Largest argon2 parameters accepted from a header, so that a damaged or
+// crafted file can't make key derivation exhaust memory or run for hours.
+// Passes allow well above the KDF_BENCH_MAX_TIME of --kdf-bench.
+const (
+	MAX_ARGON2_TIME    = 256
+	MAX_ARGON2_MEMORY  = 4 * 1024 * 1024 // KiB
+	MAX_ARGON2_THREADS = 64
+)
+
+// Key derivation parameters
+type KdfParams struct {
+	Kdf     uint8
+	Time    uint32 // argon2 passes
+	Memory  uint32 // argon2 memory in KiB
+	Threads uint8  // argon2 parallelism
+}
+
+// Return the default key derivation parameters for new containers
+func DefaultKdfParams() *KdfParams {
+	return &KdfParams{KDF_ARGON2ID, DEFAULT_ARGON2_TIME, DEFAULT_ARGON2_MEMORY, DEFAULT_ARGON2_THREADS}
+}
+
+// Header of an encrypted database
+type FileHeader struct {
+	Version uint8
+	Cipher  uint8
+	Kdf     uint8
+	Flags   uint8
+	Time    uint32 // argon2 passes
+	Memory  uint32 // argon2 memory in KiB
+	Threads uint8  // argon2 parallelism
+	Salt    []byte
+}
+
+// Create a fresh header for the given cipher and KDF parameters with a new salt.
+// If params is nil, the defaults are used.
+func NewFileHeader(cipherId uint8, params *KdfParams) (error, *FileHeader) {
+
+	var err error
+	var salt []byte
+
+	if params == nil {
+		params = DefaultKdfParams()
+	}
+
+	err, salt = GenerateRandomBytes(SALT_SIZE)
+	if err != nil {
+		return err, nil
+	}
+
+	return nil, &FileHeader{
+		Version: FORMAT_VERSION,
+		Cipher:  cipherId,
+		Kdf:     params.Kdf,
+		Time:    params.Time,
+		Memory:  params.Memory,
+		Threads: params.Threads,
+		Salt:    salt,
+	}
+}
+
+// Return the key derivation parameters recorded in the header
+func (h *FileHeader) KdfParams() *KdfParams {
+	return &KdfParams{h.Kdf, h.Time, h.Memory, h.Threads}
+}
+
+// Return true if the database needs a keyfile to unlock
+func (h *FileHeader) RequiresKeyFile() bool {
+	return h.Flags&FLAG_KEYFILE != 0
+}
+
+// Serialize the header. Legacy headers serialize to magic + salt.
+func (h *FileHeader) Bytes() []byte {
+
+	var data []byte
+	var buf [4]byte
+
+	data = []byte(fmt.Sprintf("%x", MAGIC_HEADER))
+
+	if h.Version == 0 {
+		return append(data, h.Salt...)
+	}
+
+	data = append(data, []byte(FORMAT_MARKER)...)
+	data = append(data, h.Version, h.Cipher, h.Kdf, h.Flags)
+	binary.BigEndian.PutUint32(buf[:], h.Time)
+	data = append(data, buf[:]...)
+	binary.BigEndian.PutUint32(buf[:], h.Memory)
+	data = append(data, buf[:]...)
+	data = append(data, h.Threads)
+	data = append(data, h.Salt...)
+
+	return data
+}
+
+// Authenticated data bound to the ciphertext. Legacy files have none.
+func (h *FileHeader) additionalData() []byte {
+	if h.Version == 0 {
+		return nil
+	}
+	return h.Bytes()
+}
+
+// Parse the header of an encrypted container and return it along with
+// the rest of the data (hmac + nonce + ciphertext)
+func ParseFileHeader(encText []byte) (error, *FileHeader, []byte) {
+
+	var header FileHeader
+	var magicSize int
+
+	magicSize = int(unsafe.Sizeof(MAGIC_HEADER))
+
+	if len(encText) < magicSize || string(encText[:magicSize]) != fmt.Sprintf("%x", MAGIC_HEADER) {
+		return errors.New("not an encrypted database - invalid magic number"), nil, nil
+	}
+
+	if len(encText) >= HEADER_FIXED_SIZE && string(encText[magicSize:magicSize+len(FORMAT_MARKER)]) == FORMAT_MARKER {
+		fields := encText[magicSize+len(FORMAT_MARKER):]
+
+		header.Version = fields[0]
+		header.Cipher = fields[1]
+		header.Kdf = fields[2]
+		header.Flags = fields[3]
+		header.Time = binary.BigEndian.Uint32(fields[4:8])
+		header.Memory = binary.BigEndian.Uint32(fields[8:12])
+		header.Threads = fields[12]
+
+		if header.Version > FORMAT_VERSION {
+			return fmt.Errorf("unsupported format version %d - upgrade varuh", header.Version), nil, nil
+		}
+
+		if header.Time > MAX_ARGON2_TIME || header.Memory > MAX_ARGON2_MEMORY || header.Threads > MAX_ARGON2_THREADS {
+			return fmt.Errorf("invalid key derivation parameters - passes %d, memory %d KiB, threads %d",
+				header.Time, header.Memory, header.Threads), nil, nil
+		}
+
+		encText = encText[HEADER_FIXED_SIZE:]
+	} else {
+		// Legacy container
+		header.Version = 0
+		header.Cipher = CIPHER_UNKNOWN
+		header.Kdf = KDF_ARGON2I
+		header.Time = LEGACY_ARGON2_TIME
+		header.Memory = LEGACY_ARGON2_MEMORY
+		header.Threads = LEGACY_ARGON2_THREADS
+
+		encText = encText[magicSize:]
+	}
+
+	if len(encText) < SALT_SIZE+HMAC_SHA512_SIZE {
+		return errors.New("encrypted database is truncated"), nil, nil
+	}
+
+	header.Salt, encText = encText[:SALT_SIZE], encText[SALT_SIZE:]
+
+	return nil, &header, encText
+}
+
+// Read and parse the header of an encrypted database file
+func ReadFileHeader(encDbPath string) (error, *FileHeader) {
+
+	var encText []byte
+	var err error
+	var header *FileHeader
+
+	encText, err = os.ReadFile(encDbPath)
+	if err != nil {
+		return err, nil
+	}
+
+	err, header, _ = ParseFileHeader(encText)
+	return err, header
+}
+
+// Return the cipher id given a cipher name from the configuration
+func CipherIdFromName(name string) uint8 {
+
+	switch name {
+	case "aes":
+		return CIPHER_AES
+	case "xchacha", "chacha", "xchachapoly":
+		return CIPHER_XCHACHA
+	}
+
+	return CIPHER_UNKNOWN
+}
+
+// Return the KDF id given a KDF name from the configuration
+func KdfIdFromName(name string) uint8 {
+
+	switch name {
+	case "argon2i":
+		return KDF_ARGON2I
+	case "argon2id", "argon2":
+		return KDF_ARGON2ID
+	}
+
+	return 0
+}
+
+// Return a readable name for a KDF id
+func KdfName(kdfId uint8) string {
+
+	switch kdfId {
+	case KDF_ARGON2I:
+		return "argon2i"
+	case KDF_ARGON2ID:
+		return "argon2id"
+	}
+
+	return "unknown"
+}
+
+// Return a readable name for a cipher id
+func CipherName(cipherId uint8) string {
+
+	switch cipherId {
+	case CIPHER_AES:
+		return "aes"
+	case CIPHER_XCHACHA:
+		return "xchacha"
+	}
+
+	return "unknown"
+}
@@ -5,6 +5,7 @@ go 1.16
 require (
 	github.com/atotto/clipboard v0.1.4
 	github.com/kirsle/configdir v0.0.0-20170128060238-e45d2f54772f
+	github.com/mattn/go-sqlite3 v1.14.17
 	github.com/polyglothacker/creditcard v0.0.0-20220814132008-214952378026
 	github.com/pythonhacker/argparse v1.3.2
 	golang.org/x/crypto v0.0.0-20210921155107-089bfa567519
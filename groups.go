@@ -0,0 +1,356 @@
+// Hierarchical groups (folders) of entries
+package varuh
+
+import (
+	"errors"
+	"fmt"
+	"gorm.io/gorm"
+	"sort"
+	"strings"
+)
+
+// Separator of group names in a group path, e.g work/prod/db
+const GROUP_SEPARATOR = "/"
+
+// Split a group path into its names. The top level is "" or "/".
+func splitGroupPath(path string) (error, []string) {
+
+	var names []string
+
+	for _, name := range strings.Split(strings.Trim(strings.TrimSpace(path), GROUP_SEPARATOR), GROUP_SEPARATOR) {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if name == "." || name == ".." {
+			return fmt.Errorf("invalid group name \"%s\"", name), nil
+		}
+		names = append(names, name)
+	}
+
+	return nil, names
+}
+
+// Find a group by path. Returns a nil group for the top level.
+func findGroup(db *gorm.DB, path string) (error, *Group) {
+
+	var err error
+	var names []string
+	var group *Group
+
+	err, names = splitGroupPath(path)
+	if err != nil {
+		return err, nil
+	}
+
+	parentID := 0
+	for _, name := range names {
+		var child Group
+
+		res := db.Where("parent_id = ? and name = ?", parentID, name).Limit(1).Find(&child)
+		if res.Error != nil {
+			return res.Error, nil
+		}
+		if res.RowsAffected == 0 {
+			return fmt.Errorf("no such group - %s", path), nil
+		}
+
+		group = &child
+		parentID = child.ID
+	}
+
+	return nil, group
+}
+
+// Return the id of a group given its path, 0 for the top level
+func GetGroupIdByPath(path string) (error, int) {
+
+	err, db := openActiveDatabase()
+	if err != nil {
+		return err, 0
+	}
+
+	err, group := findGroup(db, path)
+	if err != nil || group == nil {
+		return err, 0
+	}
+
+	return nil, group.ID
+}
+
+// Create a group given its path along with any missing parent groups
+func MakeGroupPath(path string) (error, *Group, bool) {
+
+	err, db := openActiveDatabase()
+	if err != nil {
+		return err, nil, false
+	}
+
+	return makeGroupPath(db, path)
+}
+
+// Create a group and its missing parents using the given connection.
+// Returns true if any group was created.
+func makeGroupPath(db *gorm.DB, path string) (error, *Group, bool) {
+
+	var err error
+	var names []string
+	var group *Group
+	var created bool
+
+	err, names = splitGroupPath(path)
+	if err != nil {
+		return err, nil, false
+	}
+
+	if len(names) == 0 {
+		return errors.New("group name cannot be empty"), nil, false
+	}
+
+	parentID := 0
+	for _, name := range names {
+		var child Group
+
+		res := db.Where("parent_id = ? and name = ?", parentID, name).Limit(1).Find(&child)
+		if res.Error != nil {
+			return res.Error, nil, false
+		}
+
+		if res.RowsAffected == 0 {
+			child = Group{Name: name, ParentID: parentID}
+			if res = db.Create(&child); res.Error != nil {
+				return res.Error, nil, false
+			}
+			created = true
+		}
+
+		group = &child
+		parentID = child.ID
+	}
+
+	return nil, group, created
+}
+
+// Return all groups keyed by id
+func getGroupMap(db *gorm.DB) map[int]Group {
+
+	var groups []Group
+
+	groupMap := make(map[int]Group)
+
+	db.Find(&groups)
+	for _, group := range groups {
+		groupMap[group.ID] = group
+	}
+
+	return groupMap
+}
+
+// Return the path of a group from the map of all groups
+func groupPathFromMap(groupMap map[int]Group, groupID int) string {
+
+	var names []string
+
+	// Guard against cycles in a damaged database
+	for depth := 0; groupID != 0 && depth < len(groupMap); depth++ {
+		group, ok := groupMap[groupID]
+		if !ok {
+			break
+		}
+		names = append([]string{group.Name}, names...)
+		groupID = group.ParentID
+	}
+
+	return strings.Join(names, GROUP_SEPARATOR)
+}
+
+// Return the path of a group given its id, "" for the top level
+func GetGroupPath(groupID int) string {
+
+	if groupID == 0 {
+		return ""
+	}
+
+	err, db := openActiveDatabase()
+	if err != nil {
+		return ""
+	}
+
+	return groupPathFromMap(getGroupMap(db), groupID)
+}
+
+// Return the ids of a group and all groups below it
+func subtreeGroupIds(groupMap map[int]Group, groupID int) []int {
+
+	var ids []int
+
+	ids = append(ids, groupID)
+
+	// Guard against cycles in a damaged database
+	visited := map[int]bool{groupID: true}
+
+	for idx := 0; idx < len(ids); idx++ {
+		for _, group := range groupMap {
+			if group.ParentID == ids[idx] && !visited[group.ID] {
+				visited[group.ID] = true
+				ids = append(ids, group.ID)
+			}
+		}
+	}
+
+	return ids
+}
+
+// Return the ids of the group at path and all groups below it
+func GetSubtreeGroupIds(path string) (error, []int) {
+
+	err, db := openActiveDatabase()
+	if err != nil {
+		return err, nil
+	}
+
+	err, group := findGroup(db, path)
+	if err != nil {
+		return err, nil
+	}
+
+	if group == nil {
+		return nil, subtreeGroupIds(getGroupMap(db), 0)
+	}
+
+	return nil, subtreeGroupIds(getGroupMap(db), group.ID)
+}
+
+// Keep only entries in the given groups
+func FilterEntriesByGroups(entries []Entry, groupIds []int) []Entry {
+
+	var filtered []Entry
+
+	m := make(map[int]bool)
+	for _, id := range groupIds {
+		m[id] = true
+	}
+
+	for _, entry := range entries {
+		if m[entry.GroupID] {
+			filtered = append(filtered, entry)
+		}
+	}
+
+	return filtered
+}
+
+// Move an entry to the group at path
+func MoveEntryToGroup(entry *Entry, path string) error {
+
+	err, db := openActiveDatabase()
+	if err != nil {
+		return err
+	}
+
+	err, group := findGroup(db, path)
+	if err != nil {
+		return err
+	}
+
+	groupID := 0
+	if group != nil {
+		groupID = group.ID
+	}
+
+	if res := db.Model(entry).Update("group_id", groupID); res.Error != nil {
+		return res.Error
+	}
+
+	entry.GroupID = groupID
+	return nil
+}
+
+// Order entries by the path of their group, keeping the order within a group
+func SortEntriesByGroup(entries []Entry) {
+
+	err, db := openActiveDatabase()
+	if err != nil {
+		return
+	}
+
+	groupMap := getGroupMap(db)
+	if len(groupMap) == 0 {
+		return
+	}
+
+	paths := make(map[int]string)
+	for _, entry := range entries {
+		paths[entry.GroupID] = groupPathFromMap(groupMap, entry.GroupID)
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		return paths[entries[i].GroupID] < paths[entries[j].GroupID]
+	})
+}
+
+// A node in a tree of groups and their entries
+type GroupNode struct {
+	Group    Group
+	Children []*GroupNode
+	Entries  []Entry
+}
+
+// Build the tree of groups and entries below the group at path
+func GetGroupTree(path string) (error, *GroupNode) {
+
+	var err error
+	var db *gorm.DB
+	var group *Group
+	var entries []Entry
+
+	err, db = openActiveDatabase()
+	if err != nil {
+		return err, nil
+	}
+
+	err, group = findGroup(db, path)
+	if err != nil {
+		return err, nil
+	}
+
+	groupMap := getGroupMap(db)
+	nodes := make(map[int]*GroupNode)
+
+	nodes[0] = &GroupNode{}
+	for id, g := range groupMap {
+		nodes[id] = &GroupNode{Group: g}
+	}
+
+	// Children in name order
+	var ids []int
+	for id := range groupMap {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return groupMap[ids[i]].Name < groupMap[ids[j]].Name })
+
+	for _, id := range ids {
+		if parent, ok := nodes[groupMap[id].ParentID]; ok {
+			parent.Children = append(parent.Children, nodes[id])
+		}
+	}
+
+	if res := db.Order("title asc").Find(&entries); res.Error != nil {
+		return res.Error, nil
+	}
+
+	for _, entry := range entries {
+		if node, ok := nodes[entry.GroupID]; ok {
+			node.Entries = append(node.Entries, entry)
+		} else {
+			// Group is gone - show at top level
+			nodes[0].Entries = append(nodes[0].Entries, entry)
+		}
+	}
+
+	if group == nil {
+		return nil, nodes[0]
+	}
+
+	return nil, nodes[group.ID]
+}
@@ -0,0 +1,507 @@
+// Import of entries from CSV files, including varuh's own exports, and
+// the framework the importers of other password managers plug into
+package varuh
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"gorm.io/gorm"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// An entry read from a file to import along with its custom fields
+type ImportEntry struct {
+	Entry         Entry
+	CustomEntries []CustomEntry
+	Addresses     []Address
+	Attachments   []Attachment // Name and data, and the content type if known
+	Group         string       // Group path, below the group imported into
+	Source        string       // Where in the file it came from, e.g "row 3"
+	Unmapped      []string     // Data of the item which could not be imported
+}
+
+// Add a custom field to an entry being imported if it has a value
+func (item *ImportEntry) addField(name, value string) {
+
+	if strings.TrimSpace(value) != "" {
+		item.CustomEntries = append(item.CustomEntries, CustomEntry{FieldName: name, FieldValue: value})
+	}
+}
+
+// Set the one-time code of an entry being imported from a secret or URI.
+// One which cannot be parsed is kept as a custom field.
+func (item *ImportEntry) setOtp(otp string) {
+
+	if otp = strings.TrimSpace(otp); otp == "" {
+		return
+	}
+
+	if err, uri := NormalizeOtp(otp, item.Entry.Title); err == nil {
+		item.Entry.Otp = uri
+	} else {
+		item.addField("OTP", otp)
+	}
+}
+
+// Fields CSV columns can be mapped to, with the column names
+// recognized for each field by automatic mapping, most likely first
+var csvImportFields = []struct {
+	field   string
+	columns []string
+}{
+	{"title", []string{"title", "name", "account", "entry"}},
+	{"user", []string{"user", "username", "login", "loginusername", "email"}},
+	{"url", []string{"url", "website", "site", "uri", "loginuri", "web"}},
+	{"password", []string{"password", "pass", "passwd", "loginpassword"}},
+	{"notes", []string{"notes", "note", "comments", "comment", "extra", "description"}},
+	{"tags", []string{"tags", "tag", "labels"}},
+	{"otp", []string{"otp", "totp", "logintotp", "2fa"}},
+	{"group", []string{"group", "folder", "grouping"}},
+}
+
+// Columns which are not imported as custom fields when left unmapped
+var csvIgnoredColumns = []string{"id", "modified", "created", "timestamp", "lastmodified"}
+
+// Lower case a column name and drop everything but letters and digits
+func normalizeColumnName(name string) string {
+
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToLower(r)
+		}
+		return -1
+	}, name)
+}
+
+// Find a column by its name or 1-based position
+func findCSVColumn(header []string, column string) int {
+
+	if pos, err := strconv.Atoi(strings.TrimSpace(column)); err == nil {
+		if pos >= 1 && pos <= len(header) {
+			return pos - 1
+		}
+		return -1
+	}
+
+	name := normalizeColumnName(column)
+	for idx, col := range header {
+		if normalizeColumnName(col) == name {
+			return idx
+		}
+	}
+
+	return -1
+}
+
+// Map the columns of a CSV header to entry fields. The mapping is a list like
+// "title=Name,user=2" of fields and the column names or positions they are
+// read from. Fields not in it are mapped automatically by column name.
+func MapCSVColumns(header []string, mapping string) (error, map[string]int) {
+
+	columns := make(map[string]int)
+	used := make(map[int]bool)
+
+	for _, item := range strings.Split(mapping, ",") {
+		if strings.TrimSpace(item) == "" {
+			continue
+		}
+
+		pieces := strings.SplitN(item, "=", 2)
+		if len(pieces) != 2 {
+			return fmt.Errorf("invalid mapping \"%s\" - should be <field>=<column>", item), nil
+		}
+
+		field := strings.ToLower(strings.TrimSpace(pieces[0]))
+		known := false
+		for _, f := range csvImportFields {
+			known = known || f.field == field
+		}
+		if !known {
+			return fmt.Errorf("unknown field \"%s\" in mapping", field), nil
+		}
+
+		idx := findCSVColumn(header, pieces[1])
+		if idx < 0 {
+			return fmt.Errorf("no column \"%s\" in CSV header", strings.TrimSpace(pieces[1])), nil
+		}
+
+		columns[field] = idx
+		used[idx] = true
+	}
+
+	for _, f := range csvImportFields {
+		if _, ok := columns[f.field]; ok {
+			continue
+		}
+
+		for _, name := range f.columns {
+			if idx := findCSVColumn(header, name); idx >= 0 && !used[idx] {
+				columns[f.field] = idx
+				used[idx] = true
+				break
+			}
+		}
+	}
+
+	if _, ok := columns["title"]; !ok {
+		return fmt.Errorf("no title column found - map one with --import-map title=<column>"), nil
+	}
+
+	return nil, columns
+}
+
+// Convert CSV records with a header row to entries to import. Columns
+// not mapped to a field become custom fields.
+func CSVRecordsToEntries(records [][]string, mapping string) (error, []ImportEntry) {
+
+	var entries []ImportEntry
+
+	if len(records) == 0 {
+		return fmt.Errorf("file is empty"), nil
+	}
+
+	header := records[0]
+
+	err, columns := MapCSVColumns(header, mapping)
+	if err != nil {
+		return err, nil
+	}
+
+	mapped := make(map[int]bool)
+	for _, idx := range columns {
+		mapped[idx] = true
+	}
+	for _, name := range csvIgnoredColumns {
+		if idx := findCSVColumn(header, name); idx >= 0 {
+			mapped[idx] = true
+		}
+	}
+
+	for row, record := range records[1:] {
+		var item ImportEntry
+
+		value := func(field string) string {
+			idx, ok := columns[field]
+			if !ok || idx >= len(record) {
+				return ""
+			}
+			return strings.TrimSpace(record[idx])
+		}
+
+		if strings.TrimSpace(strings.Join(record, "")) == "" {
+			continue
+		}
+
+		item.Source = fmt.Sprintf("row %d", row+2)
+		item.Group = value("group")
+		item.Entry = Entry{Title: value("title"), User: value("user"), Url: value("url"),
+			Password: value("password"), Notes: value("notes"),
+			Tags: strings.Join(ParseTags(value("tags")), " ")}
+
+		item.setOtp(value("otp"))
+
+		for idx, val := range record {
+			if mapped[idx] || idx >= len(header) || strings.TrimSpace(header[idx]) == "" || val == "" {
+				continue
+			}
+			item.CustomEntries = append(item.CustomEntries, CustomEntry{FieldName: strings.TrimSpace(header[idx]),
+				FieldValue: val})
+		}
+
+		entries = append(entries, item)
+	}
+
+	return nil, entries
+}
+
+// Key to compare entries by when looking for duplicates
+func importDuplicateKey(entry *Entry) string {
+
+	entryType := entry.Type
+	if entryType == "" {
+		entryType = "password"
+	}
+
+	return strings.Join([]string{entryType, strings.ToLower(strings.TrimSpace(entry.Title)),
+		strings.TrimSpace(entry.User), strings.TrimSpace(entry.Url)}, "\x00")
+}
+
+// Add entries read from a file to the active database. Entries with the same
+// type, title, user and URL as an existing entry or an earlier one of the file
+// are skipped. All entries are added in one transaction, so either all of them
+// are imported or none. With dryRun, only print what would be imported.
+func importEntries(entries []ImportEntry, group string, dryRun bool) error {
+
+	var err error
+	var db *gorm.DB
+	var existing []Entry
+	var added []ImportEntry
+	var skipped int
+	var partial int
+
+	err, db = openActiveDatabase()
+	if err != nil {
+		return err
+	}
+
+	if res := db.Select("id", "type", "title", "user", "url").Find(&existing); res.Error != nil {
+		return res.Error
+	}
+
+	seen := make(map[string]string)
+	for _, entry := range existing {
+		seen[importDuplicateKey(&entry)] = fmt.Sprintf("entry %d", entry.ID)
+	}
+
+	for _, item := range entries {
+		description := item.Entry.Title
+		if item.Entry.User != "" {
+			description += " (" + item.Entry.User + ")"
+		}
+
+		if item.Entry.Title == "" {
+			fmt.Printf("%s: no title - skipped\n", item.Source)
+			skipped++
+			continue
+		}
+
+		key := importDuplicateKey(&item.Entry)
+		if duplicate, ok := seen[key]; ok {
+			fmt.Printf("%s: %s - duplicate of %s, skipped\n", item.Source, description, duplicate)
+			skipped++
+			continue
+		}
+
+		var attachments []Attachment
+		for _, attachment := range item.Attachments {
+			// Names come from the file and must not point outside the
+			// directory the attachment is extracted to
+			name := filepath.Base(attachment.Name)
+			if !isAttachmentBaseName(name) {
+				item.Unmapped = append(item.Unmapped, fmt.Sprintf("attachment with invalid name %q", attachment.Name))
+				continue
+			}
+			attachment.Name = name
+
+			if len(attachment.Data) > ATTACHMENT_MAX_SIZE {
+				item.Unmapped = append(item.Unmapped, fmt.Sprintf("attachment %s (larger than %s)",
+					attachment.Name, FormatSize(ATTACHMENT_MAX_SIZE)))
+				continue
+			}
+			attachments = append(attachments, attachment)
+		}
+		item.Attachments = attachments
+
+		seen[key] = item.Source
+		added = append(added, item)
+
+		if dryRun {
+			fmt.Printf("%s: %s - new\n", item.Source, description)
+		}
+		if len(item.Unmapped) > 0 {
+			fmt.Printf("%s: %s - could not import %s\n", item.Source, description, strings.Join(item.Unmapped, ", "))
+			partial++
+		}
+	}
+
+	if partial > 0 {
+		fmt.Printf("<Warning - %d entries have data which could not be imported>\n", partial)
+	}
+
+	if dryRun {
+		fmt.Printf("Dry run - %d entries would be imported, %d skipped.\n", len(added), skipped)
+		return nil
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		groupIds := make(map[string]int)
+
+		for _, item := range added {
+			entry := item.Entry
+
+			path := strings.Trim(strings.Trim(group, GROUP_SEPARATOR)+GROUP_SEPARATOR+
+				strings.Trim(item.Group, GROUP_SEPARATOR), GROUP_SEPARATOR)
+			if path != "" {
+				if _, ok := groupIds[path]; !ok {
+					err, groupEntry, _ := makeGroupPath(tx, path)
+					if err != nil {
+						return fmt.Errorf("%s: %s", item.Source, err.Error())
+					}
+					groupIds[path] = groupEntry.ID
+				}
+				entry.GroupID = groupIds[path]
+			}
+
+			if err := tx.Create(&entry).Error; err != nil {
+				return fmt.Errorf("%s: %s", item.Source, err.Error())
+			}
+
+			for _, customEntry := range item.CustomEntries {
+				exEntry := ExtendedEntry{FieldName: customEntry.FieldName, FieldValue: customEntry.FieldValue,
+					EntryID: entry.ID}
+				if err := tx.Create(&exEntry).Error; err != nil {
+					return fmt.Errorf("%s: %s", item.Source, err.Error())
+				}
+			}
+
+			for _, address := range item.Addresses {
+				address.ID = 0
+				address.EntryID = entry.ID
+				if err := tx.Create(&address).Error; err != nil {
+					return fmt.Errorf("%s: %s", item.Source, err.Error())
+				}
+			}
+
+			names := make(map[string]bool)
+			for _, attachment := range item.Attachments {
+				// Names are unique within an entry
+				name := attachment.Name
+				for count := 2; names[name]; count++ {
+					ext := filepath.Ext(attachment.Name)
+					name = fmt.Sprintf("%s-%d%s", strings.TrimSuffix(attachment.Name, ext), count, ext)
+				}
+				names[name] = true
+
+				contentType := attachment.ContentType
+				if contentType == "" {
+					contentType = attachmentContentType(name, attachment.Data)
+				}
+
+				file := Attachment{Name: name, ContentType: contentType,
+					Size: int64(len(attachment.Data)), Data: attachment.Data, EntryID: entry.ID}
+				if err := tx.Create(&file).Error; err != nil {
+					return fmt.Errorf("%s: %s", item.Source, err.Error())
+				}
+			}
+
+			if err := syncEntryTags(tx, entry.ID); err != nil {
+				return fmt.Errorf("%s: %s", item.Source, err.Error())
+			}
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		fmt.Printf("Import rolled back - no entries were added.\n")
+		return err
+	}
+
+	fmt.Printf("Imported %d entries, %d skipped.\n", len(added), skipped)
+	return nil
+}
+
+// Read the records of a CSV file
+func readCSVRecords(data []byte) (error, [][]string) {
+
+	reader := csv.NewReader(bytes.NewReader(data))
+	// Rows of some exports have fewer columns than the header
+	reader.FieldsPerRecord = -1
+
+	records, err := reader.ReadAll()
+	if err != nil {
+		return err, nil
+	}
+
+	if len(records) > 0 && len(records[0]) > 0 {
+		records[0][0] = strings.TrimPrefix(records[0][0], "\ufeff")
+	}
+
+	return nil, records
+}
+
+// Import entries from a CSV file with a header row, such as one written
+// by --export. See MapCSVColumns for the mapping.
+func ImportFromCSV(fileName, mapping, group string, dryRun bool) error {
+
+	var err error
+	var data []byte
+	var records [][]string
+	var entries []ImportEntry
+
+	data, err = os.ReadFile(fileName)
+	if err != nil {
+		return err
+	}
+
+	err, records = readCSVRecords(data)
+	if err != nil {
+		return err
+	}
+
+	err, entries = CSVRecordsToEntries(records, mapping)
+	if err != nil {
+		return err
+	}
+
+	return importEntries(entries, group, dryRun)
+}
+
+// Import entries from a file in one of the import formats into the active
+// database. The format is found from the file unless given.
+func ImportFromFormat(fileName, format, group string, dryRun bool) error {
+
+	var err error
+	var data []byte
+	var entries []ImportEntry
+
+	if format == "" {
+		err, format = DetectImportFormat(fileName)
+		if err != nil {
+			return err
+		}
+	}
+
+	parser, ok := importFormats[strings.ToLower(format)]
+	if !ok {
+		return fmt.Errorf("unknown import format \"%s\"", format)
+	}
+
+	data, err = os.ReadFile(fileName)
+	if err != nil {
+		return err
+	}
+
+	err, entries = parser(data)
+	if err != nil {
+		return err
+	}
+
+	return importEntries(entries, group, dryRun)
+}
+
+// Import entries from a file into the active database
+func ImportFromFile(fileName string) error {
+
+	var err error
+	var format string
+
+	format = SettingsRider.ImportFormat
+	if format == "" {
+		err, format = DetectImportFormat(fileName)
+	}
+
+	if err == nil {
+		switch format {
+		case "csv":
+			err = ImportFromCSV(fileName, SettingsRider.ImportMap, SettingsRider.Group, SettingsRider.DryRun)
+		case "pass":
+			fmt.Printf("<Importing %s as %s>\n", fileName, format)
+			err = importPassStore(fileName)
+		default:
+			fmt.Printf("<Importing %s as %s>\n", fileName, format)
+			err = ImportFromFormat(fileName, format, SettingsRider.Group, SettingsRider.DryRun)
+		}
+	}
+
+	if err != nil {
+		fmt.Printf("Error importing from \"%s\" - \"%s\"\n", fileName, err.Error())
+	}
+
+	return err
+}
@@ -0,0 +1,988 @@
+// Importers of the exports of other password managers - Bitwarden,
+// 1Password, LastPass, KeePass, pass and the Chrome and Firefox browsers
+package varuh
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Parse the contents of an export into entries to import
+type importParser func(data []byte) (error, []ImportEntry)
+
+// Import formats by the name given with --import-format
+var importFormats = map[string]importParser{
+	"bitwarden":     parseBitwardenJSON,
+	"bitwarden-csv": parseBitwardenCSV,
+	"1password":     parse1PUX,
+	"1password-csv": parse1PasswordCSV,
+	"lastpass":      parseLastPassCSV,
+	"chrome":        parseBrowserCSV,
+	"firefox":       parseBrowserCSV,
+	"keepass":       parseKeePass,
+	"varuh":         parseVaruhJSON,
+}
+
+// Tag given to entries marked as favorites
+const IMPORT_FAVORITE_TAG = "favorite"
+
+// Find the format of an export from its extension and contents
+func DetectImportFormat(fileName string) (error, string) {
+
+	// A pass store is a directory
+	if info, err := os.Stat(fileName); err == nil && info.IsDir() {
+		return nil, "pass"
+	}
+
+	ext := strings.ToLower(filepath.Ext(fileName))
+
+	switch ext {
+	case ".1pux":
+		return nil, "1password"
+	case ".kdbx":
+		return nil, "keepass"
+	case ".json":
+		var probe struct {
+			Format string           `json:"format"`
+			Items  *json.RawMessage `json:"items"`
+		}
+
+		data, err := os.ReadFile(fileName)
+		if err != nil {
+			return err, ""
+		}
+		if json.Unmarshal(data, &probe) == nil {
+			if probe.Format == JSON_EXPORT_FORMAT {
+				return nil, "varuh"
+			}
+			if probe.Items != nil {
+				return nil, "bitwarden"
+			}
+		}
+		return fmt.Errorf("unknown JSON export - give its format with --import-format"), ""
+	case ".csv":
+		data, err := os.ReadFile(fileName)
+		if err != nil {
+			return err, ""
+		}
+
+		err, records := readCSVRecords(data)
+		if err != nil {
+			return err, ""
+		}
+		if len(records) == 0 {
+			return fmt.Errorf("file is empty"), ""
+		}
+		return nil, detectCSVFormat(records[0])
+	}
+
+	return fmt.Errorf("format %s not supported", ext), ""
+}
+
+// Find the password manager which wrote a CSV file from its header,
+// "csv" if it is not a known one
+func detectCSVFormat(header []string) string {
+
+	columns := make(map[string]bool)
+	for _, column := range header {
+		columns[normalizeColumnName(column)] = true
+	}
+
+	switch {
+	case columns["loginuri"] && columns["loginusername"]:
+		return "bitwarden-csv"
+	case columns["grouping"] && columns["extra"]:
+		return "lastpass"
+	case columns["httprealm"] || columns["formactionorigin"]:
+		return "firefox"
+	case columns["otpauth"] && columns["archived"]:
+		return "1password-csv"
+	case len(header) <= 5 && columns["name"] && columns["url"] && columns["username"] && columns["password"]:
+		return "chrome"
+	}
+
+	return "csv"
+}
+
+// A row of a CSV export
+type csvRow struct {
+	source string
+	header []string
+	record []string
+}
+
+// Split a CSV export into rows, leaving out blank ones
+func csvRows(data []byte) (error, []csvRow) {
+
+	var rows []csvRow
+
+	err, records := readCSVRecords(data)
+	if err != nil {
+		return err, nil
+	}
+
+	if len(records) == 0 {
+		return fmt.Errorf("file is empty"), nil
+	}
+
+	for idx, record := range records[1:] {
+		if strings.TrimSpace(strings.Join(record, "")) != "" {
+			rows = append(rows, csvRow{source: fmt.Sprintf("row %d", idx+2), header: records[0], record: record})
+		}
+	}
+
+	return nil, rows
+}
+
+// Value of a column as is, given its name in any case
+func (r *csvRow) raw(column string) string {
+
+	if idx := findCSVColumn(r.header, column); idx >= 0 && idx < len(r.record) {
+		return r.record[idx]
+	}
+
+	return ""
+}
+
+// Value of a column without surrounding spaces
+func (r *csvRow) get(column string) string {
+	return strings.TrimSpace(r.raw(column))
+}
+
+// Add the columns of a row other than the known ones as custom fields
+func (r *csvRow) addOtherFields(item *ImportEntry, known ...string) {
+
+	skip := make(map[string]bool)
+	for _, column := range known {
+		skip[normalizeColumnName(column)] = true
+	}
+
+	for idx, value := range r.record {
+		if idx < len(r.header) && !skip[normalizeColumnName(r.header[idx])] {
+			item.addField(strings.TrimSpace(r.header[idx]), value)
+		}
+	}
+}
+
+// Host of a URL without www, to title entries which have no name
+func urlTitle(address string) string {
+
+	parsed, err := url.Parse(strings.TrimSpace(address))
+	if err != nil || parsed.Hostname() == "" {
+		return strings.TrimSpace(address)
+	}
+
+	return strings.TrimPrefix(parsed.Hostname(), "www.")
+}
+
+// Tags string of a list of tags, which may have spaces in them
+func importTags(tags ...string) string {
+
+	var names []string
+
+	for _, tag := range tags {
+		if tag = strings.Join(strings.Fields(strings.ReplaceAll(tag, ",", " ")), "-"); tag != "" {
+			names = append(names, tag)
+		}
+	}
+
+	return strings.Join(ParseTags(strings.Join(names, " ")), " ")
+}
+
+// Card expiry as mm/yy from a month and year in any of the usual forms
+func cardExpiry(month, year string) string {
+
+	m, errMonth := strconv.Atoi(strings.TrimSpace(month))
+	if errMonth != nil {
+		// Month names as in LastPass
+		for idx := time.January; idx <= time.December; idx++ {
+			if strings.EqualFold(strings.TrimSpace(month), idx.String()) {
+				m, errMonth = int(idx), nil
+			}
+		}
+	}
+
+	y, errYear := strconv.Atoi(strings.TrimSpace(year))
+	if errMonth != nil || errYear != nil || m < 1 || m > 12 {
+		return strings.Trim(strings.TrimSpace(month)+"/"+strings.TrimSpace(year), "/")
+	}
+
+	return fmt.Sprintf("%02d/%02d", m, y%100)
+}
+
+// Fill an entry being imported as an SSH key. The public key and its type
+// are taken from the private key if it is not encrypted.
+func importSSHKey(item *ImportEntry, privateKey, publicKey string) {
+
+	item.Entry.Type = "sshkey"
+	item.Entry.PrivateKey = strings.TrimSpace(privateKey) + "\n"
+
+	if fields := strings.Fields(publicKey); len(fields) >= 2 {
+		item.Entry.PublicKey = fields[0] + " " + fields[1]
+		if item.Entry.User == "" {
+			item.Entry.User = strings.Join(fields[2:], " ")
+		}
+	}
+
+	if err, key, _ := ParseSSHPrivateKey(item.Entry.PrivateKey, ""); err == nil {
+		item.Entry.PublicKey = FormatSSHPublicKey(key, "")
+		item.Entry.Class = key.Type()
+	} else if err, keyType, _ := SSHKeyFingerprint(item.Entry.PublicKey); err == nil {
+		item.Entry.Class = keyType
+	}
+}
+
+// Structure of an unencrypted Bitwarden JSON export
+type bitwardenExport struct {
+	Encrypted         bool `json:"encrypted"`
+	PasswordProtected bool `json:"passwordProtected"`
+	Folders           []struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+	} `json:"folders"`
+	Items []bitwardenItem `json:"items"`
+}
+
+// Item of a Bitwarden export - type 1 is a login, 2 a secure note,
+// 3 a card, 4 an identity and 5 an SSH key
+type bitwardenItem struct {
+	FolderID string `json:"folderId"`
+	Type     int    `json:"type"`
+	Name     string `json:"name"`
+	Notes    string `json:"notes"`
+	Favorite bool   `json:"favorite"`
+	Fields   []struct {
+		Name  string `json:"name"`
+		Value string `json:"value"`
+		Type  int    `json:"type"` // 3 is a field linked to another
+	} `json:"fields"`
+	Login *struct {
+		Uris []struct {
+			Uri string `json:"uri"`
+		} `json:"uris"`
+		Username         string            `json:"username"`
+		Password         string            `json:"password"`
+		Totp             string            `json:"totp"`
+		Fido2Credentials []json.RawMessage `json:"fido2Credentials"`
+	} `json:"login"`
+	Card *struct {
+		CardholderName string `json:"cardholderName"`
+		Brand          string `json:"brand"`
+		Number         string `json:"number"`
+		ExpMonth       string `json:"expMonth"`
+		ExpYear        string `json:"expYear"`
+		Code           string `json:"code"`
+	} `json:"card"`
+	Identity *struct {
+		Title          string `json:"title"`
+		FirstName      string `json:"firstName"`
+		MiddleName     string `json:"middleName"`
+		LastName       string `json:"lastName"`
+		Address1       string `json:"address1"`
+		Address2       string `json:"address2"`
+		Address3       string `json:"address3"`
+		City           string `json:"city"`
+		State          string `json:"state"`
+		PostalCode     string `json:"postalCode"`
+		Country        string `json:"country"`
+		Company        string `json:"company"`
+		Email          string `json:"email"`
+		Phone          string `json:"phone"`
+		SSN            string `json:"ssn"`
+		Username       string `json:"username"`
+		PassportNumber string `json:"passportNumber"`
+		LicenseNumber  string `json:"licenseNumber"`
+	} `json:"identity"`
+	SSHKey *struct {
+		PrivateKey string `json:"privateKey"`
+		PublicKey  string `json:"publicKey"`
+	} `json:"sshKey"`
+	PasswordHistory []json.RawMessage `json:"passwordHistory"`
+	Attachments     []json.RawMessage `json:"attachments"`
+}
+
+// Join the non-empty values with a separator
+func joinNonEmpty(sep string, values ...string) string {
+
+	var parts []string
+
+	for _, value := range values {
+		if value = strings.TrimSpace(value); value != "" {
+			parts = append(parts, value)
+		}
+	}
+
+	return strings.Join(parts, sep)
+}
+
+// Parse an unencrypted Bitwarden JSON export
+func parseBitwardenJSON(data []byte) (error, []ImportEntry) {
+
+	var export bitwardenExport
+	var entries []ImportEntry
+
+	if err := json.Unmarshal(data, &export); err != nil {
+		return err, nil
+	}
+
+	if export.Encrypted || export.PasswordProtected {
+		return fmt.Errorf("encrypted Bitwarden exports cannot be read - export as unencrypted JSON"), nil
+	}
+
+	folders := make(map[string]string)
+	for _, folder := range export.Folders {
+		folders[folder.ID] = folder.Name
+	}
+
+	for idx, bwItem := range export.Items {
+		item := ImportEntry{Source: fmt.Sprintf("item %d", idx+1), Group: folders[bwItem.FolderID]}
+		item.Entry = Entry{Title: strings.TrimSpace(bwItem.Name), Notes: bwItem.Notes}
+
+		if bwItem.Favorite {
+			item.Entry.Tags = IMPORT_FAVORITE_TAG
+		}
+
+		switch {
+		case bwItem.Type == 1 && bwItem.Login != nil:
+			login := bwItem.Login
+			item.Entry.User = login.Username
+			item.Entry.Password = login.Password
+			for count, uri := range login.Uris {
+				if count == 0 {
+					item.Entry.Url = uri.Uri
+				} else {
+					item.addField(fmt.Sprintf("URL %d", count+1), uri.Uri)
+				}
+			}
+			item.setOtp(login.Totp)
+			if len(login.Fido2Credentials) > 0 {
+				item.Unmapped = append(item.Unmapped, "passkey")
+			}
+		case bwItem.Type == 2:
+			item.Entry.Type = "note"
+		case bwItem.Type == 3 && bwItem.Card != nil:
+			card := bwItem.Card
+			item.Entry.Type = "card"
+			item.Entry.User = card.CardholderName
+			item.Entry.Url = strings.ReplaceAll(card.Number, " ", "")
+			item.Entry.Password = card.Code
+			item.Entry.Class = card.Brand
+			item.Entry.ExpiryDate = cardExpiry(card.ExpMonth, card.ExpYear)
+			if item.Entry.Class == "" && item.Entry.Url != "" {
+				item.Entry.Class, _ = DetectCardType(item.Entry.Url)
+			}
+		case bwItem.Type == 4 && bwItem.Identity != nil:
+			identity := bwItem.Identity
+			item.Entry.Type = "identity"
+			item.Entry.FirstName = identity.FirstName
+			item.Entry.MiddleName = identity.MiddleName
+			item.Entry.LastName = identity.LastName
+			item.Entry.User = FullName(identity.FirstName, identity.MiddleName, identity.LastName)
+			item.Entry.Email = identity.Email
+			item.Entry.PhoneNumber = identity.Phone
+			item.Entry.Company = identity.Company
+
+			if identity.PassportNumber != "" {
+				item.Entry.Class = "passport"
+				item.Entry.Number = identity.PassportNumber
+				item.addField("License Number", identity.LicenseNumber)
+			} else if identity.LicenseNumber != "" {
+				item.Entry.Class = "driving license"
+				item.Entry.Number = identity.LicenseNumber
+			}
+			item.addField("Title", identity.Title)
+			item.addField("SSN", identity.SSN)
+			item.addField("Username", identity.Username)
+
+			address := Address{Street: joinNonEmpty(", ", identity.Address1, identity.Address2, identity.Address3),
+				City: identity.City, State: identity.State, ZipCode: identity.PostalCode, Country: identity.Country}
+			if address.String() != "" {
+				item.Addresses = append(item.Addresses, address)
+			}
+		case bwItem.Type == 5 && bwItem.SSHKey != nil:
+			importSSHKey(&item, bwItem.SSHKey.PrivateKey, bwItem.SSHKey.PublicKey)
+		default:
+			item.Entry.Type = "note"
+			item.Unmapped = append(item.Unmapped, fmt.Sprintf("item of type %d, only its notes", bwItem.Type))
+		}
+
+		for _, field := range bwItem.Fields {
+			if field.Type == 3 {
+				item.Unmapped = append(item.Unmapped, "linked field "+field.Name)
+				continue
+			}
+			item.addField(field.Name, field.Value)
+		}
+
+		if len(bwItem.PasswordHistory) > 0 {
+			item.Unmapped = append(item.Unmapped, "password history")
+		}
+		if len(bwItem.Attachments) > 0 {
+			item.Unmapped = append(item.Unmapped, "attachments")
+		}
+
+		entries = append(entries, item)
+	}
+
+	return nil, entries
+}
+
+// Parse a Bitwarden CSV export, which only has logins and notes
+func parseBitwardenCSV(data []byte) (error, []ImportEntry) {
+
+	var entries []ImportEntry
+
+	err, rows := csvRows(data)
+	if err != nil {
+		return err, nil
+	}
+
+	for _, row := range rows {
+		item := ImportEntry{Source: row.source, Group: row.get("folder")}
+		item.Entry = Entry{Title: row.get("name"), Notes: row.raw("notes")}
+
+		if row.get("favorite") == "1" {
+			item.Entry.Tags = IMPORT_FAVORITE_TAG
+		}
+
+		if row.get("type") == "note" {
+			item.Entry.Type = "note"
+		} else {
+			item.Entry.User = row.get("login_username")
+			item.Entry.Password = row.raw("login_password")
+			for count, uri := range strings.Split(row.get("login_uri"), ",") {
+				if count == 0 {
+					item.Entry.Url = strings.TrimSpace(uri)
+				} else {
+					item.addField(fmt.Sprintf("URL %d", count+1), strings.TrimSpace(uri))
+				}
+			}
+			item.setOtp(row.get("login_totp"))
+		}
+
+		// Custom fields are "name: value" lines
+		for _, line := range strings.Split(row.raw("fields"), "\n") {
+			if pieces := strings.SplitN(line, ": ", 2); len(pieces) == 2 {
+				item.addField(strings.TrimSpace(pieces[0]), strings.TrimRight(pieces[1], "\r"))
+			} else if strings.TrimSpace(line) != "" {
+				item.addField("Field", strings.TrimSpace(line))
+			}
+		}
+
+		row.addOtherFields(&item, "folder", "favorite", "type", "name", "notes", "fields", "reprompt",
+			"login_uri", "login_username", "login_password", "login_totp")
+		entries = append(entries, item)
+	}
+
+	return nil, entries
+}
+
+// Fill an entry from the extra field of a LastPass secure note with a
+// type, which has "key:value" lines ending with the notes
+func parseLastPassNote(item *ImportEntry, extra string) {
+
+	values := make(map[string]string)
+	var keys []string
+
+	lines := strings.Split(strings.ReplaceAll(extra, "\r\n", "\n"), "\n")
+	for idx, line := range lines {
+		pieces := strings.SplitN(line, ":", 2)
+		if len(pieces) != 2 {
+			continue
+		}
+		if pieces[0] == "Notes" {
+			item.Entry.Notes = strings.TrimSpace(strings.Join(append([]string{pieces[1]}, lines[idx+1:]...), "\n"))
+			break
+		}
+		keys = append(keys, pieces[0])
+		values[pieces[0]] = strings.Trim(strings.TrimSpace(pieces[1]), ",")
+	}
+
+	known := map[string]bool{"NoteType": true, "Language": true}
+
+	if values["NoteType"] == "Credit Card" {
+		expiry := strings.SplitN(values["Expiration Date"], ",", 2)
+
+		item.Entry.Type = "card"
+		item.Entry.User = values["Name on Card"]
+		item.Entry.Url = strings.ReplaceAll(values["Number"], " ", "")
+		item.Entry.Password = values["Security Code"]
+		item.Entry.Class = values["Type"]
+		if len(expiry) == 2 {
+			item.Entry.ExpiryDate = cardExpiry(expiry[0], expiry[1])
+		}
+		if item.Entry.Class == "" && item.Entry.Url != "" {
+			item.Entry.Class, _ = DetectCardType(item.Entry.Url)
+		}
+
+		for _, key := range []string{"Name on Card", "Number", "Security Code", "Type", "Expiration Date"} {
+			known[key] = true
+		}
+	} else {
+		item.Entry.Type = "note"
+		item.addField("Note Type", values["NoteType"])
+	}
+
+	for _, key := range keys {
+		if !known[key] {
+			item.addField(key, values[key])
+		}
+	}
+}
+
+// Parse a LastPass CSV export. Secure notes have the URL http://sn.
+func parseLastPassCSV(data []byte) (error, []ImportEntry) {
+
+	var entries []ImportEntry
+
+	err, rows := csvRows(data)
+	if err != nil {
+		return err, nil
+	}
+
+	for _, row := range rows {
+		// Sub folders are separated by backslashes
+		item := ImportEntry{Source: row.source, Group: strings.ReplaceAll(row.get("grouping"), "\\", GROUP_SEPARATOR)}
+		item.Entry.Title = row.get("name")
+
+		if row.get("fav") == "1" {
+			item.Entry.Tags = IMPORT_FAVORITE_TAG
+		}
+
+		if row.get("url") == "http://sn" {
+			extra := row.raw("extra")
+			if strings.HasPrefix(extra, "NoteType:") {
+				parseLastPassNote(&item, extra)
+			} else {
+				item.Entry.Type = "note"
+				item.Entry.Notes = extra
+			}
+		} else {
+			item.Entry.User = row.get("username")
+			item.Entry.Password = row.raw("password")
+			item.Entry.Url = row.get("url")
+			item.Entry.Notes = row.raw("extra")
+			item.setOtp(row.get("totp"))
+			if item.Entry.Title == "" {
+				item.Entry.Title = urlTitle(item.Entry.Url)
+			}
+		}
+
+		row.addOtherFields(&item, "url", "username", "password", "totp", "extra", "name", "grouping", "fav")
+		entries = append(entries, item)
+	}
+
+	return nil, entries
+}
+
+// Parse a CSV export of saved passwords from Chrome or Firefox. Firefox
+// entries have no name and are titled by the host of their URL.
+func parseBrowserCSV(data []byte) (error, []ImportEntry) {
+
+	var entries []ImportEntry
+
+	err, rows := csvRows(data)
+	if err != nil {
+		return err, nil
+	}
+
+	for _, row := range rows {
+		item := ImportEntry{Source: row.source}
+		item.Entry = Entry{Title: row.get("name"), User: row.get("username"), Password: row.raw("password"),
+			Url: row.get("url"), Notes: row.raw("note")}
+
+		if item.Entry.Title == "" {
+			item.Entry.Title = urlTitle(item.Entry.Url)
+		}
+
+		item.addField("HTTP Realm", row.get("httpRealm"))
+
+		// Firefox bookkeeping is left out
+		row.addOtherFields(&item, "name", "url", "username", "password", "note", "httpRealm",
+			"formActionOrigin", "guid", "timeCreated", "timeLastUsed", "timePasswordChanged")
+		entries = append(entries, item)
+	}
+
+	return nil, entries
+}
+
+// Parse a CSV export of 1Password
+func parse1PasswordCSV(data []byte) (error, []ImportEntry) {
+
+	var entries []ImportEntry
+
+	err, rows := csvRows(data)
+	if err != nil {
+		return err, nil
+	}
+
+	for _, row := range rows {
+		item := ImportEntry{Source: row.source}
+		item.Entry = Entry{Title: row.get("title"), User: row.get("username"), Password: row.raw("password"),
+			Url: row.get("url"), Notes: row.raw("notes"), Tags: importTags(strings.Split(row.get("tags"), ",")...)}
+
+		if item.Entry.Url == "" {
+			item.Entry.Url = row.get("website")
+		}
+		if strings.EqualFold(row.get("favorite"), "true") {
+			item.Entry.Tags = strings.TrimSpace(item.Entry.Tags + " " + IMPORT_FAVORITE_TAG)
+		}
+		if strings.EqualFold(row.get("archived"), "true") {
+			item.Unmapped = append(item.Unmapped, "archived state")
+		}
+		item.setOtp(row.get("otpauth"))
+
+		row.addOtherFields(&item, "title", "username", "password", "url", "website", "notes", "tags",
+			"favorite", "archived", "otpauth")
+		entries = append(entries, item)
+	}
+
+	return nil, entries
+}
+
+// A file in a 1PUX export
+type onePuxFile struct {
+	FileName   string `json:"fileName"`
+	DocumentID string `json:"documentId"`
+}
+
+// A field in a section of a 1PUX item. The value is an object with
+// its type as the key, e.g {"concealed": "secret"}
+type onePuxField struct {
+	Title string                     `json:"title"`
+	ID    string                     `json:"id"`
+	Value map[string]json.RawMessage `json:"value"`
+}
+
+// An item of a 1PUX export. Category 001 is a login, 002 a credit card,
+// 003 a secure note, 004 an identity, 005 a password, 006 a document
+// and 114 an SSH key.
+type onePuxItem struct {
+	FavIndex     int    `json:"favIndex"`
+	State        string `json:"state"`
+	CategoryUuid string `json:"categoryUuid"`
+	Details      struct {
+		LoginFields []struct {
+			Value       string `json:"value"`
+			Name        string `json:"name"`
+			FieldType   string `json:"fieldType"`
+			Designation string `json:"designation"`
+		} `json:"loginFields"`
+		NotesPlain string `json:"notesPlain"`
+		Password   string `json:"password"`
+		Sections   []struct {
+			Title  string        `json:"title"`
+			Fields []onePuxField `json:"fields"`
+		} `json:"sections"`
+		PasswordHistory    []json.RawMessage `json:"passwordHistory"`
+		DocumentAttributes *onePuxFile       `json:"documentAttributes"`
+	} `json:"details"`
+	Overview struct {
+		Title string `json:"title"`
+		URL   string `json:"url"`
+		URLs  []struct {
+			URL string `json:"url"`
+		} `json:"urls"`
+		Tags []string `json:"tags"`
+	} `json:"overview"`
+}
+
+// Structure of the export.data file of a 1PUX export
+type onePuxExport struct {
+	Accounts []struct {
+		Attrs struct {
+			AccountName string `json:"accountName"`
+		} `json:"attrs"`
+		Vaults []struct {
+			Attrs struct {
+				Name string `json:"name"`
+			} `json:"attrs"`
+			Items []json.RawMessage `json:"items"`
+		} `json:"vaults"`
+	} `json:"accounts"`
+}
+
+// Read a file of a 1PUX export, nil if there is none
+func read1PUXFile(files map[string]*zip.File, file *onePuxFile) []byte {
+
+	zipFile, ok := files["files/"+file.DocumentID+"__"+file.FileName]
+	if !ok {
+		return nil
+	}
+
+	fh, err := zipFile.Open()
+	if err != nil {
+		return nil
+	}
+	defer fh.Close()
+
+	data, err := io.ReadAll(io.LimitReader(fh, ATTACHMENT_MAX_SIZE+1))
+	if err != nil {
+		return nil
+	}
+
+	return data
+}
+
+// Fill an entry being imported from a field of a 1PUX item, mapping
+// fields known for the category to the entry and the rest to custom fields
+func map1PUXField(item *ImportEntry, category string, field onePuxField, files map[string]*zip.File) {
+
+	var text string
+	var number int64
+
+	name := field.Title
+	if name == "" {
+		name = field.ID
+	}
+
+	for kind, raw := range field.Value {
+		switch kind {
+		case "string", "concealed", "url", "phone", "menu", "gender", "totp",
+			"creditCardNumber", "creditCardType", "email":
+			if json.Unmarshal(raw, &text) != nil {
+				// Newer exports have emails as objects
+				var email struct {
+					Address string `json:"email_address"`
+				}
+				json.Unmarshal(raw, &email)
+				text = email.Address
+			}
+		case "monthYear":
+			json.Unmarshal(raw, &number)
+			text = cardExpiry(strconv.FormatInt(number%100, 10), strconv.FormatInt(number/100, 10))
+		case "date":
+			json.Unmarshal(raw, &number)
+			if number != 0 {
+				text = time.Unix(number, 0).UTC().Format("2006-01-02")
+			}
+		case "address":
+			var address struct {
+				Street  string `json:"street"`
+				City    string `json:"city"`
+				Country string `json:"country"`
+				Zip     string `json:"zip"`
+				State   string `json:"state"`
+			}
+			json.Unmarshal(raw, &address)
+			entryAddress := Address{Street: address.Street, City: address.City, State: address.State,
+				ZipCode: address.Zip, Country: address.Country}
+			if entryAddress.String() != "" {
+				item.Addresses = append(item.Addresses, entryAddress)
+			}
+			return
+		case "sshKey":
+			var key struct {
+				PrivateKey string `json:"privateKey"`
+				Metadata   struct {
+					PublicKey string `json:"publicKey"`
+				} `json:"metadata"`
+			}
+			json.Unmarshal(raw, &key)
+			importSSHKey(item, key.PrivateKey, key.Metadata.PublicKey)
+			return
+		case "file":
+			var file onePuxFile
+			json.Unmarshal(raw, &file)
+			if data := read1PUXFile(files, &file); data != nil {
+				item.Attachments = append(item.Attachments, Attachment{Name: file.FileName, Data: data})
+			} else {
+				item.Unmapped = append(item.Unmapped, "attachment "+file.FileName)
+			}
+			return
+		default:
+			item.Unmapped = append(item.Unmapped, fmt.Sprintf("%s field %s", kind, name))
+			return
+		}
+
+		if kind == "totp" && item.Entry.Otp == "" {
+			item.setOtp(text)
+			return
+		}
+	}
+
+	if text == "" {
+		return
+	}
+
+	// Fields of cards and identities with a place in the entry
+	var target *string
+	switch category + "/" + field.ID {
+	case "002/cardholder":
+		target = &item.Entry.User
+	case "002/type":
+		target = &item.Entry.Class
+	case "002/ccnum":
+		target = &item.Entry.Url
+	case "002/cvv":
+		target = &item.Entry.Password
+	case "002/pin":
+		target = &item.Entry.Pin
+	case "002/expiry":
+		target = &item.Entry.ExpiryDate
+	case "002/bank":
+		target = &item.Entry.Issuer
+	case "004/firstname":
+		target = &item.Entry.FirstName
+	case "004/initial":
+		target = &item.Entry.MiddleName
+	case "004/lastname":
+		target = &item.Entry.LastName
+	case "004/company":
+		target = &item.Entry.Company
+	case "004/email":
+		target = &item.Entry.Email
+	case "004/defphone", "004/cellphone", "004/homephone", "004/busphone":
+		target = &item.Entry.PhoneNumber
+	}
+
+	if target != nil && *target == "" {
+		*target = text
+	} else {
+		item.addField(name, text)
+	}
+}
+
+// Parse a 1PUX export of 1Password, a zip file with the items in
+// export.data and attached files under files/. Vaults become groups.
+func parse1PUX(data []byte) (error, []ImportEntry) {
+
+	var export onePuxExport
+	var entries []ImportEntry
+	var exportData []byte
+
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return fmt.Errorf("not a 1PUX file - %s", err.Error()), nil
+	}
+
+	files := make(map[string]*zip.File)
+	for _, file := range archive.File {
+		files[file.Name] = file
+	}
+
+	if file, ok := files["export.data"]; !ok {
+		return fmt.Errorf("not a 1PUX file - export.data missing"), nil
+	} else {
+		fh, err := file.Open()
+		if err != nil {
+			return err, nil
+		}
+		exportData, err = io.ReadAll(fh)
+		fh.Close()
+		if err != nil {
+			return err, nil
+		}
+	}
+
+	if err = json.Unmarshal(exportData, &export); err != nil {
+		return err, nil
+	}
+
+	for _, account := range export.Accounts {
+		for _, vault := range account.Vaults {
+			group := vault.Attrs.Name
+			if len(export.Accounts) > 1 {
+				group = account.Attrs.AccountName + GROUP_SEPARATOR + group
+			}
+
+			for _, raw := range vault.Items {
+				var onePux onePuxItem
+				var wrapped struct {
+					Item *onePuxItem `json:"item"`
+				}
+
+				// Older exports have each item wrapped in an object
+				if json.Unmarshal(raw, &wrapped) == nil && wrapped.Item != nil {
+					onePux = *wrapped.Item
+				} else if err = json.Unmarshal(raw, &onePux); err != nil {
+					return err, nil
+				}
+
+				item := ImportEntry{Source: fmt.Sprintf("item %d", len(entries)+1), Group: group}
+				item.Entry = Entry{Title: strings.TrimSpace(onePux.Overview.Title), Url: onePux.Overview.URL,
+					Notes: onePux.Details.NotesPlain, Tags: importTags(onePux.Overview.Tags...)}
+
+				if onePux.FavIndex > 0 {
+					item.Entry.Tags = strings.TrimSpace(item.Entry.Tags + " " + IMPORT_FAVORITE_TAG)
+				}
+				if onePux.State == "archived" {
+					item.Unmapped = append(item.Unmapped, "archived state")
+				}
+
+				category := onePux.CategoryUuid
+				switch category {
+				case "002":
+					item.Entry.Type = "card"
+				case "003", "006":
+					item.Entry.Type = "note"
+				case "004":
+					item.Entry.Type = "identity"
+				}
+
+				for _, loginField := range onePux.Details.LoginFields {
+					switch {
+					case loginField.Designation == "username" && item.Entry.User == "":
+						item.Entry.User = loginField.Value
+					case loginField.Designation == "password" && item.Entry.Password == "":
+						item.Entry.Password = loginField.Value
+					case loginField.FieldType == "T" || loginField.FieldType == "P" || loginField.FieldType == "E":
+						item.addField(loginField.Name, loginField.Value)
+					}
+				}
+
+				if item.Entry.Password == "" {
+					item.Entry.Password = onePux.Details.Password
+				}
+
+				count := 1
+				for _, urlItem := range onePux.Overview.URLs {
+					if urlItem.URL != item.Entry.Url {
+						count++
+						item.addField(fmt.Sprintf("URL %d", count), urlItem.URL)
+					}
+				}
+
+				for _, section := range onePux.Details.Sections {
+					for _, field := range section.Fields {
+						map1PUXField(&item, category, field, files)
+					}
+				}
+
+				if document := onePux.Details.DocumentAttributes; document != nil {
+					if data := read1PUXFile(files, document); data != nil {
+						item.Attachments = append(item.Attachments, Attachment{Name: document.FileName, Data: data})
+					} else {
+						item.Unmapped = append(item.Unmapped, "attachment "+document.FileName)
+					}
+				}
+
+				switch item.Entry.Type {
+				case "card":
+					if item.Entry.Class == "" && item.Entry.Url != "" {
+						item.Entry.Class, _ = DetectCardType(item.Entry.Url)
+					}
+				case "identity":
+					item.Entry.User = FullName(item.Entry.FirstName, item.Entry.MiddleName, item.Entry.LastName)
+				}
+
+				if len(onePux.Details.PasswordHistory) > 0 {
+					item.Unmapped = append(item.Unmapped, "password history")
+				}
+
+				entries = append(entries, item)
+			}
+		}
+	}
+
+	return nil, entries
+}
@@ -0,0 +1,272 @@
+// Export to and import from varuh's own JSON format, which keeps every
+// field of the entries along with their custom fields, addresses and
+// attachments
+package varuh
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Marker of the JSON format and its version. The version goes up when
+// a change would be misread by older versions of varuh.
+const (
+	JSON_EXPORT_FORMAT  = "varuh"
+	JSON_EXPORT_VERSION = 1
+)
+
+// A JSON export of a database
+type jsonExport struct {
+	Format   string      `json:"format"`
+	Version  int         `json:"version"`
+	Exported string      `json:"exported"`
+	Entries  []jsonEntry `json:"entries"`
+}
+
+// An entry of a JSON export. Empty fields are left out.
+type jsonEntry struct {
+	ID         int    `json:"id"`
+	Type       string `json:"type,omitempty"`
+	Title      string `json:"title"`
+	User       string `json:"user,omitempty"`
+	Url        string `json:"url,omitempty"`
+	Password   string `json:"password,omitempty"`
+	Pin        string `json:"pin,omitempty"`
+	ExpiryDate string `json:"expiry_date,omitempty"`
+	Issuer     string `json:"issuer,omitempty"`
+	Class      string `json:"class,omitempty"`
+	Notes      string `json:"notes,omitempty"`
+	Tags       string `json:"tags,omitempty"`
+	Otp        string `json:"otp,omitempty"`
+	Group      string `json:"group,omitempty"` // Group path
+
+	FirstName   string `json:"first_name,omitempty"`
+	MiddleName  string `json:"middle_name,omitempty"`
+	LastName    string `json:"last_name,omitempty"`
+	Email       string `json:"email,omitempty"`
+	PhoneNumber string `json:"phone_number,omitempty"`
+	Company     string `json:"company,omitempty"`
+	Number      string `json:"number,omitempty"`
+
+	PrivateKey string `json:"private_key,omitempty"`
+	PublicKey  string `json:"public_key,omitempty"`
+
+	Modified string `json:"modified,omitempty"` // RFC 3339
+
+	CustomFields []jsonField      `json:"custom_fields,omitempty"`
+	Addresses    []jsonAddress    `json:"addresses,omitempty"`
+	Attachments  []jsonAttachment `json:"attachments,omitempty"`
+}
+
+// A custom field of an entry
+type jsonField struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type jsonAddress struct {
+	Type     string `json:"type,omitempty"`
+	Number   string `json:"number,omitempty"`
+	Building string `json:"building,omitempty"`
+	Street   string `json:"street,omitempty"`
+	Locality string `json:"locality,omitempty"`
+	Area     string `json:"area,omitempty"`
+	City     string `json:"city,omitempty"`
+	State    string `json:"state,omitempty"`
+	Country  string `json:"country,omitempty"`
+	Landmark string `json:"landmark,omitempty"`
+	ZipCode  string `json:"zipcode,omitempty"`
+}
+
+// An attachment, with its contents in base64
+type jsonAttachment struct {
+	Name        string `json:"name"`
+	ContentType string `json:"content_type,omitempty"`
+	Data        []byte `json:"data"`
+}
+
+// Convert an entry with its custom fields, addresses and attachments
+func jsonEntryOf(entry *Entry, group string, customEntries []ExtendedEntry, addresses []Address,
+	attachments []Attachment) jsonEntry {
+
+	item := jsonEntry{ID: entry.ID, Type: entry.Type, Title: entry.Title, User: entry.User, Url: entry.Url,
+		Password: entry.Password, Pin: entry.Pin, ExpiryDate: entry.ExpiryDate, Issuer: entry.Issuer,
+		Class: entry.Class, Notes: entry.Notes, Tags: entry.Tags, Otp: entry.Otp, Group: group,
+		FirstName: entry.FirstName, MiddleName: entry.MiddleName, LastName: entry.LastName,
+		Email: entry.Email, PhoneNumber: entry.PhoneNumber, Company: entry.Company, Number: entry.Number,
+		PrivateKey: entry.PrivateKey, PublicKey: entry.PublicKey}
+
+	if !entry.Timestamp.IsZero() {
+		item.Modified = entry.Timestamp.Format(time.RFC3339)
+	}
+
+	for _, exEntry := range customEntries {
+		item.CustomFields = append(item.CustomFields, jsonField{Name: exEntry.FieldName, Value: exEntry.FieldValue})
+	}
+
+	for _, a := range addresses {
+		item.Addresses = append(item.Addresses, jsonAddress{Type: a.Type, Number: a.Number, Building: a.Building,
+			Street: a.Street, Locality: a.Locality, Area: a.Area, City: a.City, State: a.State,
+			Country: a.Country, Landmark: a.Landmark, ZipCode: a.ZipCode})
+	}
+
+	for _, attachment := range attachments {
+		item.Attachments = append(item.Attachments, jsonAttachment{Name: attachment.Name,
+			ContentType: attachment.ContentType, Data: attachment.Data})
+	}
+
+	return item
+}
+
+// Export the active database to a JSON file with all fields of the entries
+func ExportToJSON(fileName string) error {
+
+	var err error
+	var db *gorm.DB
+	var entries []Entry
+	var exEntries []ExtendedEntry
+	var attachments []Attachment
+	var data []byte
+	var fh *AtomicFile
+
+	err, db = openActiveDatabase()
+	if err != nil {
+		return err
+	}
+
+	if err = db.Order("id asc").Find(&entries).Error; err != nil {
+		return err
+	}
+	if err = db.Order("id asc").Find(&exEntries).Error; err != nil {
+		return err
+	}
+	if db.Migrator().HasTable(&Attachment{}) {
+		if err = db.Order("name asc").Find(&attachments).Error; err != nil {
+			return err
+		}
+	}
+
+	customMap := make(map[int][]ExtendedEntry)
+	for _, exEntry := range exEntries {
+		customMap[exEntry.EntryID] = append(customMap[exEntry.EntryID], exEntry)
+	}
+	addressMap := make(map[int][]Address)
+	for _, address := range addressesOf(db, nil) {
+		addressMap[address.EntryID] = append(addressMap[address.EntryID], address)
+	}
+	attachmentMap := make(map[int][]Attachment)
+	for _, attachment := range attachments {
+		attachmentMap[attachment.EntryID] = append(attachmentMap[attachment.EntryID], attachment)
+	}
+
+	export := jsonExport{Format: JSON_EXPORT_FORMAT, Version: JSON_EXPORT_VERSION,
+		Exported: time.Now().Format(time.RFC3339), Entries: []jsonEntry{}}
+
+	groupMap := getGroupMap(db)
+	for idx := range entries {
+		entry := &entries[idx]
+		export.Entries = append(export.Entries, jsonEntryOf(entry, groupPathFromMap(groupMap, entry.GroupID),
+			customMap[entry.ID], addressMap[entry.ID], attachmentMap[entry.ID]))
+	}
+
+	data, err = json.MarshalIndent(export, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	err, fh = CreateAtomicFile(fileName, 0600)
+	if err != nil {
+		return err
+	}
+
+	defer fh.Abort()
+
+	if _, err = fh.Write(append(data, '\n')); err != nil {
+		return err
+	}
+
+	return fh.Commit()
+}
+
+// Parse a JSON export of varuh into entries to import
+func parseVaruhJSON(data []byte) (error, []ImportEntry) {
+
+	var export jsonExport
+	var items []ImportEntry
+
+	if err := json.Unmarshal(data, &export); err != nil {
+		return fmt.Errorf("invalid JSON export - %s", err.Error()), nil
+	}
+
+	if export.Format != JSON_EXPORT_FORMAT {
+		return fmt.Errorf("not a %s JSON export", APP), nil
+	}
+	if export.Version < 1 || export.Version > JSON_EXPORT_VERSION {
+		return fmt.Errorf("JSON export version %d is not supported - upgrade %s", export.Version, APP), nil
+	}
+
+	for idx, item := range export.Entries {
+		entry := Entry{Type: item.Type, Title: item.Title, User: item.User, Url: item.Url,
+			Password: item.Password, Pin: item.Pin, ExpiryDate: item.ExpiryDate, Issuer: item.Issuer,
+			Class: item.Class, Notes: item.Notes, Tags: item.Tags, Otp: item.Otp,
+			FirstName: item.FirstName, MiddleName: item.MiddleName, LastName: item.LastName,
+			Email: item.Email, PhoneNumber: item.PhoneNumber, Company: item.Company, Number: item.Number,
+			PrivateKey: item.PrivateKey, PublicKey: item.PublicKey}
+
+		if item.Modified != "" {
+			if modified, err := time.Parse(time.RFC3339, item.Modified); err == nil {
+				entry.Timestamp = modified
+			}
+		}
+
+		imported := ImportEntry{Entry: entry, Group: item.Group, Source: fmt.Sprintf("entry %d", idx+1)}
+		if item.ID > 0 {
+			imported.Source = fmt.Sprintf("entry %d", item.ID)
+		}
+
+		// Unlike other formats, custom fields with empty values are kept
+		for _, field := range item.CustomFields {
+			imported.CustomEntries = append(imported.CustomEntries, CustomEntry{FieldName: field.Name,
+				FieldValue: field.Value})
+		}
+
+		for _, a := range item.Addresses {
+			imported.Addresses = append(imported.Addresses, Address{Type: a.Type, Number: a.Number,
+				Building: a.Building, Street: a.Street, Locality: a.Locality, Area: a.Area, City: a.City,
+				State: a.State, Country: a.Country, Landmark: a.Landmark, ZipCode: a.ZipCode})
+		}
+
+		for _, attachment := range item.Attachments {
+			imported.Attachments = append(imported.Attachments, Attachment{Name: attachment.Name,
+				ContentType: attachment.ContentType, Data: attachment.Data})
+		}
+
+		items = append(items, imported)
+	}
+
+	return nil, items
+}
+
+// Import the entries of a JSON export of varuh into the active database
+func ImportFromJSON(fileName, group string, dryRun bool) error {
+
+	var err error
+	var data []byte
+	var entries []ImportEntry
+
+	data, err = os.ReadFile(fileName)
+	if err != nil {
+		return err
+	}
+
+	err, entries = parseVaruhJSON(data)
+	if err != nil {
+		return err
+	}
+
+	return importEntries(entries, group, dryRun)
+}
@@ -0,0 +1,1398 @@
+// KeePass KDBX 4 databases - reading them to import and writing the
+// active database as one to export
+package varuh
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/chacha20"
+	"gorm.io/gorm"
+)
+
+// Layout of a KDBX 4 file
+//
+//	signatures | version | header fields | sha256 | hmac | hmac blocks
+//
+// The blocks hold the encrypted (and usually gzipped) inner header with
+// the attachments followed by the XML of the database.
+const (
+	KDBX_SIGNATURE1    = 0x9AA2D903
+	KDBX_SIGNATURE2    = 0xB54BFB67
+	KDBX_VERSION_MAJOR = 4
+)
+
+// Header fields
+const (
+	kdbxHeaderEnd         = 0
+	kdbxHeaderCipher      = 2
+	kdbxHeaderCompression = 3
+	kdbxHeaderMasterSeed  = 4
+	kdbxHeaderIV          = 7
+	kdbxHeaderKdf         = 11
+)
+
+// Inner header fields
+const (
+	kdbxInnerEnd       = 0
+	kdbxInnerStreamId  = 1
+	kdbxInnerStreamKey = 2
+	kdbxInnerBinary    = 3
+)
+
+// Inner random stream protecting values in the XML
+const kdbxStreamChaCha20 = 3
+
+// Size of the HMAC blocks written
+const kdbxBlockSize = 1024 * 1024
+
+// Argon2 memory of KeePass files which is refused as too large, in bytes
+const KDBX_MAX_KDF_MEMORY = 4 * 1024 * 1024 * 1024
+
+// Most Argon2 passes and AES-KDF rounds accepted from KeePass files, so
+// that a crafted file can't keep the import busy for hours. Both are far
+// above what KeePass and KeePassXC pick for a second of unlock time.
+const (
+	KDBX_MAX_ARGON2_ITERATIONS = 4096
+	KDBX_MAX_AES_KDF_ROUNDS    = 1 << 30
+)
+
+// Seconds between 0001-01-01, the epoch of KDBX 4 times, and 1970-01-01
+const kdbxEpochOffset = 62135596800
+
+// Custom data of entries keeping what KeePass has no place for
+const (
+	KDBX_TYPE_KEY      = "varuh-type"
+	KDBX_ADDRESSES_KEY = "varuh-addresses"
+)
+
+func kdbxUUID(value string) []byte {
+	id, _ := hex.DecodeString(value)
+	return id
+}
+
+// Ciphers and KDFs by UUID
+var (
+	kdbxCipherAES      = kdbxUUID("31c1f2e6bf714350be5805216afc5aff")
+	kdbxCipherChaCha20 = kdbxUUID("d6038a2b8b6f4cb5a524339a31dbb59a")
+	kdbxKdfAES         = kdbxUUID("c9d9f39a628a4460bf740d08c18a4fea")
+	kdbxKdfArgon2d     = kdbxUUID("ef636ddf8c29444b91f7a9a403e30a0c")
+	kdbxKdfArgon2id    = kdbxUUID("9e298b1956db4773b23dfc3ec6f0a1e6")
+)
+
+// Parameters of the KDF, a KeePass VariantDictionary of typed values
+type kdbxVariants map[string]interface{}
+
+// Types of values in a VariantDictionary
+const (
+	kdbxVariantUint32 = 0x04
+	kdbxVariantUint64 = 0x05
+	kdbxVariantBool   = 0x08
+	kdbxVariantInt32  = 0x0C
+	kdbxVariantInt64  = 0x0D
+	kdbxVariantString = 0x18
+	kdbxVariantBytes  = 0x42
+)
+
+// Serialize the dictionary, with the keys in order
+func (d kdbxVariants) Bytes() []byte {
+
+	var keys []string
+	var buf [8]byte
+
+	data := []byte{0x00, 0x01}
+
+	for key := range d {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		var kind byte
+		var value []byte
+
+		switch v := d[key].(type) {
+		case uint32:
+			binary.LittleEndian.PutUint32(buf[:4], v)
+			kind, value = kdbxVariantUint32, append([]byte{}, buf[:4]...)
+		case uint64:
+			binary.LittleEndian.PutUint64(buf[:], v)
+			kind, value = kdbxVariantUint64, append([]byte{}, buf[:]...)
+		case bool:
+			kind, value = kdbxVariantBool, []byte{0}
+			if v {
+				value[0] = 1
+			}
+		case int32:
+			binary.LittleEndian.PutUint32(buf[:4], uint32(v))
+			kind, value = kdbxVariantInt32, append([]byte{}, buf[:4]...)
+		case int64:
+			binary.LittleEndian.PutUint64(buf[:], uint64(v))
+			kind, value = kdbxVariantInt64, append([]byte{}, buf[:]...)
+		case string:
+			kind, value = kdbxVariantString, []byte(v)
+		case []byte:
+			kind, value = kdbxVariantBytes, v
+		default:
+			continue
+		}
+
+		data = append(data, kind)
+		binary.LittleEndian.PutUint32(buf[:4], uint32(len(key)))
+		data = append(data, buf[:4]...)
+		data = append(data, key...)
+		binary.LittleEndian.PutUint32(buf[:4], uint32(len(value)))
+		data = append(data, buf[:4]...)
+		data = append(data, value...)
+	}
+
+	return append(data, 0)
+}
+
+// Parse a serialized VariantDictionary
+func parseKdbxVariants(data []byte) (error, kdbxVariants) {
+
+	variants := make(kdbxVariants)
+
+	if len(data) < 2 || data[1] != 0x01 {
+		return errors.New("unsupported KDF parameters version"), nil
+	}
+	data = data[2:]
+
+	for len(data) > 0 {
+		kind := data[0]
+		if kind == 0 {
+			return nil, variants
+		}
+
+		if len(data) < 5 {
+			break
+		}
+		keySize := int(binary.LittleEndian.Uint32(data[1:5]))
+		if keySize < 0 || len(data) < 5+keySize+4 {
+			break
+		}
+		key := string(data[5 : 5+keySize])
+		data = data[5+keySize:]
+
+		valueSize := int(binary.LittleEndian.Uint32(data[:4]))
+		if valueSize < 0 || len(data) < 4+valueSize {
+			break
+		}
+		value := data[4 : 4+valueSize]
+		data = data[4+valueSize:]
+
+		switch {
+		case kind == kdbxVariantUint32 && len(value) == 4:
+			variants[key] = binary.LittleEndian.Uint32(value)
+		case kind == kdbxVariantUint64 && len(value) == 8:
+			variants[key] = binary.LittleEndian.Uint64(value)
+		case kind == kdbxVariantBool && len(value) == 1:
+			variants[key] = value[0] != 0
+		case kind == kdbxVariantInt32 && len(value) == 4:
+			variants[key] = int32(binary.LittleEndian.Uint32(value))
+		case kind == kdbxVariantInt64 && len(value) == 8:
+			variants[key] = int64(binary.LittleEndian.Uint64(value))
+		case kind == kdbxVariantString:
+			variants[key] = string(value)
+		case kind == kdbxVariantBytes:
+			variants[key] = append([]byte{}, value...)
+		default:
+			return fmt.Errorf("invalid KDF parameter %s", key), nil
+		}
+	}
+
+	return errors.New("KDF parameters are truncated"), nil
+}
+
+// Key of a KeePass key file - the key in an XML key file, 32 bytes as
+// they are or in hex, or else the hash of any other file
+func KDBXKeyFileKey(data []byte) (error, []byte) {
+
+	var keyFile struct {
+		XMLName xml.Name `xml:"KeyFile"`
+		Meta    struct {
+			Version string `xml:"Version"`
+		} `xml:"Meta"`
+		Key struct {
+			Data struct {
+				Hash string `xml:"Hash,attr"`
+				Text string `xml:",chardata"`
+			} `xml:"Data"`
+		} `xml:"Key"`
+	}
+
+	if bytes.Contains(data, []byte("<KeyFile")) && xml.Unmarshal(data, &keyFile) == nil {
+		text := strings.Join(strings.Fields(keyFile.Key.Data.Text), "")
+
+		if strings.HasPrefix(keyFile.Meta.Version, "2.") {
+			key, err := hex.DecodeString(text)
+			if err != nil {
+				return errors.New("invalid key file - bad key data"), nil
+			}
+			sum := sha256.Sum256(key)
+			if keyFile.Key.Data.Hash != "" && !strings.EqualFold(hex.EncodeToString(sum[:4]), keyFile.Key.Data.Hash) {
+				return errors.New("invalid key file - checksum mismatch"), nil
+			}
+			return nil, key
+		}
+
+		key, err := base64.StdEncoding.DecodeString(text)
+		if err != nil {
+			return errors.New("invalid key file - bad key data"), nil
+		}
+		return nil, key
+	}
+
+	if len(data) == 32 {
+		return nil, data
+	}
+
+	if len(data) == 64 {
+		if key, err := hex.DecodeString(string(data)); err == nil {
+			return nil, key
+		}
+	}
+
+	sum := sha256.Sum256(data)
+	return nil, sum[:]
+}
+
+// Composite key of a password and key file key
+func kdbxCompositeKey(password string, keyFileData []byte) (error, []byte) {
+
+	h := sha256.New()
+
+	// A key file may be used without a password
+	if password != "" || keyFileData == nil {
+		passHash := sha256.Sum256([]byte(password))
+		h.Write(passHash[:])
+	}
+
+	if keyFileData != nil {
+		err, key := KDBXKeyFileKey(keyFileData)
+		if err != nil {
+			return err, nil
+		}
+		h.Write(key)
+	}
+
+	return nil, h.Sum(nil)
+}
+
+// Transform the composite key with the KDF of the file
+func kdbxTransformKey(compositeKey []byte, kdf kdbxVariants) (error, []byte) {
+
+	kdfId, _ := kdf["$UUID"].([]byte)
+
+	switch {
+	case bytes.Equal(kdfId, kdbxKdfArgon2d) || bytes.Equal(kdfId, kdbxKdfArgon2id):
+		salt, _ := kdf["S"].([]byte)
+		parallelism, _ := kdf["P"].(uint32)
+		memory, _ := kdf["M"].(uint64)
+		iterations, _ := kdf["I"].(uint64)
+		version, _ := kdf["V"].(uint32)
+
+		if version != argon2Version {
+			return fmt.Errorf("unsupported Argon2 version 0x%x", version), nil
+		}
+		if len(salt) < 8 || parallelism < 1 || parallelism > math.MaxUint8 || iterations < 1 || memory < 8*1024 {
+			return errors.New("invalid Argon2 parameters"), nil
+		}
+		if memory > KDBX_MAX_KDF_MEMORY {
+			return fmt.Errorf("Argon2 memory of %s is too large", FormatSize(int64(memory))), nil
+		}
+		if iterations > KDBX_MAX_ARGON2_ITERATIONS {
+			return fmt.Errorf("%d Argon2 iterations are too many", iterations), nil
+		}
+
+		if bytes.Equal(kdfId, kdbxKdfArgon2id) {
+			return nil, argon2.IDKey(compositeKey, salt, uint32(iterations), uint32(memory/1024), uint8(parallelism), 32)
+		}
+		return nil, Argon2dKey(compositeKey, salt, uint32(iterations), uint32(memory/1024), uint8(parallelism), 32)
+
+	case bytes.Equal(kdfId, kdbxKdfAES):
+		seed, _ := kdf["S"].([]byte)
+		rounds, _ := kdf["R"].(uint64)
+
+		block, err := aes.NewCipher(seed)
+		if err != nil || len(compositeKey) != 32 {
+			return errors.New("invalid AES-KDF parameters"), nil
+		}
+		if rounds > KDBX_MAX_AES_KDF_ROUNDS {
+			return fmt.Errorf("%d AES-KDF rounds are too many", rounds), nil
+		}
+
+		key := append([]byte{}, compositeKey...)
+		for i := uint64(0); i < rounds; i++ {
+			block.Encrypt(key[:16], key[:16])
+			block.Encrypt(key[16:], key[16:])
+		}
+
+		sum := sha256.Sum256(key)
+		return nil, sum[:]
+	}
+
+	return errors.New("unsupported KDF"), nil
+}
+
+// Key of the HMAC of a block, or of the header with index MaxUint64
+func kdbxBlockKey(hmacBase []byte, index uint64) []byte {
+
+	var buf [8]byte
+
+	binary.LittleEndian.PutUint64(buf[:], index)
+
+	h := sha512.New()
+	h.Write(buf[:])
+	h.Write(hmacBase)
+
+	return h.Sum(nil)
+}
+
+// HMAC of a block of the encrypted payload
+func kdbxBlockHMAC(hmacBase []byte, index uint64, data []byte) []byte {
+
+	var buf [12]byte
+
+	binary.LittleEndian.PutUint64(buf[:8], index)
+	binary.LittleEndian.PutUint32(buf[8:], uint32(len(data)))
+
+	mac := hmac.New(sha256.New, kdbxBlockKey(hmacBase, index))
+	mac.Write(buf[:])
+	mac.Write(data)
+
+	return mac.Sum(nil)
+}
+
+// Inner random stream xoring protected values
+func kdbxInnerStream(streamId uint32, key []byte) (error, cipher.Stream) {
+
+	if streamId != kdbxStreamChaCha20 {
+		return fmt.Errorf("unsupported inner stream %d", streamId), nil
+	}
+
+	hash := sha512.Sum512(key)
+	stream, err := chacha20.NewUnauthenticatedCipher(hash[:32], hash[32:44])
+
+	return err, stream
+}
+
+// Xor the values marked protected in the XML of a database with the
+// inner random stream, in document order. Decoding takes the base64
+// values to plain text and encoding does the reverse.
+func kdbxProtectValues(doc []byte, stream cipher.Stream, decode bool) (error, []byte) {
+
+	var out bytes.Buffer
+	var text []byte
+	var protected bool
+
+	decoder := xml.NewDecoder(bytes.NewReader(doc))
+	encoder := xml.NewEncoder(&out)
+
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err, nil
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			for _, attr := range t.Attr {
+				if attr.Name.Local == "Protected" && strings.EqualFold(attr.Value, "True") {
+					protected, text = true, nil
+				}
+			}
+		case xml.CharData:
+			if protected {
+				text = append(text, t...)
+				continue
+			}
+		case xml.EndElement:
+			if protected {
+				value := append([]byte{}, text...)
+				if decode {
+					if value, err = base64.StdEncoding.DecodeString(strings.TrimSpace(string(text))); err != nil {
+						return errors.New("invalid protected value"), nil
+					}
+				}
+
+				stream.XORKeyStream(value, value)
+				if !decode {
+					value = []byte(base64.StdEncoding.EncodeToString(value))
+				}
+
+				if err = encoder.EncodeToken(xml.CharData(value)); err != nil {
+					return err, nil
+				}
+				protected = false
+			}
+		}
+
+		if err = encoder.EncodeToken(xml.CopyToken(token)); err != nil {
+			return err, nil
+		}
+	}
+
+	if err := encoder.Flush(); err != nil {
+		return err, nil
+	}
+
+	return nil, out.Bytes()
+}
+
+// XML of a database, with only the parts varuh uses
+type kdbxFile struct {
+	XMLName xml.Name `xml:"KeePassFile"`
+	Meta    kdbxMeta `xml:"Meta"`
+	Root    struct {
+		Group kdbxGroup `xml:"Group"`
+	} `xml:"Root"`
+}
+
+type kdbxMeta struct {
+	Generator        string `xml:"Generator"`
+	DatabaseName     string `xml:"DatabaseName"`
+	MemoryProtection struct {
+		ProtectTitle    string `xml:"ProtectTitle"`
+		ProtectUserName string `xml:"ProtectUserName"`
+		ProtectPassword string `xml:"ProtectPassword"`
+		ProtectURL      string `xml:"ProtectURL"`
+		ProtectNotes    string `xml:"ProtectNotes"`
+	} `xml:"MemoryProtection"`
+	RecycleBinEnabled string `xml:"RecycleBinEnabled"`
+	RecycleBinUUID    string `xml:"RecycleBinUUID"`
+}
+
+type kdbxTimes struct {
+	CreationTime         string `xml:"CreationTime"`
+	LastModificationTime string `xml:"LastModificationTime"`
+	LastAccessTime       string `xml:"LastAccessTime"`
+	ExpiryTime           string `xml:"ExpiryTime"`
+	Expires              string `xml:"Expires"`
+	UsageCount           int    `xml:"UsageCount"`
+	LocationChanged      string `xml:"LocationChanged"`
+}
+
+type kdbxGroup struct {
+	UUID       string      `xml:"UUID"`
+	Name       string      `xml:"Name"`
+	Notes      string      `xml:"Notes"`
+	IconID     int         `xml:"IconID"`
+	Times      kdbxTimes   `xml:"Times"`
+	IsExpanded string      `xml:"IsExpanded"`
+	Entries    []kdbxEntry `xml:"Entry"`
+	Groups     []kdbxGroup `xml:"Group"`
+}
+
+type kdbxValue struct {
+	Protected string `xml:"Protected,attr,omitempty"`
+	Text      string `xml:",chardata"`
+}
+
+type kdbxString struct {
+	Key   string    `xml:"Key"`
+	Value kdbxValue `xml:"Value"`
+}
+
+type kdbxBinaryRef struct {
+	Key   string `xml:"Key"`
+	Value struct {
+		Ref string `xml:"Ref,attr"`
+	} `xml:"Value"`
+}
+
+type kdbxItem struct {
+	Key   string `xml:"Key"`
+	Value string `xml:"Value"`
+}
+
+type kdbxEntry struct {
+	UUID       string          `xml:"UUID"`
+	IconID     int             `xml:"IconID"`
+	Tags       string          `xml:"Tags"`
+	Times      kdbxTimes       `xml:"Times"`
+	Strings    []kdbxString    `xml:"String"`
+	Binaries   []kdbxBinaryRef `xml:"Binary"`
+	CustomData *struct {
+		Items []kdbxItem `xml:"Item"`
+	} `xml:"CustomData"`
+	History *struct {
+		Entries []kdbxEntry `xml:"Entry"`
+	} `xml:"History"`
+}
+
+// A string of an entry and the column of the entry it is kept in
+type kdbxField struct {
+	key     string
+	protect bool
+	column  func(entry *Entry) *string
+}
+
+// Strings KeePass always has in an entry
+var kdbxStandardKeys = []string{"Title", "UserName", "Password", "URL", "Notes"}
+
+var (
+	kdbxTitle    = kdbxField{"Title", false, func(e *Entry) *string { return &e.Title }}
+	kdbxUserName = kdbxField{"UserName", false, func(e *Entry) *string { return &e.User }}
+	kdbxPassword = kdbxField{"Password", true, func(e *Entry) *string { return &e.Password }}
+	kdbxURL      = kdbxField{"URL", false, func(e *Entry) *string { return &e.Url }}
+	kdbxNotes    = kdbxField{"Notes", false, func(e *Entry) *string { return &e.Notes }}
+)
+
+// Strings of each entry type - card numbers are kept in the URL column,
+// CVVs in the password and so on
+var kdbxTypeFields = map[string][]kdbxField{
+	"": {kdbxTitle, kdbxUserName, kdbxPassword, kdbxURL, kdbxNotes},
+	"card": {kdbxTitle, kdbxUserName, kdbxPassword,
+		{"Card Number", true, func(e *Entry) *string { return &e.Url }},
+		{"PIN", true, func(e *Entry) *string { return &e.Pin }},
+		{"Expiry", false, func(e *Entry) *string { return &e.ExpiryDate }},
+		{"Issuer", false, func(e *Entry) *string { return &e.Issuer }},
+		{"Brand", false, func(e *Entry) *string { return &e.Class }},
+		kdbxNotes},
+	"identity": {kdbxTitle, kdbxUserName, kdbxURL,
+		{"First Name", false, func(e *Entry) *string { return &e.FirstName }},
+		{"Middle Name", false, func(e *Entry) *string { return &e.MiddleName }},
+		{"Last Name", false, func(e *Entry) *string { return &e.LastName }},
+		{"Email", false, func(e *Entry) *string { return &e.Email }},
+		{"Phone", false, func(e *Entry) *string { return &e.PhoneNumber }},
+		{"Company", false, func(e *Entry) *string { return &e.Company }},
+		{"Document Number", true, func(e *Entry) *string { return &e.Number }},
+		{"Document Type", false, func(e *Entry) *string { return &e.Class }},
+		{"Issuer", false, func(e *Entry) *string { return &e.Issuer }},
+		{"Expiry", false, func(e *Entry) *string { return &e.ExpiryDate }},
+		kdbxNotes},
+	"sshkey": {kdbxTitle, kdbxUserName, kdbxPassword, kdbxURL,
+		{"Private Key", true, func(e *Entry) *string { return &e.PrivateKey }},
+		{"Public Key", false, func(e *Entry) *string { return &e.PublicKey }},
+		{"Key Type", false, func(e *Entry) *string { return &e.Class }},
+		kdbxNotes},
+}
+
+// Strings of an entry type, the defaults for logins, notes and addresses
+func kdbxFieldsOf(entryType string) []kdbxField {
+
+	if fields, ok := kdbxTypeFields[entryType]; ok {
+		return fields
+	}
+
+	return kdbxTypeFields[""]
+}
+
+// Parts of an address in the order they are kept in custom data
+func addressParts(a *Address) []*string {
+	return []*string{&a.Number, &a.Building, &a.Street, &a.Locality, &a.Area, &a.City,
+		&a.State, &a.Country, &a.Landmark, &a.ZipCode, &a.Type}
+}
+
+// Time as written in KDBX 4 files
+func kdbxTime(t time.Time) string {
+
+	var buf [8]byte
+
+	binary.LittleEndian.PutUint64(buf[:], uint64(t.Unix()+kdbxEpochOffset))
+	return base64.StdEncoding.EncodeToString(buf[:])
+}
+
+func kdbxTimesOf(t time.Time) kdbxTimes {
+
+	now := kdbxTime(t)
+	return kdbxTimes{CreationTime: now, LastModificationTime: now, LastAccessTime: now,
+		ExpiryTime: now, Expires: "False", LocationChanged: now}
+}
+
+// A new random UUID in base64
+func kdbxNewUUID() string {
+
+	_, id := GenerateRandomBytes(16)
+	return base64.StdEncoding.EncodeToString(id)
+}
+
+// OTP URI of the native TOTP settings of KeePass
+func kdbxTimeOtp(values map[string]string) string {
+
+	query := url.Values{}
+	query.Set("secret", values["TimeOtp-Secret-Base32"])
+
+	if digits := values["TimeOtp-Length"]; digits != "" {
+		query.Set("digits", digits)
+	}
+	if period := values["TimeOtp-Period"]; period != "" {
+		query.Set("period", period)
+	}
+	if algorithm := values["TimeOtp-Algorithm"]; algorithm != "" {
+		// HMAC-SHA-256 and so on
+		query.Set("algorithm", strings.ReplaceAll(strings.TrimPrefix(algorithm, "HMAC-"), "-", ""))
+	}
+
+	return "otpauth://totp/?" + query.Encode()
+}
+
+// Entry to import from an entry of a database
+func kdbxImportEntry(kdbx *kdbxEntry, group, source string, binaries [][]byte) ImportEntry {
+
+	item := ImportEntry{Source: source, Group: group}
+	values := make(map[string]string)
+	custom := make(map[string]string)
+
+	for _, str := range kdbx.Strings {
+		values[str.Key] = str.Value.Text
+	}
+	if kdbx.CustomData != nil {
+		for _, data := range kdbx.CustomData.Items {
+			custom[data.Key] = data.Value
+		}
+	}
+
+	// Types written by varuh
+	if _, ok := kdbxTypeFields[custom[KDBX_TYPE_KEY]]; ok || custom[KDBX_TYPE_KEY] == "note" ||
+		custom[KDBX_TYPE_KEY] == "address" {
+		item.Entry.Type = custom[KDBX_TYPE_KEY]
+	}
+
+	known := make(map[string]bool)
+	for _, field := range kdbxFieldsOf(item.Entry.Type) {
+		*field.column(&item.Entry) = values[field.key]
+		known[field.key] = true
+	}
+	for _, key := range kdbxStandardKeys {
+		known[key] = true
+	}
+
+	if encoded := custom[KDBX_ADDRESSES_KEY]; encoded != "" {
+		var parts [][]string
+		if json.Unmarshal([]byte(encoded), &parts) == nil {
+			for _, values := range parts {
+				var address Address
+				for idx, part := range addressParts(&address) {
+					if idx < len(values) {
+						*part = values[idx]
+					}
+				}
+				item.Addresses = append(item.Addresses, address)
+			}
+		}
+	}
+
+	for _, str := range kdbx.Strings {
+		switch {
+		case known[str.Key]:
+		case len(item.Addresses) > 0 && (str.Key == "Address" || strings.HasPrefix(str.Key, "Address ")):
+			// Kept in custom data
+		case strings.EqualFold(str.Key, "otp") && item.Entry.Otp == "":
+			item.setOtp(str.Value.Text)
+		case str.Key == "TimeOtp-Secret-Base32" && item.Entry.Otp == "":
+			item.setOtp(kdbxTimeOtp(values))
+		case strings.HasPrefix(str.Key, "TimeOtp-") && values["TimeOtp-Secret-Base32"] != "":
+		default:
+			item.addField(str.Key, str.Value.Text)
+		}
+	}
+
+	item.Entry.Tags = importTags(strings.FieldsFunc(kdbx.Tags, func(r rune) bool {
+		return r == ';' || r == ','
+	})...)
+
+	if item.Entry.Type == "identity" && item.Entry.User == "" {
+		item.Entry.User = FullName(item.Entry.FirstName, item.Entry.MiddleName, item.Entry.LastName)
+	}
+
+	for _, ref := range kdbx.Binaries {
+		idx, err := strconv.Atoi(ref.Value.Ref)
+		if err != nil || idx < 0 || idx >= len(binaries) {
+			item.Unmapped = append(item.Unmapped, "attachment "+ref.Key)
+			continue
+		}
+		item.Attachments = append(item.Attachments, Attachment{Name: ref.Key, Data: binaries[idx]})
+	}
+
+	if kdbx.History != nil && len(kdbx.History.Entries) > 0 {
+		item.Unmapped = append(item.Unmapped, "history")
+	}
+
+	return item
+}
+
+// Entries to import from the groups of a database. The root group and
+// the recycle bin are left out of group paths.
+func kdbxImportEntries(doc *kdbxFile, binaries [][]byte) []ImportEntry {
+
+	var entries []ImportEntry
+	var walk func(group *kdbxGroup, path string)
+
+	recycleBin := ""
+	if strings.EqualFold(doc.Meta.RecycleBinEnabled, "True") {
+		recycleBin = doc.Meta.RecycleBinUUID
+	}
+
+	walk = func(group *kdbxGroup, path string) {
+		for idx := range group.Entries {
+			source := fmt.Sprintf("entry %d", len(entries)+1)
+			entries = append(entries, kdbxImportEntry(&group.Entries[idx], path, source, binaries))
+		}
+
+		for idx := range group.Groups {
+			child := &group.Groups[idx]
+			if recycleBin != "" && child.UUID == recycleBin {
+				continue
+			}
+
+			// Group separators can't be part of a name
+			name := strings.ReplaceAll(strings.TrimSpace(child.Name), GROUP_SEPARATOR, "-")
+			walk(child, strings.TrimPrefix(path+GROUP_SEPARATOR+name, GROUP_SEPARATOR))
+		}
+	}
+
+	walk(&doc.Root.Group, "")
+	return entries
+}
+
+// Decrypt the payload of a database. The header is parsed here too.
+func decryptKDBX(data []byte, password string, keyFileData []byte) (error, []byte) {
+
+	var cipherId, masterSeed, iv []byte
+	var kdf kdbxVariants
+	var compression uint32
+	var err error
+
+	if len(data) < 12 || binary.LittleEndian.Uint32(data[0:4]) != KDBX_SIGNATURE1 ||
+		binary.LittleEndian.Uint32(data[4:8]) != KDBX_SIGNATURE2 {
+		return errors.New("not a KeePass database"), nil
+	}
+
+	if major := binary.LittleEndian.Uint32(data[8:12]) >> 16; major != KDBX_VERSION_MAJOR {
+		return fmt.Errorf("KDBX version %d is not supported - save it as KDBX 4 in KeePass", major), nil
+	}
+
+	pos := 12
+	for {
+		if len(data) < pos+5 {
+			return errors.New("KeePass database is truncated"), nil
+		}
+
+		id := data[pos]
+		size := int(binary.LittleEndian.Uint32(data[pos+1 : pos+5]))
+		if size < 0 || len(data) < pos+5+size {
+			return errors.New("KeePass database is truncated"), nil
+		}
+		value := data[pos+5 : pos+5+size]
+		pos += 5 + size
+
+		if id == kdbxHeaderEnd {
+			break
+		}
+
+		switch id {
+		case kdbxHeaderCipher:
+			cipherId = value
+		case kdbxHeaderCompression:
+			if len(value) == 4 {
+				compression = binary.LittleEndian.Uint32(value)
+			}
+		case kdbxHeaderMasterSeed:
+			masterSeed = value
+		case kdbxHeaderIV:
+			iv = value
+		case kdbxHeaderKdf:
+			if err, kdf = parseKdbxVariants(value); err != nil {
+				return err, nil
+			}
+		}
+	}
+
+	if len(masterSeed) != 32 || kdf == nil {
+		return errors.New("invalid KeePass header"), nil
+	}
+
+	header := data[:pos]
+	if len(data) < pos+64 {
+		return errors.New("KeePass database is truncated"), nil
+	}
+
+	headerHash := sha256.Sum256(header)
+	if !bytes.Equal(headerHash[:], data[pos:pos+32]) {
+		return errors.New("KeePass header is corrupt"), nil
+	}
+
+	err, compositeKey := kdbxCompositeKey(password, keyFileData)
+	if err != nil {
+		return err, nil
+	}
+
+	err, transformedKey := kdbxTransformKey(compositeKey, kdf)
+	if err != nil {
+		return err, nil
+	}
+
+	hmacBase := sha512.Sum512(append(append(append([]byte{}, masterSeed...), transformedKey...), 0x01))
+
+	mac := hmac.New(sha256.New, kdbxBlockKey(hmacBase[:], math.MaxUint64))
+	mac.Write(header)
+	if !hmac.Equal(mac.Sum(nil), data[pos+32:pos+64]) {
+		return errors.New("wrong password or key file"), nil
+	}
+
+	// Blocks up to the empty one at the end
+	var payload []byte
+	pos += 64
+	for index := uint64(0); ; index++ {
+		if len(data) < pos+36 {
+			return errors.New("KeePass database is truncated"), nil
+		}
+
+		size := int(int32(binary.LittleEndian.Uint32(data[pos+32 : pos+36])))
+		if size < 0 || len(data) < pos+36+size {
+			return errors.New("KeePass database is truncated"), nil
+		}
+
+		block := data[pos+36 : pos+36+size]
+		if !hmac.Equal(kdbxBlockHMAC(hmacBase[:], index, block), data[pos:pos+32]) {
+			return errors.New("KeePass database is corrupt"), nil
+		}
+		pos += 36 + size
+
+		if size == 0 {
+			break
+		}
+		payload = append(payload, block...)
+	}
+
+	encKey := sha256.Sum256(append(append([]byte{}, masterSeed...), transformedKey...))
+
+	switch {
+	case bytes.Equal(cipherId, kdbxCipherAES):
+		block, _ := aes.NewCipher(encKey[:])
+		if len(iv) != aes.BlockSize || len(payload) == 0 || len(payload)%aes.BlockSize != 0 {
+			return errors.New("invalid KeePass payload"), nil
+		}
+
+		cipher.NewCBCDecrypter(block, iv).CryptBlocks(payload, payload)
+
+		padding := int(payload[len(payload)-1])
+		if padding < 1 || padding > aes.BlockSize || !bytes.Equal(payload[len(payload)-padding:],
+			bytes.Repeat([]byte{byte(padding)}, padding)) {
+			return errors.New("invalid KeePass payload"), nil
+		}
+		payload = payload[:len(payload)-padding]
+	case bytes.Equal(cipherId, kdbxCipherChaCha20):
+		stream, err := chacha20.NewUnauthenticatedCipher(encKey[:], iv)
+		if err != nil {
+			return err, nil
+		}
+		stream.XORKeyStream(payload, payload)
+	default:
+		return errors.New("unsupported KeePass cipher - use AES or ChaCha20"), nil
+	}
+
+	if compression == 1 {
+		reader, err := gzip.NewReader(bytes.NewReader(payload))
+		if err != nil {
+			return err, nil
+		}
+		if payload, err = io.ReadAll(reader); err != nil {
+			return err, nil
+		}
+	}
+
+	return nil, payload
+}
+
+// Read the entries of a KeePass KDBX 4 database given its password and
+// the contents of its key file, if any
+func ReadKDBX(data []byte, password string, keyFileData []byte) (error, []ImportEntry) {
+
+	var streamId uint32
+	var streamKey []byte
+	var binaries [][]byte
+	var stream cipher.Stream
+	var doc kdbxFile
+
+	err, payload := decryptKDBX(data, password, keyFileData)
+	if err != nil {
+		return err, nil
+	}
+
+	for {
+		if len(payload) < 5 {
+			return errors.New("KeePass inner header is truncated"), nil
+		}
+
+		id := payload[0]
+		size := int(int32(binary.LittleEndian.Uint32(payload[1:5])))
+		if size < 0 || len(payload) < 5+size {
+			return errors.New("KeePass inner header is truncated"), nil
+		}
+		value := payload[5 : 5+size]
+		payload = payload[5+size:]
+
+		if id == kdbxInnerEnd {
+			break
+		}
+
+		switch id {
+		case kdbxInnerStreamId:
+			if len(value) == 4 {
+				streamId = binary.LittleEndian.Uint32(value)
+			}
+		case kdbxInnerStreamKey:
+			streamKey = value
+		case kdbxInnerBinary:
+			// After a byte of flags
+			if len(value) > 0 {
+				value = value[1:]
+			}
+			binaries = append(binaries, value)
+		}
+	}
+
+	if err, stream = kdbxInnerStream(streamId, streamKey); err != nil {
+		return err, nil
+	}
+
+	if err, payload = kdbxProtectValues(payload, stream, true); err != nil {
+		return err, nil
+	}
+
+	if err = xml.Unmarshal(payload, &doc); err != nil {
+		return err, nil
+	}
+
+	return nil, kdbxImportEntries(&doc, binaries)
+}
+
+// Encrypt the XML of a database and its attachments as a KDBX 4 file
+func encryptKDBX(doc *kdbxFile, binaries [][]byte, password string, keyFileData []byte,
+	cipherId uint8, params *KdfParams) (error, []byte) {
+
+	var err error
+	var xmlData, masterSeed, salt, iv, streamKey []byte
+	var stream cipher.Stream
+	var buf [8]byte
+
+	if err, masterSeed = GenerateRandomBytes(32); err != nil {
+		return err, nil
+	}
+	if err, salt = GenerateRandomBytes(32); err != nil {
+		return err, nil
+	}
+	if err, streamKey = GenerateRandomBytes(64); err != nil {
+		return err, nil
+	}
+
+	cipherUUID := kdbxCipherAES
+	ivSize := aes.BlockSize
+	if cipherId == CIPHER_XCHACHA {
+		cipherUUID, ivSize = kdbxCipherChaCha20, chacha20.NonceSize
+	}
+	if err, iv = GenerateRandomBytes(ivSize); err != nil {
+		return err, nil
+	}
+
+	if params == nil {
+		params = DefaultKdfParams()
+	}
+
+	// Only Argon2d and Argon2id are known to KeePass
+	kdf := kdbxVariants{"$UUID": kdbxKdfArgon2id, "S": salt, "P": uint32(params.Threads),
+		"M": uint64(params.Memory) * 1024, "I": uint64(params.Time), "V": uint32(argon2Version)}
+
+	// Inner header, then the XML with its protected values xored
+	var inner bytes.Buffer
+
+	writeField := func(out *bytes.Buffer, id byte, value []byte) {
+		binary.LittleEndian.PutUint32(buf[:4], uint32(len(value)))
+		out.WriteByte(id)
+		out.Write(buf[:4])
+		out.Write(value)
+	}
+
+	binary.LittleEndian.PutUint32(buf[:4], kdbxStreamChaCha20)
+	writeField(&inner, kdbxInnerStreamId, append([]byte{}, buf[:4]...))
+	writeField(&inner, kdbxInnerStreamKey, streamKey)
+	for _, data := range binaries {
+		writeField(&inner, kdbxInnerBinary, append([]byte{0}, data...))
+	}
+	writeField(&inner, kdbxInnerEnd, nil)
+
+	if xmlData, err = xml.Marshal(doc); err != nil {
+		return err, nil
+	}
+
+	if err, stream = kdbxInnerStream(kdbxStreamChaCha20, streamKey); err != nil {
+		return err, nil
+	}
+	if err, xmlData = kdbxProtectValues(xmlData, stream, false); err != nil {
+		return err, nil
+	}
+	inner.WriteString(xml.Header)
+	inner.Write(xmlData)
+
+	var compressed bytes.Buffer
+	writer := gzip.NewWriter(&compressed)
+	writer.Write(inner.Bytes())
+	if err = writer.Close(); err != nil {
+		return err, nil
+	}
+
+	// Outer header
+	var header bytes.Buffer
+
+	binary.LittleEndian.PutUint32(buf[:4], KDBX_SIGNATURE1)
+	header.Write(buf[:4])
+	binary.LittleEndian.PutUint32(buf[:4], KDBX_SIGNATURE2)
+	header.Write(buf[:4])
+	binary.LittleEndian.PutUint32(buf[:4], KDBX_VERSION_MAJOR<<16)
+	header.Write(buf[:4])
+
+	writeField(&header, kdbxHeaderCipher, cipherUUID)
+	binary.LittleEndian.PutUint32(buf[:4], 1) // gzip
+	writeField(&header, kdbxHeaderCompression, append([]byte{}, buf[:4]...))
+	writeField(&header, kdbxHeaderMasterSeed, masterSeed)
+	writeField(&header, kdbxHeaderIV, iv)
+	writeField(&header, kdbxHeaderKdf, kdf.Bytes())
+	writeField(&header, kdbxHeaderEnd, []byte("\r\n\r\n"))
+
+	err, compositeKey := kdbxCompositeKey(password, keyFileData)
+	if err != nil {
+		return err, nil
+	}
+
+	err, transformedKey := kdbxTransformKey(compositeKey, kdf)
+	if err != nil {
+		return err, nil
+	}
+
+	encKey := sha256.Sum256(append(append([]byte{}, masterSeed...), transformedKey...))
+	hmacBase := sha512.Sum512(append(append(append([]byte{}, masterSeed...), transformedKey...), 0x01))
+
+	payload := compressed.Bytes()
+	if cipherId == CIPHER_XCHACHA {
+		stream, err := chacha20.NewUnauthenticatedCipher(encKey[:], iv)
+		if err != nil {
+			return err, nil
+		}
+		stream.XORKeyStream(payload, payload)
+	} else {
+		block, _ := aes.NewCipher(encKey[:])
+		padding := aes.BlockSize - len(payload)%aes.BlockSize
+		payload = append(payload, bytes.Repeat([]byte{byte(padding)}, padding)...)
+		cipher.NewCBCEncrypter(block, iv).CryptBlocks(payload, payload)
+	}
+
+	out := bytes.NewBuffer(header.Bytes())
+	headerHash := sha256.Sum256(header.Bytes())
+	out.Write(headerHash[:])
+
+	mac := hmac.New(sha256.New, kdbxBlockKey(hmacBase[:], math.MaxUint64))
+	mac.Write(header.Bytes())
+	out.Write(mac.Sum(nil))
+
+	// Blocks of the payload and an empty one to end them
+	for index := uint64(0); ; index++ {
+		size := len(payload)
+		if size > kdbxBlockSize {
+			size = kdbxBlockSize
+		}
+
+		block := payload[:size]
+		payload = payload[size:]
+
+		out.Write(kdbxBlockHMAC(hmacBase[:], index, block))
+		binary.LittleEndian.PutUint32(buf[:4], uint32(size))
+		out.Write(buf[:4])
+		out.Write(block)
+
+		if size == 0 {
+			break
+		}
+	}
+
+	return nil, out.Bytes()
+}
+
+// Entry of a database for an entry, adding its attachments to binaries
+func kdbxEntryOf(entry *Entry, customEntries []ExtendedEntry, addresses []Address,
+	attachments []Attachment, binaries *[][]byte) kdbxEntry {
+
+	kdbx := kdbxEntry{UUID: kdbxNewUUID(), Times: kdbxTimesOf(entry.Timestamp),
+		Tags: strings.Join(ParseTags(entry.Tags), ";")}
+	used := make(map[string]bool)
+
+	addString := func(key, value string, protect bool) {
+		// Keys are unique within an entry
+		name := key
+		for count := 2; used[name]; count++ {
+			name = fmt.Sprintf("%s (%d)", key, count)
+		}
+		used[name] = true
+
+		str := kdbxString{Key: name, Value: kdbxValue{Text: value}}
+		if protect {
+			str.Value.Protected = "True"
+		}
+		kdbx.Strings = append(kdbx.Strings, str)
+	}
+
+	for _, field := range kdbxFieldsOf(entry.Type) {
+		addString(field.key, *field.column(entry), field.protect)
+	}
+	for _, key := range kdbxStandardKeys {
+		if !used[key] {
+			addString(key, "", key == "Password")
+		}
+	}
+
+	// KeePassXC keeps OTP URIs under this key
+	if entry.Otp != "" {
+		addString("otp", entry.Otp, true)
+	}
+
+	var parts [][]string
+	for idx, address := range addresses {
+		key := "Address"
+		if idx > 0 {
+			key = fmt.Sprintf("Address %d", idx+1)
+		}
+		addString(key, address.String(), false)
+
+		var values []string
+		for _, part := range addressParts(&address) {
+			values = append(values, *part)
+		}
+		parts = append(parts, values)
+	}
+
+	for _, customEntry := range customEntries {
+		addString(customEntry.FieldName, customEntry.FieldValue, false)
+	}
+
+	for _, attachment := range attachments {
+		ref := kdbxBinaryRef{Key: attachment.Name}
+		ref.Value.Ref = strconv.Itoa(len(*binaries))
+		*binaries = append(*binaries, attachment.Data)
+		kdbx.Binaries = append(kdbx.Binaries, ref)
+	}
+
+	var items []kdbxItem
+	if entry.Type != "" {
+		items = append(items, kdbxItem{KDBX_TYPE_KEY, entry.Type})
+	}
+	if len(parts) > 0 {
+		encoded, _ := json.Marshal(parts)
+		items = append(items, kdbxItem{KDBX_ADDRESSES_KEY, string(encoded)})
+	}
+	if len(items) > 0 {
+		kdbx.CustomData = &struct {
+			Items []kdbxItem `xml:"Item"`
+		}{items}
+	}
+
+	return kdbx
+}
+
+// Write the entries of the active database to a KeePass KDBX 4 file
+// encrypted with the given password and key file. Groups are kept, with
+// custom fields and attachments.
+func WriteKDBX(fileName, password string, keyFileData []byte, cipherId uint8, params *KdfParams) error {
+
+	var err error
+	var db *gorm.DB
+	var entries []Entry
+	var exEntries []ExtendedEntry
+	var attachments []Attachment
+	var binaries [][]byte
+	var data []byte
+	var fh *AtomicFile
+
+	err, db = openActiveDatabase()
+	if err != nil {
+		return err
+	}
+
+	if err = db.Order("id asc").Find(&entries).Error; err != nil {
+		return err
+	}
+	if err = db.Order("id asc").Find(&exEntries).Error; err != nil {
+		return err
+	}
+	if db.Migrator().HasTable(&Attachment{}) {
+		if err = db.Order("id asc").Find(&attachments).Error; err != nil {
+			return err
+		}
+	}
+
+	customMap := make(map[int][]ExtendedEntry)
+	for _, exEntry := range exEntries {
+		customMap[exEntry.EntryID] = append(customMap[exEntry.EntryID], exEntry)
+	}
+	addressMap := make(map[int][]Address)
+	for _, address := range addressesOf(db, nil) {
+		addressMap[address.EntryID] = append(addressMap[address.EntryID], address)
+	}
+	attachmentMap := make(map[int][]Attachment)
+	for _, attachment := range attachments {
+		attachmentMap[attachment.EntryID] = append(attachmentMap[attachment.EntryID], attachment)
+	}
+
+	groupMap := getGroupMap(db)
+	entryMap := make(map[int][]kdbxEntry)
+	for idx := range entries {
+		entry := &entries[idx]
+		groupID := entry.GroupID
+		if _, ok := groupMap[groupID]; !ok {
+			groupID = 0
+		}
+		entryMap[groupID] = append(entryMap[groupID], kdbxEntryOf(entry, customMap[entry.ID],
+			addressMap[entry.ID], attachmentMap[entry.ID], &binaries))
+	}
+
+	childMap := make(map[int][]Group)
+	for _, group := range groupMap {
+		childMap[group.ParentID] = append(childMap[group.ParentID], group)
+	}
+
+	var build func(id int, name string, timestamp time.Time) kdbxGroup
+	build = func(id int, name string, timestamp time.Time) kdbxGroup {
+		group := kdbxGroup{UUID: kdbxNewUUID(), Name: name, Times: kdbxTimesOf(timestamp),
+			IsExpanded: "True", Entries: entryMap[id]}
+		if id == 0 {
+			group.IconID = 48
+		}
+
+		children := childMap[id]
+		sort.Slice(children, func(i, j int) bool { return children[i].Name < children[j].Name })
+		for _, child := range children {
+			group.Groups = append(group.Groups, build(child.ID, child.Name, child.Timestamp))
+		}
+		return group
+	}
+
+	doc := kdbxFile{Meta: kdbxMeta{Generator: APP, DatabaseName: APP, RecycleBinEnabled: "False",
+		RecycleBinUUID: base64.StdEncoding.EncodeToString(make([]byte, 16))}}
+	doc.Meta.MemoryProtection.ProtectTitle = "False"
+	doc.Meta.MemoryProtection.ProtectUserName = "False"
+	doc.Meta.MemoryProtection.ProtectPassword = "True"
+	doc.Meta.MemoryProtection.ProtectURL = "False"
+	doc.Meta.MemoryProtection.ProtectNotes = "False"
+	doc.Root.Group = build(0, APP, time.Now())
+
+	err, data = encryptKDBX(&doc, binaries, password, keyFileData, cipherId, params)
+	if err != nil {
+		return err
+	}
+
+	err, fh = CreateAtomicFile(fileName, 0600)
+	if err != nil {
+		return err
+	}
+
+	defer fh.Abort()
+
+	if _, err = fh.Write(data); err != nil {
+		return err
+	}
+
+	return fh.Commit()
+}
+
+// Read the contents of the key file given with --keyfile for a KeePass
+// database, if any
+func readKDBXKeyFile() (error, []byte) {
+
+	if SettingsRider.KeyFile == "" {
+		return nil, nil
+	}
+
+	data, err := os.ReadFile(SettingsRider.KeyFile)
+	if err != nil {
+		return fmt.Errorf("can't read keyfile - %s", err.Error()), nil
+	}
+
+	return nil, data
+}
+
+// Export the active database to a KeePass KDBX 4 file with a new password
+// and the key file given with --keyfile, if any
+func ExportToKDBX(fileName string) error {
+
+	var err error
+	var passwd string
+	var keyFileData []byte
+
+	if err, keyFileData = readKDBXKeyFile(); err != nil {
+		return err
+	}
+
+	err, passwd = readNewPassword("KeePass Password")
+	if err != nil {
+		return err
+	}
+
+	return WriteKDBX(fileName, passwd, keyFileData, getEncryptionCipher(), getKdfParams())
+}
+
+// Parse a KeePass database to import, asking for its password
+func parseKeePass(data []byte) (error, []ImportEntry) {
+
+	var err error
+	var passwd string
+	var keyFileData []byte
+
+	if err, keyFileData = readKDBXKeyFile(); err != nil {
+		return err, nil
+	}
+
+	fmt.Printf("KeePass Password: ")
+	err, passwd = ReadPassword()
+	fmt.Println()
+	if err != nil {
+		return err, nil
+	}
+
+	return ReadKDBX(data, passwd, keyFileData)
+}
+
+// Import the entries of a KeePass KDBX 4 database into the active database
+func ImportFromKDBX(fileName, password string, keyFileData []byte, group string, dryRun bool) error {
+
+	var err error
+	var data []byte
+	var entries []ImportEntry
+
+	data, err = os.ReadFile(fileName)
+	if err != nil {
+		return err
+	}
+
+	err, entries = ReadKDBX(data, password, keyFileData)
+	if err != nil {
+		return err
+	}
+
+	return importEntries(entries, group, dryRun)
+}
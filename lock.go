@@ -0,0 +1,242 @@
+// Advisory locking of databases between concurrent varuh processes
+package varuh
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Lock files are kept next to the database as <path>.lock
+const LOCK_SUFFIX = ".lock"
+
+// Interval between attempts while waiting for a busy database
+const LOCK_POLL_INTERVAL = 100 * time.Millisecond
+
+// Returned when another process holds the lock on a database
+var ErrVaultBusy = errors.New("vault busy")
+
+// A lock held by this process. Locks are re-entrant so that actions which
+// call each other (e.g switching databases encrypts the current one) work.
+type vaultLock struct {
+	fh    *os.File
+	count int
+}
+
+var heldLocks = make(map[string]*vaultLock)
+var heldLocksMutex sync.Mutex
+
+// Return the path of the lock file of a database
+func lockFilePath(dbPath string) string {
+	return dbPath + LOCK_SUFFIX
+}
+
+// Return true if a process with the pid exists
+func isProcessAlive(pid int) bool {
+
+	err := syscall.Kill(pid, 0)
+	return err == nil || err == syscall.EPERM
+}
+
+// Read the pid recorded in a lock file, 0 if none
+func readLockPid(fh *os.File) int {
+
+	var buf [32]byte
+
+	n, _ := fh.ReadAt(buf[:], 0)
+	pid, _ := strconv.Atoi(strings.TrimSpace(string(buf[:n])))
+
+	return pid
+}
+
+// Try once to take the lock file. Returns the pid of the holder if busy.
+func tryLockFile(lockPath string) (error, *os.File, int) {
+
+	var err error
+	var fh *os.File
+	var pid int
+
+	fh, err = os.OpenFile(lockPath, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return err, nil, 0
+	}
+
+	if err = syscall.Flock(int(fh.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		pid = readLockPid(fh)
+		fh.Close()
+		if err == syscall.EWOULDBLOCK {
+			return ErrVaultBusy, nil, pid
+		}
+		return err, nil, 0
+	}
+
+	// The holder may have removed the file between our open and flock
+	fileInfo, err1 := fh.Stat()
+	pathInfo, err2 := os.Stat(lockPath)
+	if err1 != nil || err2 != nil || !os.SameFile(fileInfo, pathInfo) {
+		fh.Close()
+		return tryLockFile(lockPath)
+	}
+
+	if pid = readLockPid(fh); pid != 0 && pid != os.Getpid() && !isProcessAlive(pid) {
+		fmt.Printf("Removing stale lock on %s left by pid %d\n", filepath.Base(strings.TrimSuffix(lockPath, LOCK_SUFFIX)), pid)
+	}
+
+	fh.Truncate(0)
+	fh.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+	fh.Sync()
+
+	return nil, fh, 0
+}
+
+// Take an exclusive lock on a database, waiting up to timeout for another
+// process to release it. Returns a function which releases the lock.
+func LockDatabase(dbPath string, timeout time.Duration) (error, VoidFunc) {
+
+	var err error
+	var fh *os.File
+	var pid int
+	var deadline time.Time
+
+	dbPath, _ = filepath.Abs(dbPath)
+
+	deadline = time.Now().Add(timeout)
+
+	// The mutex is not held while waiting, so that locks can be released
+	// meanwhile - such as by the signal handler
+	for {
+		if holdHeldLock(dbPath) {
+			return nil, unlocker(dbPath)
+		}
+
+		err, fh, pid = tryLockFile(lockFilePath(dbPath))
+		if err != ErrVaultBusy || !time.Now().Before(deadline) {
+			break
+		}
+		time.Sleep(LOCK_POLL_INTERVAL)
+	}
+
+	if err == ErrVaultBusy {
+		if pid != 0 {
+			return fmt.Errorf("%w - %s is in use by another varuh process (pid %d)", ErrVaultBusy, dbPath, pid), nil
+		}
+		return fmt.Errorf("%w - %s is in use by another varuh process", ErrVaultBusy, dbPath), nil
+	} else if err != nil {
+		return err, nil
+	}
+
+	heldLocksMutex.Lock()
+	heldLocks[dbPath] = &vaultLock{fh: fh, count: 1}
+	heldLocksMutex.Unlock()
+
+	return nil, unlocker(dbPath)
+}
+
+// Take one more hold of a lock this process has, returning false if it
+// has none
+func holdHeldLock(dbPath string) bool {
+
+	heldLocksMutex.Lock()
+	defer heldLocksMutex.Unlock()
+
+	if held, ok := heldLocks[dbPath]; ok {
+		held.count++
+		return true
+	}
+
+	return false
+}
+
+// Return a function releasing one hold of the lock. Calling it again is a no-op.
+func unlocker(dbPath string) VoidFunc {
+
+	var once sync.Once
+
+	return func() error {
+		var err error
+
+		once.Do(func() { err = unlockDatabase(dbPath) })
+		return err
+	}
+}
+
+// Release one hold of the lock on a database
+func unlockDatabase(dbPath string) error {
+
+	heldLocksMutex.Lock()
+	defer heldLocksMutex.Unlock()
+
+	held, ok := heldLocks[dbPath]
+	if !ok {
+		return nil
+	}
+
+	held.count--
+	if held.count > 0 {
+		return nil
+	}
+
+	delete(heldLocks, dbPath)
+
+	// Remove while still holding the lock, waiters notice the file is gone
+	os.Remove(lockFilePath(dbPath))
+	syscall.Flock(int(held.fh.Fd()), syscall.LOCK_UN)
+
+	return held.fh.Close()
+}
+
+// Return how long to wait for a busy database - command line override or config
+func getLockTimeout() time.Duration {
+
+	var timeout string
+
+	if SettingsRider.LockTimeout != "" {
+		timeout = SettingsRider.LockTimeout
+	} else if _, settings := GetOrCreateLocalConfig(APP); settings != nil {
+		timeout = settings.LockTimeout
+	}
+
+	if timeout == "" {
+		return 0
+	}
+
+	duration, err := time.ParseDuration(timeout)
+	if err != nil {
+		fmt.Printf("Warning - invalid lock timeout \"%s\", not waiting\n", timeout)
+		return 0
+	}
+
+	return duration
+}
+
+// Lock a database for an action, printing an error if it is busy
+func lockForAction(dbPath string) (error, VoidFunc) {
+
+	err, unlock := LockDatabase(dbPath, getLockTimeout())
+	if err != nil {
+		fmt.Printf("Error - %s\n", err.Error())
+		if errors.Is(err, ErrVaultBusy) {
+			fmt.Println("Retry once it is done or set a wait with --lock-timeout <duration>.")
+		}
+		return err, nil
+	}
+
+	return nil, unlock
+}
+
+// Lock the active database for an action. Does nothing if there is none.
+func lockActiveDatabase() (error, VoidFunc) {
+
+	_, settings := GetOrCreateLocalConfig(APP)
+	if settings == nil || settings.ActiveDB == "" {
+		return nil, func() error { return nil }
+	}
+
+	return lockForAction(settings.ActiveDB)
+}
@@ -0,0 +1,271 @@
+// One-time codes - TOTP (RFC 6238) and HOTP (RFC 4226)
+package varuh
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const OTP_DEFAULT_DIGITS = 6
+const OTP_DEFAULT_PERIOD = 30
+const OTP_DEFAULT_ALGORITHM = "SHA1"
+
+// Parameters of a one-time code generator, as in an otpauth:// URI
+type OtpParams struct {
+	Type      string // totp or hotp
+	Label     string
+	Secret    string // base32
+	Issuer    string
+	Algorithm string // SHA1, SHA256 or SHA512
+	Digits    int
+	Period    int    // totp only
+	Counter   uint64 // hotp only - counter of the next code
+}
+
+// Normalize a base32 secret - upper case without spaces or padding
+func normalizeOtpSecret(secret string) string {
+
+	secret = strings.ToUpper(strings.Join(strings.Fields(secret), ""))
+	return strings.TrimRight(secret, "=")
+}
+
+// Decode a base32 secret with or without padding
+func decodeOtpSecret(secret string) (error, []byte) {
+
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(normalizeOtpSecret(secret))
+	if err != nil {
+		return errors.New("secret is not valid base32"), nil
+	}
+
+	if len(key) == 0 {
+		return errors.New("secret is empty"), nil
+	}
+
+	return nil, key
+}
+
+// Parse an otpauth:// URI or a bare base32 secret, which is taken as a
+// TOTP secret with the default parameters
+func ParseOtp(value string) (error, *OtpParams) {
+
+	var err error
+	var uri *url.URL
+
+	value = strings.TrimSpace(value)
+
+	params := &OtpParams{Type: "totp", Algorithm: OTP_DEFAULT_ALGORITHM,
+		Digits: OTP_DEFAULT_DIGITS, Period: OTP_DEFAULT_PERIOD}
+
+	if !strings.HasPrefix(strings.ToLower(value), "otpauth://") {
+		params.Secret = normalizeOtpSecret(value)
+		if err, _ = decodeOtpSecret(params.Secret); err != nil {
+			return err, nil
+		}
+		return nil, params
+	}
+
+	uri, err = url.Parse(value)
+	if err != nil {
+		return fmt.Errorf("invalid otpauth URI - %s", err.Error()), nil
+	}
+
+	params.Type = strings.ToLower(uri.Host)
+	if params.Type != "totp" && params.Type != "hotp" {
+		return fmt.Errorf("unsupported OTP type \"%s\"", uri.Host), nil
+	}
+
+	params.Label = strings.TrimPrefix(uri.Path, "/")
+
+	query := uri.Query()
+
+	params.Secret = normalizeOtpSecret(query.Get("secret"))
+	if err, _ = decodeOtpSecret(params.Secret); err != nil {
+		return err, nil
+	}
+
+	params.Issuer = query.Get("issuer")
+
+	if algorithm := query.Get("algorithm"); algorithm != "" {
+		params.Algorithm = strings.ToUpper(algorithm)
+		if params.Algorithm != "SHA1" && params.Algorithm != "SHA256" && params.Algorithm != "SHA512" {
+			return fmt.Errorf("unsupported OTP algorithm \"%s\"", algorithm), nil
+		}
+	}
+
+	if digits := query.Get("digits"); digits != "" {
+		params.Digits, err = strconv.Atoi(digits)
+		if err != nil || params.Digits < 6 || params.Digits > 10 {
+			return fmt.Errorf("invalid OTP digits \"%s\" - should be 6 to 10", digits), nil
+		}
+	}
+
+	if period := query.Get("period"); period != "" {
+		params.Period, err = strconv.Atoi(period)
+		if err != nil || params.Period <= 0 {
+			return fmt.Errorf("invalid OTP period \"%s\"", period), nil
+		}
+	}
+
+	if params.Type == "hotp" {
+		counter := query.Get("counter")
+		if counter == "" {
+			return errors.New("HOTP URI has no counter"), nil
+		}
+		params.Counter, err = strconv.ParseUint(counter, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid HOTP counter \"%s\"", counter), nil
+		}
+	}
+
+	return nil, params
+}
+
+// Validate an OTP secret or URI and return it as a URI, labelled with
+// the given label if it has none
+func NormalizeOtp(otp, label string) (error, string) {
+
+	err, params := ParseOtp(otp)
+	if err != nil {
+		return err, ""
+	}
+
+	if params.Label == "" {
+		params.Label = label
+	}
+
+	return nil, params.URI()
+}
+
+// Return the parameters as an otpauth:// URI
+func (p *OtpParams) URI() string {
+
+	query := url.Values{}
+
+	query.Set("secret", p.Secret)
+	if p.Issuer != "" {
+		query.Set("issuer", p.Issuer)
+	}
+	query.Set("algorithm", p.Algorithm)
+	query.Set("digits", strconv.Itoa(p.Digits))
+
+	if p.Type == "hotp" {
+		query.Set("counter", strconv.FormatUint(p.Counter, 10))
+	} else {
+		query.Set("period", strconv.Itoa(p.Period))
+	}
+
+	uri := url.URL{Scheme: "otpauth", Host: p.Type, Path: "/" + p.Label, RawQuery: query.Encode()}
+	return uri.String()
+}
+
+// Return a short description of the parameters without the secret
+func (p *OtpParams) Describe() string {
+
+	if p.Type == "hotp" {
+		return fmt.Sprintf("HOTP (%s, %d digits, counter %d)", p.Algorithm, p.Digits, p.Counter)
+	}
+
+	return fmt.Sprintf("TOTP (%s, %d digits, %ds)", p.Algorithm, p.Digits, p.Period)
+}
+
+// Generate the HOTP code for a counter (RFC 4226)
+func GenerateHOTP(secret string, counter uint64, digits int, algorithm string) (error, string) {
+
+	var hashFunc func() hash.Hash
+	var msg [8]byte
+
+	err, key := decodeOtpSecret(secret)
+	if err != nil {
+		return err, ""
+	}
+
+	switch strings.ToUpper(algorithm) {
+	case "", "SHA1":
+		hashFunc = sha1.New
+	case "SHA256":
+		hashFunc = sha256.New
+	case "SHA512":
+		hashFunc = sha512.New
+	default:
+		return fmt.Errorf("unsupported OTP algorithm \"%s\"", algorithm), ""
+	}
+
+	binary.BigEndian.PutUint64(msg[:], counter)
+
+	mac := hmac.New(hashFunc, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	// Dynamic truncation
+	offset := sum[len(sum)-1] & 0x0f
+	code := uint64(binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff)
+
+	modulo := uint64(1)
+	for i := 0; i < digits; i++ {
+		modulo *= 10
+	}
+
+	return nil, fmt.Sprintf("%0*d", digits, code%modulo)
+}
+
+// Generate the TOTP code at a time (RFC 6238) and the time it remains valid
+func GenerateTOTP(params *OtpParams, now time.Time) (error, string, time.Duration) {
+
+	period := int64(params.Period)
+	step := now.Unix() / period
+
+	err, code := GenerateHOTP(params.Secret, uint64(step), params.Digits, params.Algorithm)
+	if err != nil {
+		return err, "", 0
+	}
+
+	remaining := time.Unix((step+1)*period, 0).Sub(now)
+
+	return nil, code, remaining
+}
+
+// Generate the current code of an entry. For HOTP the counter stored with
+// the entry is moved on so that each code is used once. The remaining
+// validity is zero for HOTP.
+func GetEntryOtpCode(entry *Entry) (error, string, time.Duration) {
+
+	var err error
+	var params *OtpParams
+	var code string
+
+	if entry.Otp == "" {
+		return fmt.Errorf("entry %d has no OTP secret", entry.ID), "", 0
+	}
+
+	err, params = ParseOtp(entry.Otp)
+	if err != nil {
+		return err, "", 0
+	}
+
+	if params.Type == "totp" {
+		return GenerateTOTP(params, time.Now())
+	}
+
+	err, code = GenerateHOTP(params.Secret, params.Counter, params.Digits, params.Algorithm)
+	if err != nil {
+		return err, "", 0
+	}
+
+	params.Counter++
+	if err = updateEntryOtp(entry, params.URI()); err != nil {
+		return err, "", 0
+	}
+
+	return nil, code, 0
+}
@@ -0,0 +1,269 @@
+// Import of a pass (password-store) directory, where each entry is a
+// GPG encrypted file with the password on its first line
+package varuh
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/armor"
+)
+
+// Extension of the files of a pass store
+const PASS_FILE_EXT = ".gpg"
+
+// Read an OpenPGP key ring with the private key to decrypt with, as
+// exported by gpg --export-secret-keys with or without --armor
+func ReadPGPKeyRing(data []byte) (error, openpgp.EntityList) {
+
+	var keyRing openpgp.EntityList
+	var err error
+
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("-----BEGIN")) {
+		keyRing, err = openpgp.ReadArmoredKeyRing(bytes.NewReader(data))
+	} else {
+		keyRing, err = openpgp.ReadKeyRing(bytes.NewReader(data))
+	}
+
+	if err != nil {
+		return fmt.Errorf("can't read OpenPGP key - %s", err.Error()), nil
+	}
+
+	if len(keyRing.DecryptionKeys()) == 0 {
+		return errors.New("no private key to decrypt with - RSA and ElGamal keys exported with gpg --export-secret-keys are supported"), nil
+	}
+
+	return nil, keyRing
+}
+
+// Return true if the private keys of a key ring are protected with a passphrase
+func PGPKeyRingLocked(keyRing openpgp.EntityList) bool {
+
+	for _, key := range keyRing.DecryptionKeys() {
+		if key.PrivateKey.Encrypted {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Decrypt the private keys of a key ring with their passphrase
+func UnlockPGPKeyRing(keyRing openpgp.EntityList, passphrase string) error {
+
+	for _, key := range keyRing.DecryptionKeys() {
+		if key.PrivateKey.Encrypted {
+			if err := key.PrivateKey.Decrypt([]byte(passphrase)); err != nil {
+				return errors.New("wrong passphrase for the OpenPGP key")
+			}
+		}
+	}
+
+	return nil
+}
+
+// Decrypt a file of a pass store, which may be armored
+func decryptPassFile(data []byte, keyRing openpgp.EntityList) (error, string) {
+
+	var reader io.Reader = bytes.NewReader(data)
+
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("-----BEGIN PGP MESSAGE")) {
+		block, err := armor.Decode(bytes.NewReader(data))
+		if err != nil {
+			return err, ""
+		}
+		reader = block.Body
+	}
+
+	// Keys are unlocked up front, so there is nothing to prompt for
+	prompt := func(keys []openpgp.Key, symmetric bool) ([]byte, error) {
+		return nil, errors.New("no private key to decrypt with")
+	}
+
+	message, err := openpgp.ReadMessage(reader, keyRing, prompt, nil)
+	if err != nil {
+		return err, ""
+	}
+
+	content, err := io.ReadAll(message.UnverifiedBody)
+	if err != nil {
+		return err, ""
+	}
+
+	return nil, string(content)
+}
+
+// Fill an entry being imported from the decrypted contents of a pass
+// file - the password, then "key: value" lines and free text as notes
+func parsePassContent(item *ImportEntry, content string) {
+
+	var notes []string
+
+	lines := strings.Split(strings.ReplaceAll(content, "\r\n", "\n"), "\n")
+	item.Entry.Password = lines[0]
+
+	for _, line := range lines[1:] {
+		trimmed := strings.TrimSpace(line)
+
+		// Lines added by pass-otp
+		if strings.HasPrefix(strings.ToLower(trimmed), "otpauth://") && item.Entry.Otp == "" {
+			item.setOtp(trimmed)
+			continue
+		}
+
+		// Keys are followed by a space, unlike the scheme of a URL
+		parts := strings.SplitN(line, ":", 2)
+		key := parts[0]
+		if len(parts) < 2 || key == "" || key != strings.TrimSpace(key) ||
+			(parts[1] != "" && !strings.HasPrefix(parts[1], " ") && !strings.HasPrefix(parts[1], "\t")) {
+			notes = append(notes, line)
+			continue
+		}
+
+		value := strings.TrimSpace(parts[1])
+		switch strings.ToLower(key) {
+		case "login", "user", "username":
+			if item.Entry.User == "" {
+				item.Entry.User = value
+				continue
+			}
+		case "email":
+			if item.Entry.User == "" && value != "" {
+				item.Entry.User = value
+				continue
+			}
+		case "url", "website", "site":
+			if item.Entry.Url == "" {
+				item.Entry.Url = value
+				continue
+			}
+		case "otp", "totp":
+			if item.Entry.Otp == "" {
+				item.setOtp(value)
+				continue
+			}
+		}
+
+		item.addField(key, value)
+	}
+
+	item.Entry.Notes = strings.TrimSpace(strings.Join(notes, "\n"))
+}
+
+// Read the entries of a pass store, decrypting its files with the key
+// ring. Entries are titled with their file names and put in groups of
+// their folders, or tagged with them if asTags is set.
+func ParsePassStore(storeDir string, keyRing openpgp.EntityList, asTags bool) (error, []ImportEntry) {
+
+	var entries []ImportEntry
+
+	err := filepath.WalkDir(storeDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		// .git, .extensions and the like
+		if d.IsDir() && path != storeDir && strings.HasPrefix(d.Name(), ".") {
+			return filepath.SkipDir
+		}
+		if d.IsDir() || filepath.Ext(d.Name()) != PASS_FILE_EXT {
+			return nil
+		}
+
+		relPath, _ := filepath.Rel(storeDir, path)
+		relPath = filepath.ToSlash(relPath)
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+
+		err, content := decryptPassFile(data, keyRing)
+		if err != nil {
+			return fmt.Errorf("can't decrypt %s - %s", relPath, err.Error())
+		}
+
+		item := ImportEntry{Source: relPath}
+		item.Entry.Title = strings.TrimSuffix(d.Name(), PASS_FILE_EXT)
+
+		if folder := filepath.ToSlash(filepath.Dir(relPath)); folder != "." {
+			if asTags {
+				item.Entry.Tags = importTags(strings.Split(folder, "/")...)
+			} else {
+				item.Group = folder
+			}
+		}
+
+		parsePassContent(&item, content)
+		entries = append(entries, item)
+
+		return nil
+	})
+
+	if err != nil {
+		return err, nil
+	}
+
+	if len(entries) == 0 {
+		return fmt.Errorf("no %s files in %s", PASS_FILE_EXT, storeDir), nil
+	}
+
+	return nil, entries
+}
+
+// Import the entries of a pass store into the active database
+func ImportFromPassStore(storeDir string, keyRing openpgp.EntityList, group string, asTags, dryRun bool) error {
+
+	err, entries := ParsePassStore(storeDir, keyRing, asTags)
+	if err != nil {
+		return err
+	}
+
+	return importEntries(entries, group, dryRun)
+}
+
+// Import a pass store with the private key given with --pgp-key, asking
+// for its passphrase if it has one
+func importPassStore(storeDir string) error {
+
+	var err error
+	var data []byte
+	var passphrase string
+	var keyRing openpgp.EntityList
+
+	if SettingsRider.PGPKey == "" {
+		return errors.New("give the OpenPGP private key of the store with --pgp-key")
+	}
+
+	data, err = os.ReadFile(SettingsRider.PGPKey)
+	if err != nil {
+		return err
+	}
+
+	err, keyRing = ReadPGPKeyRing(data)
+	if err != nil {
+		return err
+	}
+
+	if PGPKeyRingLocked(keyRing) {
+		fmt.Printf("OpenPGP Key Passphrase: ")
+		err, passphrase = ReadPassword()
+		fmt.Println()
+		if err != nil {
+			return err
+		}
+
+		if err = UnlockPGPKeyRing(keyRing, passphrase); err != nil {
+			return err
+		}
+	}
+
+	return ImportFromPassStore(storeDir, keyRing, SettingsRider.Group, SettingsRider.ImportTags, SettingsRider.DryRun)
+}
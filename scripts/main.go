@@ -69,21 +69,42 @@ func performAction(optMap map[string]interface{}) {
 		"path":     varuh.ShowActiveDatabasePath,
 		"list-all": varuh.WrapperMaxKryptVoidFunc(varuh.ListAllEntries),
 		"encrypt":  varuh.EncryptActiveDatabase,
+		"agent":    varuh.RunAgent,
+		"lock":     varuh.LockAgent,
+		"trash":    varuh.WrapperMaxKryptVoidFunc(varuh.ListTrashedEntries),
+		"purge":    varuh.WrapperMaxKryptVoidFunc(varuh.PurgeTrash),
+		"tags":     varuh.WrapperMaxKryptVoidFunc(varuh.ListAllTags),
 	}
 
 	stringActionsMap := map[string]varuh.ActionFunc{
-		"edit":       varuh.WrapperMaxKryptStringFunc(varuh.EditCurrentEntry),
-		"init":       varuh.InitNewDatabase,
-		"list-entry": varuh.WrapperMaxKryptStringFunc(varuh.ListCurrentEntry),
-		"remove":     varuh.WrapperMaxKryptStringFunc(varuh.RemoveCurrentEntry),
-		"clone":      varuh.WrapperMaxKryptStringFunc(varuh.CopyCurrentEntry),
-		"use-db":     varuh.SetActiveDatabasePath,
-		"export":     varuh.ExportToFile,
-		"migrate":    varuh.MigrateDatabase,
+		"edit":           varuh.WrapperMaxKryptStringFunc(varuh.EditCurrentEntry),
+		"init":           varuh.InitNewDatabase,
+		"list-entry":     varuh.WrapperMaxKryptStringFunc(varuh.ListCurrentEntry),
+		"remove":         varuh.WrapperMaxKryptStringFunc(varuh.RemoveCurrentEntry),
+		"clone":          varuh.WrapperMaxKryptStringFunc(varuh.CopyCurrentEntry),
+		"history":        varuh.WrapperMaxKryptStringFunc(varuh.ShowEntryHistory),
+		"restore":        varuh.WrapperMaxKryptStringFunc(varuh.RestoreEntry),
+		"otp":            varuh.WrapperMaxKryptStringFunc(varuh.ShowOtpCode),
+		"attachments":    varuh.WrapperMaxKryptStringFunc(varuh.ListAttachments),
+		"ssh-add":        varuh.WrapperMaxKryptStringFunc(varuh.LoadSSHKey),
+		"mkdir":          varuh.WrapperMaxKryptStringFunc(varuh.MakeGroup),
+		"ls":             varuh.WrapperMaxKryptStringFunc(varuh.ListGroup),
+		"use-db":         varuh.SetActiveDatabasePath,
+		"export":         varuh.ExportToFile,
+		"import":         varuh.WrapperMaxKryptStringFunc(varuh.ImportFromFile),
+		"migrate":        varuh.MigrateDatabase,
+		"upgrade-format": varuh.UpgradeDatabaseFormat,
+		"passwd":         varuh.ChangeDatabasePassword,
+		"kdf-bench":      varuh.BenchmarkKdfParams,
 	}
 
 	stringListActionsMap := map[string]varuh.ActionFunc{
-		"find": varuh.WrapperMaxKryptStringFunc(varuh.FindCurrentEntry),
+		"find":       varuh.WrapperMaxKryptStringFunc(varuh.FindCurrentEntry),
+		"mv":         varuh.WrapperMaxKryptStringFunc(varuh.MoveEntry),
+		"tag-rename": varuh.WrapperMaxKryptStringFunc(varuh.RenameEntryTag),
+		"tag-merge":  varuh.WrapperMaxKryptStringFunc(varuh.MergeEntryTags),
+		"attach":     varuh.WrapperMaxKryptStringFunc(varuh.AttachFile),
+		"extract":    varuh.WrapperMaxKryptStringFunc(varuh.ExtractAttachment),
 	}
 
 	stringActions2Map := map[string]varuh.ActionFunc2{
@@ -95,13 +116,25 @@ func performAction(optMap map[string]interface{}) {
 	}
 
 	flagsActionsMap := map[string]varuh.VoidFunc{
-		"show":       varuh.SetShowPasswords,
-		"copy":       varuh.SetCopyPasswordToClipboard,
-		"assume-yes": varuh.SetAssumeYes,
+		"show":        varuh.SetShowPasswords,
+		"copy":        varuh.SetCopyPasswordToClipboard,
+		"assume-yes":  varuh.SetAssumeYes,
+		"dry-run":     varuh.SetDryRun,
+		"import-tags": varuh.SetImportTags,
 	}
 
 	flagsSettingsMap := map[string]varuh.SettingFunc{
-		"type": varuh.SetType,
+		"type":          varuh.SetType,
+		"cipher":        varuh.SetCipher,
+		"keyfile":       varuh.SetKeyFile,
+		"lock-timeout":  varuh.SetLockTimeout,
+		"older-than":    varuh.SetPurgeOlderThan,
+		"group":         varuh.SetGroup,
+		"output":        varuh.SetOutputPath,
+		"ssh-lifetime":  varuh.SetSSHLifetime,
+		"import-map":    varuh.SetImportMap,
+		"import-format": varuh.SetImportFormat,
+		"pgp-key":       varuh.SetPGPKey,
 	}
 
 	// Flag actions - always done
@@ -190,9 +223,30 @@ func initializeCmdLine(parser *argparse.Parser) map[string]interface{} {
 		{"U", "use-db", "Set <path> as active database", "<path>", ""},
 		{"E", "edit", "Edit entry by <id>", "<id>", ""},
 		{"l", "list-entry", "List entry by <id>", "<id>", ""},
+		{"", "history", "Show previous values of entry <id>", "<id>", ""},
+		{"", "restore", "Restore entry <id> from trash or roll it back to before <revision>", "<id>[@<revision>]", ""},
+		{"", "otp", "Print the one-time code of entry <id>", "<id>", ""},
+		{"", "attachments", "List files attached to entry <id>", "<id>", ""},
+		{"", "ssh-add", "Load the key of sshkey entry <id> into the running ssh-agent", "<id>", ""},
+		{"", "ssh-lifetime", "With --ssh-add, have ssh-agent forget the key after <time>", "<time>", ""},
+		{"o", "output", "With --extract, write the file to <path>", "<path>", ""},
+		{"", "mkdir", "Create group <path> along with missing parents", "<path>", ""},
+		{"", "ls", "List groups and entries below group <path>", "<path>", ""},
+		{"", "group", "Limit listing and search to entries below group <path>, or import into it", "<path>", ""},
 		{"x", "export", "Export all entries to <filename>", "<filename>", ""},
+		{"", "import", "Import entries from <filename> (csv, json, 1pux, kdbx) or a pass store directory", "<filename>", ""},
+		{"", "import-map", "With --import, read fields from the given CSV columns", "<field>=<column>,...", ""},
+		{"", "import-format", "With --import, read the file as exported by bitwarden, bitwarden-csv, 1password, 1password-csv, lastpass, keepass, pass, chrome, firefox, csv or varuh (JSON export)", "<format>", ""},
+		{"", "pgp-key", "With --import of a pass store, the OpenPGP private key to decrypt it with", "<path>", ""},
 		{"m", "migrate", "Migrate a database to latest schema", "<path>", ""},
-		{"t", "type", "Specify type when adding a new entry", "<type>", ""},
+		{"t", "type", "Specify type when adding a new entry (password, card, identity, address, note, sshkey)", "<type>", ""},
+		{"", "upgrade-format", "Upgrade an encrypted database to the latest file format", "<path>", ""},
+		{"", "passwd", "Change the password of an encrypted database", "<path>", ""},
+		{"", "kdf-bench", "Calibrate key derivation to a target unlock time", "<time>", ""},
+		{"", "cipher", "Cipher to encrypt with (aes, xchacha)", "<cipher>", ""},
+		{"", "keyfile", "Keyfile to combine with the password, or of a KeePass database", "<path>", ""},
+		{"", "older-than", "With --purge, only purge entries trashed longer than <age> ago", "<age>", ""},
+		{"", "lock-timeout", "Wait up to <time> for a database in use by another process", "<time>", ""},
 	}
 
 	for _, opt := range stringOptions {
@@ -200,7 +254,12 @@ func initializeCmdLine(parser *argparse.Parser) map[string]interface{} {
 	}
 
 	stringListOptions := []CmdOption{
-		{"f", "find", "Search entries with terms", "<t1> <t2> ...", ""},
+		{"f", "find", "Search entries with a query of terms, field:value, OR, NOT and ( )", "<t1> <t2> ...", ""},
+		{"", "mv", "Move entry <id> to group <path>", "<id> <path>", ""},
+		{"", "tag-rename", "Rename tag <old> to <new> in all entries", "<old> <new>", ""},
+		{"", "tag-merge", "Merge tags into the last tag given", "<t1> <t2> ... <into>", ""},
+		{"", "attach", "Attach <file> to entry <id>", "<id> <file>", ""},
+		{"", "extract", "Write attachment <name> of entry <id> to a file", "<id> <name>", ""},
 	}
 
 	for _, opt := range stringListOptions {
@@ -214,9 +273,16 @@ func initializeCmdLine(parser *argparse.Parser) map[string]interface{} {
 		{"a", "list-all", "List all entries in current database", "", ""},
 		{"g", "genpass", "Generate a strong password (length: 12 - 16)", "", ""},
 		{"s", "show", "Show passwords when listing entries", "", ""},
-		{"c", "copy", "Copy password to clipboard", "", ""},
+		{"c", "copy", "Copy password (or one-time code) to clipboard", "", ""},
 		{"y", "assume-yes", "Assume yes to actions requiring confirmation", "", ""},
+		{"", "dry-run", "With --import, only show what would be imported", "", ""},
+		{"", "import-tags", "With --import of a pass store, tag entries with their folders instead of grouping them", "", ""},
 		{"v", "version", "Show version information and exit", "", ""},
+		{"", "agent", "Run the unlock agent which caches database keys", "", ""},
+		{"", "lock", "Clear the keys cached by the unlock agent", "", ""},
+		{"", "trash", "List removed entries in the trash", "", ""},
+		{"", "purge", "Permanently delete entries in the trash", "", ""},
+		{"", "tags", "List tags with the number of entries having each", "", ""},
 		{"h", "help", "Print this help message and exit", "", ""},
 	}
 
@@ -227,10 +293,51 @@ func initializeCmdLine(parser *argparse.Parser) map[string]interface{} {
 	return optMap
 }
 
+// Options taking more than one value, with the number of values they
+// take or -1 for any number. argparse takes a single value per option, so
+// the values are passed on as repeats of the option, "--mv 12 work" as
+// "--mv 12 --mv work".
+var multiValueOptions = map[string]int{
+	"-f":           -1,
+	"--find":       -1,
+	"--mv":         2,
+	"--tag-rename": 2,
+	"--tag-merge":  -1,
+	"--attach":     2,
+	"--extract":    2,
+}
+
+// Repeat multi-value options before each of their values. Values end at
+// the next option, so the repeated form given by hand is kept as is.
+func expandMultiValueArgs(args []string) []string {
+
+	var expanded []string
+
+	for idx := 0; idx < len(args); idx++ {
+		expanded = append(expanded, args[idx])
+
+		count, ok := multiValueOptions[args[idx]]
+		if !ok {
+			continue
+		}
+
+		option := args[idx]
+		for n := 1; (count < 0 || n < count) && idx+2 < len(args) && !strings.HasPrefix(args[idx+2], "-"); n++ {
+			expanded = append(expanded, args[idx+1], option)
+			idx++
+		}
+	}
+
+	return expanded
+}
+
 // Main routine
 func main() {
 	if len(os.Args) == 1 {
 		os.Args = append(os.Args, "-h")
+	} else if os.Args[1] == "agent" {
+		// Allow "varuh agent" like ssh-agent
+		os.Args[1] = "--agent"
 	}
 
 	parser := argparse.NewParser("varuh",
@@ -240,13 +347,15 @@ func main() {
 
 	optMap := initializeCmdLine(parser)
 
-	err := parser.Parse(os.Args)
+	err := parser.Parse(expandMultiValueArgs(os.Args))
 
 	if err != nil {
 		fmt.Println(parser.Usage(err))
 	}
 
 	varuh.GetOrCreateLocalConfig(varuh.APP)
+	// Finish or clean up a write interrupted by a crash
+	varuh.RecoverActiveDatabase()
 
 	performAction(optMap)
 }
@@ -0,0 +1,550 @@
+// Search queries and the full-text index backing them
+package varuh
+
+import (
+	"errors"
+	"fmt"
+	"gorm.io/gorm"
+	"strings"
+	"unicode"
+)
+
+// Full-text index of entries, one row per entry with the entry id as rowid
+const SEARCH_INDEX_TABLE = "entries_fts"
+
+// Columns of the full-text index. Secrets such as passwords, PINs and
+// keys are not indexed.
+var searchIndexColumns = []string{"title", "user", "url", "notes", "tags", "fields", "extra"}
+
+// Values of the index columns for the entry e
+var searchIndexValues = []string{
+	"e.title",
+	"e.user",
+	"e.url",
+	"e.notes",
+	"e.tags",
+	"(select group_concat(field_name || ' ' || field_value, ' ') from exentries where entry_id = e.id)",
+	`coalesce(e.issuer, '') || ' ' || coalesce(e.class, '') || ' ' || coalesce(e.email, '') || ' ' ||
+	coalesce(e.company, '') || ' ' || coalesce(e.public_key, '') || ' ' ||
+	coalesce((select group_concat(type || ' ' || number || ' ' || building || ' ' || street || ' ' ||
+	locality || ' ' || area || ' ' || city || ' ' || state || ' ' || zipcode || ' ' || country || ' ' ||
+	landmark, ' ') from address where entry_id = e.id), '')`,
+}
+
+// Qualifiers of a search term matched in a column of the index
+var searchIndexQualifiers = map[string]string{
+	"title": "title",
+	"user":  "user",
+	"notes": "notes",
+}
+
+// Node of a parsed search query
+type SearchQuery struct {
+	Op       string // and, or, not or term
+	Children []*SearchQuery
+	Field    string // qualifier of a term, empty for any field
+	Value    string
+	Phrase   bool // quoted term
+}
+
+// Return a query in a normalized form, mostly for display and tests
+func (q *SearchQuery) String() string {
+
+	var parts []string
+
+	switch q.Op {
+	case "and", "or":
+		for _, child := range q.Children {
+			parts = append(parts, child.String())
+		}
+		return "(" + strings.Join(parts, " "+strings.ToUpper(q.Op)+" ") + ")"
+	case "not":
+		return "NOT " + q.Children[0].String()
+	}
+
+	value := q.Value
+	if q.Phrase {
+		value = "\"" + value + "\""
+	}
+	if q.Field != "" {
+		return q.Field + ":" + value
+	}
+
+	return value
+}
+
+// A token of a query - an operator, a parenthesis or a term
+type queryToken struct {
+	text   string
+	field  string
+	phrase bool
+	term   bool
+}
+
+// Split a query into tokens. Terms may be quoted and may have a qualifier
+// such as title: before them.
+func tokenizeQuery(query string) (error, []queryToken) {
+
+	var tokens []queryToken
+
+	runes := []rune(query)
+
+	for i := 0; i < len(runes); {
+		r := runes[i]
+
+		if unicode.IsSpace(r) {
+			i++
+			continue
+		}
+
+		if r == '(' || r == ')' {
+			tokens = append(tokens, queryToken{text: string(r)})
+			i++
+			continue
+		}
+
+		token := queryToken{term: true}
+
+		// Qualifier
+		start := i
+		for i < len(runes) && (unicode.IsLetter(runes[i]) || unicode.IsDigit(runes[i])) {
+			i++
+		}
+		if i < len(runes) && runes[i] == ':' && i > start && isSearchQualifier(string(runes[start:i])) {
+			token.field = strings.ToLower(string(runes[start:i]))
+			i++
+		} else {
+			i = start
+		}
+
+		if i < len(runes) && runes[i] == '"' {
+			end := i + 1
+			for end < len(runes) && runes[end] != '"' {
+				end++
+			}
+			if end == len(runes) {
+				return errors.New("unterminated quote in query"), nil
+			}
+			token.text = string(runes[i+1 : end])
+			token.phrase = true
+			i = end + 1
+		} else {
+			start = i
+			for i < len(runes) && !unicode.IsSpace(runes[i]) && runes[i] != '(' && runes[i] != ')' {
+				i++
+			}
+			token.text = string(runes[start:i])
+		}
+
+		if token.field == "" && !token.phrase && (token.text == "AND" || token.text == "OR" || token.text == "NOT") {
+			token.term = false
+		}
+
+		tokens = append(tokens, token)
+	}
+
+	return nil, tokens
+}
+
+// Return true if name is a known qualifier
+func isSearchQualifier(name string) bool {
+
+	switch strings.ToLower(name) {
+	case "title", "user", "notes", "url", "tag", "type", "field":
+		return true
+	}
+
+	return false
+}
+
+// Recursive descent parser of query tokens
+type queryParser struct {
+	tokens []queryToken
+	pos    int
+}
+
+func (p *queryParser) peek() *queryToken {
+
+	if p.pos < len(p.tokens) {
+		return &p.tokens[p.pos]
+	}
+	return nil
+}
+
+func (p *queryParser) isOperator(op string) bool {
+
+	token := p.peek()
+	return token != nil && !token.term && token.text == op
+}
+
+// or := and ("OR" and)*
+func (p *queryParser) parseOr() (error, *SearchQuery) {
+
+	err, left := p.parseAnd()
+	if err != nil {
+		return err, nil
+	}
+
+	node := &SearchQuery{Op: "or", Children: []*SearchQuery{left}}
+	for p.isOperator("OR") {
+		p.pos++
+		err, right := p.parseAnd()
+		if err != nil {
+			return err, nil
+		}
+		node.Children = append(node.Children, right)
+	}
+
+	if len(node.Children) == 1 {
+		return nil, left
+	}
+	return nil, node
+}
+
+// and := not (["AND"] not)*
+func (p *queryParser) parseAnd() (error, *SearchQuery) {
+
+	err, left := p.parseNot()
+	if err != nil {
+		return err, nil
+	}
+
+	node := &SearchQuery{Op: "and", Children: []*SearchQuery{left}}
+	for {
+		if p.isOperator("AND") {
+			p.pos++
+		} else if token := p.peek(); token == nil || p.isOperator(")") || p.isOperator("OR") {
+			break
+		}
+
+		err, right := p.parseNot()
+		if err != nil {
+			return err, nil
+		}
+		node.Children = append(node.Children, right)
+	}
+
+	if len(node.Children) == 1 {
+		return nil, left
+	}
+	return nil, node
+}
+
+// not := "NOT" not | primary
+func (p *queryParser) parseNot() (error, *SearchQuery) {
+
+	if p.isOperator("NOT") {
+		p.pos++
+		err, child := p.parseNot()
+		if err != nil {
+			return err, nil
+		}
+		return nil, &SearchQuery{Op: "not", Children: []*SearchQuery{child}}
+	}
+
+	return p.parsePrimary()
+}
+
+// primary := "(" or ")" | term
+func (p *queryParser) parsePrimary() (error, *SearchQuery) {
+
+	token := p.peek()
+
+	switch {
+	case token == nil:
+		return errors.New("query ends too soon"), nil
+	case p.isOperator("("):
+		p.pos++
+		err, node := p.parseOr()
+		if err != nil {
+			return err, nil
+		}
+		if !p.isOperator(")") {
+			return errors.New("missing ) in query"), nil
+		}
+		p.pos++
+		return nil, node
+	case !token.term:
+		return fmt.Errorf("unexpected %s in query", token.text), nil
+	}
+
+	p.pos++
+
+	if token.field != "" && token.text == "" {
+		return fmt.Errorf("no value for %s: in query", token.field), nil
+	}
+
+	return nil, &SearchQuery{Op: "term", Field: token.field, Value: token.text, Phrase: token.phrase}
+}
+
+// Parse a search query. Terms are joined by AND unless OR is given, and
+// may be negated by NOT and grouped with parentheses. An empty query
+// gives a nil query which matches all entries.
+func ParseSearchQuery(query string) (error, *SearchQuery) {
+
+	err, tokens := tokenizeQuery(query)
+	if err != nil {
+		return err, nil
+	}
+
+	if len(tokens) == 0 {
+		return nil, nil
+	}
+
+	parser := &queryParser{tokens: tokens}
+
+	err, node := parser.parseOr()
+	if err != nil {
+		return err, nil
+	}
+
+	if parser.pos < len(tokens) {
+		return fmt.Errorf("unexpected %s in query", tokens[parser.pos].text), nil
+	}
+
+	return nil, node
+}
+
+// Split text into lower case words as the index tokenizer does
+func searchWords(text string) []string {
+
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// Return true if a value has glob wildcards
+func hasWildcards(value string) bool {
+	return strings.ContainsAny(value, "*?[")
+}
+
+// Escape a value for a like pattern with \ as the escape character
+func escapeLike(value string) string {
+
+	replacer := strings.NewReplacer("\\", "\\\\", "%", "\\%", "_", "\\_")
+	return replacer.Replace(value)
+}
+
+// URL of an entry without its scheme
+const urlNoSchemeExpr = `(case when instr(entries.url, '://') > 0 then substr(entries.url, instr(entries.url, '://') + 3) else entries.url end)`
+
+// Host part of the URL of an entry in lower case
+var urlHostExpr = fmt.Sprintf(`lower(case when instr(%[1]s, '/') > 0 then substr(%[1]s, 1, instr(%[1]s, '/') - 1) else %[1]s end)`,
+	urlNoSchemeExpr)
+
+// Build the SQL condition of a query node with its arguments
+func (q *SearchQuery) sql() (string, []interface{}) {
+
+	var parts []string
+	var args []interface{}
+
+	switch q.Op {
+	case "and", "or":
+		for _, child := range q.Children {
+			part, childArgs := child.sql()
+			parts = append(parts, part)
+			args = append(args, childArgs...)
+		}
+		return "(" + strings.Join(parts, " "+q.Op+" ") + ")", args
+	case "not":
+		part, childArgs := q.Children[0].sql()
+		return "not " + part, childArgs
+	}
+
+	switch q.Field {
+	case "tag":
+		op := "lower(tags.name) = lower(?)"
+		if hasWildcards(q.Value) {
+			op = "lower(tags.name) glob lower(?)"
+		}
+		return "entries.id in (select entry_tags.entry_id from entry_tags join tags on tags.id = entry_tags.tag_id where " +
+			op + ")", []interface{}{q.Value}
+	case "type":
+		if strings.ToLower(q.Value) == "password" {
+			// Entries added without a type are passwords
+			return "(entries.type = 'password' or entries.type = '' or entries.type is null)", nil
+		}
+		return "lower(entries.type) = lower(?)", []interface{}{q.Value}
+	case "field":
+		op := "lower(field_name) = lower(?)"
+		if hasWildcards(q.Value) {
+			op = "lower(field_name) glob lower(?)"
+		}
+		return "entries.id in (select entry_id from exentries where " + op + ")", []interface{}{q.Value}
+	case "url":
+		if hasWildcards(q.Value) {
+			// Globs match the host or the whole URL
+			return "(" + urlHostExpr + " glob lower(?) or lower(entries.url) glob lower(?))", []interface{}{q.Value, q.Value}
+		}
+		return "entries.url like ? escape '\\'", []interface{}{"%" + escapeLike(q.Value) + "%"}
+	}
+
+	words := searchWords(q.Value)
+	if len(words) == 0 {
+		// Nothing to look up in the index, such as punctuation only
+		pattern := "%" + escapeLike(q.Value) + "%"
+		columns := []string{"title", "user", "url", "notes", "tags"}
+		if column, ok := searchIndexQualifiers[q.Field]; ok {
+			columns = []string{column}
+		}
+		for _, column := range columns {
+			parts = append(parts, "entries."+column+" like ? escape '\\'")
+			args = append(args, pattern)
+		}
+		return "(" + strings.Join(parts, " or ") + ")", args
+	}
+
+	prefix := ""
+	if column, ok := searchIndexQualifiers[q.Field]; ok {
+		prefix = column + ":"
+	}
+
+	// Words are matched as prefixes, quoted phrases exactly
+	if q.Phrase {
+		parts = []string{prefix + "\"" + strings.Join(words, " ") + "\""}
+	} else {
+		for _, word := range words {
+			parts = append(parts, prefix+word+"*")
+		}
+	}
+
+	return "entries.id in (select rowid from " + SEARCH_INDEX_TABLE + " where " + SEARCH_INDEX_TABLE + " match ?)",
+		[]interface{}{strings.Join(parts, " ")}
+}
+
+// SQL to refresh the index row of an entry given by id
+func searchIndexRefreshSQL(id string) string {
+
+	return fmt.Sprintf("delete from %s where rowid = %s; insert into %s(rowid, %s) select e.id, %s from entries e where e.id = %s;",
+		SEARCH_INDEX_TABLE, id, SEARCH_INDEX_TABLE, strings.Join(searchIndexColumns, ", "),
+		strings.Join(searchIndexValues, ", "), id)
+}
+
+// Triggers keeping the index in sync with entries, custom fields and addresses
+var searchIndexTriggers = []struct {
+	name, event, table, body string
+}{
+	{"entries_fts_insert", "after insert", "entries", searchIndexRefreshSQL("new.id")},
+	{"entries_fts_update", "after update", "entries", searchIndexRefreshSQL("new.id")},
+	{"entries_fts_delete", "after delete", "entries", fmt.Sprintf("delete from %s where rowid = old.id;", SEARCH_INDEX_TABLE)},
+	{"exentries_fts_insert", "after insert", "exentries", searchIndexRefreshSQL("new.entry_id")},
+	{"exentries_fts_update", "after update", "exentries", searchIndexRefreshSQL("old.entry_id") + searchIndexRefreshSQL("new.entry_id")},
+	{"exentries_fts_delete", "after delete", "exentries", searchIndexRefreshSQL("old.entry_id")},
+	{"address_fts_insert", "after insert", "address", searchIndexRefreshSQL("new.entry_id")},
+	{"address_fts_update", "after update", "address", searchIndexRefreshSQL("old.entry_id") + searchIndexRefreshSQL("new.entry_id")},
+	{"address_fts_delete", "after delete", "address", searchIndexRefreshSQL("old.entry_id")},
+}
+
+// Return the SQL the full-text index was created with, empty if there is none
+func searchIndexSQL(db *gorm.DB) string {
+
+	var sql string
+
+	db.Raw("select sql from sqlite_master where name = ?", SEARCH_INDEX_TABLE).Scan(&sql)
+	return sql
+}
+
+// Return true if the full-text index was created with FTS5 by an older
+// version. It is rebuilt with FTS4, which every build of sqlite has.
+func isFTS5SearchIndex(db *gorm.DB) bool {
+
+	return strings.Contains(strings.ToLower(searchIndexSQL(db)), "using fts5")
+}
+
+// Drop an FTS5 index and its triggers. Without the FTS5 module sqlite
+// cannot drop the virtual table, so its schema row is removed and its
+// shadow tables are dropped as plain tables.
+func dropFTS5SearchIndex(db *gorm.DB) error {
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		for _, trigger := range searchIndexTriggers {
+			if err := tx.Exec("drop trigger if exists " + trigger.name).Error; err != nil {
+				return err
+			}
+		}
+
+		if err := tx.Exec("PRAGMA writable_schema = ON").Error; err != nil {
+			return err
+		}
+		err := tx.Exec("delete from sqlite_master where type = 'table' and name = ?", SEARCH_INDEX_TABLE).Error
+		tx.Exec("PRAGMA writable_schema = RESET")
+		if err != nil {
+			return err
+		}
+
+		for _, suffix := range []string{"data", "idx", "content", "docsize", "config"} {
+			if err := tx.Exec(fmt.Sprintf("drop table if exists %s_%s", SEARCH_INDEX_TABLE, suffix)).Error; err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// Create the full-text index of entries if needed, along with triggers
+// keeping it in sync with entries, custom fields and addresses. The index
+// uses FTS4 so that a database opens with any build of varuh.
+func CreateSearchIndex(db *gorm.DB) error {
+
+	var err error
+
+	if isFTS5SearchIndex(db) {
+		if err = dropFTS5SearchIndex(db); err != nil {
+			return fmt.Errorf("dropping FTS5 index - %s", err.Error())
+		}
+	}
+
+	if searchIndexSQL(db) == "" {
+		err = db.Exec(fmt.Sprintf("create virtual table %s using fts4(%s, tokenize=unicode61 \"remove_diacritics=2\")",
+			SEARCH_INDEX_TABLE, strings.Join(searchIndexColumns, ", "))).Error
+		if err != nil {
+			return err
+		}
+
+		err = db.Exec(fmt.Sprintf("insert into %s(rowid, %s) select e.id, %s from entries e", SEARCH_INDEX_TABLE,
+			strings.Join(searchIndexColumns, ", "), strings.Join(searchIndexValues, ", "))).Error
+		if err != nil {
+			return err
+		}
+	}
+
+	// Triggers go along with a table when it is rebuilt by a migration
+	for _, trigger := range searchIndexTriggers {
+		err = db.Exec(fmt.Sprintf("create trigger if not exists %s %s on %s begin %s end", trigger.name,
+			trigger.event, trigger.table, trigger.body)).Error
+		if err != nil {
+			return fmt.Errorf("trigger %s - %s", trigger.name, err.Error())
+		}
+	}
+
+	return nil
+}
+
+// Search entries with a query, see ParseSearchQuery
+func SearchEntries(query string) (error, []Entry) {
+
+	var err error
+	var db *gorm.DB
+	var node *SearchQuery
+	var entries []Entry
+
+	err, node = ParseSearchQuery(query)
+	if err != nil {
+		return err, nil
+	}
+
+	err, db = openActiveDatabase()
+	if err != nil {
+		return err, nil
+	}
+
+	tx := db.Model(&Entry{})
+	if node != nil {
+		condition, args := node.sql()
+		tx = tx.Where(condition, args...)
+	}
+
+	res := tx.Order("entries.id asc").Find(&entries)
+	return res.Error, entries
+}
@@ -0,0 +1,249 @@
+// SSH keys - generation, parsing and loading into a running ssh-agent
+package varuh
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"math/big"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const SSH_KEY_DEFAULT_RSA_BITS = 4096
+
+// Private key section of an OpenSSH ed25519 key
+type openSSHEd25519Key struct {
+	Check1  uint32
+	Check2  uint32
+	Keytype string
+	Pub     []byte
+	Priv    []byte
+	Comment string
+}
+
+// Private key section of an OpenSSH RSA key
+type openSSHRSAKey struct {
+	Check1  uint32
+	Check2  uint32
+	Keytype string
+	N       *big.Int
+	E       *big.Int
+	D       *big.Int
+	Iqmp    *big.Int
+	P       *big.Int
+	Q       *big.Int
+	Comment string
+}
+
+// Outer structure of an OpenSSH private key
+type openSSHKey struct {
+	CipherName   string
+	KdfName      string
+	KdfOpts      string
+	NumKeys      uint32
+	PubKey       []byte
+	PrivKeyBlock []byte
+}
+
+// Encode an ed25519 or RSA private key in the OpenSSH format without
+// encryption, as written by ssh-keygen -N ""
+func marshalOpenSSHPrivateKey(key interface{}, comment string) (error, []byte) {
+
+	var err error
+	var signer ssh.Signer
+	var check [4]byte
+	var block []byte
+
+	signer, err = ssh.NewSignerFromKey(key)
+	if err != nil {
+		return err, nil
+	}
+
+	if _, err = rand.Read(check[:]); err != nil {
+		return err, nil
+	}
+	checkInt := binary.BigEndian.Uint32(check[:])
+
+	switch k := key.(type) {
+	case ed25519.PrivateKey:
+		block = ssh.Marshal(openSSHEd25519Key{Check1: checkInt, Check2: checkInt, Keytype: ssh.KeyAlgoED25519,
+			Pub: []byte(k.Public().(ed25519.PublicKey)), Priv: []byte(k), Comment: comment})
+	case *rsa.PrivateKey:
+		k.Precompute()
+		block = ssh.Marshal(openSSHRSAKey{Check1: checkInt, Check2: checkInt, Keytype: ssh.KeyAlgoRSA,
+			N: k.N, E: big.NewInt(int64(k.E)), D: k.D, Iqmp: k.Precomputed.Qinv,
+			P: k.Primes[0], Q: k.Primes[1], Comment: comment})
+	default:
+		return fmt.Errorf("unsupported key type %T", key), nil
+	}
+
+	// Padded to the cipher block size, which is 8 for none
+	for i := byte(1); len(block)%8 != 0; i++ {
+		block = append(block, i)
+	}
+
+	data := append([]byte("openssh-key-v1\x00"), ssh.Marshal(openSSHKey{CipherName: "none", KdfName: "none",
+		NumKeys: 1, PubKey: signer.PublicKey().Marshal(), PrivKeyBlock: block})...)
+
+	return nil, pem.EncodeToMemory(&pem.Block{Type: "OPENSSH PRIVATE KEY", Bytes: data})
+}
+
+// Parse a key type such as ed25519, rsa or rsa-3072 into a type and
+// a size in bits, which is 0 for ed25519
+func parseSSHKeyType(keyType string) (error, string, int) {
+
+	keyType = strings.ToLower(strings.TrimSpace(keyType))
+
+	switch {
+	case keyType == "" || keyType == "ed25519":
+		return nil, "ed25519", 0
+	case keyType == "rsa":
+		return nil, "rsa", SSH_KEY_DEFAULT_RSA_BITS
+	case strings.HasPrefix(keyType, "rsa-"):
+		bits, err := strconv.Atoi(strings.TrimPrefix(keyType, "rsa-"))
+		if err != nil || (bits != 2048 && bits != 3072 && bits != 4096) {
+			return fmt.Errorf("invalid RSA key size in \"%s\" - should be 2048, 3072 or 4096", keyType), "", 0
+		}
+		return nil, "rsa", bits
+	}
+
+	return fmt.Errorf("unsupported key type \"%s\" - should be ed25519 or rsa", keyType), "", 0
+}
+
+// Generate a new key pair of the given type (ed25519, rsa, rsa-<bits>).
+// Returns the private key in OpenSSH format and the public key in
+// authorized_keys format without a comment.
+func GenerateSSHKey(keyType, comment string) (error, string, string) {
+
+	var err error
+	var key interface{}
+	var privateKey []byte
+	var publicKey ssh.PublicKey
+
+	err, keyType, bits := parseSSHKeyType(keyType)
+	if err != nil {
+		return err, "", ""
+	}
+
+	if keyType == "rsa" {
+		key, err = rsa.GenerateKey(rand.Reader, bits)
+	} else {
+		_, key, err = ed25519.GenerateKey(rand.Reader)
+	}
+	if err != nil {
+		return err, "", ""
+	}
+
+	err, privateKey = marshalOpenSSHPrivateKey(key, comment)
+	if err != nil {
+		return err, "", ""
+	}
+
+	err, publicKey, _ = ParseSSHPrivateKey(string(privateKey), "")
+	if err != nil {
+		return err, "", ""
+	}
+
+	return nil, string(privateKey), FormatSSHPublicKey(publicKey, "")
+}
+
+// Parse a private key, decrypting it with the passphrase if it is
+// encrypted. Returns its public key and the raw private key.
+func ParseSSHPrivateKey(privateKey, passphrase string) (error, ssh.PublicKey, interface{}) {
+
+	var err error
+	var key interface{}
+	var signer ssh.Signer
+
+	if passphrase != "" {
+		key, err = ssh.ParseRawPrivateKeyWithPassphrase([]byte(privateKey), []byte(passphrase))
+	} else {
+		key, err = ssh.ParseRawPrivateKey([]byte(privateKey))
+	}
+
+	if err != nil {
+		if _, ok := err.(*ssh.PassphraseMissingError); ok {
+			return errors.New("private key is encrypted - passphrase needed"), nil, nil
+		}
+		return err, nil, nil
+	}
+
+	signer, err = ssh.NewSignerFromKey(key)
+	if err != nil {
+		return err, nil, nil
+	}
+
+	return nil, signer.PublicKey(), key
+}
+
+// Return true if a private key needs a passphrase
+func IsSSHKeyEncrypted(privateKey string) bool {
+
+	_, err := ssh.ParseRawPrivateKey([]byte(privateKey))
+	_, ok := err.(*ssh.PassphraseMissingError)
+	return ok
+}
+
+// Format a public key in authorized_keys format with a comment
+func FormatSSHPublicKey(publicKey ssh.PublicKey, comment string) string {
+	return strings.TrimSpace(strings.TrimSpace(string(ssh.MarshalAuthorizedKey(publicKey))) + " " + comment)
+}
+
+// Return the type and SHA256 fingerprint of a public key in authorized_keys format
+func SSHKeyFingerprint(publicKey string) (error, string, string) {
+
+	key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(publicKey))
+	if err != nil {
+		return err, "", ""
+	}
+
+	return nil, key.Type(), ssh.FingerprintSHA256(key)
+}
+
+// Load the key of an sshkey entry into the ssh-agent at SSH_AUTH_SOCK. The
+// agent forgets the key after lifetime, unless it is zero. The decrypted key
+// is only sent over the agent socket and never written to disk.
+func AddSSHKeyToAgent(entry *Entry, lifetime time.Duration) error {
+
+	var err error
+	var key interface{}
+	var conn net.Conn
+
+	if entry.Type != "sshkey" {
+		return fmt.Errorf("entry %d is not an SSH key", entry.ID)
+	}
+
+	socket := os.Getenv("SSH_AUTH_SOCK")
+	if socket == "" {
+		return errors.New("no ssh-agent running - SSH_AUTH_SOCK is not set")
+	}
+
+	err, _, key = ParseSSHPrivateKey(entry.PrivateKey, entry.Password)
+	if err != nil {
+		return err
+	}
+
+	conn, err = net.Dial("unix", socket)
+	if err != nil {
+		return fmt.Errorf("cannot connect to ssh-agent - %s", err.Error())
+	}
+	defer conn.Close()
+
+	comment := entry.User
+	if comment == "" {
+		comment = entry.Title
+	}
+
+	return agent.NewClient(conn).Add(agent.AddedKey{PrivateKey: key, Comment: comment,
+		LifetimeSecs: uint32(lifetime.Seconds())})
+}
@@ -0,0 +1,299 @@
+// Normalized tags - a tags table linked to entries
+package varuh
+
+import (
+	"errors"
+	"fmt"
+	"gorm.io/gorm"
+	"strings"
+	"time"
+)
+
+// Structure representing a tag in the db
+type Tag struct {
+	ID   int    `gorm:"column:id;autoIncrement;primaryKey"`
+	Name string `gorm:"column:name;uniqueIndex"`
+}
+
+func (t *Tag) TableName() string {
+	return "tags"
+}
+
+// Link between an entry and one of its tags
+type EntryTag struct {
+	EntryID int `gorm:"column:entry_id;primaryKey"`
+	TagID   int `gorm:"column:tag_id;primaryKey;index"`
+}
+
+func (et *EntryTag) TableName() string {
+	return "entry_tags"
+}
+
+// A tag and the number of entries having it
+type TagCount struct {
+	Name  string
+	Count int
+}
+
+// Split a tags string into tags. Tags are separated by spaces or commas
+// and compared without case, the first spelling is kept.
+func ParseTags(tags string) []string {
+
+	var names []string
+
+	seen := make(map[string]bool)
+
+	for _, name := range strings.FieldsFunc(tags, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t' || r == '\n'
+	}) {
+		if key := strings.ToLower(name); !seen[key] {
+			seen[key] = true
+			names = append(names, name)
+		}
+	}
+
+	return names
+}
+
+// Find a tag by name ignoring case, creating it if needed
+func findOrCreateTag(db *gorm.DB, name string) (error, *Tag) {
+
+	var tag Tag
+
+	res := db.Where("lower(name) = lower(?)", name).Limit(1).Find(&tag)
+	if res.Error != nil {
+		return res.Error, nil
+	}
+
+	if res.RowsAffected == 0 {
+		tag = Tag{Name: name}
+		if res = db.Create(&tag); res.Error != nil {
+			return res.Error, nil
+		}
+	}
+
+	return nil, &tag
+}
+
+// Drop tags no longer linked to any entry
+func removeOrphanTags(db *gorm.DB) error {
+	return db.Where("id not in (?)", db.Model(&EntryTag{}).Select("tag_id")).Delete(&Tag{}).Error
+}
+
+// Rebuild the tag links of an entry from its tags string
+func syncEntryTags(db *gorm.DB, entryID int) error {
+
+	var entry Entry
+
+	res := db.Unscoped().Select("id", "tags").Limit(1).Find(&entry, entryID)
+	if res.Error != nil {
+		return res.Error
+	}
+
+	if err := db.Where("entry_id = ?", entryID).Delete(&EntryTag{}).Error; err != nil {
+		return err
+	}
+
+	if res.RowsAffected > 0 {
+		for _, name := range ParseTags(entry.Tags) {
+			err, tag := findOrCreateTag(db, name)
+			if err != nil {
+				return err
+			}
+
+			if err = db.Create(&EntryTag{EntryID: entryID, TagID: tag.ID}).Error; err != nil {
+				return err
+			}
+		}
+	}
+
+	return removeOrphanTags(db)
+}
+
+// Update the tag links of an entry after its tags have changed
+func updateEntryTags(db *gorm.DB, entryID int) {
+
+	if err := syncEntryTags(db, entryID); err != nil {
+		fmt.Printf("Error updating tags of entry %d - \"%s\"\n", entryID, err.Error())
+	}
+}
+
+// Build the tag links of all entries from their tags strings
+func SyncAllEntryTags(db *gorm.DB) error {
+
+	var ids []int
+
+	if res := db.Unscoped().Model(&Entry{}).Where("tags <> ''").Pluck("id", &ids); res.Error != nil {
+		return res.Error
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		for _, id := range ids {
+			if err := syncEntryTags(tx, id); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Return all tags with the number of entries having each, most used first.
+// Entries in the trash are not counted.
+func GetTagCounts() (error, []TagCount) {
+
+	var err error
+	var db *gorm.DB
+	var counts []TagCount
+
+	err, db = openActiveDatabase()
+	if err != nil {
+		return err, nil
+	}
+
+	res := db.Model(&Tag{}).Select("tags.name as name, count(entries.id) as count").
+		Joins("join entry_tags on entry_tags.tag_id = tags.id").
+		Joins("join entries on entries.id = entry_tags.entry_id and entries.deleted_at is null").
+		Group("tags.id").Order("count desc, tags.name asc").Scan(&counts)
+
+	return res.Error, counts
+}
+
+// Replace tags in a tags string with another, returning the new string
+func replaceTags(tags string, from []string, to string) string {
+
+	var names []string
+
+	replace := make(map[string]bool)
+	for _, name := range from {
+		replace[strings.ToLower(name)] = true
+	}
+
+	for _, name := range ParseTags(tags) {
+		if replace[strings.ToLower(name)] {
+			name = to
+		}
+		names = append(names, name)
+	}
+
+	// Merging may leave duplicates
+	return strings.Join(ParseTags(strings.Join(names, " ")), " ")
+}
+
+// Replace the tags from with the tag to in all entries, including those in
+// the trash. Each changed entry gets a revision. Returns the number changed.
+func retagEntries(from []string, to string) (error, int) {
+
+	var err error
+	var db *gorm.DB
+	var entries []Entry
+	var count int
+
+	err, db = openActiveDatabase()
+	if err != nil {
+		return err, 0
+	}
+
+	lowered := MapString(from, strings.ToLower)
+
+	res := db.Unscoped().Distinct("entries.*").
+		Joins("join entry_tags on entry_tags.entry_id = entries.id").
+		Joins("join tags on tags.id = entry_tags.tag_id").
+		Where("lower(tags.name) in ?", lowered).Find(&entries)
+	if res.Error != nil {
+		return res.Error, 0
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		for idx := range entries {
+			entry := &entries[idx]
+			updateMap := map[string]interface{}{"tags": replaceTags(entry.Tags, from, to)}
+
+			if err := RecordRevision(tx, entry, updateMap, nil, false); err != nil {
+				return err
+			}
+
+			updateMap["timestamp"] = time.Now()
+			if err := tx.Unscoped().Model(entry).Updates(updateMap).Error; err != nil {
+				return err
+			}
+
+			if err := syncEntryTags(tx, entry.ID); err != nil {
+				return err
+			}
+			count++
+		}
+
+		// The new spelling wins even if only the case changed
+		return tx.Model(&Tag{}).Where("lower(name) = lower(?)", to).Update("name", to).Error
+	})
+
+	if err != nil {
+		return err, 0
+	}
+
+	return nil, count
+}
+
+// Return true if a tag with the name exists, ignoring case
+func tagExists(db *gorm.DB, name string) bool {
+
+	var count int64
+
+	db.Model(&Tag{}).Where("lower(name) = lower(?)", name).Count(&count)
+	return count > 0
+}
+
+// Rename a tag in all entries. Returns the number of entries changed.
+func RenameTag(oldName, newName string) (error, int) {
+
+	var err error
+	var db *gorm.DB
+
+	if len(ParseTags(newName)) != 1 || ParseTags(newName)[0] != newName {
+		return fmt.Errorf("invalid tag name \"%s\"", newName), 0
+	}
+
+	err, db = openActiveDatabase()
+	if err != nil {
+		return err, 0
+	}
+
+	if !tagExists(db, oldName) {
+		return fmt.Errorf("no such tag - %s", oldName), 0
+	}
+
+	if !strings.EqualFold(oldName, newName) && tagExists(db, newName) {
+		return fmt.Errorf("tag %s exists - use --tag-merge to merge tags", newName), 0
+	}
+
+	return retagEntries([]string{oldName}, newName)
+}
+
+// Merge tags into the target tag, which may be new or one of the tags.
+// Returns the number of entries changed.
+func MergeTags(tags []string, target string) (error, int) {
+
+	var err error
+	var db *gorm.DB
+
+	if len(tags) == 0 {
+		return errors.New("no tags to merge"), 0
+	}
+
+	if len(ParseTags(target)) != 1 || ParseTags(target)[0] != target {
+		return fmt.Errorf("invalid tag name \"%s\"", target), 0
+	}
+
+	err, db = openActiveDatabase()
+	if err != nil {
+		return err, 0
+	}
+
+	for _, name := range tags {
+		if !tagExists(db, name) {
+			return fmt.Errorf("no such tag - %s", name), 0
+		}
+	}
+
+	return retagEntries(tags, target)
+}
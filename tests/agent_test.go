@@ -0,0 +1,141 @@
+package tests
+
+import (
+	"bytes"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+	"varuh"
+)
+
+// Start an agent on a private socket for the test
+func startAgent(t *testing.T, timeout time.Duration) *varuh.UnlockAgent {
+	sockPath := filepath.Join(t.TempDir(), "agent.sock")
+	os.Setenv(varuh.AGENT_SOCKET_ENV, sockPath)
+	t.Cleanup(func() { os.Unsetenv(varuh.AGENT_SOCKET_ENV) })
+
+	listener, err := net.Listen("unix", sockPath)
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	agent := varuh.NewUnlockAgent(timeout)
+	go agent.Serve(listener)
+
+	return agent
+}
+
+// Encrypt some data and return the container with its derived key
+func unlockedContainer(t *testing.T, plainText []byte) ([]byte, []byte) {
+	err, encText := varuh.EncryptData(plainText, "password", nil, varuh.CIPHER_AES, nil)
+	if err != nil {
+		t.Fatalf("EncryptData() error = %v", err)
+	}
+
+	err, _, _, key := varuh.UnlockData(encText, "password", nil)
+	if err != nil {
+		t.Fatalf("UnlockData() error = %v", err)
+	}
+
+	return encText, key
+}
+
+func TestAgentNotRunning(t *testing.T) {
+	os.Setenv(varuh.AGENT_SOCKET_ENV, filepath.Join(t.TempDir(), "none.sock"))
+	defer os.Unsetenv(varuh.AGENT_SOCKET_ENV)
+
+	if varuh.IsAgentRunning() {
+		t.Error("IsAgentRunning() = true with no agent")
+	}
+
+	if err, _ := varuh.AgentDecrypt("/tmp/db", []byte("data")); err == nil || err == varuh.ErrAgentLocked {
+		t.Errorf("AgentDecrypt() with no agent error = %v", err)
+	}
+}
+
+func TestAgentDecryptEncrypt(t *testing.T) {
+	startAgent(t, time.Minute)
+	plainText := []byte("database content")
+	encText, key := unlockedContainer(t, plainText)
+
+	if !varuh.IsAgentRunning() {
+		t.Fatal("IsAgentRunning() = false")
+	}
+
+	if err, _ := varuh.AgentDecrypt("/tmp/db", encText); err != varuh.ErrAgentLocked {
+		t.Fatalf("AgentDecrypt() before add error = %v, want ErrAgentLocked", err)
+	}
+
+	if err := varuh.AgentAddKey("/tmp/db", key, encText); err != nil {
+		t.Fatalf("AgentAddKey() error = %v", err)
+	}
+
+	err, decrypted := varuh.AgentDecrypt("/tmp/db", encText)
+	if err != nil {
+		t.Fatalf("AgentDecrypt() error = %v", err)
+	}
+	if !bytes.Equal(decrypted, plainText) {
+		t.Error("AgentDecrypt() content mismatch")
+	}
+
+	// Other databases are not unlocked
+	if err, _ := varuh.AgentDecrypt("/tmp/other", encText); err != varuh.ErrAgentLocked {
+		t.Errorf("AgentDecrypt() on other path error = %v, want ErrAgentLocked", err)
+	}
+
+	err, newText := varuh.AgentEncrypt("/tmp/db", []byte("updated content"))
+	if err != nil {
+		t.Fatalf("AgentEncrypt() error = %v", err)
+	}
+
+	err, decrypted, _ = varuh.DecryptData(newText, "password", nil)
+	if err != nil || !bytes.Equal(decrypted, []byte("updated content")) {
+		t.Errorf("DecryptData() on agent encrypted data error = %v", err)
+	}
+}
+
+func TestAgentRejectsWrongKey(t *testing.T) {
+	startAgent(t, time.Minute)
+	encText, key := unlockedContainer(t, []byte("content"))
+
+	key[0] ^= 0xff
+	if err := varuh.AgentAddKey("/tmp/db", key, encText); err == nil {
+		t.Error("AgentAddKey() should reject a wrong key")
+	}
+}
+
+func TestAgentStaleKeyAfterRekey(t *testing.T) {
+	startAgent(t, time.Minute)
+	encText, key := unlockedContainer(t, []byte("content"))
+
+	varuh.AgentAddKey("/tmp/db", key, encText)
+
+	// Password changed elsewhere - new salt
+	_, rekeyed := varuh.RekeyData(encText, "password", "newpassword", nil, varuh.CIPHER_UNKNOWN, nil)
+
+	if err, _ := varuh.AgentDecrypt("/tmp/db", rekeyed); err != varuh.ErrAgentLocked {
+		t.Errorf("AgentDecrypt() after rekey error = %v, want ErrAgentLocked", err)
+	}
+}
+
+func TestAgentLockAndTimeout(t *testing.T) {
+	startAgent(t, 200*time.Millisecond)
+	encText, key := unlockedContainer(t, []byte("content"))
+
+	varuh.AgentAddKey("/tmp/db", key, encText)
+	if err := varuh.AgentLock(); err != nil {
+		t.Fatalf("AgentLock() error = %v", err)
+	}
+	if err, _ := varuh.AgentDecrypt("/tmp/db", encText); err != varuh.ErrAgentLocked {
+		t.Errorf("AgentDecrypt() after lock error = %v, want ErrAgentLocked", err)
+	}
+
+	varuh.AgentAddKey("/tmp/db", key, encText)
+	time.Sleep(400 * time.Millisecond)
+	if err, _ := varuh.AgentDecrypt("/tmp/db", encText); err != varuh.ErrAgentLocked {
+		t.Errorf("AgentDecrypt() after idle timeout error = %v, want ErrAgentLocked", err)
+	}
+}
@@ -0,0 +1,229 @@
+package tests
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"varuh"
+)
+
+// Create a small sqlite database file and return its contents
+func sqliteFileContents(t *testing.T) []byte {
+	dbPath := filepath.Join(t.TempDir(), "valid.db")
+	if err := createMockDb(dbPath); err != nil {
+		t.Fatalf("createMockDb() error = %v", err)
+	}
+
+	err, db := varuh.OpenDatabase(dbPath)
+	if err != nil {
+		t.Fatalf("OpenDatabase() error = %v", err)
+	}
+	if err = varuh.CreateNewEntry(db); err != nil {
+		t.Fatalf("CreateNewEntry() error = %v", err)
+	}
+	sqlDB, _ := db.DB()
+	sqlDB.Close()
+
+	contents, err := os.ReadFile(dbPath)
+	if err != nil {
+		t.Fatalf("ReadFile() error = %v", err)
+	}
+
+	return contents
+}
+
+func TestWriteFileAtomic(t *testing.T) {
+	tempDir := t.TempDir()
+	testFile := filepath.Join(tempDir, "test.db")
+
+	if err := os.WriteFile(testFile, []byte("old contents"), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	if err := varuh.WriteFileAtomic(testFile, []byte("new contents"), 0600); err != nil {
+		t.Fatalf("WriteFileAtomic() error = %v", err)
+	}
+
+	contents, _ := os.ReadFile(testFile)
+	if string(contents) != "new contents" {
+		t.Errorf("WriteFileAtomic() contents = %q", contents)
+	}
+
+	info, _ := os.Stat(testFile)
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("WriteFileAtomic() mode = %o, want 600", info.Mode().Perm())
+	}
+
+	// No temp files are left behind
+	files, _ := os.ReadDir(tempDir)
+	if len(files) != 1 {
+		t.Errorf("WriteFileAtomic() left %d files in directory", len(files))
+	}
+}
+
+func TestWriteFileAtomicThroughSymlink(t *testing.T) {
+	tempDir := t.TempDir()
+	target := filepath.Join(tempDir, "target.db")
+	link := filepath.Join(tempDir, "link.db")
+
+	os.WriteFile(target, []byte("old"), 0600)
+	if err := os.Symlink(target, link); err != nil {
+		t.Skip("symlinks not supported")
+	}
+
+	if err := varuh.WriteFileAtomic(link, []byte("new"), 0600); err != nil {
+		t.Fatalf("WriteFileAtomic() error = %v", err)
+	}
+
+	if info, _ := os.Lstat(link); info.Mode()&os.ModeSymlink == 0 {
+		t.Error("WriteFileAtomic() replaced the symlink")
+	}
+
+	if contents, _ := os.ReadFile(target); string(contents) != "new" {
+		t.Errorf("WriteFileAtomic() target contents = %q", contents)
+	}
+}
+
+func TestAtomicFileAbort(t *testing.T) {
+	tempDir := t.TempDir()
+	testFile := filepath.Join(tempDir, "export.csv")
+
+	os.WriteFile(testFile, []byte("original"), 0600)
+
+	err, af := varuh.CreateAtomicFile(testFile, 0600)
+	if err != nil {
+		t.Fatalf("CreateAtomicFile() error = %v", err)
+	}
+
+	af.Write([]byte("partial"))
+	af.Abort()
+
+	if contents, _ := os.ReadFile(testFile); string(contents) != "original" {
+		t.Errorf("Abort() changed target to %q", contents)
+	}
+
+	files, _ := os.ReadDir(tempDir)
+	if len(files) != 1 {
+		t.Errorf("Abort() left %d files in directory", len(files))
+	}
+}
+
+func TestIsValidDatabaseFile(t *testing.T) {
+	tempDir := t.TempDir()
+	valid := sqliteFileContents(t)
+
+	err, encText := varuh.EncryptData(valid, "password", nil, varuh.CIPHER_AES, nil)
+	if err != nil {
+		t.Fatalf("EncryptData() error = %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		contents []byte
+		want     bool
+	}{
+		{"sqlite database", valid, true},
+		{"truncated sqlite database", valid[:len(valid)-100], false},
+		{"encrypted database", encText, true},
+		{"truncated encrypted database", encText[:50], false},
+		{"encrypted database without its last bytes", encText[:len(encText)-100], false},
+		{"empty file", []byte{}, false},
+		{"garbage", []byte("not a database at all"), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(tempDir, "check.db")
+			os.WriteFile(path, tt.contents, 0600)
+
+			if got := varuh.IsValidDatabaseFile(path); got != tt.want {
+				t.Errorf("IsValidDatabaseFile() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRecoverTempFiles(t *testing.T) {
+	valid := sqliteFileContents(t)
+
+	t.Run("intact database - leftovers removed", func(t *testing.T) {
+		tempDir := t.TempDir()
+		dbPath := filepath.Join(tempDir, "vault.db")
+		leftover := filepath.Join(tempDir, ".vault.db"+varuh.TEMP_FILE_MARKER+"abcd")
+
+		os.WriteFile(dbPath, valid, 0600)
+		os.WriteFile(leftover, valid[:512], 0600)
+		os.WriteFile(dbPath+varuh.LEGACY_BACKUP_SUFFIX, valid, 0600)
+
+		err, recovered := varuh.RecoverTempFiles(dbPath)
+		if err != nil || recovered != "" {
+			t.Fatalf("RecoverTempFiles() = %v, %q", err, recovered)
+		}
+
+		for _, path := range []string{leftover, dbPath + varuh.LEGACY_BACKUP_SUFFIX} {
+			if _, err := os.Stat(path); !os.IsNotExist(err) {
+				t.Errorf("RecoverTempFiles() did not remove %s", path)
+			}
+		}
+
+		if contents, _ := os.ReadFile(dbPath); !bytes.Equal(contents, valid) {
+			t.Error("RecoverTempFiles() modified an intact database")
+		}
+	})
+
+	t.Run("truncated database - restored from temp", func(t *testing.T) {
+		tempDir := t.TempDir()
+		dbPath := filepath.Join(tempDir, "vault.db")
+		tmpFile := filepath.Join(tempDir, ".vault.db"+varuh.TEMP_FILE_MARKER+"abcd")
+
+		os.WriteFile(dbPath, valid[:300], 0600)
+		os.WriteFile(tmpFile, valid, 0600)
+
+		err, recovered := varuh.RecoverTempFiles(dbPath)
+		if err != nil || recovered != tmpFile {
+			t.Fatalf("RecoverTempFiles() = %v, %q", err, recovered)
+		}
+
+		if contents, _ := os.ReadFile(dbPath); !bytes.Equal(contents, valid) {
+			t.Error("RecoverTempFiles() did not restore the database")
+		}
+	})
+
+	t.Run("database in use - temp files kept", func(t *testing.T) {
+		tempDir := t.TempDir()
+		dbPath := filepath.Join(tempDir, "vault.db")
+		tmpFile := filepath.Join(tempDir, ".vault.db"+varuh.TEMP_FILE_MARKER+"abcd")
+
+		os.WriteFile(dbPath, valid, 0600)
+		os.WriteFile(tmpFile, valid[:512], 0600)
+
+		fh := holdLockFile(t, dbPath, os.Getpid()+1)
+		defer fh.Close()
+
+		if err, recovered := varuh.RecoverTempFiles(dbPath); err != nil || recovered != "" {
+			t.Fatalf("RecoverTempFiles() = %v, %q", err, recovered)
+		}
+		if _, err := os.Stat(tmpFile); err != nil {
+			t.Error("RecoverTempFiles() removed the temp file of a write in progress")
+		}
+	})
+
+	t.Run("nothing usable", func(t *testing.T) {
+		tempDir := t.TempDir()
+		dbPath := filepath.Join(tempDir, "vault.db")
+		tmpFile := filepath.Join(tempDir, ".vault.db"+varuh.TEMP_FILE_MARKER+"abcd")
+
+		os.WriteFile(dbPath, valid[:300], 0600)
+		os.WriteFile(tmpFile, valid[:300], 0600)
+
+		if err, _ := varuh.RecoverTempFiles(dbPath); err == nil {
+			t.Error("RecoverTempFiles() expected error")
+		}
+
+		// Kept for manual inspection
+		if _, err := os.Stat(tmpFile); err != nil {
+			t.Error("RecoverTempFiles() removed the only copy")
+		}
+	})
+}
@@ -0,0 +1,121 @@
+package tests
+
+import (
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"varuh"
+)
+
+// Build the varuh command and return a function running it with a config
+// of its own, using dbPath as the active database
+func buildCli(t *testing.T, dbPath string) func(args ...string) string {
+	dir := t.TempDir()
+	binary := filepath.Join(dir, "varuh")
+
+	if out, err := exec.Command("go", "build", "-o", binary, "../scripts").CombinedOutput(); err != nil {
+		t.Fatalf("go build error = %v\n%s", err, out)
+	}
+
+	run := func(args ...string) string {
+		cmd := exec.Command(binary, args...)
+		cmd.Dir = dir
+		cmd.Env = append(os.Environ(), "XDG_CONFIG_HOME="+filepath.Join(dir, "config"))
+		out, _ := cmd.CombinedOutput()
+		return string(out)
+	}
+
+	// Write the default config, then make dbPath the active database
+	run("-p")
+	configFile := filepath.Join(dir, "config", varuh.APP, "config.json")
+	data, err := os.ReadFile(configFile)
+	if err != nil {
+		t.Fatalf("reading config error = %v", err)
+	}
+	var settings map[string]interface{}
+	json.Unmarshal(data, &settings)
+	settings["active_db"] = dbPath
+	settings["auto_encrypt"] = false
+	data, _ = json.Marshal(settings)
+	if err = os.WriteFile(configFile, data, 0600); err != nil {
+		t.Fatalf("writing config error = %v", err)
+	}
+
+	return run
+}
+
+func TestCliMultiValueOptions(t *testing.T) {
+	dbPath := useTestDatabase(t)
+	entry := addTestEntry(t, "Prod Database", "secret", nil)
+
+	run := buildCli(t, dbPath)
+	run("--mkdir", "work/prod")
+
+	if out := run("--mv", "1", "work/prod"); !strings.Contains(out, "Entry 1 moved to /work/prod") {
+		t.Errorf("varuh --mv 1 work/prod = %q", out)
+	}
+	_, entry = varuh.GetEntryById(entry.ID)
+	if got := varuh.GetGroupPath(entry.GroupID); got != "work/prod" {
+		t.Errorf("group after --mv = %q, want work/prod", got)
+	}
+
+	// The repeated form keeps working
+	if out := run("--mv", "1", "--mv", "/"); !strings.Contains(out, "Entry 1 moved to /") {
+		t.Errorf("varuh --mv 1 --mv / = %q", out)
+	}
+	_, entry = varuh.GetEntryById(entry.ID)
+	if entry.GroupID != 0 {
+		t.Errorf("group after --mv / = %d, want 0", entry.GroupID)
+	}
+}
+
+func TestCliTagOptions(t *testing.T) {
+	dbPath := useTestDatabase(t)
+	varuh.AddNewDatabaseEntry("Prod Database", "admin", "db.example.com", "secret", "db prod", "", "", nil)
+	varuh.AddNewDatabaseEntry("Staging Database", "admin", "staging.example.com", "secret", "db production", "", "", nil)
+
+	run := buildCli(t, dbPath)
+
+	if out := run("--tag-rename", "db", "database"); !strings.Contains(out, "Renamed tag db to database in 2 entries") {
+		t.Errorf("varuh --tag-rename db database = %q", out)
+	}
+	if out := run("--tag-merge", "prod", "production", "live"); !strings.Contains(out, "Merged tags prod, production into live in 2 entries") {
+		t.Errorf("varuh --tag-merge prod production live = %q", out)
+	}
+	for _, id := range []int{1, 2} {
+		if _, entry := varuh.GetEntryById(id); entry.Tags != "database live" {
+			t.Errorf("tags of entry %d = %q, want database live", id, entry.Tags)
+		}
+	}
+
+	out := run("-f", "tag:live", "staging")
+	if !strings.Contains(out, "Staging Database") || strings.Contains(out, "Prod Database") {
+		t.Errorf("varuh -f tag:live staging = %q", out)
+	}
+}
+
+func TestCliAttachmentOptions(t *testing.T) {
+	dbPath := useTestDatabase(t)
+	addTestEntry(t, "Server", "secret", nil)
+
+	run := buildCli(t, dbPath)
+
+	dir := t.TempDir()
+	keyFile := filepath.Join(dir, "id_ed25519")
+	os.WriteFile(keyFile, []byte("private key"), 0600)
+
+	if out := run("--attach", "1", keyFile); !strings.Contains(out, "Attached id_ed25519") {
+		t.Errorf("varuh --attach 1 %s = %q", keyFile, out)
+	}
+
+	outFile := filepath.Join(dir, "key")
+	if out := run("--extract", "1", "id_ed25519", "-o", outFile); !strings.Contains(out, "Extracted id_ed25519 to "+outFile) {
+		t.Errorf("varuh --extract 1 id_ed25519 -o %s = %q", outFile, out)
+	}
+	if data, err := os.ReadFile(outFile); err != nil || string(data) != "private key" {
+		t.Errorf("extracted file = %q, %v", data, err)
+	}
+}
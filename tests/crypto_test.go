@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 	"varuh"
 )
@@ -522,6 +523,162 @@ func TestGenerateStrongPassword(t *testing.T) {
 	}
 }
 
+func TestRekeyData(t *testing.T) {
+	plainText := []byte("database content to rekey")
+
+	err, encText := varuh.EncryptData(plainText, "oldpassword", nil, varuh.CIPHER_AES, nil)
+	if err != nil {
+		t.Fatalf("EncryptData() error = %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		oldPasswd  string
+		cipherId   uint8
+		wantErr    bool
+		wantCipher uint8
+	}{
+		{"keep cipher", "oldpassword", varuh.CIPHER_UNKNOWN, false, varuh.CIPHER_AES},
+		{"switch to xchacha", "oldpassword", varuh.CIPHER_XCHACHA, false, varuh.CIPHER_XCHACHA},
+		{"wrong old password", "wrongpassword", varuh.CIPHER_UNKNOWN, true, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err, newText := varuh.RekeyData(encText, tt.oldPasswd, "newpassword", nil, tt.cipherId, nil)
+
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("RekeyData() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			_, oldHeader, _ := varuh.ParseFileHeader(encText)
+			_, newHeader, _ := varuh.ParseFileHeader(newText)
+			if bytes.Equal(oldHeader.Salt, newHeader.Salt) {
+				t.Error("RekeyData() should use a fresh salt")
+			}
+			if newHeader.Cipher != tt.wantCipher {
+				t.Errorf("RekeyData() cipher = %d, want %d", newHeader.Cipher, tt.wantCipher)
+			}
+
+			if err, _, _ := varuh.DecryptData(newText, "oldpassword", nil); err == nil {
+				t.Error("Old password should no longer decrypt")
+			}
+
+			err, decrypted, _ := varuh.DecryptData(newText, "newpassword", nil)
+			if err != nil || !bytes.Equal(decrypted, plainText) {
+				t.Errorf("DecryptData() with new password failed - %v", err)
+			}
+		})
+	}
+}
+
+func TestRekeyFile(t *testing.T) {
+	tempDir := t.TempDir()
+	testFile := filepath.Join(tempDir, "test.db")
+	testContent := []byte("database content to rekey")
+
+	os.WriteFile(testFile, testContent, 0600)
+	varuh.EncryptFileAES(testFile, "oldpassword")
+
+	if err := varuh.RekeyFile(testFile, "oldpassword", "newpassword", nil, varuh.CIPHER_XCHACHA, nil); err != nil {
+		t.Fatalf("RekeyFile() error = %v", err)
+	}
+
+	if err := varuh.DecryptFile(testFile, "newpassword", nil); err != nil {
+		t.Fatalf("DecryptFile() with new password error = %v", err)
+	}
+
+	data, _ := os.ReadFile(testFile)
+	if !bytes.Equal(data, testContent) {
+		t.Error("Content mismatch after rekey")
+	}
+}
+
+func TestKeyFileCompositeKey(t *testing.T) {
+	tempDir := t.TempDir()
+	keyFile := filepath.Join(tempDir, "vault.key")
+	otherKeyFile := filepath.Join(tempDir, "other.key")
+	plainText := []byte("database content locked with a keyfile")
+
+	if err := varuh.CreateKeyFile(keyFile); err != nil {
+		t.Fatalf("CreateKeyFile() error = %v", err)
+	}
+	if err := varuh.CreateKeyFile(keyFile); err == nil {
+		t.Error("CreateKeyFile() should not overwrite an existing keyfile")
+	}
+	varuh.CreateKeyFile(otherKeyFile)
+
+	_, keyHash := varuh.HashKeyFile(keyFile)
+	_, otherHash := varuh.HashKeyFile(otherKeyFile)
+
+	err, encText := varuh.EncryptData(plainText, "password", keyHash, varuh.CIPHER_AES, nil)
+	if err != nil {
+		t.Fatalf("EncryptData() error = %v", err)
+	}
+
+	_, header, _ := varuh.ParseFileHeader(encText)
+	if !header.RequiresKeyFile() {
+		t.Error("Header should record that a keyfile is required")
+	}
+
+	tests := []struct {
+		name     string
+		password string
+		keyHash  []byte
+		wantErr  bool
+	}{
+		{"password and keyfile", "password", keyHash, false},
+		{"missing keyfile", "password", nil, true},
+		{"wrong keyfile", "password", otherHash, true},
+		{"wrong password", "wrongpassword", keyHash, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err, decrypted, _ := varuh.DecryptData(encText, tt.password, tt.keyHash)
+
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("DecryptData() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !bytes.Equal(decrypted, plainText) {
+				t.Error("DecryptData() content mismatch")
+			}
+		})
+	}
+
+	t.Run("missing keyfile error is clear", func(t *testing.T) {
+		err, _, _ := varuh.DecryptData(encText, "password", nil)
+		if err == nil || !strings.Contains(err.Error(), "keyfile") {
+			t.Errorf("DecryptData() error = %v, want keyfile error", err)
+		}
+	})
+
+	t.Run("keyfile given for password only database", func(t *testing.T) {
+		_, plainOnly := varuh.EncryptData(plainText, "password", nil, varuh.CIPHER_AES, nil)
+		if err, _, _ := varuh.DecryptData(plainOnly, "password", keyHash); err == nil {
+			t.Error("DecryptData() should reject an unexpected keyfile")
+		}
+	})
+}
+
+func TestGenerateKeyArgon2WithKeyFile(t *testing.T) {
+	salt := make([]byte, varuh.SALT_SIZE)
+
+	_, key1, _ := varuh.GenerateKeyArgon2("password", &salt)
+	_, key2, _ := varuh.GenerateKeyArgon2("password", &salt, []byte("keyfile hash"))
+	_, key3, _ := varuh.GenerateKeyArgon2("password", &salt, []byte("keyfile hash"))
+
+	if bytes.Equal(key1, key2) {
+		t.Error("GenerateKeyArgon2() composite key should differ from password-only key")
+	}
+	if !bytes.Equal(key2, key3) {
+		t.Error("GenerateKeyArgon2() composite key should be deterministic")
+	}
+}
+
 // Helper function to check if a string contains a character
 func containsChar(s string, char rune) bool {
 	for _, c := range s {
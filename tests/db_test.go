@@ -1,12 +1,18 @@
 package tests
 
 import (
+	"bytes"
 	"log"
+	"os"
 	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 	"gorm.io/gorm/logger"	
 	"testing"
+	"time"
 	"varuh"
 )
 
@@ -468,6 +474,62 @@ func TestIterateEntries(t *testing.T) {
 	}
 }
 
+func TestIterateEntriesOrder(t *testing.T) {
+	useTestDatabase(t)
+
+	addTestEntry(t, "beta", "secret", nil)
+	addTestEntry(t, "alpha", "secret", nil)
+
+	tests := []struct {
+		orderKey string
+		order    string
+		want     []int
+	}{
+		{"id", "asc", []int{1, 2}},
+		{"ID", "DESC", []int{2, 1}},
+		{"title", "asc", []int{2, 1}},
+		{"username", "", []int{1, 2}},
+		{"", "", []int{1, 2}},
+	}
+
+	for _, tt := range tests {
+		err, entries := varuh.IterateEntries(tt.orderKey, tt.order)
+		if err != nil {
+			t.Fatalf("IterateEntries(%q, %q) error = %v", tt.orderKey, tt.order, err)
+		}
+
+		var ids []int
+		for _, entry := range entries {
+			ids = append(ids, entry.ID)
+		}
+		if !reflect.DeepEqual(ids, tt.want) {
+			t.Errorf("IterateEntries(%q, %q) = %v, want %v", tt.orderKey, tt.order, ids, tt.want)
+		}
+	}
+
+	// Hostile list_order values are rejected before reaching the database
+	hostile := []struct {
+		orderKey string
+		order    string
+	}{
+		{"id; drop table entries; --", "asc"},
+		{"(select password from entries)", "asc"},
+		{"id", "asc; delete from entries"},
+		{"id asc, password", ""},
+		{"password", "asc"},
+	}
+
+	for _, tt := range hostile {
+		if err, _ := varuh.IterateEntries(tt.orderKey, tt.order); err == nil {
+			t.Errorf("IterateEntries(%q, %q) should fail", tt.orderKey, tt.order)
+		}
+	}
+
+	if _, entries := varuh.IterateEntries("id", "asc"); len(entries) != 2 {
+		t.Errorf("entries after hostile orders = %d, want 2", len(entries))
+	}
+}
+
 func TestEntriesToStringArray(t *testing.T) {
 	tests := []struct {
 		name           string
@@ -569,3 +631,462 @@ func BenchmarkExtendedEntry_Copy(b *testing.B) {
 		e1.Copy(e2)
 	}
 }
+
+func TestMemoryDatabaseRoundTrip(t *testing.T) {
+	err, db := varuh.OpenMemoryDatabase(nil)
+	if err != nil {
+		t.Fatalf("OpenMemoryDatabase() error = %v", err)
+	}
+
+	if err = varuh.CreateNewEntry(db); err != nil {
+		t.Fatalf("CreateNewEntry() error = %v", err)
+	}
+	db.Create(&varuh.Entry{Title: "In memory", Password: "secret"})
+
+	err, contents := varuh.SerializeDatabase(db)
+	if err != nil {
+		t.Fatalf("SerializeDatabase() error = %v", err)
+	}
+
+	// Loads back from the image
+	err, db2 := varuh.OpenMemoryDatabase(contents)
+	if err != nil {
+		t.Fatalf("OpenMemoryDatabase() from image error = %v", err)
+	}
+
+	var entry varuh.Entry
+	if err = db2.First(&entry).Error; err != nil || entry.Title != "In memory" {
+		t.Errorf("Entry not found in loaded database: %v %+v", err, entry)
+	}
+
+	// Unmodified database serializes to the same image
+	_, again := varuh.SerializeDatabase(db2)
+	if !bytes.Equal(again, contents) {
+		t.Error("SerializeDatabase() of unmodified database changed")
+	}
+}
+
+func TestOpenDatabaseMemoryHandle(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "vault.db")
+
+	// Only the encrypted file exists on disk
+	os.WriteFile(dbPath, []byte("encrypted"), 0600)
+
+	_, db := varuh.OpenMemoryDatabase(nil)
+	varuh.SetMemoryDatabase(dbPath, db)
+
+	err, handle := varuh.OpenDatabase(dbPath)
+	if err != nil || handle != db {
+		t.Errorf("OpenDatabase() should return the in-memory handle, got error %v", err)
+	}
+
+	varuh.SetMemoryDatabase(dbPath, nil)
+
+	if _, handle = varuh.OpenDatabase(dbPath); handle == db {
+		t.Error("OpenDatabase() returned in-memory handle after clearing")
+	}
+}
+
+// Point the config at a fresh database for the test, restoring the old one after
+func useTestDatabase(t *testing.T) string {
+	dbPath := filepath.Join(t.TempDir(), "test.db")
+	if err := createMockDb(dbPath); err != nil {
+		t.Fatalf("createMockDb() error = %v", err)
+	}
+
+	err, db := varuh.OpenDatabase(dbPath)
+	if err != nil {
+		t.Fatalf("OpenDatabase() error = %v", err)
+	}
+	varuh.CreateNewEntry(db)
+	varuh.CreateNewExEntry(db)
+
+	_, settings := varuh.GetOrCreateLocalConfig(varuh.APP)
+	oldActive := settings.ActiveDB
+
+	varuh.UpdateActiveDbPath(dbPath)
+	t.Cleanup(func() { varuh.UpdateActiveDbPath(oldActive) })
+
+	return dbPath
+}
+
+// Add an entry to the active database and return it
+func addTestEntry(t *testing.T, title, passwd string, customEntries []varuh.CustomEntry) *varuh.Entry {
+	if err := varuh.AddNewDatabaseEntry(title, "user", "http://example.com", passwd, "", "", "", customEntries); err != nil {
+		t.Fatalf("AddNewDatabaseEntry() error = %v", err)
+	}
+
+	err, entries := varuh.SearchDatabaseEntry(title)
+	if err != nil || len(entries) == 0 {
+		t.Fatalf("SearchDatabaseEntry() found no entry: %v", err)
+	}
+
+	return &entries[len(entries)-1]
+}
+
+func TestEntryRevisions(t *testing.T) {
+	useTestDatabase(t)
+
+	entry := addTestEntry(t, "Revisions", "first", []varuh.CustomEntry{{FieldName: "API Key", FieldValue: "key1"}, {FieldName: "Region", FieldValue: "eu"}})
+
+	// Rotate the password and replace the custom fields
+	err := varuh.UpdateDatabaseEntry(entry, "", "", "", "second", "", "", []varuh.CustomEntry{{FieldName: "API Key", FieldValue: "key2"}, {FieldName: "Zone", FieldValue: "a"}}, true)
+	if err != nil {
+		t.Fatalf("UpdateDatabaseEntry() error = %v", err)
+	}
+
+	// An update to the same value records nothing
+	_, entry = varuh.GetEntryById(entry.ID)
+	varuh.UpdateDatabaseEntry(entry, "", "", "", "second", "", "", nil, false)
+
+	err, revisions := varuh.GetEntryRevisions(entry)
+	if err != nil {
+		t.Fatalf("GetEntryRevisions() error = %v", err)
+	}
+
+	got := make(map[string]varuh.Revision)
+	for _, rev := range revisions {
+		if rev.Revision != 1 {
+			t.Errorf("unexpected revision %d", rev.Revision)
+		}
+		got[rev.FieldName] = rev
+	}
+
+	if len(got) != 4 {
+		t.Fatalf("GetEntryRevisions() = %d fields, want 4: %+v", len(got), revisions)
+	}
+	if got["password"].OldValue != "first" || got["password"].Custom {
+		t.Errorf("password revision = %+v", got["password"])
+	}
+	if got["API Key"].OldValue != "key1" || !got["API Key"].Custom {
+		t.Errorf("API Key revision = %+v", got["API Key"])
+	}
+	if got["Region"].OldValue != "eu" {
+		t.Errorf("deleted field revision = %+v", got["Region"])
+	}
+	if !got["Zone"].Absent {
+		t.Errorf("added field revision = %+v", got["Zone"])
+	}
+
+	// Roll back
+	if err = varuh.RestoreEntryToRevision(entry, 1); err != nil {
+		t.Fatalf("RestoreEntryToRevision() error = %v", err)
+	}
+
+	_, entry = varuh.GetEntryById(entry.ID)
+	if entry.Password != "first" {
+		t.Errorf("restored password = %q, want \"first\"", entry.Password)
+	}
+
+	custom := make(map[string]string)
+	for _, exEntry := range varuh.GetExtendedEntries(entry) {
+		custom[exEntry.FieldName] = exEntry.FieldValue
+	}
+	if len(custom) != 2 || custom["API Key"] != "key1" || custom["Region"] != "eu" {
+		t.Errorf("restored custom fields = %v", custom)
+	}
+
+	// The rollback is a revision of its own
+	_, revisions = varuh.GetEntryRevisions(entry)
+	if len(revisions) == 0 || revisions[0].Revision != 2 {
+		t.Errorf("rollback not recorded as revision 2")
+	}
+
+	if err = varuh.RestoreEntryToRevision(entry, 9); err == nil {
+		t.Error("RestoreEntryToRevision() of unknown revision should fail")
+	}
+}
+
+func TestTrashEntries(t *testing.T) {
+	useTestDatabase(t)
+
+	kept := addTestEntry(t, "Trash kept", "secret", nil)
+	removed := addTestEntry(t, "Trash removed", "secret", []varuh.CustomEntry{{FieldName: "Key", FieldValue: "value"}})
+
+	if err := varuh.RemoveDatabaseEntry(removed); err != nil {
+		t.Fatalf("RemoveDatabaseEntry() error = %v", err)
+	}
+
+	// Trashed entries are hidden from lookups, search, listing and export
+	if err, entry := varuh.GetEntryById(removed.ID); err == nil && entry != nil {
+		t.Error("GetEntryById() returned a trashed entry")
+	}
+
+	_, entries := varuh.SearchDatabaseEntry("Trash")
+	if len(entries) != 1 || entries[0].ID != kept.ID {
+		t.Errorf("SearchDatabaseEntry() = %+v, want only the kept entry", entries)
+	}
+
+	_, entries = varuh.IterateEntries("id", "asc")
+	if len(entries) != 1 {
+		t.Errorf("IterateEntries() = %d entries, want 1", len(entries))
+	}
+
+	_, records := varuh.EntriesToStringArray(false)
+	if len(records) != 1 {
+		t.Errorf("EntriesToStringArray() = %d records, want 1", len(records))
+	}
+
+	err, trashed := varuh.GetTrashedEntries()
+	if err != nil || len(trashed) != 1 || trashed[0].ID != removed.ID {
+		t.Fatalf("GetTrashedEntries() = %v, %+v", err, trashed)
+	}
+
+	// Restore
+	if err = varuh.RestoreTrashedEntry(removed.ID); err != nil {
+		t.Fatalf("RestoreTrashedEntry() error = %v", err)
+	}
+	if err, entry := varuh.GetEntryById(removed.ID); err != nil || entry == nil {
+		t.Error("GetEntryById() did not find the restored entry")
+	}
+	if len(varuh.GetExtendedEntries(removed)) != 1 {
+		t.Error("custom fields were not kept in the trash")
+	}
+	if err = varuh.RestoreTrashedEntry(removed.ID); err == nil {
+		t.Error("RestoreTrashedEntry() of an entry not in the trash should fail")
+	}
+
+	// Purge
+	varuh.RemoveDatabaseEntry(removed)
+
+	if _, count := varuh.PurgeTrashedEntries(time.Hour); count != 0 {
+		t.Errorf("PurgeTrashedEntries(1h) purged %d fresh entries", count)
+	}
+
+	if err, count := varuh.PurgeTrashedEntries(0); err != nil || count != 1 {
+		t.Fatalf("PurgeTrashedEntries() = %v, %d", err, count)
+	}
+
+	if _, trashed = varuh.GetTrashedEntries(); len(trashed) != 0 {
+		t.Error("trash not empty after purge")
+	}
+	if len(varuh.GetExtendedEntries(removed)) != 0 {
+		t.Error("custom fields not purged")
+	}
+}
+
+func TestEntryGroups(t *testing.T) {
+	useTestDatabase(t)
+
+	err, group, created := varuh.MakeGroupPath("work/prod")
+	if err != nil || !created {
+		t.Fatalf("MakeGroupPath() = %v, %v", err, created)
+	}
+
+	// Existing path is not created again
+	if err, again, created := varuh.MakeGroupPath("/work/prod/"); err != nil || created || again.ID != group.ID {
+		t.Errorf("MakeGroupPath() of existing path = %v, %+v, %v", err, again, created)
+	}
+
+	for _, path := range []string{"", "/", "work/../prod"} {
+		if err, _, _ := varuh.MakeGroupPath(path); err == nil {
+			t.Errorf("MakeGroupPath(%q) expected error", path)
+		}
+	}
+
+	if got := varuh.GetGroupPath(group.ID); got != "work/prod" {
+		t.Errorf("GetGroupPath() = %q, want work/prod", got)
+	}
+
+	top := addTestEntry(t, "Group top", "secret", nil)
+	work := addTestEntry(t, "Group work", "secret", nil)
+	prod := addTestEntry(t, "Group prod", "secret", nil)
+
+	if err = varuh.MoveEntryToGroup(work, "work"); err != nil {
+		t.Fatalf("MoveEntryToGroup() error = %v", err)
+	}
+	if err = varuh.MoveEntryToGroup(prod, "work/prod"); err != nil {
+		t.Fatalf("MoveEntryToGroup() error = %v", err)
+	}
+	if err = varuh.MoveEntryToGroup(top, "missing"); err == nil {
+		t.Error("MoveEntryToGroup() to a missing group should fail")
+	}
+
+	if _, entry := varuh.GetEntryById(prod.ID); entry == nil || entry.GroupID != group.ID {
+		t.Errorf("entry group = %+v, want %d", entry, group.ID)
+	}
+
+	// Subtree of work has both groups
+	err, ids := varuh.GetSubtreeGroupIds("work")
+	if err != nil || len(ids) != 2 {
+		t.Fatalf("GetSubtreeGroupIds() = %v, %v", err, ids)
+	}
+
+	_, entries := varuh.SearchDatabaseEntry("Group")
+	if got := varuh.FilterEntriesByGroups(entries, ids); len(got) != 2 {
+		t.Errorf("FilterEntriesByGroups() = %d entries, want 2", len(got))
+	}
+
+	err, tree := varuh.GetGroupTree("work")
+	if err != nil {
+		t.Fatalf("GetGroupTree() error = %v", err)
+	}
+	if len(tree.Entries) != 1 || tree.Entries[0].ID != work.ID {
+		t.Errorf("GetGroupTree() entries = %+v", tree.Entries)
+	}
+	if len(tree.Children) != 1 || tree.Children[0].Group.Name != "prod" || len(tree.Children[0].Entries) != 1 {
+		t.Errorf("GetGroupTree() children = %+v", tree.Children)
+	}
+
+	// Back to the top level
+	if err = varuh.MoveEntryToGroup(prod, "/"); err != nil || prod.GroupID != 0 {
+		t.Errorf("MoveEntryToGroup(/) = %v, group %d", err, prod.GroupID)
+	}
+}
+
+func TestGroupCycle(t *testing.T) {
+	dbPath := useTestDatabase(t)
+	varuh.MakeGroupPath("work/prod")
+
+	// A damaged database with a group which is its own parent. Stored
+	// with id 0, it sits below the top level as well.
+	_, db := varuh.OpenDatabase(dbPath)
+	if err := db.Exec("insert into groups(id, name, parent_id) values (0, 'loop', 0)").Error; err != nil {
+		t.Fatalf("insert error = %v", err)
+	}
+
+	err, ids := varuh.GetSubtreeGroupIds("/")
+	if err != nil || len(ids) != 3 {
+		t.Errorf("GetSubtreeGroupIds() with a cycle = %v, %v, want 3 groups", err, ids)
+	}
+}
+
+func TestIdentityEntry(t *testing.T) {
+	useTestDatabase(t)
+
+	entry := &varuh.Entry{Title: "Passport", FirstName: "Jane", LastName: "Doe", Email: "jane@example.com",
+		Class: "passport", Number: "Z1234567", Issuer: "Ministry", ExpiryDate: "2031-05-01", Tags: "travel"}
+
+	if err := varuh.AddNewDatabaseIdentityEntry(entry, nil); err != nil {
+		t.Fatalf("AddNewDatabaseIdentityEntry() error = %v", err)
+	}
+
+	_, found := varuh.GetEntryById(entry.ID)
+	if found.Type != "identity" || found.User != "Jane Doe" || found.Number != "Z1234567" {
+		t.Errorf("identity entry = %+v", found)
+	}
+
+	// Searchable by email and name
+	if _, entries := varuh.SearchDatabaseEntry("jane@example"); len(entries) != 1 {
+		t.Errorf("SearchDatabaseEntry(email) = %d entries", len(entries))
+	}
+
+	err := varuh.UpdateDatabaseIdentityEntry(found, map[string]string{"middle_name": "Q", "number": "Z7654321"}, nil, false)
+	if err != nil {
+		t.Fatalf("UpdateDatabaseIdentityEntry() error = %v", err)
+	}
+
+	_, found = varuh.GetEntryById(entry.ID)
+	if found.User != "Jane Q Doe" || found.Number != "Z7654321" {
+		t.Errorf("updated identity entry = %+v", found)
+	}
+
+	// Clone keeps the identity fields
+	err, clone := varuh.CloneEntry(found)
+	if err != nil || clone.Email != "jane@example.com" || clone.MiddleName != "Q" || clone.Type != "identity" {
+		t.Errorf("CloneEntry() = %v, %+v", err, clone)
+	}
+
+	// Exported with email as URL and document number as password
+	_, records := varuh.EntriesToStringArray(false)
+	for _, record := range records {
+		if record[0] == strconv.Itoa(entry.ID) {
+			if record[3] != "jane@example.com" || record[4] != "Z7654321" || !strings.Contains(record[5], "passport Ministry") {
+				t.Errorf("exported identity = %v", record)
+			}
+		}
+	}
+}
+
+func TestAddressEntry(t *testing.T) {
+	useTestDatabase(t)
+
+	address := &varuh.Address{Type: "Home", Number: "12", Street: "MG Road", Locality: "Whitefield",
+		City: "Bangalore", State: "Karnataka", ZipCode: "560066", Country: "India", Landmark: "Forum Mall"}
+
+	if err := varuh.AddNewDatabaseAddressEntry("Home address", "", "home", address, nil); err != nil {
+		t.Fatalf("AddNewDatabaseAddressEntry() error = %v", err)
+	}
+
+	// Found by address fields
+	_, entries := varuh.SearchDatabaseEntry("Whitefield")
+	if len(entries) != 1 || entries[0].Type != "address" {
+		t.Fatalf("SearchDatabaseEntry(locality) = %+v", entries)
+	}
+	entry := &entries[0]
+
+	addresses := varuh.GetAddresses(entry)
+	if len(addresses) != 1 || addresses[0].City != "Bangalore" {
+		t.Fatalf("GetAddresses() = %+v", addresses)
+	}
+
+	want := "12, MG Road, Whitefield, Bangalore, Karnataka 560066, India (near Forum Mall)"
+	if got := addresses[0].String(); got != want {
+		t.Errorf("Address.String() = %q, want %q", got, want)
+	}
+
+	addresses[0].City = "Bengaluru"
+	if err := varuh.UpdateAddress(&addresses[0]); err != nil {
+		t.Fatalf("UpdateAddress() error = %v", err)
+	}
+
+	// Cloned along with the entry
+	err, clone := varuh.CloneEntry(entry)
+	if err != nil {
+		t.Fatalf("CloneEntry() error = %v", err)
+	}
+	if err = varuh.CloneAddresses(clone, varuh.GetAddresses(entry)); err != nil {
+		t.Fatalf("CloneAddresses() error = %v", err)
+	}
+	if cloned := varuh.GetAddresses(clone); len(cloned) != 1 || cloned[0].City != "Bengaluru" || cloned[0].ID == addresses[0].ID {
+		t.Errorf("GetAddresses(clone) = %+v", cloned)
+	}
+
+	// Exported in the notes
+	_, records := varuh.EntriesToStringArray(false)
+	for _, record := range records {
+		if record[0] == strconv.Itoa(entry.ID) && !strings.Contains(record[5], "Address: 12, MG Road") {
+			t.Errorf("exported address = %v", record)
+		}
+	}
+
+	// An identity can have addresses too
+	identity := &varuh.Entry{Title: "Me", FirstName: "Jane"}
+	varuh.AddNewDatabaseIdentityEntry(identity, nil)
+	if err = varuh.AddEntryAddress(identity, &varuh.Address{Type: "Work", City: "Pune"}); err != nil {
+		t.Fatalf("AddEntryAddress() error = %v", err)
+	}
+	if _, entries = varuh.SearchDatabaseEntry("Pune"); len(entries) != 1 || entries[0].ID != identity.ID {
+		t.Errorf("SearchDatabaseEntry(city) = %+v", entries)
+	}
+}
+
+func TestNoteEntry(t *testing.T) {
+	useTestDatabase(t)
+
+	body := "abandon ability able\nabout above absent\n\n  indented line"
+	if err := varuh.AddNewDatabaseNoteEntry("Recovery phrase", body, "crypto", nil); err != nil {
+		t.Fatalf("AddNewDatabaseNoteEntry() error = %v", err)
+	}
+
+	// Found by words in the body
+	_, entries := varuh.SearchDatabaseEntry("absent")
+	if len(entries) != 1 || entries[0].Type != "note" || entries[0].Notes != body {
+		t.Fatalf("SearchDatabaseEntry(body) = %+v", entries)
+	}
+	entry := &entries[0]
+
+	err, clone := varuh.CloneEntry(entry)
+	if err != nil {
+		t.Fatalf("CloneEntry() error = %v", err)
+	}
+	if clone.Type != "note" || clone.Notes != body || clone.Tags != "crypto" {
+		t.Errorf("CloneEntry() = %+v", clone)
+	}
+
+	// Exported with the body as notes
+	_, records := varuh.EntriesToStringArray(false)
+	if len(records) != 2 || records[0][5] != body {
+		t.Errorf("EntriesToStringArray() = %v", records)
+	}
+}
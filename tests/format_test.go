@@ -0,0 +1,300 @@
+package tests
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha512"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+	"varuh"
+
+	chacha "golang.org/x/crypto/chacha20poly1305"
+)
+
+// Build a legacy (v0) container the way varuh did before the versioned header
+func legacyContainer(t *testing.T, plainText []byte, password string, useChacha bool) []byte {
+	err, key, salt := varuh.GenerateKeyArgon2(password, nil)
+	if err != nil {
+		t.Fatalf("Key derivation failed: %v", err)
+	}
+
+	var aead cipher.AEAD
+	if useChacha {
+		aead, err = chacha.NewX(key)
+	} else {
+		block, _ := aes.NewCipher(key)
+		aead, err = cipher.NewGCM(block)
+	}
+	if err != nil {
+		t.Fatalf("AEAD creation failed: %v", err)
+	}
+
+	_, nonce := varuh.GenerateRandomBytes(aead.NonceSize())
+	cipherText := aead.Seal(nonce, nonce, plainText, nil)
+
+	h := hmac.New(sha512.New, key)
+	h.Write(cipherText)
+
+	encText := []byte(fmt.Sprintf("%x", varuh.MAGIC_HEADER))
+	encText = append(encText, salt...)
+	encText = append(encText, h.Sum(nil)...)
+	return append(encText, cipherText...)
+}
+
+func TestFileHeaderRoundTrip(t *testing.T) {
+	for _, cipherId := range []uint8{varuh.CIPHER_AES, varuh.CIPHER_XCHACHA} {
+		err, header := varuh.NewFileHeader(cipherId, nil)
+		if err != nil {
+			t.Fatalf("NewFileHeader() error = %v", err)
+		}
+
+		data := append(header.Bytes(), make([]byte, varuh.HMAC_SHA512_SIZE)...)
+		err, parsed, rest := varuh.ParseFileHeader(data)
+		if err != nil {
+			t.Fatalf("ParseFileHeader() error = %v", err)
+		}
+
+		if parsed.Version != varuh.FORMAT_VERSION || parsed.Cipher != cipherId {
+			t.Errorf("ParseFileHeader() version/cipher = %d/%d, want %d/%d", parsed.Version, parsed.Cipher, varuh.FORMAT_VERSION, cipherId)
+		}
+		if parsed.Time != header.Time || parsed.Memory != header.Memory || parsed.Threads != header.Threads {
+			t.Errorf("ParseFileHeader() KDF params mismatch: got %+v want %+v", parsed, header)
+		}
+		if !bytes.Equal(parsed.Salt, header.Salt) {
+			t.Error("ParseFileHeader() salt mismatch")
+		}
+		if len(rest) != varuh.HMAC_SHA512_SIZE {
+			t.Errorf("ParseFileHeader() rest length = %d, want %d", len(rest), varuh.HMAC_SHA512_SIZE)
+		}
+	}
+}
+
+func TestParseFileHeaderInvalid(t *testing.T) {
+	magic := []byte(fmt.Sprintf("%x", varuh.MAGIC_HEADER))
+
+	// Parameters which would make argon2 allocate terabytes or spin up
+	// hundreds of threads
+	_, huge := varuh.NewFileHeader(varuh.CIPHER_AES, &varuh.KdfParams{Kdf: varuh.KDF_ARGON2ID, Time: 1,
+		Memory: 0xFFFFFFFF, Threads: 4})
+	_, threads := varuh.NewFileHeader(varuh.CIPHER_AES, &varuh.KdfParams{Kdf: varuh.KDF_ARGON2ID, Time: 1,
+		Memory: 1024, Threads: 255})
+	_, passes := varuh.NewFileHeader(varuh.CIPHER_AES, &varuh.KdfParams{Kdf: varuh.KDF_ARGON2ID, Time: 0xFFFFFFFF,
+		Memory: 1024, Threads: 4})
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", []byte{}},
+		{"bad magic", []byte("deadbeef and more data")},
+		{"truncated", append(magic, []byte("short")...)},
+		{"huge kdf memory", append(huge.Bytes(), make([]byte, varuh.HMAC_SHA512_SIZE)...)},
+		{"too many kdf threads", append(threads.Bytes(), make([]byte, varuh.HMAC_SHA512_SIZE)...)},
+		{"endless kdf passes", append(passes.Bytes(), make([]byte, varuh.HMAC_SHA512_SIZE)...)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err, _, _ := varuh.ParseFileHeader(tt.data)
+			if err == nil {
+				t.Error("ParseFileHeader() expected error")
+			}
+		})
+	}
+
+	// Key derivation refuses the same parameters in headers built in memory
+	for _, header := range []*varuh.FileHeader{huge, threads, passes} {
+		if err, _ := varuh.DeriveKey("password", nil, header); err == nil {
+			t.Errorf("DeriveKey() with %+v should fail", header.KdfParams())
+		}
+	}
+}
+
+func TestDecryptLegacyFormat(t *testing.T) {
+	plainText := []byte("legacy database content")
+
+	for _, useChacha := range []bool{false, true} {
+		encText := legacyContainer(t, plainText, "password", useChacha)
+
+		err, header, _ := varuh.ParseFileHeader(encText)
+		if err != nil {
+			t.Fatalf("ParseFileHeader() error = %v", err)
+		}
+		if header.Version != 0 {
+			t.Errorf("Legacy header version = %d, want 0", header.Version)
+		}
+
+		err, decrypted, header := varuh.DecryptData(encText, "password", nil)
+		if err != nil {
+			t.Fatalf("DecryptData() on legacy container error = %v", err)
+		}
+		if !bytes.Equal(decrypted, plainText) {
+			t.Error("DecryptData() legacy content mismatch")
+		}
+
+		want := varuh.CIPHER_AES
+		if useChacha {
+			want = varuh.CIPHER_XCHACHA
+		}
+		if header.Cipher != want {
+			t.Errorf("Detected cipher = %d, want %d", header.Cipher, want)
+		}
+	}
+}
+
+func TestDecryptIndependentOfCipherSetting(t *testing.T) {
+	tempDir := t.TempDir()
+	testFile := filepath.Join(tempDir, "test.db")
+	testContent := []byte("encrypted with xchacha")
+
+	os.WriteFile(testFile, testContent, 0600)
+
+	if err := varuh.EncryptFileXChachaPoly(testFile, "password"); err != nil {
+		t.Fatalf("EncryptFileXChachaPoly() error = %v", err)
+	}
+
+	// Cipher is read from the header, so the AES entry point works too
+	if err := varuh.DecryptFileAES(testFile, "password"); err != nil {
+		t.Fatalf("DecryptFileAES() on xchacha file error = %v", err)
+	}
+
+	data, _ := os.ReadFile(testFile)
+	if !bytes.Equal(data, testContent) {
+		t.Error("Decrypted content mismatch")
+	}
+}
+
+func TestUpgradeFileFormat(t *testing.T) {
+	tempDir := t.TempDir()
+	testFile := filepath.Join(tempDir, "legacy.db")
+	plainText := []byte("legacy database content")
+
+	os.WriteFile(testFile, legacyContainer(t, plainText, "password", true), 0600)
+
+	if err := varuh.UpgradeFileFormat(testFile, "password", nil); err != nil {
+		t.Fatalf("UpgradeFileFormat() error = %v", err)
+	}
+
+	err, header := varuh.ReadFileHeader(testFile)
+	if err != nil {
+		t.Fatalf("ReadFileHeader() error = %v", err)
+	}
+	if header.Version != varuh.FORMAT_VERSION || header.Cipher != varuh.CIPHER_XCHACHA {
+		t.Errorf("Upgraded header version/cipher = %d/%d", header.Version, header.Cipher)
+	}
+
+	if err := varuh.DecryptFile(testFile, "password", nil); err != nil {
+		t.Fatalf("DecryptFile() after upgrade error = %v", err)
+	}
+
+	data, _ := os.ReadFile(testFile)
+	if !bytes.Equal(data, plainText) {
+		t.Error("Content mismatch after upgrade")
+	}
+}
+
+func TestTamperedHeaderRejected(t *testing.T) {
+	err, encText := varuh.EncryptData([]byte("content"), "password", nil, varuh.CIPHER_AES, nil)
+	if err != nil {
+		t.Fatalf("EncryptData() error = %v", err)
+	}
+
+	// Flip the cipher id - header is authenticated
+	tampered := append([]byte{}, encText...)
+	tampered[8+len(varuh.FORMAT_MARKER)+1] = varuh.CIPHER_XCHACHA
+
+	if err, _, _ := varuh.DecryptData(tampered, "password", nil); err == nil {
+		t.Error("DecryptData() should reject a tampered header")
+	}
+}
+
+func TestKdfParamsInHeader(t *testing.T) {
+	plainText := []byte("argon2 parameters")
+
+	tests := []struct {
+		name   string
+		params *varuh.KdfParams
+	}{
+		{"argon2id", &varuh.KdfParams{Kdf: varuh.KDF_ARGON2ID, Time: 2, Memory: 16 * 1024, Threads: 2}},
+		{"argon2i", &varuh.KdfParams{Kdf: varuh.KDF_ARGON2I, Time: 1, Memory: 8 * 1024, Threads: 1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err, encText := varuh.EncryptData(plainText, "password", nil, varuh.CIPHER_XCHACHA, tt.params)
+			if err != nil {
+				t.Fatalf("EncryptData() error = %v", err)
+			}
+
+			err, header, _ := varuh.ParseFileHeader(encText)
+			if err != nil {
+				t.Fatalf("ParseFileHeader() error = %v", err)
+			}
+			if *header.KdfParams() != *tt.params {
+				t.Errorf("Header KDF params = %+v, want %+v", header.KdfParams(), tt.params)
+			}
+
+			err, decrypted, _ := varuh.DecryptData(encText, "password", nil)
+			if err != nil {
+				t.Fatalf("DecryptData() error = %v", err)
+			}
+			if !bytes.Equal(decrypted, plainText) {
+				t.Error("DecryptData() content mismatch")
+			}
+		})
+	}
+}
+
+func TestDefaultKdfIsArgon2id(t *testing.T) {
+	err, header := varuh.NewFileHeader(varuh.CIPHER_AES, nil)
+	if err != nil {
+		t.Fatalf("NewFileHeader() error = %v", err)
+	}
+	if header.Kdf != varuh.KDF_ARGON2ID {
+		t.Errorf("Default KDF = %s, want argon2id", varuh.KdfName(header.Kdf))
+	}
+}
+
+func TestRekeyUpgradesLegacyKdf(t *testing.T) {
+	encText := legacyContainer(t, []byte("legacy"), "password", false)
+
+	err, newText := varuh.RekeyData(encText, "password", "password", nil, varuh.CIPHER_UNKNOWN, varuh.DefaultKdfParams())
+	if err != nil {
+		t.Fatalf("RekeyData() error = %v", err)
+	}
+
+	_, header, _ := varuh.ParseFileHeader(newText)
+	if header.Kdf != varuh.KDF_ARGON2ID || header.Memory != varuh.DEFAULT_ARGON2_MEMORY {
+		t.Errorf("Rekeyed KDF params = %+v", header.KdfParams())
+	}
+}
+
+func TestCalibrateKdfParams(t *testing.T) {
+	err, params, elapsed := varuh.CalibrateKdfParams(50*time.Millisecond, 16*1024, 1)
+	if err != nil {
+		t.Fatalf("CalibrateKdfParams() error = %v", err)
+	}
+
+	if params.Kdf != varuh.KDF_ARGON2ID {
+		t.Errorf("CalibrateKdfParams() kdf = %d, want argon2id", params.Kdf)
+	}
+	if params.Time < 1 || params.Time > varuh.KDF_BENCH_MAX_TIME {
+		t.Errorf("CalibrateKdfParams() time = %d out of range", params.Time)
+	}
+	if params.Memory < varuh.KDF_BENCH_MIN_MEMORY || params.Memory > 16*1024 {
+		t.Errorf("CalibrateKdfParams() memory = %d out of range", params.Memory)
+	}
+	if elapsed <= 0 {
+		t.Error("CalibrateKdfParams() should report the measured time")
+	}
+
+	// A failed derivation is not timed as a result
+	if err, _, _ = varuh.CalibrateKdfParams(50*time.Millisecond, 16*1024, 0); err == nil {
+		t.Error("CalibrateKdfParams() with no threads should fail")
+	}
+}
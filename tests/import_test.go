@@ -0,0 +1,179 @@
+package tests
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"varuh"
+)
+
+// Write a CSV file to import
+func writeImportFile(t *testing.T, name, data string) string {
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	return path
+}
+
+// Titles of all entries in id order
+func entryTitles(t *testing.T) []string {
+	var titles []string
+
+	err, entries := varuh.IterateEntries("id", "asc")
+	if err != nil {
+		t.Fatalf("IterateEntries() error = %v", err)
+	}
+	for _, entry := range entries {
+		titles = append(titles, entry.Title)
+	}
+
+	return titles
+}
+
+func TestMapCSVColumns(t *testing.T) {
+	tests := []struct {
+		header  []string
+		mapping string
+		want    map[string]int
+	}{
+		{[]string{"ID", "Title", "User", "URL", "Password", "Notes", "Modified"}, "",
+			map[string]int{"title": 1, "user": 2, "url": 3, "password": 4, "notes": 5}},
+		{[]string{"folder", "favorite", "type", "name", "notes", "fields", "login_uri", "login_username", "login_password", "login_totp"}, "",
+			map[string]int{"group": 0, "title": 3, "notes": 4, "url": 6, "user": 7, "password": 8, "otp": 9}},
+		{[]string{"Site", "Email", "Username", "Secret"}, "title=site, password=Secret",
+			map[string]int{"title": 0, "user": 2, "password": 3}},
+		{[]string{"a", "b", "c"}, "title=2,user=3,url=1",
+			map[string]int{"title": 1, "user": 2, "url": 0}},
+	}
+
+	for _, tt := range tests {
+		err, columns := varuh.MapCSVColumns(tt.header, tt.mapping)
+		if err != nil {
+			t.Errorf("MapCSVColumns(%v, %q) error = %v", tt.header, tt.mapping, err)
+			continue
+		}
+		if !reflect.DeepEqual(columns, tt.want) {
+			t.Errorf("MapCSVColumns(%v, %q) = %v, want %v", tt.header, tt.mapping, columns, tt.want)
+		}
+	}
+
+	for _, mapping := range []string{"title", "color=a", "title=d", "title=0", "title=4"} {
+		if err, _ := varuh.MapCSVColumns([]string{"a", "b", "c"}, mapping); err == nil {
+			t.Errorf("MapCSVColumns() with mapping %q should fail", mapping)
+		}
+	}
+}
+
+func TestImportFromCSV(t *testing.T) {
+	useTestDatabase(t)
+
+	varuh.AddNewDatabaseEntry("GitHub", "alice", "https://github.com", "secret", "", "", "", nil)
+
+	data := "Name,Login,Website,Password,Tags,Folder,Security question\n" +
+		"GitHub,alice,https://github.com,other,,,\n" +
+		"GitLab,bob,https://gitlab.com,pass1,\"dev,prod\",work/git,Pet's name\n" +
+		",,,,,,\n" +
+		"gitlab,bob,https://gitlab.com,pass2,,,\n" +
+		"Mail,carol,https://mail.example.com,pass3,,,\n"
+	path := writeImportFile(t, "logins.csv", data)
+
+	// Nothing is written on a dry run
+	if err := varuh.ImportFromCSV(path, "", "", true); err != nil {
+		t.Fatalf("ImportFromCSV() dry run error = %v", err)
+	}
+	if got := entryTitles(t); !reflect.DeepEqual(got, []string{"GitHub"}) {
+		t.Fatalf("entries after dry run = %v", got)
+	}
+
+	if err := varuh.ImportFromCSV(path, "", "imported", false); err != nil {
+		t.Fatalf("ImportFromCSV() error = %v", err)
+	}
+
+	// Duplicates of existing entries and earlier rows are skipped
+	if got := entryTitles(t); !reflect.DeepEqual(got, []string{"GitHub", "GitLab", "Mail"}) {
+		t.Fatalf("entries after import = %v", got)
+	}
+
+	_, entry := varuh.GetEntryById(2)
+	if entry.User != "bob" || entry.Url != "https://gitlab.com" || entry.Password != "pass1" || entry.Tags != "dev prod" {
+		t.Errorf("imported entry = %+v", entry)
+	}
+	if got := varuh.GetGroupPath(entry.GroupID); got != "imported/work/git" {
+		t.Errorf("imported entry group = %s, want imported/work/git", got)
+	}
+
+	fields := varuh.GetExtendedEntries(entry)
+	if len(fields) != 1 || fields[0].FieldName != "Security question" || fields[0].FieldValue != "Pet's name" {
+		t.Errorf("imported custom fields = %+v", fields)
+	}
+
+	if got := searchIds(t, "tag:prod"); !reflect.DeepEqual(got, []int{2}) {
+		t.Errorf("search of imported tag = %v, want [2]", got)
+	}
+
+	// Importing again adds nothing
+	if err := varuh.ImportFromCSV(path, "", "imported", false); err != nil {
+		t.Fatalf("ImportFromCSV() again error = %v", err)
+	}
+	if got := entryTitles(t); len(got) != 3 {
+		t.Errorf("entries after second import = %v", got)
+	}
+}
+
+func TestImportFromCSVRollback(t *testing.T) {
+	useTestDatabase(t)
+
+	// The second row fails on its invalid group after the first was added
+	data := "title,user,group\nFirst,alice,\nSecond,bob,../escape\n"
+	path := writeImportFile(t, "bad.csv", data)
+
+	if err := varuh.ImportFromCSV(path, "", "", false); err == nil {
+		t.Fatalf("ImportFromCSV() with an invalid group should fail")
+	}
+	if got := entryTitles(t); got != nil {
+		t.Errorf("entries after failed import = %v, want none", got)
+	}
+
+	if err := varuh.ImportFromCSV(writeImportFile(t, "empty.csv", ""), "", "", false); err == nil {
+		t.Errorf("ImportFromCSV() of an empty file should fail")
+	}
+	if err := varuh.ImportFromCSV(writeImportFile(t, "untitled.csv", "a,b\n1,2\n"), "", "", false); err == nil {
+		t.Errorf("ImportFromCSV() without a title column should fail")
+	}
+}
+
+func TestImportExportRoundTrip(t *testing.T) {
+	useTestDatabase(t)
+
+	varuh.AddNewDatabaseEntry("GitHub", "alice", "https://github.com", "s3cr3t,\"quoted\"", "", "line one\nline two", "", nil)
+	varuh.AddNewDatabaseEntry("Mail", "bob", "https://mail.example.com", "pass", "", "", "", nil)
+
+	path := filepath.Join(t.TempDir(), "export.csv")
+	if err := varuh.ExportToCSV(path); err != nil {
+		t.Fatalf("ExportToCSV() error = %v", err)
+	}
+
+	_, before := varuh.IterateEntries("id", "asc")
+
+	useTestDatabase(t)
+	if err := varuh.ImportFromCSV(path, "", "", false); err != nil {
+		t.Fatalf("ImportFromCSV() error = %v", err)
+	}
+
+	_, after := varuh.IterateEntries("id", "asc")
+	if len(after) != len(before) {
+		t.Fatalf("imported %d entries, want %d", len(after), len(before))
+	}
+
+	for idx := range before {
+		b, a := before[idx], after[idx]
+		if a.Title != b.Title || a.User != b.User || a.Url != b.Url || a.Password != b.Password || a.Notes != b.Notes {
+			t.Errorf("round trip of %+v = %+v", b, a)
+		}
+		if fields := varuh.GetExtendedEntries(&a); len(fields) != 0 {
+			t.Errorf("round trip added custom fields %+v", fields)
+		}
+	}
+}
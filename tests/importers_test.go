@@ -0,0 +1,385 @@
+package tests
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"varuh"
+)
+
+// Custom fields of an entry as a map
+func entryFields(entry *varuh.Entry) map[string]string {
+	fields := make(map[string]string)
+	for _, field := range varuh.GetExtendedEntries(entry) {
+		fields[field.FieldName] = field.FieldValue
+	}
+	return fields
+}
+
+// Import a file, failing the test on errors, and return all entries
+func importFile(t *testing.T, path, format string) []varuh.Entry {
+	if err := varuh.ImportFromFormat(path, format, "", false); err != nil {
+		t.Fatalf("ImportFromFormat(%s) error = %v", path, err)
+	}
+
+	err, entries := varuh.IterateEntries("id", "asc")
+	if err != nil {
+		t.Fatalf("IterateEntries() error = %v", err)
+	}
+
+	return entries
+}
+
+func TestDetectImportFormat(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want string
+	}{
+		{"bw.json", `{"encrypted": false, "items": []}`, "bitwarden"},
+		{"bw.csv", "folder,favorite,type,name,notes,fields,reprompt,login_uri,login_username,login_password,login_totp\n", "bitwarden-csv"},
+		{"lp.csv", "url,username,password,totp,extra,name,grouping,fav\n", "lastpass"},
+		{"ff.csv", "\"url\",\"username\",\"password\",\"httpRealm\",\"formActionOrigin\",\"guid\"\n", "firefox"},
+		{"op.csv", "Title,Url,Username,Password,OTPAuth,Favorite,Archived,Tags,Notes\n", "1password-csv"},
+		{"chrome.csv", "name,url,username,password,note\n", "chrome"},
+		{"own.csv", "ID,Title,User,URL,Password,Notes,Modified\n", "csv"},
+		{"export.1pux", "", "1password"},
+		{"vault.kdbx", "", "keepass"},
+		{"own.json", `{"format": "varuh", "version": 1, "entries": []}`, "varuh"},
+	}
+
+	for _, tt := range tests {
+		err, format := varuh.DetectImportFormat(writeImportFile(t, tt.name, tt.data))
+		if err != nil || format != tt.want {
+			t.Errorf("DetectImportFormat(%s) = %v, %s, want %s", tt.name, err, format, tt.want)
+		}
+	}
+
+	for _, name := range []string{"other.json", "vault.txt"} {
+		if err, _ := varuh.DetectImportFormat(writeImportFile(t, name, `{"a": 1}`)); err == nil {
+			t.Errorf("DetectImportFormat(%s) should fail", name)
+		}
+	}
+}
+
+func TestImportBitwardenJSON(t *testing.T) {
+	useTestDatabase(t)
+
+	err, privateKey, publicKey := varuh.GenerateSSHKey("ed25519", "")
+	if err != nil {
+		t.Fatalf("GenerateSSHKey() error = %v", err)
+	}
+
+	export := map[string]interface{}{
+		"encrypted": false,
+		"folders":   []interface{}{map[string]string{"id": "f1", "name": "Work/Git"}},
+		"items": []interface{}{
+			map[string]interface{}{"type": 1, "name": "GitHub", "folderId": "f1", "favorite": true, "notes": "my account",
+				"login": map[string]interface{}{"username": "alice", "password": "s3cret", "totp": "JBSWY3DPEHPK3PXP",
+					"uris":             []interface{}{map[string]string{"uri": "https://github.com"}, map[string]string{"uri": "https://gist.github.com"}},
+					"fido2Credentials": []interface{}{map[string]string{"credentialId": "x"}}},
+				"fields": []interface{}{map[string]interface{}{"name": "Recovery", "value": "abcd", "type": 1},
+					map[string]interface{}{"name": "Linked", "value": nil, "type": 3}},
+				"passwordHistory": []interface{}{map[string]string{"password": "old"}}},
+			map[string]interface{}{"type": 2, "name": "Wifi", "notes": "the password is hunter2", "secureNote": map[string]int{"type": 0}},
+			map[string]interface{}{"type": 3, "name": "Travel card", "card": map[string]string{"cardholderName": "Alice",
+				"brand": "Visa", "number": "4111 1111 1111 1111", "expMonth": "7", "expYear": "2031", "code": "123"}},
+			map[string]interface{}{"type": 4, "name": "Passport", "identity": map[string]string{"firstName": "Alice",
+				"lastName": "Smith", "email": "alice@example.com", "passportNumber": "P1234", "ssn": "123-45-6789",
+				"address1": "1 Main St", "address2": "Apt 2", "city": "Springfield", "postalCode": "12345", "country": "US"}},
+			map[string]interface{}{"type": 5, "name": "Deploy key", "sshKey": map[string]string{"privateKey": privateKey,
+				"publicKey": publicKey + " deploy@ci"}},
+		},
+	}
+
+	data, _ := json.Marshal(export)
+	entries := importFile(t, writeImportFile(t, "bitwarden.json", string(data)), "")
+
+	if len(entries) != 5 {
+		t.Fatalf("imported %d entries, want 5", len(entries))
+	}
+
+	login := &entries[0]
+	if login.User != "alice" || login.Password != "s3cret" || login.Url != "https://github.com" ||
+		login.Tags != "favorite" || !strings.HasPrefix(login.Otp, "otpauth://totp/") {
+		t.Errorf("login = %+v", login)
+	}
+	if got := varuh.GetGroupPath(login.GroupID); got != "Work/Git" {
+		t.Errorf("login group = %s, want Work/Git", got)
+	}
+	if fields := entryFields(login); !reflect.DeepEqual(fields, map[string]string{"URL 2": "https://gist.github.com", "Recovery": "abcd"}) {
+		t.Errorf("login fields = %v", fields)
+	}
+
+	if note := entries[1]; note.Type != "note" || note.Notes != "the password is hunter2" {
+		t.Errorf("note = %+v", note)
+	}
+
+	card := entries[2]
+	if card.Type != "card" || card.User != "Alice" || card.Url != "4111111111111111" || card.Password != "123" ||
+		card.Class != "Visa" || card.ExpiryDate != "07/31" {
+		t.Errorf("card = %+v", card)
+	}
+
+	identity := &entries[3]
+	if identity.Type != "identity" || identity.User != "Alice Smith" || identity.Email != "alice@example.com" ||
+		identity.Class != "passport" || identity.Number != "P1234" {
+		t.Errorf("identity = %+v", identity)
+	}
+	if fields := entryFields(identity); fields["SSN"] != "123-45-6789" {
+		t.Errorf("identity fields = %v", fields)
+	}
+	if addresses := varuh.GetAddresses(identity); len(addresses) != 1 || addresses[0].Street != "1 Main St, Apt 2" || addresses[0].ZipCode != "12345" {
+		t.Errorf("identity addresses = %+v", addresses)
+	}
+
+	if key := entries[4]; key.Type != "sshkey" || key.PublicKey != publicKey || key.User != "deploy@ci" || key.Class != "ssh-ed25519" {
+		t.Errorf("ssh key = %+v", key)
+	}
+
+	// Encrypted exports are refused
+	path := writeImportFile(t, "encrypted.json", `{"encrypted": true, "items": []}`)
+	if err := varuh.ImportFromFormat(path, "", "", false); err == nil {
+		t.Errorf("ImportFromFormat() of an encrypted export should fail")
+	}
+}
+
+func TestImportBitwardenCSV(t *testing.T) {
+	useTestDatabase(t)
+
+	data := "folder,favorite,type,name,notes,fields,reprompt,login_uri,login_username,login_password,login_totp\n" +
+		"Social,1,login,Twitter,,\"PIN: 1234\nQuestion: blue\",0,\"https://twitter.com,https://x.com\",alice, pass ,\n" +
+		",,note,Recipe,\"flour\nsugar\",,0,,,,\n"
+	entries := importFile(t, writeImportFile(t, "bitwarden.csv", data), "")
+
+	if len(entries) != 2 {
+		t.Fatalf("imported %d entries, want 2", len(entries))
+	}
+	if login := &entries[0]; login.User != "alice" || login.Password != " pass " || login.Url != "https://twitter.com" ||
+		login.Tags != "favorite" || varuh.GetGroupPath(login.GroupID) != "Social" {
+		t.Errorf("login = %+v", login)
+	}
+	if fields := entryFields(&entries[0]); !reflect.DeepEqual(fields, map[string]string{"PIN": "1234", "Question": "blue", "URL 2": "https://x.com"}) {
+		t.Errorf("login fields = %v", fields)
+	}
+	if note := entries[1]; note.Type != "note" || note.Notes != "flour\nsugar" {
+		t.Errorf("note = %+v", note)
+	}
+}
+
+func TestImportLastPassCSV(t *testing.T) {
+	useTestDatabase(t)
+
+	data := "url,username,password,totp,extra,name,grouping,fav\n" +
+		"https://mail.example.com,bob,pw,,some notes,Mail,Personal\\Email,0\n" +
+		"http://sn,,,,just a note,Diary,,1\n" +
+		"http://sn,,,,\"NoteType:Credit Card\nLanguage:en-US\nName on Card:Bob\nType:Mastercard\nNumber:5555555555554444\n" +
+		"Security Code:321\nStart Date:,\nExpiration Date:March,2030\nNotes:line one\nline two\",Bank card,,0\n"
+	entries := importFile(t, writeImportFile(t, "lastpass.csv", data), "")
+
+	if len(entries) != 3 {
+		t.Fatalf("imported %d entries, want 3", len(entries))
+	}
+	if login := entries[0]; login.User != "bob" || login.Notes != "some notes" || varuh.GetGroupPath(login.GroupID) != "Personal/Email" {
+		t.Errorf("login = %+v", login)
+	}
+	if note := entries[1]; note.Type != "note" || note.Notes != "just a note" || note.Tags != "favorite" {
+		t.Errorf("note = %+v", note)
+	}
+
+	card := &entries[2]
+	if card.Type != "card" || card.User != "Bob" || card.Url != "5555555555554444" || card.Password != "321" ||
+		card.Class != "Mastercard" || card.ExpiryDate != "03/30" || card.Notes != "line one\nline two" {
+		t.Errorf("card = %+v", card)
+	}
+	if fields := entryFields(card); len(fields) != 0 {
+		t.Errorf("card fields = %v", fields)
+	}
+}
+
+func TestImportBrowserCSV(t *testing.T) {
+	useTestDatabase(t)
+
+	chrome := "name,url,username,password,note\nexample.com,https://example.com/login,carol,pw1,\n"
+	firefox := "\"url\",\"username\",\"password\",\"httpRealm\",\"formActionOrigin\",\"guid\",\"timeCreated\"\n" +
+		"\"https://www.example.org\",\"dave\",\"pw2\",\"\",\"https://www.example.org\",\"{1}\",\"1690000000000\"\n" +
+		"\"https://intranet.corp\",\"erin\",\"pw3\",\"Staff only\",\"\",\"{2}\",\"1690000000000\"\n"
+
+	importFile(t, writeImportFile(t, "chrome.csv", chrome), "")
+	entries := importFile(t, writeImportFile(t, "firefox.csv", firefox), "")
+
+	if got := []string{entries[0].Title, entries[1].Title, entries[2].Title}; !reflect.DeepEqual(got, []string{"example.com", "example.org", "intranet.corp"}) {
+		t.Errorf("titles = %v", got)
+	}
+	if fields := entryFields(&entries[1]); len(fields) != 0 {
+		t.Errorf("firefox fields = %v", fields)
+	}
+	if fields := entryFields(&entries[2]); !reflect.DeepEqual(fields, map[string]string{"HTTP Realm": "Staff only"}) {
+		t.Errorf("firefox fields = %v", fields)
+	}
+}
+
+func TestImport1PasswordCSV(t *testing.T) {
+	useTestDatabase(t)
+
+	data := "Title,Url,Username,Password,OTPAuth,Favorite,Archived,Tags,Notes\n" +
+		"Bank,https://bank.example.com,frank,pw,otpauth://totp/Bank?secret=JBSWY3DPEHPK3PXP,true,false,\"money,home stuff\",\n"
+	entries := importFile(t, writeImportFile(t, "1password.csv", data), "")
+
+	if len(entries) != 1 || entries[0].Tags != "money home-stuff favorite" || !strings.Contains(entries[0].Otp, "JBSWY3DPEHPK3PXP") {
+		t.Errorf("entries = %+v", entries)
+	}
+}
+
+// Write a 1PUX zip with the given export data and files
+func write1PUX(t *testing.T, exportData interface{}, files map[string]string) string {
+	path := filepath.Join(t.TempDir(), "export.1pux")
+
+	fh, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	defer fh.Close()
+
+	archive := zip.NewWriter(fh)
+	data, _ := json.Marshal(exportData)
+	files["export.data"] = string(data)
+
+	for name, contents := range files {
+		w, _ := archive.Create(name)
+		w.Write([]byte(contents))
+	}
+	archive.Close()
+
+	return path
+}
+
+func TestImport1PUX(t *testing.T) {
+	useTestDatabase(t)
+
+	field := func(id, title, kind string, value interface{}) map[string]interface{} {
+		return map[string]interface{}{"id": id, "title": title, "value": map[string]interface{}{kind: value}}
+	}
+
+	items := []interface{}{
+		map[string]interface{}{"categoryUuid": "001", "favIndex": 1, "state": "active",
+			"overview": map[string]interface{}{"title": "Dropbox", "url": "https://dropbox.com", "tags": []string{"cloud storage"},
+				"urls": []interface{}{map[string]string{"url": "https://dropbox.com"}, map[string]string{"url": "https://db.tt"}}},
+			"details": map[string]interface{}{
+				"loginFields": []interface{}{
+					map[string]string{"designation": "username", "value": "gina", "fieldType": "T", "name": "email"},
+					map[string]string{"designation": "password", "value": "pw", "fieldType": "P", "name": "password"},
+					map[string]string{"value": "✓", "fieldType": "C", "name": "remember"}},
+				"notesPlain": "work account",
+				"sections": []interface{}{map[string]interface{}{"title": "", "fields": []interface{}{
+					field("otp", "one-time password", "totp", "JBSWY3DPEHPK3PXP"),
+					field("q", "Security question", "concealed", "blue"),
+					field("ref", "Related", "reference", "abc")}}},
+				"passwordHistory": []interface{}{map[string]interface{}{"value": "old"}}}},
+		map[string]interface{}{"categoryUuid": "002", "state": "archived",
+			"overview": map[string]interface{}{"title": "Amex"},
+			"details": map[string]interface{}{"sections": []interface{}{map[string]interface{}{"fields": []interface{}{
+				field("cardholder", "cardholder name", "string", "Gina"),
+				field("ccnum", "number", "creditCardNumber", "378282246310005"),
+				field("cvv", "verification number", "concealed", "1234"),
+				field("expiry", "expiry date", "monthYear", 202904),
+				field("bank", "issuing bank", "string", "AmEx"),
+				field("scan", "card scan", "file", map[string]string{"fileName": "card.png", "documentId": "d2"})}}}}},
+		map[string]interface{}{"categoryUuid": "004",
+			"overview": map[string]interface{}{"title": "Me"},
+			"details": map[string]interface{}{"sections": []interface{}{map[string]interface{}{"fields": []interface{}{
+				field("firstname", "first name", "string", "Gina"),
+				field("lastname", "last name", "string", "Lee"),
+				field("email", "email", "email", map[string]string{"email_address": "gina@example.com"}),
+				field("address", "address", "address", map[string]string{"street": "2 High St", "city": "Leeds", "zip": "LS1"}),
+				field("birthdate", "birth date", "date", 631152000)}}}}},
+		map[string]interface{}{"categoryUuid": "006",
+			"overview": map[string]interface{}{"title": "Lease"},
+			"details":  map[string]interface{}{"documentAttributes": map[string]string{"fileName": "lease.pdf", "documentId": "d1"}}},
+	}
+
+	exportData := map[string]interface{}{"accounts": []interface{}{map[string]interface{}{
+		"attrs":  map[string]string{"accountName": "Gina"},
+		"vaults": []interface{}{map[string]interface{}{"attrs": map[string]string{"name": "Private"}, "items": items}}}}}
+
+	path := write1PUX(t, exportData, map[string]string{"files/d1__lease.pdf": "%PDF-1.4 lease"})
+	entries := importFile(t, path, "")
+
+	if len(entries) != 4 {
+		t.Fatalf("imported %d entries, want 4", len(entries))
+	}
+
+	login := &entries[0]
+	if login.User != "gina" || login.Password != "pw" || login.Tags != "cloud-storage favorite" ||
+		!strings.HasPrefix(login.Otp, "otpauth://") || varuh.GetGroupPath(login.GroupID) != "Private" {
+		t.Errorf("login = %+v", login)
+	}
+	if fields := entryFields(login); !reflect.DeepEqual(fields, map[string]string{"URL 2": "https://db.tt", "Security question": "blue"}) {
+		t.Errorf("login fields = %v", fields)
+	}
+
+	card := entries[1]
+	if card.Type != "card" || card.User != "Gina" || card.Url != "378282246310005" || card.Password != "1234" ||
+		card.ExpiryDate != "04/29" || card.Issuer != "AmEx" || card.Class == "" {
+		t.Errorf("card = %+v", card)
+	}
+
+	identity := &entries[2]
+	if identity.Type != "identity" || identity.User != "Gina Lee" || identity.Email != "gina@example.com" {
+		t.Errorf("identity = %+v", identity)
+	}
+	if fields := entryFields(identity); fields["birth date"] != "1990-01-01" {
+		t.Errorf("identity fields = %v", fields)
+	}
+	if addresses := varuh.GetAddresses(identity); len(addresses) != 1 || addresses[0].City != "Leeds" {
+		t.Errorf("identity addresses = %+v", addresses)
+	}
+
+	err, attachment := varuh.GetAttachment(&entries[3], "lease.pdf")
+	if err != nil || string(attachment.Data) != "%PDF-1.4 lease" || attachment.ContentType != "application/pdf" {
+		t.Errorf("document attachment = %v, %+v", err, attachment)
+	}
+}
+
+func TestImportAttachmentNames(t *testing.T) {
+	dbPath := useTestDatabase(t)
+
+	document := func(title, fileName, id string) map[string]interface{} {
+		return map[string]interface{}{"categoryUuid": "006", "overview": map[string]interface{}{"title": title},
+			"details": map[string]interface{}{"documentAttributes": map[string]string{"fileName": fileName, "documentId": id}}}
+	}
+	exportData := map[string]interface{}{"accounts": []interface{}{map[string]interface{}{
+		"vaults": []interface{}{map[string]interface{}{"items": []interface{}{
+			document("Dotfile", "../../.bashrc", "d1"), document("Parent", "..", "d2")}}}}}}
+
+	path := write1PUX(t, exportData, map[string]string{"files/d1__../../.bashrc": "echo pwned", "files/d2__..": "data"})
+	entries := importFile(t, path, "")
+
+	if err, attachments := varuh.GetAttachments(&entries[0]); err != nil || len(attachments) != 1 || attachments[0].Name != ".bashrc" {
+		t.Errorf("attachments of a path = %v, %+v", err, attachments)
+	}
+	if _, attachments := varuh.GetAttachments(&entries[1]); len(attachments) != 0 {
+		t.Errorf("attachments named .. = %+v", attachments)
+	}
+
+	// Extracting never follows a stored name out of the directory
+	_, db := varuh.OpenDatabase(dbPath)
+	db.Create(&varuh.Attachment{Name: "../escape", Data: []byte("data"), EntryID: entries[1].ID})
+
+	outDir := filepath.Join(t.TempDir(), "out")
+	os.Mkdir(outDir, 0700)
+	varuh.SetOutputPath(outDir)
+	defer varuh.SetOutputPath("")
+
+	if err := varuh.ExtractAttachment(fmt.Sprintf("%d ../escape", entries[1].ID)); err == nil {
+		t.Errorf("ExtractAttachment() of a path name should fail")
+	}
+	if _, err := os.Stat(filepath.Join(filepath.Dir(outDir), "escape")); err == nil {
+		t.Errorf("ExtractAttachment() wrote outside the output directory")
+	}
+}
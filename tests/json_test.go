@@ -0,0 +1,98 @@
+package tests
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"varuh"
+)
+
+func TestJSONRoundTrip(t *testing.T) {
+	dbPath := useTestDatabase(t)
+	addKDBXTestEntries(t)
+
+	// A content type which can't be guessed from the name or data again
+	_, db := varuh.OpenDatabase(dbPath)
+	db.Model(&varuh.Attachment{}).Where("name = ?", "recovery.txt").Update("content_type", "application/x-recovery-codes")
+
+	path := filepath.Join(t.TempDir(), "vault.json")
+	if err := varuh.ExportToJSON(path); err != nil {
+		t.Fatalf("ExportToJSON() error = %v", err)
+	}
+
+	useTestDatabase(t)
+	if err := varuh.ImportFromJSON(path, "", false); err != nil {
+		t.Fatalf("ImportFromJSON() error = %v", err)
+	}
+
+	_, github := varuh.GetEntryById(1)
+	if github.User != "alice" || github.Password != "s3cr3t <&>" || github.Notes != "line one\nline two" ||
+		github.Tags != "dev work" || github.Otp == "" || varuh.GetGroupPath(github.GroupID) != "work/git" {
+		t.Errorf("imported entry = %+v in %s", github, varuh.GetGroupPath(github.GroupID))
+	}
+	if fields := entryFields(github); len(fields) != 2 || fields["Password"] != "clashes with the standard field" {
+		t.Errorf("imported custom fields = %v", fields)
+	}
+	if err, attachment := varuh.GetAttachment(github, "recovery.txt"); err != nil || string(attachment.Data) != "recovery codes" ||
+		attachment.ContentType != "application/x-recovery-codes" {
+		t.Errorf("imported attachment = %v, %+v", err, attachment)
+	}
+
+	_, card := varuh.GetEntryById(2)
+	if card.Type != "card" || card.Url != "4111111111111111" || card.Password != "123" || card.Pin != "4321" ||
+		card.ExpiryDate != "08/29" || card.Issuer != "HDFC Bank" || card.Class != "VISA" {
+		t.Errorf("imported card = %+v", card)
+	}
+
+	_, passport := varuh.GetEntryById(3)
+	if passport.Type != "identity" || passport.FirstName != "Jane" || passport.LastName != "Doe" ||
+		passport.Number != "Z1234567" || passport.Class != "passport" || passport.ExpiryDate != "2031-05-01" {
+		t.Errorf("imported identity = %+v", passport)
+	}
+
+	_, home := varuh.GetEntryById(4)
+	if addresses := varuh.GetAddresses(home); len(addresses) != 1 || addresses[0].Street != "MG Road" ||
+		addresses[0].Type != "Home" {
+		t.Errorf("imported addresses = %+v", addresses)
+	}
+
+	// Exporting the imported entries gives the same entries back
+	again := filepath.Join(t.TempDir(), "again.json")
+	if err := varuh.ExportToJSON(again); err != nil {
+		t.Fatalf("ExportToJSON() error = %v", err)
+	}
+	first, _ := os.ReadFile(path)
+	second, _ := os.ReadFile(again)
+	entries := func(data []byte) string { return string(data[strings.Index(string(data), `"entries"`):]) }
+	if entries(first) != entries(second) {
+		t.Errorf("second export differs from the first:\n%s\n%s", first, second)
+	}
+
+	// Importing again finds only duplicates
+	if err := varuh.ImportFromJSON(path, "", false); err != nil {
+		t.Fatalf("ImportFromJSON() again error = %v", err)
+	}
+	if got := entryTitles(t); len(got) != 4 {
+		t.Errorf("entries after second import = %v", got)
+	}
+}
+
+func TestImportFromJSONErrors(t *testing.T) {
+	useTestDatabase(t)
+
+	tests := []struct {
+		name string
+		data string
+	}{
+		{"bad.json", "not json"},
+		{"bitwarden.json", `{"items": []}`},
+		{"newer.json", `{"format": "varuh", "version": 99, "entries": []}`},
+	}
+
+	for _, tt := range tests {
+		if err := varuh.ImportFromJSON(writeImportFile(t, tt.name, tt.data), "", false); err == nil {
+			t.Errorf("ImportFromJSON(%s) should fail", tt.name)
+		}
+	}
+}
@@ -0,0 +1,324 @@
+package tests
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"varuh"
+)
+
+// Cheap KDF parameters to keep the tests fast
+var testKdbxParams = &varuh.KdfParams{Kdf: varuh.KDF_ARGON2ID, Time: 1, Memory: 1024, Threads: 1}
+
+// Find an imported entry by title
+func importedEntry(t *testing.T, items []varuh.ImportEntry, title string) *varuh.ImportEntry {
+	for idx := range items {
+		if items[idx].Entry.Title == title {
+			return &items[idx]
+		}
+	}
+	t.Fatalf("no imported entry %s", title)
+	return nil
+}
+
+// Fill the active database with an entry of each type
+func addKDBXTestEntries(t *testing.T) {
+	customEntries := []varuh.CustomEntry{{FieldName: "Security question", FieldValue: "Pet's name"},
+		{FieldName: "Password", FieldValue: "clashes with the standard field"}}
+	if err := varuh.AddNewDatabaseEntry("GitHub", "alice", "https://github.com", "s3cr3t <&>", "dev work",
+		"line one\nline two", "JBSWY3DPEHPK3PXP", customEntries); err != nil {
+		t.Fatalf("AddNewDatabaseEntry() error = %v", err)
+	}
+	_, github := varuh.GetEntryById(1)
+
+	varuh.MakeGroupPath("work/git")
+	if err := varuh.MoveEntryToGroup(github, "work/git"); err != nil {
+		t.Fatalf("MoveEntryToGroup() error = %v", err)
+	}
+
+	file := filepath.Join(t.TempDir(), "recovery.txt")
+	os.WriteFile(file, []byte("recovery codes"), 0600)
+	if err, _ := varuh.AddAttachment(github, file, false); err != nil {
+		t.Fatalf("AddAttachment() error = %v", err)
+	}
+
+	varuh.AddNewDatabaseCardEntry("Travel card", "4111111111111111", "Carol", "HDFC Bank", "VISA",
+		"123", "4321", "08/29", "", "", nil)
+
+	identity := &varuh.Entry{Title: "Passport", FirstName: "Jane", LastName: "Doe", Email: "jane@example.com",
+		Class: "passport", Number: "Z1234567", Issuer: "Ministry", ExpiryDate: "2031-05-01"}
+	varuh.AddNewDatabaseIdentityEntry(identity, nil)
+
+	address := &varuh.Address{Type: "Home", Number: "12", Street: "MG Road", City: "Bangalore", ZipCode: "560066"}
+	varuh.AddNewDatabaseAddressEntry("Home address", "", "home", address, nil)
+}
+
+func TestKDBXRoundTrip(t *testing.T) {
+	useTestDatabase(t)
+	addKDBXTestEntries(t)
+
+	keyFile := []byte("any file can be a key file")
+
+	for _, cipherId := range []uint8{varuh.CIPHER_AES, varuh.CIPHER_XCHACHA} {
+		path := filepath.Join(t.TempDir(), "vault.kdbx")
+		if err := varuh.WriteKDBX(path, "keepass pass", keyFile, cipherId, testKdbxParams); err != nil {
+			t.Fatalf("WriteKDBX() error = %v", err)
+		}
+
+		data, _ := os.ReadFile(path)
+
+		if err, _ := varuh.ReadKDBX(data, "wrong pass", keyFile); err == nil {
+			t.Errorf("ReadKDBX() with a wrong password should fail")
+		}
+		if err, _ := varuh.ReadKDBX(data, "keepass pass", nil); err == nil {
+			t.Errorf("ReadKDBX() without the key file should fail")
+		}
+
+		err, items := varuh.ReadKDBX(data, "keepass pass", keyFile)
+		if err != nil {
+			t.Fatalf("ReadKDBX(%s) error = %v", varuh.CipherName(cipherId), err)
+		}
+		if len(items) != 4 {
+			t.Fatalf("ReadKDBX() = %d entries, want 4", len(items))
+		}
+
+		github := importedEntry(t, items, "GitHub")
+		if github.Entry.User != "alice" || github.Entry.Password != "s3cr3t <&>" || github.Entry.Url != "https://github.com" ||
+			github.Entry.Notes != "line one\nline two" || github.Entry.Tags != "dev work" || github.Group != "work/git" {
+			t.Errorf("GitHub entry = %+v in %s", github.Entry, github.Group)
+		}
+		if github.Entry.Otp == "" || len(github.CustomEntries) != 2 || github.CustomEntries[1].FieldName != "Password (2)" {
+			t.Errorf("GitHub otp %q, custom fields %+v", github.Entry.Otp, github.CustomEntries)
+		}
+		if len(github.Attachments) != 1 || github.Attachments[0].Name != "recovery.txt" ||
+			string(github.Attachments[0].Data) != "recovery codes" {
+			t.Errorf("GitHub attachments = %+v", github.Attachments)
+		}
+
+		card := importedEntry(t, items, "Travel card")
+		if card.Entry.Type != "card" || card.Entry.Url != "4111111111111111" || card.Entry.Password != "123" ||
+			card.Entry.Pin != "4321" || card.Entry.ExpiryDate != "08/29" || card.Entry.Issuer != "HDFC Bank" {
+			t.Errorf("card entry = %+v", card.Entry)
+		}
+
+		passport := importedEntry(t, items, "Passport")
+		if passport.Entry.Type != "identity" || passport.Entry.User != "Jane Doe" || passport.Entry.Number != "Z1234567" ||
+			passport.Entry.Email != "jane@example.com" || len(passport.CustomEntries) != 0 {
+			t.Errorf("identity entry = %+v, %+v", passport.Entry, passport.CustomEntries)
+		}
+
+		home := importedEntry(t, items, "Home address")
+		if home.Entry.Type != "address" || len(home.Addresses) != 1 || home.Addresses[0].Street != "MG Road" ||
+			home.Addresses[0].ZipCode != "560066" || len(home.CustomEntries) != 0 {
+			t.Errorf("address entry = %+v, %+v", home.Addresses, home.CustomEntries)
+		}
+	}
+}
+
+func TestImportFromKDBX(t *testing.T) {
+	useTestDatabase(t)
+	addKDBXTestEntries(t)
+
+	path := filepath.Join(t.TempDir(), "vault.kdbx")
+	if err := varuh.WriteKDBX(path, "keepass pass", nil, varuh.CIPHER_AES, testKdbxParams); err != nil {
+		t.Fatalf("WriteKDBX() error = %v", err)
+	}
+
+	useTestDatabase(t)
+	if err := varuh.ImportFromKDBX(path, "keepass pass", nil, "keepass", false); err != nil {
+		t.Fatalf("ImportFromKDBX() error = %v", err)
+	}
+
+	err, entries := varuh.SearchDatabaseEntry("GitHub")
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("SearchDatabaseEntry() = %v, %d entries", err, len(entries))
+	}
+	github := &entries[0]
+
+	if got := varuh.GetGroupPath(github.GroupID); got != "keepass/work/git" {
+		t.Errorf("imported group = %s, want keepass/work/git", got)
+	}
+	if fields := entryFields(github); fields["Security question"] != "Pet's name" {
+		t.Errorf("imported custom fields = %v", fields)
+	}
+	if err, attachment := varuh.GetAttachment(github, "recovery.txt"); err != nil || string(attachment.Data) != "recovery codes" {
+		t.Errorf("imported attachment = %v", err)
+	}
+
+	// A second import finds only duplicates
+	if err := varuh.ImportFromKDBX(path, "keepass pass", nil, "keepass", false); err != nil {
+		t.Fatalf("ImportFromKDBX() again error = %v", err)
+	}
+	if got := entryTitles(t); len(got) != 4 {
+		t.Errorf("entries after second import = %v", got)
+	}
+
+	if err := varuh.ImportFromKDBX(writeImportFile(t, "bad.kdbx", "not a database"), "", nil, "", false); err == nil {
+		t.Errorf("ImportFromKDBX() of a bad file should fail")
+	}
+}
+
+func TestKDBXKeyFileKey(t *testing.T) {
+	raw := []byte("0123456789abcdef0123456789abcdef")
+
+	xmlV2 := []byte(`<?xml version="1.0" encoding="utf-8"?>
+<KeyFile><Meta><Version>2.0</Version></Meta><Key>
+<Data Hash="3EB1BD43">30313233 34353637 38396162 63646566 30313233 34353637 38396162 63646566</Data>
+</Key></KeyFile>`)
+
+	for _, data := range [][]byte{raw, []byte("3031323334353637383961626364656630313233343536373839616263646566"), xmlV2} {
+		err, key := varuh.KDBXKeyFileKey(data)
+		if err != nil || string(key) != string(raw) {
+			t.Errorf("KDBXKeyFileKey(%s) = %v, %x", data, err, key)
+		}
+	}
+
+	badHash := []byte(`<KeyFile><Meta><Version>2.0</Version></Meta><Key><Data Hash="00000000">3031</Data></Key></KeyFile>`)
+	if err, _ := varuh.KDBXKeyFileKey(badHash); err == nil {
+		t.Errorf("KDBXKeyFileKey() with a bad checksum should fail")
+	}
+}
+
+// Databases laid out like KeePassXC writes them, see testdata/kdbx/generate.go
+func TestReadKDBXFixtures(t *testing.T) {
+	const password = "correct horse battery staple"
+
+	data, _ := os.ReadFile(filepath.Join("testdata", "kdbx", "argon2d-aes.kdbx"))
+	err, items := varuh.ReadKDBX(data, password, nil)
+	if err != nil {
+		t.Fatalf("ReadKDBX(argon2d-aes) error = %v", err)
+	}
+	if len(items) != 2 {
+		t.Fatalf("ReadKDBX(argon2d-aes) = %d entries, want 2 without the recycle bin", len(items))
+	}
+
+	github := importedEntry(t, items, "GitHub")
+	if github.Entry.User != "alice" || github.Entry.Password != "hunter2 <&>" || github.Entry.Url != "https://github.com/login" ||
+		github.Entry.Notes != "Personal account\nSSO through work" || github.Entry.Tags != "dev work" || github.Group != "Internet" {
+		t.Errorf("GitHub entry = %+v in %s", github.Entry, github.Group)
+	}
+	if !strings.Contains(github.Entry.Otp, "secret=JBSWY3DPEHPK3PXP") {
+		t.Errorf("GitHub otp = %q", github.Entry.Otp)
+	}
+	if len(github.CustomEntries) != 2 || github.CustomEntries[0] != (varuh.CustomEntry{FieldName: "Recovery email", FieldValue: "alice@example.com"}) ||
+		github.CustomEntries[1] != (varuh.CustomEntry{FieldName: "Backup PIN", FieldValue: "8642"}) {
+		t.Errorf("GitHub custom fields = %+v", github.CustomEntries)
+	}
+
+	// Protected values of the history come before this entry in the stream
+	if mail := importedEntry(t, items, "Mail"); mail.Entry.Password != "after the history" || mail.Group != "Internet" {
+		t.Errorf("Mail entry = %+v in %s", mail.Entry, mail.Group)
+	}
+
+	if err, _ = varuh.ReadKDBX(data, "wrong", nil); err == nil {
+		t.Errorf("ReadKDBX(argon2d-aes) with a wrong password should fail")
+	}
+
+	data, _ = os.ReadFile(filepath.Join("testdata", "kdbx", "chacha20-attachment.kdbx"))
+	if err, items = varuh.ReadKDBX(data, password, nil); err != nil || len(items) != 1 {
+		t.Fatalf("ReadKDBX(chacha20-attachment) = %v, %d entries", err, len(items))
+	}
+
+	server := &items[0]
+	if server.Entry.Title != "Build server" || server.Entry.User != "deploy" || server.Entry.Password != "s3rv3r" ||
+		server.Entry.Url != "ssh://build.example.com" || server.Group != "Servers" {
+		t.Errorf("server entry = %+v in %s", server.Entry, server.Group)
+	}
+	if len(server.Attachments) != 1 || server.Attachments[0].Name != "recovery-codes.txt" ||
+		string(server.Attachments[0].Data) != "1111-2222\n3333-4444\n\x00\xff binary tail" {
+		t.Errorf("server attachments = %+v", server.Attachments)
+	}
+}
+
+// Argon2d outputs of the reference implementation of the Argon2 authors for
+// the password "password" and the salt "somesalt", from golang.org/x/crypto
+func TestArgon2dKey(t *testing.T) {
+	tests := []struct {
+		time, memory uint32
+		threads      uint8
+		hash         string
+	}{
+		{1, 64, 1, "8727405fd07c32c78d64f547f24150d3f2e703a89f981a19"},
+		{2, 64, 1, "3be9ec79a69b75d3752acb59a1fbb8b295a46529c48fbb75"},
+		{2, 64, 2, "68e2462c98b8bc6bb60ec68db418ae2c9ed24fc6748a40e9"},
+		{3, 256, 2, "f4f0669218eaf3641f39cc97efb915721102f4b128211ef2"},
+		{4, 4096, 4, "935598181aa8dc2b720914aa6435ac8d3e3a4210c5b0fb2d"},
+		{4, 1024, 8, "83604fc2ad0589b9d055578f4d3cc55bc616df3578a896e9"},
+		{2, 64, 3, "22474a423bda2ccd36ec9afd5119e5c8949798cadf659f51"},
+		{3, 1024, 6, "a3351b0319a53229152023d9206902f4ef59661cdca89481"},
+	}
+
+	for _, tt := range tests {
+		key := varuh.Argon2dKey([]byte("password"), []byte("somesalt"), tt.time, tt.memory, tt.threads, 24)
+		if got := hex.EncodeToString(key); got != tt.hash {
+			t.Errorf("Argon2dKey(t=%d, m=%d, p=%d) = %s, want %s", tt.time, tt.memory, tt.threads, got, tt.hash)
+		}
+	}
+}
+
+// Replace bytes of the header of a KDBX file, keeping its checksum valid
+func patchKDBXHeader(t *testing.T, data []byte, old, new []byte) []byte {
+	if !bytes.Contains(data, old) {
+		t.Fatalf("KDBX header has no %x", old)
+	}
+	data = bytes.Replace(data, old, new, 1)
+
+	pos := 12
+	for data[pos] != 0 {
+		pos += 5 + int(binary.LittleEndian.Uint32(data[pos+1:pos+5]))
+	}
+	pos += 5 + int(binary.LittleEndian.Uint32(data[pos+1:pos+5]))
+
+	sum := sha256.Sum256(data[:pos])
+	copy(data[pos:], sum[:])
+	return data
+}
+
+func TestReadKDBXKdfBounds(t *testing.T) {
+	useTestDatabase(t)
+	addTestEntry(t, "GitHub", "secret", nil)
+
+	path := filepath.Join(t.TempDir(), "vault.kdbx")
+	if err := varuh.WriteKDBX(path, "keepass pass", nil, varuh.CIPHER_AES, testKdbxParams); err != nil {
+		t.Fatalf("WriteKDBX() error = %v", err)
+	}
+	data, _ := os.ReadFile(path)
+
+	// A uint64 entry of the KDF variant dictionary
+	variant := func(key string, value uint64) []byte {
+		item := make([]byte, 18)
+		item[0] = 0x05
+		binary.LittleEndian.PutUint32(item[1:], 1)
+		item[5] = key[0]
+		binary.LittleEndian.PutUint32(item[6:], 8)
+		binary.LittleEndian.PutUint64(item[10:], value)
+		return item
+	}
+
+	argon2id, _ := hex.DecodeString("9e298b1956db4773b23dfc3ec6f0a1e6")
+	aesKdf, _ := hex.DecodeString("c9d9f39a628a4460bf740d08c18a4fea")
+	iterations := variant("I", 1)
+
+	tests := []struct {
+		name string
+		data []byte
+		want string
+	}{
+		{"argon2 iterations", patchKDBXHeader(t, append([]byte{}, data...), iterations,
+			variant("I", 0xFFFFFFFF)), "Argon2 iterations are too many"},
+		{"aes-kdf rounds", patchKDBXHeader(t, patchKDBXHeader(t, append([]byte{}, data...), argon2id, aesKdf),
+			iterations, variant("R", 0xFFFFFFFFFFFFFFFF)), "AES-KDF rounds are too many"},
+	}
+
+	for _, tt := range tests {
+		err, _ := varuh.ReadKDBX(tt.data, "keepass pass", nil)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("ReadKDBX() with too costly %s error = %v, want %q", tt.name, err, tt.want)
+		}
+	}
+}
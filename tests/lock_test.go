@@ -0,0 +1,154 @@
+package tests
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+	"varuh"
+)
+
+// Hold the lock file of a database like another process would
+func holdLockFile(t *testing.T, dbPath string, pid int) *os.File {
+	fh, err := os.OpenFile(dbPath+varuh.LOCK_SUFFIX, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		t.Fatalf("OpenFile() error = %v", err)
+	}
+
+	if err = syscall.Flock(int(fh.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		t.Fatalf("Flock() error = %v", err)
+	}
+
+	fh.WriteString(strconv.Itoa(pid))
+	return fh
+}
+
+func TestLockDatabaseBusy(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "vault.db")
+	fh := holdLockFile(t, dbPath, 4242)
+	defer fh.Close()
+
+	err, _ := varuh.LockDatabase(dbPath, 0)
+	if !errors.Is(err, varuh.ErrVaultBusy) {
+		t.Fatalf("LockDatabase() error = %v, want ErrVaultBusy", err)
+	}
+
+	if want := "pid 4242"; err != nil && !strings.Contains(err.Error(), want) {
+		t.Errorf("LockDatabase() error = %q, should mention %q", err.Error(), want)
+	}
+}
+
+func TestLockDatabaseWaits(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "vault.db")
+	fh := holdLockFile(t, dbPath, 4242)
+
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		os.Remove(dbPath + varuh.LOCK_SUFFIX)
+		fh.Close()
+	}()
+
+	err, unlock := varuh.LockDatabase(dbPath, 2*time.Second)
+	if err != nil {
+		t.Fatalf("LockDatabase() with timeout error = %v", err)
+	}
+	unlock()
+
+	if _, err = os.Stat(dbPath + varuh.LOCK_SUFFIX); !os.IsNotExist(err) {
+		t.Error("unlock did not remove the lock file")
+	}
+}
+
+func TestLockDatabaseReentrant(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "vault.db")
+
+	err, unlock1 := varuh.LockDatabase(dbPath, 0)
+	if err != nil {
+		t.Fatalf("LockDatabase() error = %v", err)
+	}
+
+	err, unlock2 := varuh.LockDatabase(dbPath, 0)
+	if err != nil {
+		t.Fatalf("LockDatabase() again in the same process error = %v", err)
+	}
+
+	unlock2()
+	// Double release must not drop the outer hold
+	unlock2()
+
+	if _, err = os.Stat(dbPath + varuh.LOCK_SUFFIX); err != nil {
+		t.Error("inner unlock released the outer lock")
+	}
+
+	unlock1()
+
+	if _, err = os.Stat(dbPath + varuh.LOCK_SUFFIX); !os.IsNotExist(err) {
+		t.Error("outer unlock did not release the lock")
+	}
+}
+
+func TestLockDatabaseStalePid(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "vault.db")
+
+	// Pid of a process which has exited
+	cmd := exec.Command("true")
+	if err := cmd.Run(); err != nil {
+		t.Skip("can't run true")
+	}
+
+	os.WriteFile(dbPath+varuh.LOCK_SUFFIX, []byte(strconv.Itoa(cmd.Process.Pid)), 0600)
+
+	err, unlock := varuh.LockDatabase(dbPath, 0)
+	if err != nil {
+		t.Fatalf("LockDatabase() over stale lock error = %v", err)
+	}
+
+	contents, _ := os.ReadFile(dbPath + varuh.LOCK_SUFFIX)
+	if pid, _ := strconv.Atoi(string(contents[:len(contents)-1])); pid != os.Getpid() {
+		t.Errorf("lock file pid = %q, want %d", contents, os.Getpid())
+	}
+
+	unlock()
+}
+
+func TestLockDatabaseReleaseWhileWaiting(t *testing.T) {
+	dir := t.TempDir()
+	held, busy := filepath.Join(dir, "held.db"), filepath.Join(dir, "busy.db")
+
+	err, unlockHeld := varuh.LockDatabase(held, 0)
+	if err != nil {
+		t.Fatalf("LockDatabase() error = %v", err)
+	}
+
+	fh := holdLockFile(t, busy, 4242)
+	defer fh.Close()
+
+	waited := make(chan error)
+	go func() {
+		err, _ := varuh.LockDatabase(busy, time.Second)
+		waited <- err
+	}()
+
+	// Releasing another lock doesn't wait for the waiting one to give up
+	time.Sleep(2 * varuh.LOCK_POLL_INTERVAL)
+	released := make(chan struct{})
+	go func() {
+		unlockHeld()
+		close(released)
+	}()
+
+	select {
+	case <-released:
+	case <-time.After(500 * time.Millisecond):
+		t.Fatal("unlock blocked while another lock was waited for")
+	}
+
+	if err = <-waited; !errors.Is(err, varuh.ErrVaultBusy) {
+		t.Errorf("LockDatabase() of a busy database error = %v, want ErrVaultBusy", err)
+	}
+}
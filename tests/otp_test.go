@@ -0,0 +1,126 @@
+package tests
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+	"varuh"
+)
+
+// Base32 secret of an ascii seed as used in the RFC test vectors
+func rfcSecret(seed string, size int) string {
+	return base32.StdEncoding.EncodeToString([]byte(strings.Repeat(seed, size/len(seed)+1)[:size]))
+}
+
+func TestGenerateHOTP(t *testing.T) {
+	// RFC 4226 appendix D
+	secret := rfcSecret("12345678901234567890", 20)
+	want := []string{"755224", "287082", "359152", "969429", "338314"}
+
+	for counter, code := range want {
+		err, got := varuh.GenerateHOTP(secret, uint64(counter), 6, "SHA1")
+		if err != nil || got != code {
+			t.Errorf("GenerateHOTP(counter %d) = %v, %s, want %s", counter, err, got, code)
+		}
+	}
+}
+
+func TestGenerateTOTP(t *testing.T) {
+	// RFC 6238 appendix B
+	secrets := map[string]string{
+		"SHA1":   rfcSecret("12345678901234567890", 20),
+		"SHA256": rfcSecret("12345678901234567890", 32),
+		"SHA512": rfcSecret("12345678901234567890", 64),
+	}
+
+	tests := []struct {
+		unix      int64
+		algorithm string
+		code      string
+	}{
+		{59, "SHA1", "94287082"},
+		{59, "SHA256", "46119246"},
+		{59, "SHA512", "90693936"},
+		{1111111109, "SHA1", "07081804"},
+		{1111111109, "SHA256", "68084774"},
+		{1111111109, "SHA512", "25091201"},
+		{20000000000, "SHA512", "47863826"},
+	}
+
+	for _, tt := range tests {
+		params := &varuh.OtpParams{Type: "totp", Secret: secrets[tt.algorithm], Algorithm: tt.algorithm, Digits: 8, Period: 30}
+
+		err, code, remaining := varuh.GenerateTOTP(params, time.Unix(tt.unix, 0))
+		if err != nil || code != tt.code {
+			t.Errorf("GenerateTOTP(%d, %s) = %v, %s, want %s", tt.unix, tt.algorithm, err, code, tt.code)
+		}
+		if remaining <= 0 || remaining > 30*time.Second {
+			t.Errorf("GenerateTOTP(%d) remaining = %v", tt.unix, remaining)
+		}
+	}
+}
+
+func TestParseOtp(t *testing.T) {
+	err, params := varuh.ParseOtp("jbsw y3dp ehpk 3pxp")
+	if err != nil || params.Type != "totp" || params.Secret != "JBSWY3DPEHPK3PXP" || params.Digits != 6 || params.Period != 30 {
+		t.Errorf("ParseOtp(secret) = %v, %+v", err, params)
+	}
+
+	err, params = varuh.ParseOtp("otpauth://totp/ACME:alice?secret=JBSWY3DPEHPK3PXP&issuer=ACME&algorithm=SHA256&digits=8&period=60")
+	if err != nil || params.Algorithm != "SHA256" || params.Digits != 8 || params.Period != 60 || params.Label != "ACME:alice" || params.Issuer != "ACME" {
+		t.Errorf("ParseOtp(totp URI) = %v, %+v", err, params)
+	}
+
+	// Round trip
+	if err, again := varuh.ParseOtp(params.URI()); err != nil || *again != *params {
+		t.Errorf("ParseOtp(URI()) = %v, %+v, want %+v", err, again, params)
+	}
+
+	for _, value := range []string{
+		"not base32!",
+		"otpauth://totp/x?secret=",
+		"otpauth://totp/x?secret=JBSWY3DPEHPK3PXP&algorithm=MD5",
+		"otpauth://totp/x?secret=JBSWY3DPEHPK3PXP&digits=4",
+		"otpauth://hotp/x?secret=JBSWY3DPEHPK3PXP",
+		"otpauth://motp/x?secret=JBSWY3DPEHPK3PXP",
+	} {
+		if err, _ := varuh.ParseOtp(value); err == nil {
+			t.Errorf("ParseOtp(%q) expected error", value)
+		}
+	}
+}
+
+func TestEntryHOTPCounter(t *testing.T) {
+	useTestDatabase(t)
+
+	entry := addTestEntry(t, "OTP entry", "secret", nil)
+
+	uri := "otpauth://hotp/test?secret=" + rfcSecret("12345678901234567890", 20) + "&counter=0"
+	if err := varuh.SetEntryOtp(entry, uri); err != nil {
+		t.Fatalf("SetEntryOtp() error = %v", err)
+	}
+
+	// Each use moves the counter on
+	for _, want := range []string{"755224", "287082"} {
+		_, entry = varuh.GetEntryById(entry.ID)
+
+		err, code, _ := varuh.GetEntryOtpCode(entry)
+		if err != nil || code != want {
+			t.Errorf("GetEntryOtpCode() = %v, %s, want %s", err, code, want)
+		}
+	}
+
+	_, entry = varuh.GetEntryById(entry.ID)
+	if !strings.Contains(entry.Otp, "counter=2") {
+		t.Errorf("entry OTP after two codes = %s", entry.Otp)
+	}
+
+	// Removing the secret
+	if err := varuh.SetEntryOtp(entry, ""); err != nil {
+		t.Fatalf("SetEntryOtp(\"\") error = %v", err)
+	}
+	if err, _, _ := varuh.GetEntryOtpCode(entry); err == nil {
+		t.Error("GetEntryOtpCode() of entry without OTP should fail")
+	}
+}
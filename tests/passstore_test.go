@@ -0,0 +1,132 @@
+package tests
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"varuh"
+
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/armor"
+	// Hash openpgp.Encrypt falls back to for keys of NewEntity
+	_ "golang.org/x/crypto/ripemd160"
+)
+
+// Create a pass store with files encrypted to a new key, returning the
+// store directory and the armored private key
+func writePassStore(t *testing.T, files map[string]string) (string, []byte) {
+	entity, err := openpgp.NewEntity("Test", "", "test@example.com", nil)
+	if err != nil {
+		t.Fatalf("NewEntity() error = %v", err)
+	}
+
+	storeDir := t.TempDir()
+	os.WriteFile(filepath.Join(storeDir, ".gpg-id"), []byte("test@example.com\n"), 0600)
+
+	for name, content := range files {
+		var buf bytes.Buffer
+
+		writer, err := openpgp.Encrypt(&buf, []*openpgp.Entity{entity}, nil, nil, nil)
+		if err != nil {
+			t.Fatalf("Encrypt() error = %v", err)
+		}
+		writer.Write([]byte(content))
+		writer.Close()
+
+		path := filepath.Join(storeDir, filepath.FromSlash(name))
+		os.MkdirAll(filepath.Dir(path), 0700)
+		if err = os.WriteFile(path, buf.Bytes(), 0600); err != nil {
+			t.Fatalf("WriteFile() error = %v", err)
+		}
+	}
+
+	var key bytes.Buffer
+	writer, _ := armor.Encode(&key, openpgp.PrivateKeyType, nil)
+	if err = entity.SerializePrivate(writer, nil); err != nil {
+		t.Fatalf("SerializePrivate() error = %v", err)
+	}
+	writer.Close()
+
+	return storeDir, key.Bytes()
+}
+
+func TestImportPassStore(t *testing.T) {
+	useTestDatabase(t)
+
+	storeDir, key := writePassStore(t, map[string]string{
+		"email/mail.example.com.gpg": "pass1\nlogin: alice\nurl: https://mail.example.com\n" +
+			"Security question: Pet's name\notpauth://totp/mail?secret=JBSWY3DPEHPK3PXP\n" +
+			"Recovery codes are in the safe\nhttps://help.example.com\n",
+		"web/shop/store.gpg": "pass2\n",
+		"bank.gpg":           "pass3\nemail: bob@example.com\n",
+		".git/HEAD.gpg":      "not an entry",
+	})
+
+	err, keyRing := varuh.ReadPGPKeyRing(key)
+	if err != nil {
+		t.Fatalf("ReadPGPKeyRing() error = %v", err)
+	}
+	if varuh.PGPKeyRingLocked(keyRing) {
+		t.Errorf("PGPKeyRingLocked() of a key without passphrase = true")
+	}
+
+	if err = varuh.ImportFromPassStore(storeDir, keyRing, "pass", false, false); err != nil {
+		t.Fatalf("ImportFromPassStore() error = %v", err)
+	}
+
+	if got := entryTitles(t); !reflect.DeepEqual(got, []string{"bank", "mail.example.com", "store"}) {
+		t.Fatalf("imported entries = %v", got)
+	}
+
+	_, mail := varuh.GetEntryById(2)
+	if mail.Password != "pass1" || mail.User != "alice" || mail.Url != "https://mail.example.com" || mail.Otp == "" ||
+		mail.Notes != "Recovery codes are in the safe\nhttps://help.example.com" {
+		t.Errorf("imported entry = %+v", mail)
+	}
+	if fields := entryFields(mail); len(fields) != 1 || fields["Security question"] != "Pet's name" {
+		t.Errorf("imported custom fields = %v", fields)
+	}
+	if got := varuh.GetGroupPath(mail.GroupID); got != "pass/email" {
+		t.Errorf("imported group = %s, want pass/email", got)
+	}
+
+	_, bank := varuh.GetEntryById(1)
+	if bank.User != "bob@example.com" || varuh.GetGroupPath(bank.GroupID) != "pass" {
+		t.Errorf("imported entry = %+v in %s", bank, varuh.GetGroupPath(bank.GroupID))
+	}
+}
+
+func TestImportPassStoreAsTags(t *testing.T) {
+	useTestDatabase(t)
+
+	storeDir, key := writePassStore(t, map[string]string{"web/shop/store.gpg": "pass2"})
+	_, keyRing := varuh.ReadPGPKeyRing(key)
+
+	err, entries := varuh.ParsePassStore(storeDir, keyRing, true)
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("ParsePassStore() = %v, %d entries", err, len(entries))
+	}
+	if entries[0].Entry.Tags != "web shop" || entries[0].Group != "" || entries[0].Entry.Password != "pass2" {
+		t.Errorf("entry with folders as tags = %+v in %q", entries[0].Entry, entries[0].Group)
+	}
+
+	if err, format := varuh.DetectImportFormat(storeDir); err != nil || format != "pass" {
+		t.Errorf("DetectImportFormat(store) = %v, %s", err, format)
+	}
+
+	// Files encrypted to some other key can't be read
+	_, otherKey := writePassStore(t, nil)
+	_, otherKeyRing := varuh.ReadPGPKeyRing(otherKey)
+	if err, _ = varuh.ParsePassStore(storeDir, otherKeyRing, false); err == nil {
+		t.Errorf("ParsePassStore() with another key should fail")
+	}
+
+	if err, _ = varuh.ParsePassStore(t.TempDir(), keyRing, false); err == nil {
+		t.Errorf("ParsePassStore() of an empty directory should fail")
+	}
+	if err, _ = varuh.ReadPGPKeyRing([]byte("not a key")); err == nil {
+		t.Errorf("ReadPGPKeyRing() of a bad key should fail")
+	}
+}
@@ -0,0 +1,223 @@
+package tests
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"varuh"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func TestParseSearchQuery(t *testing.T) {
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"github", "github"},
+		{"github alice", "(github AND alice)"},
+		{"github AND alice", "(github AND alice)"},
+		{"title:github OR user:alice", "(title:github OR user:alice)"},
+		{"a OR b c", "(a OR (b AND c))"},
+		{"NOT tag:prod", "NOT tag:prod"},
+		{"(a OR b) NOT c", "((a OR b) AND NOT c)"},
+		{`field:"API Key"`, `field:"API Key"`},
+		{`"two words" url:*.corp.com`, `("two words" AND url:*.corp.com)`},
+		{"https://example.com", "https://example.com"},
+		{"or and", "(or AND and)"},
+	}
+
+	for _, tt := range tests {
+		err, query := varuh.ParseSearchQuery(tt.query)
+		if err != nil {
+			t.Errorf("ParseSearchQuery(%q) error = %v", tt.query, err)
+			continue
+		}
+		if got := query.String(); got != tt.want {
+			t.Errorf("ParseSearchQuery(%q) = %s, want %s", tt.query, got, tt.want)
+		}
+	}
+
+	for _, query := range []string{"(a OR b", "a)", "a OR", "NOT", `"open`, "title:", "AND a"} {
+		if err, _ := varuh.ParseSearchQuery(query); err == nil {
+			t.Errorf("ParseSearchQuery(%q) should fail", query)
+		}
+	}
+
+	if err, query := varuh.ParseSearchQuery("  "); err != nil || query != nil {
+		t.Errorf("ParseSearchQuery(blank) = %v, %v", err, query)
+	}
+}
+
+func TestSearchEntries(t *testing.T) {
+	useTestDatabase(t)
+
+	varuh.AddNewDatabaseEntry("GitHub", "alice", "https://github.com/alice", "secret", "dev prod", "", "",
+		[]varuh.CustomEntry{{FieldName: "API Key", FieldValue: "tok3n"}})
+	varuh.AddNewDatabaseEntry("GitLab corp", "bob", "https://git.corp.com/login", "secret", "dev", "", "", nil)
+	varuh.AddNewDatabaseCardEntry("Travel card", "4111111111111111", "Carol", "HDFC Bank", "VISA",
+		"123", "1234", "12/30", "", "", nil)
+	varuh.AddNewDatabaseNoteEntry("Wallet", "the recovery phrase words", "", nil)
+
+	tests := []struct {
+		query string
+		want  []int
+	}{
+		{"", []int{1, 2, 3, 4}},
+		{"git", []int{1, 2}},
+		{"title:github", []int{1}},
+		{"user:alice", []int{1}},
+		{"tag:prod", []int{1}},
+		{"tag:DEV", []int{1, 2}},
+		{"tag:pr*", []int{1}},
+		{"url:*.corp.com", []int{2}},
+		{"url:github.com", []int{1}},
+		{"type:card", []int{3}},
+		{"type:password", []int{1, 2}},
+		{`field:"API Key"`, []int{1}},
+		{"tok3n", []int{1}},
+		{"hdfc", []int{3}},
+		{`"recovery phrase"`, []int{4}},
+		{`"phrase recovery"`, nil},
+		{"notes:recovery", []int{4}},
+		{"title:recovery", nil},
+		{"tag:dev NOT user:bob", []int{1}},
+		{"(title:github OR title:gitlab) AND user:bob", []int{2}},
+		{"wallet OR card", []int{3, 4}},
+		{"NOT git", []int{3, 4}},
+	}
+
+	for _, tt := range tests {
+		if got := searchIds(t, tt.query); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("search %q = %v, want %v", tt.query, got, tt.want)
+		}
+	}
+
+	if err, _ := varuh.SearchEntries("(git"); err == nil {
+		t.Errorf("SearchEntries() of an invalid query should fail")
+	}
+
+	// The index follows changes to entries and custom fields
+	_, entry := varuh.GetEntryById(2)
+	varuh.UpdateDatabaseEntry(entry, "Bitbucket", "", "", "", "", "", []varuh.CustomEntry{{FieldName: "Region", FieldValue: "mumbai"}}, true)
+
+	if got := searchIds(t, "gitlab"); got != nil {
+		t.Errorf("search after rename = %v, want none", got)
+	}
+	if got := searchIds(t, "bitbucket mumbai"); !reflect.DeepEqual(got, []int{2}) {
+		t.Errorf("search of new title and field = %v, want [2]", got)
+	}
+
+	_, entry = varuh.GetEntryById(1)
+	varuh.RemoveDatabaseEntry(entry)
+	if got := searchIds(t, "github"); got != nil {
+		t.Errorf("search of trashed entry = %v, want none", got)
+	}
+}
+
+func TestSearchHostileTerms(t *testing.T) {
+	useTestDatabase(t)
+
+	varuh.AddNewDatabaseEntry("GitHub", "alice", "https://github.com/alice", "secret", "dev", "", "", nil)
+	varuh.AddNewDatabaseEntry("100%_off", "bob", "https://shop.example.com", "secret", "", "", "", nil)
+
+	tests := []struct {
+		query string
+		want  []int
+	}{
+		{`"\" or 1=1 --"`, nil},
+		{`"' or '1'='1"`, nil},
+		{`'; drop table entries; --`, nil},
+		{`title:"github'); delete from entries; --"`, nil},
+		{`tag:"dev' or tags like '%"`, nil},
+		{`url:"%' or 1=1 --"`, nil},
+		{`"%"`, []int{2}},
+		{`"_"`, []int{2}},
+		{`"%_"`, []int{2}},
+		{`\`, nil},
+	}
+
+	for _, tt := range tests {
+		if got := searchIds(t, tt.query); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("search %q = %v, want %v", tt.query, got, tt.want)
+		}
+	}
+
+	// Nothing was run by any of the terms
+	if got := searchIds(t, ""); !reflect.DeepEqual(got, []int{1, 2}) {
+		t.Errorf("entries after hostile searches = %v, want [1 2]", got)
+	}
+}
+
+// Replace the search index of a database with the FTS5 table an older
+// build made with -tags sqlite_fts5, which this build cannot load
+func makeFTS5SearchIndex(t *testing.T, dbPath string) {
+	db, err := gorm.Open(sqlite.Open(dbPath), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatalf("gorm.Open() error = %v", err)
+	}
+	sqlDB, _ := db.DB()
+	defer sqlDB.Close()
+	sqlDB.SetMaxOpenConns(1)
+
+	statements := []string{
+		"drop table entries_fts",
+		"create table entries_fts_data(id integer primary key, block blob)",
+		"create table entries_fts_idx(segid, term, pgno, primary key(segid, term)) without rowid",
+		"create table entries_fts_content(id integer primary key, c0, c1, c2, c3, c4, c5, c6)",
+		"create table entries_fts_docsize(id integer primary key, sz blob)",
+		"create table entries_fts_config(k primary key, v) without rowid",
+		"PRAGMA writable_schema = ON",
+		`insert into sqlite_master values('table', 'entries_fts', 'entries_fts', 0, 'CREATE VIRTUAL TABLE entries_fts ` +
+			`USING fts5(title, user, url, notes, tags, fields, extra, tokenize = ''unicode61 remove_diacritics 2'')')`,
+		"PRAGMA writable_schema = RESET",
+	}
+	for _, statement := range statements {
+		if err = db.Exec(statement).Error; err != nil {
+			t.Fatalf("%s - %v", statement, err)
+		}
+	}
+}
+
+func TestSearchIndexFromFTS5(t *testing.T) {
+	dbPath := useTestDatabase(t)
+
+	varuh.AddNewDatabaseEntry("GitHub", "alice", "https://github.com/alice", "secret", "dev", "", "", nil)
+	makeFTS5SearchIndex(t, dbPath)
+
+	// Writes fail through the triggers while the FTS5 table is there,
+	// with no such module: fts5
+	db, _ := gorm.Open(sqlite.Open(dbPath), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	err := db.Exec("update entries set title = title").Error
+	if sqlDB, _ := db.DB(); sqlDB != nil {
+		sqlDB.Close()
+	}
+	if err == nil {
+		t.Fatalf("write with an FTS5 index should fail")
+	}
+
+	// Opening the database rebuilds the index with FTS4
+	if err = varuh.AddNewDatabaseEntry("GitLab", "bob", "https://gitlab.com", "secret", "", "", "", nil); err != nil {
+		t.Fatalf("AddNewDatabaseEntry() with an FTS5 index error = %v", err)
+	}
+	if got := searchIds(t, "git"); !reflect.DeepEqual(got, []int{1, 2}) {
+		t.Errorf("search after rebuild = %v, want [1 2]", got)
+	}
+	if got := searchIds(t, "user:alice"); !reflect.DeepEqual(got, []int{1}) {
+		t.Errorf("search of user after rebuild = %v, want [1]", got)
+	}
+
+	err, db = varuh.OpenDatabase(dbPath)
+	if err != nil {
+		t.Fatalf("OpenDatabase() error = %v", err)
+	}
+	var sql string
+	var shadows int64
+	db.Raw("select sql from sqlite_master where name = 'entries_fts'").Scan(&sql)
+	db.Raw("select count(*) from sqlite_master where name = 'entries_fts_data'").Scan(&shadows)
+	if !strings.Contains(sql, "fts4") || shadows != 0 {
+		t.Errorf("search index after rebuild = %q with %d FTS5 tables, want fts4 only", sql, shadows)
+	}
+}
@@ -0,0 +1,165 @@
+package tests
+
+import (
+	"reflect"
+	"testing"
+	"varuh"
+)
+
+// Add an entry with tags to the active database and return it
+func addTaggedEntry(t *testing.T, title, tags string) *varuh.Entry {
+	if err := varuh.AddNewDatabaseEntry(title, "user", "http://example.com", "secret", tags, "", "", nil); err != nil {
+		t.Fatalf("AddNewDatabaseEntry() error = %v", err)
+	}
+
+	err, entries := varuh.SearchDatabaseEntry(title)
+	if err != nil || len(entries) == 0 {
+		t.Fatalf("SearchDatabaseEntry() found no entry: %v", err)
+	}
+
+	return &entries[len(entries)-1]
+}
+
+// Return the ids of entries found for the search term
+func searchIds(t *testing.T, term string) []int {
+	var ids []int
+
+	err, entries := varuh.SearchDatabaseEntry(term)
+	if err != nil {
+		t.Fatalf("SearchDatabaseEntry(%q) error = %v", term, err)
+	}
+
+	for _, entry := range entries {
+		ids = append(ids, entry.ID)
+	}
+
+	return ids
+}
+
+func TestParseTags(t *testing.T) {
+	tests := []struct {
+		tags string
+		want []string
+	}{
+		{"", nil},
+		{"prod db", []string{"prod", "db"}},
+		{" prod,db ,  web ", []string{"prod", "db", "web"}},
+		{"prod Prod PROD", []string{"prod"}},
+	}
+
+	for _, tt := range tests {
+		if got := varuh.ParseTags(tt.tags); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseTags(%q) = %v, want %v", tt.tags, got, tt.want)
+		}
+	}
+}
+
+func TestTagSearchAndCounts(t *testing.T) {
+	useTestDatabase(t)
+
+	prod := addTaggedEntry(t, "Tag prod", "prod db")
+	production := addTaggedEntry(t, "Tag production", "production")
+	web := addTaggedEntry(t, "Tag web", "prod web")
+
+	// Exact match only - prod does not match production
+	if got := searchIds(t, "tag:prod"); !reflect.DeepEqual(got, []int{prod.ID, web.ID}) {
+		t.Errorf("search tag:prod = %v, want %v", got, []int{prod.ID, web.ID})
+	}
+	if got := searchIds(t, "tag:PRODUCTION"); !reflect.DeepEqual(got, []int{production.ID}) {
+		t.Errorf("search tag:PRODUCTION = %v", got)
+	}
+
+	err, counts := varuh.GetTagCounts()
+	if err != nil {
+		t.Fatalf("GetTagCounts() error = %v", err)
+	}
+	want := []varuh.TagCount{{Name: "prod", Count: 2}, {Name: "db", Count: 1},
+		{Name: "production", Count: 1}, {Name: "web", Count: 1}}
+	if !reflect.DeepEqual(counts, want) {
+		t.Errorf("GetTagCounts() = %+v, want %+v", counts, want)
+	}
+
+	// Editing the tags string updates the links
+	if err = varuh.UpdateDatabaseEntry(web, "", "", "", "", "web", "", nil, false); err != nil {
+		t.Fatalf("UpdateDatabaseEntry() error = %v", err)
+	}
+	if got := searchIds(t, "tag:prod"); !reflect.DeepEqual(got, []int{prod.ID}) {
+		t.Errorf("search tag:prod after edit = %v", got)
+	}
+
+	// Trashed entries are not counted
+	varuh.RemoveDatabaseEntry(production)
+	_, counts = varuh.GetTagCounts()
+	for _, count := range counts {
+		if count.Name == "production" {
+			t.Errorf("GetTagCounts() counted a trashed entry")
+		}
+	}
+}
+
+func TestRenameAndMergeTags(t *testing.T) {
+	useTestDatabase(t)
+
+	first := addTaggedEntry(t, "Tag first", "prod db")
+	second := addTaggedEntry(t, "Tag second", "production")
+
+	if err, _ := varuh.RenameTag("prod", "production"); err == nil {
+		t.Error("RenameTag() onto an existing tag should fail")
+	}
+	if err, _ := varuh.RenameTag("missing", "other"); err == nil {
+		t.Error("RenameTag() of a missing tag should fail")
+	}
+
+	err, count := varuh.RenameTag("db", "database")
+	if err != nil || count != 1 {
+		t.Fatalf("RenameTag() = %v, %d", err, count)
+	}
+
+	if _, entry := varuh.GetEntryById(first.ID); entry.Tags != "prod database" {
+		t.Errorf("entry tags after rename = %q", entry.Tags)
+	}
+
+	// Renames are kept in the history
+	if _, revisions := varuh.GetEntryRevisions(first); len(revisions) != 1 || revisions[0].OldValue != "prod db" {
+		t.Errorf("GetEntryRevisions() after rename = %+v", revisions)
+	}
+
+	err, count = varuh.MergeTags([]string{"prod", "production"}, "live")
+	if err != nil || count != 2 {
+		t.Fatalf("MergeTags() = %v, %d", err, count)
+	}
+
+	if got := searchIds(t, "tag:live"); !reflect.DeepEqual(got, []int{first.ID, second.ID}) {
+		t.Errorf("search tag:live = %v", got)
+	}
+	if got := searchIds(t, "tag:prod"); len(got) != 0 {
+		t.Errorf("search tag:prod after merge = %v", got)
+	}
+
+	_, counts := varuh.GetTagCounts()
+	if len(counts) != 2 {
+		t.Errorf("GetTagCounts() after merge = %+v", counts)
+	}
+}
+
+func TestMigrateTags(t *testing.T) {
+	dbPath := useTestDatabase(t)
+
+	// Entries written before the tags table existed
+	err, db := varuh.OpenDatabase(dbPath)
+	if err != nil {
+		t.Fatalf("OpenDatabase() error = %v", err)
+	}
+	db.Create(&varuh.Entry{Title: "Old tagged", Tags: "legacy prod"})
+	db.Exec("PRAGMA user_version = 0")
+
+	if err = varuh.MigrateSchema(db); err != nil {
+		t.Fatalf("MigrateSchema() error = %v", err)
+	}
+	sqlDB, _ := db.DB()
+	sqlDB.Close()
+
+	if got := searchIds(t, "tag:legacy"); len(got) != 1 {
+		t.Errorf("search tag:legacy after migration = %v", got)
+	}
+}
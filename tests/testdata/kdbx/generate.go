@@ -0,0 +1,351 @@
+//go:build ignore
+// +build ignore
+
+// Writes the KDBX 4 fixtures of the KeePass import tests. The files are laid
+// out like those of KeePassXC 2.7 - Argon2d, gzip, a ChaCha20 inner stream,
+// protected attachments in the inner header and KeePassXC's XML with history,
+// a recycle bin and the otp attribute. This writer follows the KDBX 4 spec and
+// shares no code with kdbx.go except Argon2d, which TestArgon2dKey checks
+// against the outputs of the reference implementation, so that a bug common
+// to varuh's reader and writer shows up. Run from the module root with
+//
+//	go run tests/testdata/kdbx/generate.go
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"varuh"
+
+	"golang.org/x/crypto/chacha20"
+)
+
+const password = "correct horse battery staple"
+
+var (
+	cipherAES      = []byte{0x31, 0xc1, 0xf2, 0xe6, 0xbf, 0x71, 0x43, 0x50, 0xbe, 0x58, 0x05, 0x21, 0x6a, 0xfc, 0x5a, 0xff}
+	cipherChaCha20 = []byte{0xd6, 0x03, 0x8a, 0x2b, 0x8b, 0x6f, 0x4c, 0xb5, 0xa5, 0x24, 0x33, 0x9a, 0x31, 0xdb, 0xb5, 0x9a}
+	kdfArgon2d     = []byte{0xef, 0x63, 0x6d, 0xdf, 0x8c, 0x29, 0x44, 0x4b, 0x91, 0xf7, 0xa9, 0xa4, 0x03, 0xe3, 0x0a, 0x0c}
+)
+
+// Fixed "random" bytes, so that the files only change with this program
+func seeded(label string, size int) []byte {
+	sum := sha512.Sum512([]byte(label))
+	return sum[:size]
+}
+
+func uuid(label string) string {
+	return base64.StdEncoding.EncodeToString(seeded("uuid "+label, 16))
+}
+
+// Seconds since 0001-01-01 as base64 of a little endian int64
+func stamp(t time.Time) string {
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], uint64(t.Unix()+62135596800))
+	return base64.StdEncoding.EncodeToString(buf[:])
+}
+
+func u32(v uint32) []byte {
+	var buf [4]byte
+	binary.LittleEndian.PutUint32(buf[:], v)
+	return buf[:]
+}
+
+func u64(v uint64) []byte {
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], v)
+	return buf[:]
+}
+
+func variant(kind byte, key string, value []byte) []byte {
+	data := append([]byte{kind}, u32(uint32(len(key)))...)
+	data = append(data, key...)
+	data = append(data, u32(uint32(len(value)))...)
+	return append(data, value...)
+}
+
+// The XML of a database, written in document order so that protected
+// values take their bytes of the inner stream as KeePass does
+type document struct {
+	strings.Builder
+	stream cipher.Stream
+}
+
+func (d *document) protected(value string) string {
+	data := []byte(value)
+	d.stream.XORKeyStream(data, data)
+	return base64.StdEncoding.EncodeToString(data)
+}
+
+func (d *document) str(key, value string, protect bool) {
+	if protect {
+		fmt.Fprintf(&d.Builder, "\t\t\t\t<String>\n\t\t\t\t\t<Key>%s</Key>\n\t\t\t\t\t<Value Protected=\"True\">%s</Value>\n\t\t\t\t</String>\n",
+			key, d.protected(value))
+		return
+	}
+	value = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(value)
+	if value == "" {
+		fmt.Fprintf(&d.Builder, "\t\t\t\t<String>\n\t\t\t\t\t<Key>%s</Key>\n\t\t\t\t\t<Value/>\n\t\t\t\t</String>\n", key)
+		return
+	}
+	fmt.Fprintf(&d.Builder, "\t\t\t\t<String>\n\t\t\t\t\t<Key>%s</Key>\n\t\t\t\t\t<Value>%s</Value>\n\t\t\t\t</String>\n", key, value)
+}
+
+func (d *document) times(t time.Time) {
+	s := stamp(t)
+	fmt.Fprintf(&d.Builder, "\t\t\t\t<Times>\n\t\t\t\t\t<LastModificationTime>%s</LastModificationTime>\n"+
+		"\t\t\t\t\t<CreationTime>%s</CreationTime>\n\t\t\t\t\t<LastAccessTime>%s</LastAccessTime>\n"+
+		"\t\t\t\t\t<ExpiryTime>%s</ExpiryTime>\n\t\t\t\t\t<Expires>False</Expires>\n"+
+		"\t\t\t\t\t<UsageCount>0</UsageCount>\n\t\t\t\t\t<LocationChanged>%s</LocationChanged>\n\t\t\t\t</Times>\n",
+		s, s, s, s, s)
+}
+
+type field struct {
+	key, value string
+	protect    bool
+}
+
+type entry struct {
+	title    string
+	tags     string
+	fields   []field
+	binaries map[string]int
+	history  []entry
+}
+
+func (d *document) entry(e entry, modified time.Time) {
+	d.writeEntry(e, modified, false)
+}
+
+// Entries kept in the history of another have no history of their own
+func (d *document) writeEntry(e entry, modified time.Time, old bool) {
+	fmt.Fprintf(&d.Builder, "\t\t\t<Entry>\n\t\t\t\t<UUID>%s</UUID>\n\t\t\t\t<IconID>0</IconID>\n"+
+		"\t\t\t\t<ForegroundColor/>\n\t\t\t\t<BackgroundColor/>\n\t\t\t\t<OverrideURL/>\n\t\t\t\t<Tags>%s</Tags>\n",
+		uuid(e.title), e.tags)
+	d.times(modified)
+
+	fields := append([]field{{"Title", e.title, false}}, e.fields...)
+	for _, f := range fields {
+		d.str(f.key, f.value, f.protect)
+	}
+	for name, ref := range e.binaries {
+		fmt.Fprintf(&d.Builder, "\t\t\t\t<Binary>\n\t\t\t\t\t<Key>%s</Key>\n\t\t\t\t\t<Value Ref=\"%d\"/>\n\t\t\t\t</Binary>\n", name, ref)
+	}
+	d.WriteString("\t\t\t\t<AutoType>\n\t\t\t\t\t<Enabled>True</Enabled>\n\t\t\t\t\t<DataTransferObfuscation>0</DataTransferObfuscation>\n" +
+		"\t\t\t\t\t<DefaultSequence/>\n\t\t\t\t</AutoType>\n")
+
+	if !old {
+		d.WriteString("\t\t\t\t<History>\n")
+		for _, previous := range e.history {
+			d.writeEntry(previous, modified.Add(-24*time.Hour), true)
+		}
+		d.WriteString("\t\t\t\t</History>\n")
+	}
+	d.WriteString("\t\t\t</Entry>\n")
+}
+
+func (d *document) group(name string, modified time.Time, body func()) {
+	fmt.Fprintf(&d.Builder, "\t\t<Group>\n\t\t\t<UUID>%s</UUID>\n\t\t\t<Name>%s</Name>\n\t\t\t<Notes/>\n\t\t\t<IconID>48</IconID>\n",
+		uuid(name), name)
+	d.times(modified)
+	d.WriteString("\t\t\t<IsExpanded>True</IsExpanded>\n\t\t\t<DefaultAutoTypeSequence/>\n\t\t\t<EnableAutoType>null</EnableAutoType>\n" +
+		"\t\t\t<EnableSearching>null</EnableSearching>\n\t\t\t<LastTopVisibleEntry>AAAAAAAAAAAAAAAAAAAAAA==</LastTopVisibleEntry>\n")
+	body()
+	d.WriteString("\t\t</Group>\n")
+}
+
+func (d *document) meta(name string, modified time.Time) {
+	s := stamp(modified)
+	fmt.Fprintf(&d.Builder, "<?xml version=\"1.0\" encoding=\"UTF-8\" standalone=\"yes\"?>\n<KeePassFile>\n\t<Meta>\n"+
+		"\t\t<Generator>KeePassXC</Generator>\n\t\t<DatabaseName>%s</DatabaseName>\n\t\t<DatabaseNameChanged>%s</DatabaseNameChanged>\n"+
+		"\t\t<DatabaseDescription/>\n\t\t<DatabaseDescriptionChanged>%s</DatabaseDescriptionChanged>\n"+
+		"\t\t<DefaultUserName/>\n\t\t<DefaultUserNameChanged>%s</DefaultUserNameChanged>\n"+
+		"\t\t<MaintenanceHistoryDays>365</MaintenanceHistoryDays>\n\t\t<Color/>\n\t\t<MasterKeyChanged>%s</MasterKeyChanged>\n"+
+		"\t\t<MasterKeyChangeRec>-1</MasterKeyChangeRec>\n\t\t<MasterKeyChangeForce>-1</MasterKeyChangeForce>\n"+
+		"\t\t<MemoryProtection>\n\t\t\t<ProtectTitle>False</ProtectTitle>\n\t\t\t<ProtectUserName>False</ProtectUserName>\n"+
+		"\t\t\t<ProtectPassword>True</ProtectPassword>\n\t\t\t<ProtectURL>False</ProtectURL>\n\t\t\t<ProtectNotes>False</ProtectNotes>\n"+
+		"\t\t</MemoryProtection>\n\t\t<CustomIcons/>\n\t\t<RecycleBinEnabled>True</RecycleBinEnabled>\n"+
+		"\t\t<RecycleBinUUID>%s</RecycleBinUUID>\n\t\t<RecycleBinChanged>%s</RecycleBinChanged>\n"+
+		"\t\t<EntryTemplatesGroup>AAAAAAAAAAAAAAAAAAAAAA==</EntryTemplatesGroup>\n\t\t<EntryTemplatesGroupChanged>%s</EntryTemplatesGroupChanged>\n"+
+		"\t\t<LastSelectedGroup>AAAAAAAAAAAAAAAAAAAAAA==</LastSelectedGroup>\n\t\t<LastTopVisibleGroup>AAAAAAAAAAAAAAAAAAAAAA==</LastTopVisibleGroup>\n"+
+		"\t\t<HistoryMaxItems>10</HistoryMaxItems>\n\t\t<HistoryMaxSize>6291456</HistoryMaxSize>\n"+
+		"\t\t<SettingsChanged>%s</SettingsChanged>\n\t\t<CustomData>\n\t\t\t<Item>\n"+
+		"\t\t\t\t<Key>KPXC_DECRYPTION_TIME_PREFERENCE</Key>\n\t\t\t\t<Value>100</Value>\n\t\t\t</Item>\n"+
+		"\t\t</CustomData>\n\t</Meta>\n\t<Root>\n",
+		name, s, s, s, s, uuid("Recycle Bin"), s, s, s)
+}
+
+// Encrypt the inner header and XML into a KDBX 4 file
+func write(path string, cipherId []byte, xml string, streamKey []byte, binaries [][]byte) {
+	masterSeed := seeded(path+" seed", 32)
+	salt := seeded(path+" salt", 32)
+	ivSize := 16
+	if bytes.Equal(cipherId, cipherChaCha20) {
+		ivSize = 12
+	}
+	iv := seeded(path+" iv", ivSize)
+
+	kdf := []byte{0x00, 0x01}
+	kdf = append(kdf, variant(0x42, "$UUID", kdfArgon2d)...)
+	kdf = append(kdf, variant(0x04, "V", u32(0x13))...)
+	kdf = append(kdf, variant(0x42, "S", salt)...)
+	kdf = append(kdf, variant(0x04, "P", u32(2))...)
+	kdf = append(kdf, variant(0x05, "M", u64(1024*1024))...)
+	kdf = append(kdf, variant(0x05, "I", u64(2))...)
+	kdf = append(kdf, 0x00)
+
+	header := append(u32(0x9AA2D903), u32(0xB54BFB67)...)
+	header = append(header, u32(0x00040000)...)
+	for _, f := range []struct {
+		id    byte
+		value []byte
+	}{{2, cipherId}, {3, u32(1)}, {4, masterSeed}, {7, iv}, {11, kdf}, {0, []byte("\r\n\r\n")}} {
+		header = append(header, f.id)
+		header = append(header, u32(uint32(len(f.value)))...)
+		header = append(header, f.value...)
+	}
+
+	passHash := sha256.Sum256([]byte(password))
+	composite := sha256.Sum256(passHash[:])
+	transformed := varuh.Argon2dKey(composite[:], salt, 2, 1024, 2, 32)
+
+	encKey := sha256.Sum256(append(append([]byte{}, masterSeed...), transformed...))
+	hmacBase := sha512.Sum512(append(append(append([]byte{}, masterSeed...), transformed...), 0x01))
+	blockMac := func(index uint64, data []byte) []byte {
+		key := sha512.Sum512(append(u64(index), hmacBase[:]...))
+		mac := hmac.New(sha256.New, key[:])
+		mac.Write(data)
+		return mac.Sum(nil)
+	}
+
+	var inner []byte
+	inner = append(inner, 1)
+	inner = append(inner, u32(4)...)
+	inner = append(inner, u32(3)...)
+	inner = append(inner, 2)
+	inner = append(inner, u32(uint32(len(streamKey)))...)
+	inner = append(inner, streamKey...)
+	for _, data := range binaries {
+		inner = append(inner, 3)
+		inner = append(inner, u32(uint32(len(data)+1))...)
+		inner = append(inner, 0x01) // protected in memory
+		inner = append(inner, data...)
+	}
+	inner = append(inner, 0)
+	inner = append(inner, u32(0)...)
+
+	var compressed bytes.Buffer
+	zw := gzip.NewWriter(&compressed)
+	zw.Write(inner)
+	zw.Write([]byte(xml))
+	zw.Close()
+	payload := compressed.Bytes()
+
+	if bytes.Equal(cipherId, cipherAES) {
+		padding := aes.BlockSize - len(payload)%aes.BlockSize
+		payload = append(payload, bytes.Repeat([]byte{byte(padding)}, padding)...)
+		block, _ := aes.NewCipher(encKey[:])
+		cipher.NewCBCEncrypter(block, iv).CryptBlocks(payload, payload)
+	} else {
+		stream, _ := chacha20.NewUnauthenticatedCipher(encKey[:], iv)
+		stream.XORKeyStream(payload, payload)
+	}
+
+	out := append([]byte{}, header...)
+	headerHash := sha256.Sum256(header)
+	out = append(out, headerHash[:]...)
+	out = append(out, blockMac(^uint64(0), header)...)
+
+	for index, block := range [][]byte{payload, nil} {
+		data := append(u64(uint64(index)), u32(uint32(len(block)))...)
+		out = append(out, blockMac(uint64(index), append(data, block...))...)
+		out = append(out, u32(uint32(len(block)))...)
+		out = append(out, block...)
+	}
+
+	if err := os.WriteFile(path, out, 0644); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+}
+
+func newDocument(label string) (*document, []byte) {
+	streamKey := seeded(label+" stream", 64)
+	hash := sha512.Sum512(streamKey)
+	stream, _ := chacha20.NewUnauthenticatedCipher(hash[:32], hash[32:44])
+	return &document{stream: stream}, streamKey
+}
+
+func main() {
+	dir := filepath.Join("tests", "testdata", "kdbx")
+	modified := time.Date(2024, 3, 9, 14, 30, 0, 0, time.UTC)
+
+	// Argon2d and AES - a login with an OTP and history, another entry after
+	// it whose password decodes only if the history took its stream bytes,
+	// and an entry in the recycle bin
+	doc, streamKey := newDocument("aes")
+	doc.meta("Personal", modified)
+	doc.group("Root", modified, func() {
+		doc.group("Internet", modified, func() {
+			doc.entry(entry{title: "GitHub", tags: "dev;work", fields: []field{
+				{"UserName", "alice", false},
+				{"Password", "hunter2 <&>", true},
+				{"URL", "https://github.com/login", false},
+				{"Notes", "Personal account\nSSO through work", false},
+				{"otp", "otpauth://totp/GitHub:alice?secret=JBSWY3DPEHPK3PXP&period=30&digits=6&issuer=GitHub", true},
+				{"Recovery email", "alice@example.com", false},
+				{"Backup PIN", "8642", true},
+			}, history: []entry{{title: "GitHub", fields: []field{
+				{"UserName", "alice", false},
+				{"Password", "an older password", true},
+				{"URL", "https://github.com", false},
+				{"Notes", "", false},
+			}}}}, modified)
+			doc.entry(entry{title: "Mail", fields: []field{
+				{"UserName", "alice@example.com", false},
+				{"Password", "after the history", true},
+				{"URL", "https://mail.example.com", false},
+				{"Notes", "", false},
+			}}, modified)
+		})
+		doc.group("Recycle Bin", modified, func() {
+			doc.entry(entry{title: "Deleted", fields: []field{
+				{"UserName", "", false},
+				{"Password", "gone", true},
+				{"URL", "", false},
+				{"Notes", "", false},
+			}}, modified)
+		})
+	})
+	doc.WriteString("\t</Root>\n</KeePassFile>\n")
+	write(filepath.Join(dir, "argon2d-aes.kdbx"), cipherAES, doc.String(), streamKey, nil)
+
+	// ChaCha20 with attachments in the inner header
+	doc, streamKey = newDocument("chacha20")
+	doc.meta("Work", modified)
+	doc.group("Root", modified, func() {
+		doc.group("Servers", modified, func() {
+			doc.entry(entry{title: "Build server", tags: "ops", fields: []field{
+				{"UserName", "deploy", false},
+				{"Password", "s3rv3r", true},
+				{"URL", "ssh://build.example.com", false},
+				{"Notes", "", false},
+			}, binaries: map[string]int{"recovery-codes.txt": 0}}, modified)
+		})
+	})
+	doc.WriteString("\t</Root>\n</KeePassFile>\n")
+	write(filepath.Join(dir, "chacha20-attachment.kdbx"), cipherChaCha20, doc.String(), streamKey,
+		[][]byte{[]byte("1111-2222\n3333-4444\n\x00\xff binary tail")})
+}
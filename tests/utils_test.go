@@ -382,6 +382,51 @@ func TestCheckValidExpiry(t *testing.T) {
 	}
 }
 
+func TestValidateIdentityFields(t *testing.T) {
+	emails := map[string]bool{
+		"alice@example.com":         true,
+		"a.b+tag@mail.example.org":  true,
+		"alice@localhost":           false,
+		"Alice <alice@example.com>": false,
+		"not an email":              false,
+	}
+	for email, want := range emails {
+		if got := varuh.ValidateEmail(email); got != want {
+			t.Errorf("ValidateEmail(%q) = %v, want %v", email, got, want)
+		}
+	}
+
+	phones := map[string]bool{
+		"+91 98450 12345":      true,
+		"(555) 123-4567":       true,
+		"1234":                 false,
+		"+1 555 CALL NOW":      false,
+		"12345678901234567890": false,
+	}
+	for phone, want := range phones {
+		if got := varuh.ValidatePhoneNumber(phone); got != want {
+			t.Errorf("ValidatePhoneNumber(%q) = %v, want %v", phone, got, want)
+		}
+	}
+
+	nextYear := time.Now().AddDate(1, 0, 0).Format("2006-01-02")
+	dates := map[string]bool{
+		nextYear:     true,
+		"2001-02-03": true, // expired documents are kept
+		"2030-13-01": false,
+		"03/2030":    false,
+	}
+	for date, want := range dates {
+		if got := varuh.CheckValidDocumentExpiry(date); got != want {
+			t.Errorf("CheckValidDocumentExpiry(%q) = %v, want %v", date, got, want)
+		}
+	}
+
+	if !varuh.IsDateExpired("2001-02-03") || varuh.IsDateExpired(nextYear) {
+		t.Error("IsDateExpired() gave wrong result")
+	}
+}
+
 // TestDetectCardType tests the DetectCardType function
 func TestDetectCardType(t *testing.T) {
 	// Test case 1: Valid Visa card
@@ -667,3 +712,17 @@ func TestUpdateActiveDbPath(t *testing.T) {
 	// We expect either an error or success
 	_ = err
 }
+
+func TestHideNote(t *testing.T) {
+	cases := map[string]string{
+		"":                     "",
+		"one line":             "<1 line hidden, 8 characters>",
+		"first\nsecond\nthird": "<3 lines hidden, 18 characters>",
+	}
+
+	for text, want := range cases {
+		if got := varuh.HideNote(text); got != want {
+			t.Errorf("HideNote(%q) = %q, want %q", text, got, want)
+		}
+	}
+}
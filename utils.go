@@ -11,8 +11,11 @@ import (
 	"github.com/kirsle/configdir"
 	"github.com/polyglothacker/creditcard"
 	"golang.org/x/crypto/ssh/terminal"
+	"io"
 	"io/fs"
+	"net/mail"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"regexp"
 	"strconv"
@@ -24,10 +27,22 @@ const DELIMSIZE int = 69
 
 // Over-ride settings via cmd line
 type SettingsOverride struct {
-	ShowPasswords bool
-	CopyPassword  bool
-	AssumeYes     bool
-	Type          string // Type of entity to add
+	ShowPasswords  bool
+	CopyPassword   bool
+	AssumeYes      bool
+	Type           string // Type of entity to add
+	Cipher         string // Cipher to encrypt with
+	KeyFile        string // Keyfile for composite key unlocking
+	LockTimeout    string // How long to wait for a database locked by another process
+	PurgeOlderThan string // Age of trashed entries to purge
+	Group          string // Group path to scope listings and searches to
+	OutputPath     string // Where to write extracted files
+	SSHLifetime    string // How long ssh-agent keeps a key added with --ssh-add
+	ImportMap      string // Explicit mapping of CSV columns to entry fields
+	ImportFormat   string // Format of the file to import, found from the file if empty
+	DryRun         bool   // Only show what an import would do
+	ImportTags     bool   // Tag entries imported from a pass store with their folders
+	PGPKey         string // OpenPGP private key to decrypt a pass store with
 }
 
 // Settings structure for local config
@@ -43,11 +58,24 @@ type Settings struct {
 	// 1. timestamp,{desc,asc}
 	// 2. title,{desc,asc}
 	// 3. username, {desc,asc}
-	// 4. id, {desc,asc{
+	// 4. id, {desc,asc}
+	// 5. url, {desc,asc}
+	// 6. type, {desc,asc}
 	ListOrder string `json:"list_order"`
 	Delim     string `json:"delimiter"`
 	Color     string `json:"color"`   // fg color to print
 	BgColor   string `json:"bgcolor"` // bg color to print
+	// Key derivation for newly encrypted databases
+	// Existing databases keep the parameters in their header
+	Kdf        string `json:"kdf"`         // argon2id or argon2i
+	KdfTime    uint32 `json:"kdf_time"`    // argon2 passes
+	KdfMemory  uint32 `json:"kdf_memory"`  // argon2 memory in KiB
+	KdfThreads uint8  `json:"kdf_threads"` // argon2 parallelism
+	// Idle time after which the unlock agent forgets keys (e.g "15m")
+	AgentTimeout string `json:"agent_timeout"`
+	// How long to wait for a database in use by another varuh process (e.g "10s")
+	// Empty means fail right away
+	LockTimeout string `json:"lock_timeout"`
 }
 
 // Global settings override
@@ -73,6 +101,21 @@ func HideSecret(secret string) string {
 	return strings.Join(stars, "")
 }
 
+// Describe a hidden multi-line text without showing it
+func HideNote(text string) string {
+
+	if len(text) == 0 {
+		return ""
+	}
+
+	lines := strings.Count(text, "\n") + 1
+	if lines == 1 {
+		return fmt.Sprintf("<1 line hidden, %d characters>", len([]rune(text)))
+	}
+
+	return fmt.Sprintf("<%d lines hidden, %d characters>", lines, len([]rune(text)))
+}
+
 // Write settings to disk
 func WriteSettings(settings *Settings, configFile string) error {
 
@@ -94,7 +137,7 @@ func WriteSettings(settings *Settings, configFile string) error {
 // Write updated settings to disk
 func UpdateSettings(settings *Settings, configFile string) error {
 
-	fh, err := os.OpenFile(configFile, os.O_RDWR, 0644)
+	fh, err := os.OpenFile(configFile, os.O_RDWR|os.O_TRUNC, 0644)
 	if err != nil {
 		fmt.Printf("Error opening config file %s - \"%s\"\n", configFile, err.Error())
 		return err
@@ -148,7 +191,8 @@ func GetOrCreateLocalConfig(app string) (error, *Settings) {
 
 	} else {
 		//      fmt.Printf("Creating default configuration ...")
-		settings = Settings{"", "aes", true, true, false, configFile, "id,asc", ">", "default", "bgblack"}
+		settings = Settings{"", "aes", true, true, false, configFile, "id,asc", ">", "default", "bgblack",
+			"argon2id", DEFAULT_ARGON2_TIME, DEFAULT_ARGON2_MEMORY, DEFAULT_ARGON2_THREADS, AGENT_DEFAULT_TIMEOUT, ""}
 
 		if err = WriteSettings(&settings, configFile); err == nil {
 			// fmt.Println(" ...done")
@@ -165,6 +209,10 @@ func HasActiveDatabase() bool {
 
 	err, settings := GetOrCreateLocalConfig(APP)
 	if err == nil && settings.ActiveDB != "" {
+		if memoryDB != nil && isMemoryDatabasePath(settings.ActiveDB) {
+			// Unlocked in memory
+			return true
+		}
 		if _, err := os.Stat(settings.ActiveDB); err == nil {
 			if _, flag := IsFileEncrypted(settings.ActiveDB); !flag {
 				return true
@@ -223,35 +271,17 @@ func ReadPassword() (error, string) {
 // Rewrite the contents of the base file (path minus extension) with the new contents
 func RewriteBaseFile(path string, contents []byte, mode fs.FileMode) (error, string) {
 
-	var err error
 	var origFile string
 
 	origFile = strings.TrimSuffix(path, filepath.Ext(path))
-	// Overwrite it
-	err = os.WriteFile(origFile, contents, 0644)
-
-	if err == nil {
-		// Chmod it
-		os.Chmod(origFile, mode)
-	}
-
-	return err, origFile
+	// Replace it
+	return WriteFileAtomic(origFile, contents, mode), origFile
 }
 
 // Rewrite the contents of the file with the new contents
 func RewriteFile(path string, contents []byte, mode fs.FileMode) (error, string) {
-
-	var err error
-
-	// Overwrite it
-	err = os.WriteFile(path, contents, 0644)
-
-	if err == nil {
-		// Chmod it
-		os.Chmod(path, mode)
-	}
-
-	return err, path
+	// Replace it
+	return WriteFileAtomic(path, contents, mode), path
 }
 
 // Get color codes for console colors
@@ -343,6 +373,21 @@ func PrettifyCardNumber(cardNumber string) string {
 	return strings.Join(numbers, " ")
 }
 
+// Print the names of the attachments of an entry, if any
+func printAttachmentNames(entry *Entry) {
+
+	var names []string
+
+	_, attachments := GetAttachments(entry)
+	for _, attachment := range attachments {
+		names = append(names, attachment.Name)
+	}
+
+	if len(names) > 0 {
+		fmt.Printf("Attachments: %s\n", strings.Join(names, ", "))
+	}
+}
+
 // Print a card entry to the console
 func PrintCardEntry(entry *Entry, settings *Settings, delim bool) error {
 
@@ -391,8 +436,14 @@ func PrintCardEntry(entry *Entry, settings *Settings, delim bool) error {
 		}
 	}
 
+	if entry.GroupID != 0 || len(entry.Tags) > 0 {
+		fmt.Println()
+	}
+	if entry.GroupID != 0 {
+		fmt.Printf("Group: %s\n", GetGroupPath(entry.GroupID))
+	}
 	if len(entry.Tags) > 0 {
-		fmt.Printf("\nTags: %s\n", entry.Tags)
+		fmt.Printf("Tags: %s\n", entry.Tags)
 	}
 	if len(entry.Notes) > 0 {
 		fmt.Printf("Notes: %s\n", entry.Notes)
@@ -405,6 +456,8 @@ func PrintCardEntry(entry *Entry, settings *Settings, delim bool) error {
 		}
 	}
 
+	printAttachmentNames(entry)
+
 	fmt.Printf("Modified: %s\n", entry.Timestamp.Format("2006-01-02 15:04:05"))
 	PrintDelim(settings.Delim, settings.Color)
 	// Reset
@@ -414,6 +467,290 @@ func PrintCardEntry(entry *Entry, settings *Settings, delim bool) error {
 
 }
 
+// Print the addresses of an entry, if any
+func printAddresses(entry *Entry) {
+
+	addresses := GetAddresses(entry)
+	if len(addresses) == 0 {
+		return
+	}
+
+	fmt.Println()
+	for _, address := range addresses {
+		if address.Type != "" {
+			fmt.Printf("Address (%s): %s\n", address.Type, address.String())
+		} else {
+			fmt.Printf("Address: %s\n", address.String())
+		}
+	}
+}
+
+// Print an address entry to the console
+func PrintAddressEntry(entry *Entry, settings *Settings, delim bool) error {
+
+	var customEntries []ExtendedEntry
+
+	fmt.Printf("%s", GetColor(strings.ToLower(settings.Color)))
+	if strings.HasPrefix(settings.BgColor, "bg") {
+		fmt.Printf("%s", GetColor(strings.ToLower(settings.BgColor)))
+	}
+
+	if delim {
+		PrintDelim(settings.Delim, settings.Color)
+	}
+
+	fmt.Printf("[Type: address]\n")
+	fmt.Printf("ID: %d\n", entry.ID)
+	fmt.Printf("Title: %s\n", entry.Title)
+
+	for _, address := range GetAddresses(entry) {
+		fields := [][2]string{{"Type", address.Type}, {"Number", address.Number}, {"Building", address.Building},
+			{"Street", address.Street}, {"Locality", address.Locality}, {"Area", address.Area},
+			{"City", address.City}, {"State", address.State}, {"PIN/ZIP Code", address.ZipCode},
+			{"Country", address.Country}, {"Landmark", address.Landmark}}
+
+		for _, field := range fields {
+			if field[1] != "" {
+				fmt.Printf("%s: %s\n", field[0], field[1])
+			}
+		}
+	}
+
+	if entry.GroupID != 0 || len(entry.Tags) > 0 {
+		fmt.Println()
+	}
+	if entry.GroupID != 0 {
+		fmt.Printf("Group: %s\n", GetGroupPath(entry.GroupID))
+	}
+	if len(entry.Tags) > 0 {
+		fmt.Printf("Tags: %s\n", entry.Tags)
+	}
+	if len(entry.Notes) > 0 {
+		fmt.Printf("Notes: %s\n", entry.Notes)
+	}
+	// Query extended entries
+	customEntries = GetExtendedEntries(entry)
+	if len(customEntries) > 0 {
+		for _, customEntry := range customEntries {
+			fmt.Printf("%s: %s\n", customEntry.FieldName, customEntry.FieldValue)
+		}
+	}
+
+	printAttachmentNames(entry)
+
+	fmt.Printf("Modified: %s\n", entry.Timestamp.Format("2006-01-02 15:04:05"))
+	PrintDelim(settings.Delim, settings.Color)
+	// Reset
+	fmt.Printf("%s", GetColor("default"))
+
+	return nil
+}
+
+// Print a note entry to the console. The body is hidden unless
+// passwords are shown.
+func PrintNoteEntry(entry *Entry, settings *Settings, delim bool) error {
+
+	var customEntries []ExtendedEntry
+
+	fmt.Printf("%s", GetColor(strings.ToLower(settings.Color)))
+	if strings.HasPrefix(settings.BgColor, "bg") {
+		fmt.Printf("%s", GetColor(strings.ToLower(settings.BgColor)))
+	}
+
+	if delim {
+		PrintDelim(settings.Delim, settings.Color)
+	}
+
+	fmt.Printf("[Type: note]\n")
+	fmt.Printf("ID: %d\n", entry.ID)
+	fmt.Printf("Title: %s\n", entry.Title)
+
+	if settings.ShowPasswords || SettingsRider.ShowPasswords {
+		fmt.Printf("Body:\n%s\n", entry.Notes)
+	} else {
+		fmt.Printf("Body: %s\n", HideNote(entry.Notes))
+	}
+
+	if entry.GroupID != 0 || len(entry.Tags) > 0 {
+		fmt.Println()
+	}
+	if entry.GroupID != 0 {
+		fmt.Printf("Group: %s\n", GetGroupPath(entry.GroupID))
+	}
+	if len(entry.Tags) > 0 {
+		fmt.Printf("Tags: %s\n", entry.Tags)
+	}
+	// Query extended entries
+	customEntries = GetExtendedEntries(entry)
+	if len(customEntries) > 0 {
+		for _, customEntry := range customEntries {
+			fmt.Printf("%s: %s\n", customEntry.FieldName, customEntry.FieldValue)
+		}
+	}
+
+	printAttachmentNames(entry)
+
+	fmt.Printf("Modified: %s\n", entry.Timestamp.Format("2006-01-02 15:04:05"))
+	PrintDelim(settings.Delim, settings.Color)
+	// Reset
+	fmt.Printf("%s", GetColor("default"))
+
+	return nil
+}
+
+// Print an sshkey entry to the console. The private key and passphrase
+// are hidden unless passwords are shown.
+func PrintSSHKeyEntry(entry *Entry, settings *Settings, delim bool) error {
+
+	var customEntries []ExtendedEntry
+
+	fmt.Printf("%s", GetColor(strings.ToLower(settings.Color)))
+	if strings.HasPrefix(settings.BgColor, "bg") {
+		fmt.Printf("%s", GetColor(strings.ToLower(settings.BgColor)))
+	}
+
+	if delim {
+		PrintDelim(settings.Delim, settings.Color)
+	}
+
+	fmt.Printf("[Type: sshkey]\n")
+	fmt.Printf("ID: %d\n", entry.ID)
+	fmt.Printf("Title: %s\n", entry.Title)
+	fmt.Printf("Comment: %s\n", entry.User)
+
+	if err, keyType, fingerprint := SSHKeyFingerprint(entry.PublicKey); err == nil {
+		fmt.Printf("Key Type: %s\n", keyType)
+		fmt.Printf("Fingerprint: %s\n", fingerprint)
+	}
+	fmt.Printf("Public Key: %s\n", strings.TrimSpace(entry.PublicKey+" "+entry.User))
+
+	if settings.ShowPasswords || SettingsRider.ShowPasswords {
+		fmt.Printf("Private Key:\n%s\n", strings.TrimSpace(entry.PrivateKey))
+		if len(entry.Password) > 0 {
+			fmt.Printf("Passphrase: %s\n", entry.Password)
+		}
+	} else {
+		fmt.Printf("Private Key: %s\n", HideNote(strings.TrimSpace(entry.PrivateKey)))
+		if len(entry.Password) > 0 {
+			fmt.Printf("Passphrase: %s\n", HideSecret(entry.Password))
+		}
+	}
+
+	if entry.GroupID != 0 || len(entry.Tags) > 0 {
+		fmt.Println()
+	}
+	if entry.GroupID != 0 {
+		fmt.Printf("Group: %s\n", GetGroupPath(entry.GroupID))
+	}
+	if len(entry.Tags) > 0 {
+		fmt.Printf("Tags: %s\n", entry.Tags)
+	}
+	if len(entry.Notes) > 0 {
+		fmt.Printf("Notes: %s\n", entry.Notes)
+	}
+	// Query extended entries
+	customEntries = GetExtendedEntries(entry)
+	if len(customEntries) > 0 {
+		for _, customEntry := range customEntries {
+			fmt.Printf("%s: %s\n", customEntry.FieldName, customEntry.FieldValue)
+		}
+	}
+
+	printAttachmentNames(entry)
+
+	fmt.Printf("Modified: %s\n", entry.Timestamp.Format("2006-01-02 15:04:05"))
+	PrintDelim(settings.Delim, settings.Color)
+	// Reset
+	fmt.Printf("%s", GetColor("default"))
+
+	return nil
+}
+
+// Print an identity entry to the console
+func PrintIdentityEntry(entry *Entry, settings *Settings, delim bool) error {
+
+	var customEntries []ExtendedEntry
+
+	fmt.Printf("%s", GetColor(strings.ToLower(settings.Color)))
+	if strings.HasPrefix(settings.BgColor, "bg") {
+		fmt.Printf("%s", GetColor(strings.ToLower(settings.BgColor)))
+	}
+
+	if delim {
+		PrintDelim(settings.Delim, settings.Color)
+	}
+
+	fmt.Printf("[Type: identity]\n")
+	fmt.Printf("ID: %d\n", entry.ID)
+	fmt.Printf("Title: %s\n", entry.Title)
+	fmt.Printf("Name: %s\n", entry.User)
+
+	if entry.Email != "" {
+		fmt.Printf("Email: %s\n", entry.Email)
+	}
+	if entry.PhoneNumber != "" {
+		fmt.Printf("Phone: %s\n", entry.PhoneNumber)
+	}
+	if entry.Company != "" {
+		fmt.Printf("Company: %s\n", entry.Company)
+	}
+
+	if entry.Number != "" || entry.Class != "" {
+		fmt.Println()
+		if entry.Class != "" {
+			fmt.Printf("Document Type: %s\n", entry.Class)
+		}
+		if entry.Number != "" {
+			if settings.ShowPasswords || SettingsRider.ShowPasswords {
+				fmt.Printf("Document Number: %s\n", entry.Number)
+			} else {
+				fmt.Printf("Document Number: %s\n", HideSecret(entry.Number))
+			}
+		}
+		if entry.Issuer != "" {
+			fmt.Printf("Issued By: %s\n", entry.Issuer)
+		}
+		if entry.ExpiryDate != "" {
+			if IsDateExpired(entry.ExpiryDate) {
+				fmt.Printf("Expiry Date: %s (expired)\n", entry.ExpiryDate)
+			} else {
+				fmt.Printf("Expiry Date: %s\n", entry.ExpiryDate)
+			}
+		}
+	}
+
+	printAddresses(entry)
+
+	if entry.GroupID != 0 || len(entry.Tags) > 0 {
+		fmt.Println()
+	}
+	if entry.GroupID != 0 {
+		fmt.Printf("Group: %s\n", GetGroupPath(entry.GroupID))
+	}
+	if len(entry.Tags) > 0 {
+		fmt.Printf("Tags: %s\n", entry.Tags)
+	}
+	if len(entry.Notes) > 0 {
+		fmt.Printf("Notes: %s\n", entry.Notes)
+	}
+	// Query extended entries
+	customEntries = GetExtendedEntries(entry)
+	if len(customEntries) > 0 {
+		for _, customEntry := range customEntries {
+			fmt.Printf("%s: %s\n", customEntry.FieldName, customEntry.FieldValue)
+		}
+	}
+
+	printAttachmentNames(entry)
+
+	fmt.Printf("Modified: %s\n", entry.Timestamp.Format("2006-01-02 15:04:05"))
+	PrintDelim(settings.Delim, settings.Color)
+	// Reset
+	fmt.Printf("%s", GetColor("default"))
+
+	return nil
+}
+
 // Print an entry to the console
 func PrintEntry(entry *Entry, delim bool) error {
 
@@ -430,6 +767,14 @@ func PrintEntry(entry *Entry, delim bool) error {
 
 	if entry.Type == "card" {
 		return PrintCardEntry(entry, settings, delim)
+	} else if entry.Type == "identity" {
+		return PrintIdentityEntry(entry, settings, delim)
+	} else if entry.Type == "address" {
+		return PrintAddressEntry(entry, settings, delim)
+	} else if entry.Type == "note" {
+		return PrintNoteEntry(entry, settings, delim)
+	} else if entry.Type == "sshkey" {
+		return PrintSSHKeyEntry(entry, settings, delim)
 	}
 
 	fmt.Printf("%s", GetColor(strings.ToLower(settings.Color)))
@@ -453,6 +798,19 @@ func PrintEntry(entry *Entry, delim bool) error {
 		fmt.Printf("Password: %s\n", HideSecret(entry.Password))
 	}
 
+	if len(entry.Otp) > 0 {
+		if err, params := ParseOtp(entry.Otp); err != nil {
+			fmt.Printf("OTP: invalid - %s\n", err.Error())
+		} else if settings.ShowPasswords || SettingsRider.ShowPasswords {
+			fmt.Printf("OTP: %s\n", entry.Otp)
+		} else {
+			fmt.Printf("OTP: %s\n", params.Describe())
+		}
+	}
+
+	if entry.GroupID != 0 {
+		fmt.Printf("Group: %s\n", GetGroupPath(entry.GroupID))
+	}
 	if len(entry.Tags) > 0 {
 		fmt.Printf("Tags: %s\n", entry.Tags)
 	}
@@ -468,6 +826,8 @@ func PrintEntry(entry *Entry, delim bool) error {
 		}
 	}
 
+	printAttachmentNames(entry)
+
 	fmt.Printf("Modified: %s\n", entry.Timestamp.Format("2006-01-02 15:04:05"))
 
 	PrintDelim(settings.Delim, settings.Color)
@@ -525,6 +885,101 @@ func readInput(reader *bufio.Reader, prompt string) string {
 	return strings.TrimSpace(input)
 }
 
+// Return true if stdin is a terminal and not piped input
+func isTerminalInput() bool {
+	return terminal.IsTerminal(int(os.Stdin.Fd()))
+}
+
+// Return the editor for multi-line input from $VISUAL or $EDITOR
+func getEditor() string {
+
+	if editor := os.Getenv("VISUAL"); len(strings.TrimSpace(editor)) > 0 {
+		return editor
+	}
+
+	return strings.TrimSpace(os.Getenv("EDITOR"))
+}
+
+// Edit text in an external editor and return the edited text. The text is
+// kept in a private temporary file which is overwritten before removal.
+func editWithEditor(editor string, text string) (error, string) {
+
+	var err error
+	var dir string
+	var data []byte
+
+	dir, err = os.MkdirTemp("", "varuh")
+	if err != nil {
+		return err, ""
+	}
+
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "note.txt")
+	if err = os.WriteFile(path, []byte(text), 0600); err != nil {
+		return err, ""
+	}
+
+	defer func() {
+		if info, err := os.Stat(path); err == nil {
+			os.WriteFile(path, make([]byte, info.Size()), 0600)
+		}
+	}()
+
+	args := strings.Fields(editor)
+	cmd := exec.Command(args[0], append(args[1:], path)...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	if err = cmd.Run(); err != nil {
+		return fmt.Errorf("editor \"%s\" failed - %s", editor, err.Error()), ""
+	}
+
+	data, err = os.ReadFile(path)
+	if err != nil {
+		return err, ""
+	}
+
+	return nil, strings.TrimRight(string(data), " \t\r\n")
+}
+
+// Read multi-line text such as the body of a note. If stdin is a terminal
+// and an editor is set, the text is edited there starting from current.
+// Otherwise lines are read until a line with a single "." or end of input.
+func readMultiLineInput(reader *bufio.Reader, prompt string, current string) (error, string) {
+
+	var lines []string
+
+	if isTerminalInput() {
+		if editor := getEditor(); editor != "" {
+			fmt.Printf("%s: opening %s ...\n", prompt, editor)
+			return editWithEditor(editor, current)
+		}
+		fmt.Printf("%s (end with a line having a single \".\"):\n", prompt)
+	}
+
+	for {
+		line, err := reader.ReadString('\n')
+		line = strings.TrimRight(line, "\r\n")
+
+		if line == "." {
+			break
+		}
+		if err == nil || len(line) > 0 {
+			lines = append(lines, line)
+		}
+
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err, ""
+		}
+	}
+
+	return nil, strings.TrimRight(strings.Join(lines, "\n"), " \t\r\n")
+}
+
 // Check for an active, decrypted database
 func checkActiveDatabase() error {
 
@@ -563,6 +1018,10 @@ func isActiveDatabaseEncryptedAndMaxKryptOn() (bool, string) {
 
 	err, settings := GetOrCreateLocalConfig(APP)
 	if err == nil && settings.ActiveDB != "" {
+		if memoryDB != nil && isMemoryDatabasePath(settings.ActiveDB) {
+			// Already unlocked in memory
+			return false, ""
+		}
 		if _, err := os.Stat(settings.ActiveDB); err == nil {
 			if _, flag := IsFileEncrypted(settings.ActiveDB); flag && settings.KeepEncrypted && settings.AutoEncrypt {
 				return true, settings.ActiveDB
@@ -595,6 +1054,56 @@ func SetType(_type string) {
 	SettingsRider.Type = _type
 }
 
+func SetCipher(cipher string) {
+	SettingsRider.Cipher = cipher
+}
+
+func SetKeyFile(keyFile string) {
+	SettingsRider.KeyFile = keyFile
+}
+
+func SetLockTimeout(timeout string) {
+	SettingsRider.LockTimeout = timeout
+}
+
+func SetPurgeOlderThan(age string) {
+	SettingsRider.PurgeOlderThan = age
+}
+
+func SetGroup(group string) {
+	SettingsRider.Group = group
+}
+
+func SetOutputPath(path string) {
+	SettingsRider.OutputPath = path
+}
+
+func SetSSHLifetime(lifetime string) {
+	SettingsRider.SSHLifetime = lifetime
+}
+
+func SetImportMap(mapping string) {
+	SettingsRider.ImportMap = mapping
+}
+
+func SetImportFormat(format string) {
+	SettingsRider.ImportFormat = format
+}
+
+func SetDryRun() error {
+	SettingsRider.DryRun = true
+	return nil
+}
+
+func SetImportTags() error {
+	SettingsRider.ImportTags = true
+	return nil
+}
+
+func SetPGPKey(path string) {
+	SettingsRider.PGPKey = path
+}
+
 func CopyPasswordToClipboard(passwd string) {
 	clipboard.WriteAll(passwd)
 }
@@ -702,3 +1211,69 @@ func CheckValidExpiry(expiryDate string) bool {
 	}
 
 }
+
+// Verify an email address - a bare address without a display name
+func ValidateEmail(email string) bool {
+
+	address, err := mail.ParseAddress(email)
+	if err != nil || address.Address != email || !strings.Contains(strings.SplitN(email, "@", 2)[1], ".") {
+		fmt.Printf("Error: invalid email address - %s\n", email)
+		return false
+	}
+
+	return true
+}
+
+// Verify a phone number - digits with an optional leading + and separators
+func ValidatePhoneNumber(phone string) bool {
+
+	var digits int
+
+	if matched, _ := regexp.Match(`^\+?[0-9(][0-9 ().-]*$`, []byte(phone)); !matched {
+		fmt.Printf("Error: invalid phone number - %s\n", phone)
+		return false
+	}
+
+	for _, c := range phone {
+		if c >= '0' && c <= '9' {
+			digits++
+		}
+	}
+
+	// E.164 numbers have at most 15 digits
+	if digits < 5 || digits > 15 {
+		fmt.Printf("Error: phone number should have 5 to 15 digits - %s\n", phone)
+		return false
+	}
+
+	return true
+}
+
+// Verify if a document expiry date is in the form yyyy-mm-dd. Dates in
+// the past are allowed as expired documents are kept too.
+func CheckValidDocumentExpiry(expiryDate string) bool {
+
+	expiry, err := time.Parse("2006-01-02", expiryDate)
+	if err != nil {
+		fmt.Printf("Error: expiry date should be yyyy-mm-dd - %s\n", expiryDate)
+		return false
+	}
+
+	if expiry.Before(time.Now()) {
+		fmt.Printf("<Warning - document expired on %s>\n", expiryDate)
+	}
+
+	return true
+}
+
+// Return true if a yyyy-mm-dd date is in the past
+func IsDateExpired(date string) bool {
+
+	expiry, err := time.Parse("2006-01-02", date)
+	if err != nil {
+		return false
+	}
+
+	// Valid through the day of expiry
+	return time.Now().After(expiry.AddDate(0, 0, 1))
+}